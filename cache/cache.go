@@ -362,6 +362,32 @@ func CacheWith[T any](s *Storage, key string, fn func() T) T {
 	return value
 }
 
+// LoadWith reads and deserializes the value saved with `key` in the given Storage.
+//
+// It returns os.ErrNotExist if no value has been saved for `key`.
+func LoadWith[T any](s *Storage, key string) (value T, err error) {
+	var r io.Reader
+	r, err = s.Reader(key)
+	if err != nil {
+		return
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer func() { _ = closer.Close() }()
+	}
+	value, err = readAndDeserialize[T](r)
+	if err != nil {
+		err = errors.WithMessagef(err, "failed to load value for key %q", key)
+	}
+	return
+}
+
+// Load reads and deserializes the value saved with `key` in the Default storage.
+//
+// It returns os.ErrNotExist if no value has been saved for `key`.
+func Load[T any](key string) (T, error) {
+	return LoadWith[T](Default, key)
+}
+
 // Cache first checks if a value for `key` has already been saved at previous time,
 // in which case it is deserialized and returned. if not, `fn` is called, its result
 // is first saved using `key` and then returned.
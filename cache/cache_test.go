@@ -117,6 +117,16 @@ func testCacheForStorage(t *testing.T, s *Storage) {
 	assert.Equal(t, 9, callCount)
 	assert.Equal(t, int64(9), gotForG.X)
 
+	// LoadWith:
+	loadedC, err := LoadWith[[]float32](s, "c")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{3, 4, 5}, loadedC)
+	loadedG, err := LoadWith[*SimpleSerializable](s, "g")
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), loadedG.X)
+	_, err = LoadWith[int](s, "missing")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
 	// Test that cache is by-passed when key == "".
 	gotForEmpty := CacheWith(s, "", gen1)
 	assert.Equal(t, 10, gotForEmpty)
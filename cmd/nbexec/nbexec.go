@@ -8,6 +8,7 @@ import (
 	"github.com/go-rod/rod/lib/launcher"
 	"github.com/go-rod/rod/lib/proto"
 	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/ipynb"
 	"github.com/janpfeifer/must"
 	"io"
 	"k8s.io/klog/v2"
@@ -29,7 +30,8 @@ var (
 
 	flagNotebook = flag.String("n", "", "Notebook to execute. Must be a relative path "+
 		"to the current directory (or --jupyter_dir if set) and cannot use \"..\", since it is going to "+
-		"be used in an URL.")
+		"be used in an URL. Notebooks in the Go source format (`.go`) are first converted to a "+
+		"`.ipynb` file with the same name, which is the one executed and saved.")
 	flagJupyterDir = flag.String("jupyter_dir", "",
 		"Directory where to execute `jupyter notebook`. If empty, it will use the current directory. "+
 			"The notebook path is relative to this directory.")
@@ -79,6 +81,19 @@ func main() {
 		klog.Fatalf("The notebook given -n=%q resolves to %q, and I can't access it!? Error: %v",
 			*flagNotebook, notebookPath, err)
 	}
+	if path.Ext(notebookPath) == ipynb.GoSourceExt {
+		// Jupyter only opens `.ipynb` files: convert it to one, and execute that instead.
+		nb, err := ipynb.Read(notebookPath)
+		if err != nil {
+			klog.Fatalf("Failed to read notebook -n=%q: %+v", *flagNotebook, err)
+		}
+		notebookPath = strings.TrimSuffix(notebookPath, ipynb.GoSourceExt) + ".ipynb"
+		if err = nb.Write(notebookPath); err != nil {
+			klog.Fatalf("Failed to convert notebook -n=%q: %+v", *flagNotebook, err)
+		}
+		*flagNotebook = strings.TrimSuffix(*flagNotebook, ipynb.GoSourceExt) + ".ipynb"
+		klog.Infof("Notebook converted to %q", notebookPath)
+	}
 
 	// Values for input boxes.
 	var inputBoxes []string
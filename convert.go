@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/pkg/errors"
+	klog "k8s.io/klog/v2"
+)
+
+// runConvert implements the `gonb convert` subcommand, if it was given. Returns whether it was run.
+// Errors are fatal.
+func runConvert() bool {
+	if flag.NArg() == 0 || flag.Arg(0) != "convert" {
+		return false
+	}
+	convertFlags := flag.NewFlagSet("convert", flag.ExitOnError)
+	convertFlags.Usage = func() {
+		_, _ = fmt.Fprintf(convertFlags.Output(), "Usage: %s convert <from> <to>\n\n"+
+			"Converts a notebook between the Jupyter format (`.ipynb`) and the Go source format (`.go`), where\n"+
+			"cells are separated by `//gonb:cell` comments and markdown cells are written in comments.\n"+
+			"The outputs of the cells are not kept in the Go source format.\n\n", os.Args[0])
+		convertFlags.PrintDefaults()
+	}
+	_ = convertFlags.Parse(flag.Args()[1:])
+	if convertFlags.NArg() != 2 {
+		convertFlags.Usage()
+		os.Exit(1)
+	}
+	if err := convertNotebook(convertFlags.Arg(0), convertFlags.Arg(1)); err != nil {
+		klog.Exitf("Failed to convert %q: %+v", convertFlags.Arg(0), err)
+	}
+	return true
+}
+
+// convertNotebook reads the notebook in fromPath and writes it to toPath, each in the format given by
+// its extension.
+func convertNotebook(fromPath, toPath string) error {
+	for _, filePath := range []string{fromPath, toPath} {
+		if ext := filepath.Ext(filePath); ext != ".ipynb" && ext != ipynb.GoSourceExt {
+			return errors.Errorf("unknown notebook format for %q: the extension must be \".ipynb\" or %q",
+				filePath, ipynb.GoSourceExt)
+		}
+	}
+	nb, err := ipynb.Read(fromPath)
+	if err != nil {
+		return err
+	}
+	return nb.Write(toPath)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/specialcmd"
+	"github.com/pkg/errors"
+	klog "k8s.io/klog/v2"
+)
+
+// runExport implements the `gonb export` subcommand, if it was given. Returns whether it was run.
+// Errors are fatal.
+func runExport() bool {
+	if flag.NArg() == 0 || flag.Arg(0) != "export" {
+		return false
+	}
+	exportFlags := flag.NewFlagSet("export", flag.ExitOnError)
+	flagSplit := exportFlags.Bool("split", false, "Write the declarations of each cell in its own file.")
+	flagModule := exportFlags.String("module", "", "Module path of the exported `go.mod`. If empty, it keeps the one used by GoNB.")
+	flagForce := exportFlags.Bool("force", false, "Export even if the output directory is not empty, overwriting files with the same name.")
+	exportFlags.Usage = func() {
+		_, _ = fmt.Fprintf(exportFlags.Output(), "Usage: %s export [flags] <notebook.ipynb> <output_dir>\n\n"+
+			"Exports the Go code of the notebook as a standalone Go module.\n\n", os.Args[0])
+		exportFlags.PrintDefaults()
+	}
+	_ = exportFlags.Parse(flag.Args()[1:])
+	if exportFlags.NArg() != 2 {
+		exportFlags.Usage()
+		os.Exit(1)
+	}
+
+	opts := goexec.ExportOptions{SplitByCell: *flagSplit, Module: *flagModule, Force: *flagForce}
+	files, err := exportNotebook(exportFlags.Arg(0), exportFlags.Arg(1), opts)
+	if err != nil {
+		klog.Exitf("Failed to export %q: %+v", exportFlags.Arg(0), err)
+	}
+	for _, filePath := range files {
+		fmt.Println(filePath)
+	}
+	return true
+}
+
+// exportNotebook parses the Go cells of the notebook in notebookPath, as if they were executed in order
+// (see declareNotebook), and exports the resulting declarations to dir.
+func exportNotebook(notebookPath, dir string, opts goexec.ExportOptions) (files []string, err error) {
+	nb, err := ipynb.Read(notebookPath)
+	if err != nil {
+		return
+	}
+	goExec, err := goexec.New(nil, UniqueID, *flagWork, true)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create go executor")
+	}
+	defer func() {
+		if stopErr := goExec.Stop(); stopErr != nil {
+			klog.Warningf("Error during shutdown: %+v", stopErr)
+		}
+	}()
+
+	if err = declareNotebook(goExec, nb); err != nil {
+		return
+	}
+	return goExec.Export(dir, opts)
+}
+
+// declareNotebook parses the Go cells of the notebook, as if they were executed in order, memorizing their
+// declarations in goExec -- it still runs `goimports` and `go get`, so `go.mod` gets the dependencies.
+//
+// Special commands and shell commands (`%...` and `!...` lines) are not executed, and cells with a
+// cell magic (e.g.: `%%writefile`) or marked with `%wasm` are skipped -- except `%%package` cells, whose
+// packages are exported too.
+func declareNotebook(goExec *goexec.State, nb *ipynb.Notebook) error {
+	for ii, cell := range nb.CodeCells() {
+		cellId := ii + 1
+		lines := cell.Lines()
+		if fields := strings.Fields(goexec.TrimGonbCommentPrefix(lines[0])); len(fields) > 0 && fields[0] == "%%package" {
+			if len(fields) != 2 {
+				return errors.Errorf("in cell #%d: expected \"%%%%package <name>\", but got %q instead", cellId, fields[1:])
+			}
+			if err := goExec.DeclarePackageCell(nil, cellId, fields[1], lines); err != nil {
+				return errors.WithMessagef(err, "in cell #%d", cellId)
+			}
+			continue
+		}
+		if !specialcmd.IsGoCell(lines[0]) {
+			continue
+		}
+		specialLines := MakeSet[int]()
+		if err := specialcmd.Parse(nil, goExec, false, lines, specialLines); err != nil {
+			return err
+		}
+		magics := cellMagics(lines, specialLines)
+		if magics.Has("wasm") {
+			klog.Warningf("Skipping %%wasm cell #%d", cellId)
+			continue
+		}
+		goExec.CellIsTest = magics.Has("test")
+		if goexec.IsEmptyLines(lines, specialLines) {
+			goExec.PostExecuteCell()
+			continue
+		}
+		if err := goExec.DeclareCell(nil, cellId, lines, specialLines); err != nil {
+			return errors.WithMessagef(err, "in cell #%d", cellId)
+		}
+	}
+	return nil
+}
+
+// cellMagics returns the names of the special commands (`%...` lines) in the given special lines of a cell.
+func cellMagics(lines []string, specialLines Set[int]) Set[string] {
+	magics := MakeSet[string]()
+	for lineNum := range specialLines {
+		line := goexec.TrimGonbCommentPrefix(lines[lineNum])
+		if !strings.HasPrefix(line, "%") {
+			continue
+		}
+		if fields := strings.Fields(line[1:]); len(fields) > 0 {
+			magics.Insert(fields[0])
+		}
+	}
+	return magics
+}
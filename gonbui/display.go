@@ -0,0 +1,118 @@
+package gonbui
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+)
+
+// This file defines the rich display protocol: user types can implement any of the interfaces below
+// to control how they are displayed in the notebook by Display (or when they are the result of a cell),
+// in the spirit of IPython's `_repr_html_`.
+//
+// HTMLRenderer, MarkdownRenderer and ImageRenderer only use standard types, so libraries can implement them
+// without importing gonbui. MIMEBundler requires the small package gonbui/protocol, for protocol.MIMEType.
+
+// HTMLRenderer is implemented by types that can render themselves as HTML.
+type HTMLRenderer interface {
+	GonbHTML() string
+}
+
+// MarkdownRenderer is implemented by types that can render themselves as Markdown.
+type MarkdownRenderer interface {
+	GonbMarkdown() string
+}
+
+// ImageRenderer is implemented by types that can render themselves as a PNG image, given as raw bytes.
+type ImageRenderer interface {
+	GonbPNG() ([]byte, error)
+}
+
+// MIMEBundler is implemented by types that provide their own MIME bundle, mapping MIME types
+// (e.g.: "text/html", "image/svg+xml") to their contents, usually string or []byte.
+type MIMEBundler interface {
+	GonbMIMEBundle() map[protocol.MIMEType]any
+}
+
+// MIMEBundle returns all the representations of value it can find, in a MIME bundle that
+// can be sent with SendData: the front-end then picks the richest it supports.
+//
+// The representations are collected from:
+//
+//   - MIMEBundler: the types returned are used as is.
+//   - HTMLRenderer, MarkdownRenderer and ImageRenderer.
+//   - `image.Image` values are encoded as PNG.
+//
+// A "text/plain" representation is always included: if not given by the MIMEBundler, it is the value
+// formatted with `%+v`. Representations that fail to render (e.g.: an error encoding the PNG) are omitted.
+//
+// It returns nil if value is nil.
+func MIMEBundle(value any) map[protocol.MIMEType]any {
+	if value == nil {
+		return nil
+	}
+	data := make(map[protocol.MIMEType]any)
+	if bundler, ok := value.(MIMEBundler); ok {
+		for mimeType, content := range bundler.GonbMIMEBundle() {
+			data[mimeType] = content
+		}
+	}
+	setIfMissing := func(mimeType protocol.MIMEType, content func() any) {
+		if _, found := data[mimeType]; !found {
+			if c := content(); c != nil {
+				data[mimeType] = c
+			}
+		}
+	}
+	if renderer, ok := value.(HTMLRenderer); ok {
+		setIfMissing(protocol.MIMETextHTML, func() any { return renderer.GonbHTML() })
+	}
+	if renderer, ok := value.(MarkdownRenderer); ok {
+		setIfMissing(protocol.MIMETextMarkdown, func() any { return renderer.GonbMarkdown() })
+	}
+	if renderer, ok := value.(ImageRenderer); ok {
+		setIfMissing(protocol.MIMEImagePNG, func() any {
+			pngData, err := renderer.GonbPNG()
+			if err != nil {
+				Logf("Failed to render %T as PNG: %+v", value, err)
+				return nil
+			}
+			return pngData
+		})
+	}
+	if img, ok := value.(image.Image); ok {
+		setIfMissing(protocol.MIMEImagePNG, func() any {
+			buf := &bytes.Buffer{}
+			if err := png.Encode(buf, img); err != nil {
+				Logf("Failed to encode %T as PNG: %+v", value, err)
+				return nil
+			}
+			return buf.Bytes()
+		})
+		bounds := img.Bounds()
+		setIfMissing(protocol.MIMETextPlain, func() any {
+			return fmt.Sprintf("%T (%dx%d)", value, bounds.Dx(), bounds.Dy())
+		})
+	}
+	setIfMissing(protocol.MIMETextPlain, func() any { return fmt.Sprintf("%+v", value) })
+	return data
+}
+
+// Display displays value in the notebook, with all the representations it supports (see MIMEBundle).
+// Types can implement HTMLRenderer, MarkdownRenderer, ImageRenderer or MIMEBundler to control how they
+// are displayed.
+//
+// Nil values are not displayed.
+func Display(value any) {
+	if !IsNotebook {
+		return
+	}
+	data := MIMEBundle(value)
+	if data == nil {
+		return
+	}
+	SendData(&protocol.DisplayData{Data: data})
+}
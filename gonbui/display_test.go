@@ -0,0 +1,73 @@
+package gonbui
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type richValue struct {
+	html, markdown string
+	png            []byte
+	pngErr         error
+	bundle         map[protocol.MIMEType]any
+}
+
+func (v richValue) GonbHTML() string                          { return v.html }
+func (v richValue) GonbMarkdown() string                      { return v.markdown }
+func (v richValue) GonbPNG() ([]byte, error)                  { return v.png, v.pngErr }
+func (v richValue) GonbMIMEBundle() map[protocol.MIMEType]any { return v.bundle }
+
+type htmlValue struct{ X int }
+
+func (v htmlValue) GonbHTML() string { return "<b>x</b>" }
+
+type imageRendererValue struct{ *image.Gray }
+
+func (v imageRendererValue) GonbPNG() ([]byte, error) { return []byte("png"), nil }
+
+func TestMIMEBundle(t *testing.T) {
+	assert.Nil(t, MIMEBundle(nil))
+
+	// Without any renderer: text/plain with `%+v`.
+	assert.Equal(t, map[protocol.MIMEType]any{protocol.MIMETextPlain: "{X:1 Y:a}"},
+		MIMEBundle(struct {
+			X int
+			Y string
+		}{1, "a"}))
+
+	// Renderers add their representations to the text/plain one.
+	assert.Equal(t, map[protocol.MIMEType]any{
+		protocol.MIMETextHTML:  "<b>x</b>",
+		protocol.MIMETextPlain: "{X:1}",
+	}, MIMEBundle(htmlValue{X: 1}))
+
+	// MIMEBundler takes precedence over the renderers, which only fill in the missing representations.
+	v := richValue{html: "<i>html</i>", markdown: "*md*", png: []byte("png"),
+		bundle: map[protocol.MIMEType]any{protocol.MIMETextHTML: "<i>bundle</i>", protocol.MIMETextPlain: "bundle"}}
+	assert.Equal(t, map[protocol.MIMEType]any{
+		protocol.MIMETextHTML:     "<i>bundle</i>",
+		protocol.MIMETextMarkdown: "*md*",
+		protocol.MIMEImagePNG:     []byte("png"),
+		protocol.MIMETextPlain:    "bundle",
+	}, MIMEBundle(v))
+
+	// Representations that fail to render are omitted.
+	v = richValue{html: "<i>html</i>", pngErr: errors.New("failed")}
+	data := MIMEBundle(v)
+	assert.Equal(t, "<i>html</i>", data[protocol.MIMETextHTML])
+	assert.NotContains(t, data, protocol.MIMEImagePNG)
+	assert.Contains(t, data, protocol.MIMETextPlain)
+
+	// ImageRenderer takes precedence over the encoding of image.Image, which sets the text/plain to the size.
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	data = MIMEBundle(imageRendererValue{img})
+	assert.Equal(t, []byte("png"), data[protocol.MIMEImagePNG])
+	assert.Equal(t, "gonbui.imageRendererValue (3x2)", data[protocol.MIMETextPlain])
+	data = MIMEBundle(img)
+	assert.Equal(t, []byte("\x89PNG"), data[protocol.MIMEImagePNG].([]byte)[:4])
+	assert.Equal(t, "*image.Gray (3x2)", data[protocol.MIMETextPlain])
+}
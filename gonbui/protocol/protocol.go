@@ -125,6 +125,10 @@ type DisplayData struct {
 	// unique IDs to start with, and then re-use them to update them. If set, after the first time that it's
 	// used, it will trigger the use of the `update_display_data` as opposed to `display_data` message.
 	DisplayID string
+
+	// ExecuteResult indicates the data is the result of the cell (the value of its last expression), and
+	// it is published with an `execute_result` message, which includes the execution count.
+	ExecuteResult bool
 }
 
 // InputRequest for the front-end.
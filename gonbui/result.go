@@ -0,0 +1,37 @@
+package gonbui
+
+import "github.com/janpfeifer/gonb/gonbui/protocol"
+
+// ResultDataHook converts the value of the last expression of a cell to the MIME bundle displayed as the result
+// of the cell, see DisplayResult.
+//
+// It defaults to DefaultResultData, and it can be replaced (e.g.: in an `init()` function) to customize how values
+// are displayed. If it returns nil, nothing is displayed.
+var ResultDataHook = DefaultResultData
+
+// DefaultResultData returns the MIME bundle used by default to display value as the result of a cell.
+// It is the same used by Display, see MIMEBundle for details.
+func DefaultResultData(value any) map[protocol.MIMEType]any {
+	return MIMEBundle(value)
+}
+
+// DisplayResult displays value as the result of the cell: it is published as an "execute_result",
+// which front-ends usually display with the execution count (`Out[n]`).
+//
+// GoNB calls it automatically with the value of the last expression of the cell, if the cell `main()`
+// (e.g.: the code after `%%`) ends with an expression that is not a statement by itself (e.g.: `x` or `a+b`),
+// or with a function call that returns exactly one value (e.g.: `strings.ToUpper(s)`).
+// The value is converted to a MIME bundle by ResultDataHook.
+func DisplayResult(value any) {
+	if !IsNotebook {
+		return
+	}
+	data := ResultDataHook(value)
+	if data == nil {
+		return
+	}
+	SendData(&protocol.DisplayData{
+		Data:          data,
+		ExecuteResult: true,
+	})
+}
@@ -0,0 +1,110 @@
+// Package session is part of `gonbui` package and holds values that survive across cell executions,
+// when GoNB runs cells in a long-lived session worker (see `%session` in GoNB's `%help`).
+//
+// In session mode, each cell is compiled as a Go plugin and loaded into the same worker process.
+// Goroutines, servers and open connections started in one cell keep running in the following ones,
+// but the package-level variables of the cell (`package main`) are re-created by every cell. To reuse
+// a value (e.g.: a database connection pool) in later cells, store it here:
+//
+//	var db = session.GetOrInit("db", func() *sql.DB {
+//		db, err := sql.Open("postgres", dsn)
+//		if err != nil {
+//			panic(err)
+//		}
+//		return db
+//	})
+//
+// Since each cell is a different plugin, types declared in the notebook are different types in
+// each cell. So only values whose types are declared in imported packages (like `*sql.DB` above)
+// can be retrieved by later cells.
+//
+// Outside session mode, the values live only for the duration of the program (the cell).
+package session
+
+import (
+	"sort"
+	"sync"
+)
+
+type entry struct {
+	definition string
+	value      any
+}
+
+var (
+	mu     sync.Mutex
+	values = make(map[string]entry)
+)
+
+// Get returns the value stored under key, if it exists and if it is of type T.
+func Get[T any](key string) (value T, found bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, found := values[key]
+	if !found {
+		return
+	}
+	value, found = e.value.(T)
+	return
+}
+
+// Set stores value under key, replacing any previous value.
+func Set(key string, value any) {
+	mu.Lock()
+	defer mu.Unlock()
+	values[key] = entry{value: value}
+}
+
+// Delete removes the value stored under key, if there is one.
+func Delete(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(values, key)
+}
+
+// Keys returns the sorted keys of the stored values.
+func Keys() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetOrInit returns the value stored under key, if it exists and if it is of type T.
+// Otherwise, it calls fn, stores its result under key and returns it.
+func GetOrInit[T any](key string, fn func() T) T {
+	return Define(key, "", fn)
+}
+
+// Update stores value under key with the given definition, so it is returned by later calls to Define
+// with the same definition: it is used by GoNB to keep the values of the variables at the end of each cell.
+func Update(key, definition string, value any) {
+	mu.Lock()
+	defer mu.Unlock()
+	values[key] = entry{definition: definition, value: value}
+}
+
+// Define is like GetOrInit, but the stored value is only reused if it was created with
+// the same definition: it is used by GoNB to keep the values of the variables declared
+// in the notebook, whose definition is their type and initialization code.
+//
+// fn is called without holding any locks, so it can use the other functions of this package.
+func Define[T any](key, definition string, fn func() T) T {
+	mu.Lock()
+	if e, found := values[key]; found && e.definition == definition {
+		if value, ok := e.value.(T); ok {
+			mu.Unlock()
+			return value
+		}
+	}
+	mu.Unlock()
+	value := fn()
+	mu.Lock()
+	values[key] = entry{definition: definition, value: value}
+	mu.Unlock()
+	return value
+}
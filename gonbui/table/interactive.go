@@ -0,0 +1,203 @@
+package table
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"text/template"
+
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+)
+
+//go:embed interactive.js
+var interactiveJs []byte
+
+var tmplInteractiveJs = template.Must(template.New("interactiveJs").Parse(
+	string(interactiveJs)))
+
+// DefaultPageSize is the default number of rows per page of interactive tables.
+var DefaultPageSize = 20
+
+// InteractiveBuilder is used to create an interactive table on the front-end, that can be paged,
+// sorted (by clicking on the column headers) and filtered.
+//
+// The interaction is handled by the cell program, through the `gonbui/comms` package: the table only
+// responds while the program is running -- e.g., in `%session` mode, or while the cell is waiting
+// for something else. After that the table displays the last page shown.
+type InteractiveBuilder struct {
+	table           *Table
+	address, htmlId string
+	pageSize        int
+	built           bool
+
+	// listenUpdates is the channel that receives the state of the table from the front-end.
+	listenUpdates *comms.AddressChan[string]
+}
+
+// interactiveState is the state of the interactive table, synchronized with the front-end as JSON.
+type interactiveState struct {
+	Page   int    `json:"page"`
+	Sort   int    `json:"sort"`
+	Desc   bool   `json:"desc"`
+	Filter string `json:"filter"`
+}
+
+// Interactive returns a builder object that creates an interactive version of the table.
+//
+// Call `Done` method when you finish configuring the InteractiveBuilder.
+func (t *Table) Interactive() *InteractiveBuilder {
+	return &InteractiveBuilder{
+		table:    t,
+		address:  "/table/" + gonbui.UniqueId(),
+		htmlId:   "gonb_table_" + gonbui.UniqueId(),
+		pageSize: DefaultPageSize,
+	}
+}
+
+// WithPageSize sets the number of rows displayed per page. The default is DefaultPageSize.
+//
+// It panics if called after the table is built.
+func (b *InteractiveBuilder) WithPageSize(pageSize int) *InteractiveBuilder {
+	if b.built {
+		common.Panicf("InteractiveBuilder cannot change parameters after it is built")
+	}
+	if pageSize <= 0 {
+		common.Panicf("InteractiveBuilder.WithPageSize(%d): page size must be > 0", pageSize)
+	}
+	b.pageSize = pageSize
+	return b
+}
+
+// WithAddress configures the table to use the given address to communicate its state
+// with the front-end.
+//
+// The default is to use a randomly created unique address.
+//
+// It panics if called after the table is built.
+func (b *InteractiveBuilder) WithAddress(address string) *InteractiveBuilder {
+	if b.built {
+		common.Panicf("InteractiveBuilder cannot change parameters after it is built")
+	}
+	b.address = address
+	return b
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// It panics if called after the table is built.
+func (b *InteractiveBuilder) WithHtmlId(htmlId string) *InteractiveBuilder {
+	if b.built {
+		common.Panicf("InteractiveBuilder cannot change parameters after it is built")
+	}
+	b.htmlId = htmlId
+	return b
+}
+
+// Done displays the table in the front-end and starts handling the paging, sorting and filtering.
+//
+// After this is called options can no longer be set.
+func (b *InteractiveBuilder) Done() *InteractiveBuilder {
+	if b.built {
+		common.Panicf("InteractiveBuilder.Done already called!?")
+	}
+	b.built = true
+	if !gonbui.IsNotebook {
+		return b
+	}
+
+	state := interactiveState{Sort: -1}
+	var sb strings.Builder
+	sb.WriteString(tableStyle)
+	fmt.Fprintf(&sb, `<div class="gonb-table" id="%s">`, b.htmlId)
+	sb.WriteString(`<div class="gonb-table-controls"><input type="text" class="gonb-table-filter" placeholder="Filter...">`)
+	sb.WriteString(`<button data-action="prev">◀</button><button data-action="next">▶</button></div>`)
+	fmt.Fprintf(&sb, `<div id="%s">%s</div></div>`, b.bodyHtmlId(), b.renderPage(&state))
+	gonbui.DisplayHtml(sb.String())
+
+	b.listenUpdates = comms.Listen[string](b.address)
+	go func() {
+		for stateJSON := range b.listenUpdates.C {
+			var newState interactiveState
+			if err := json.Unmarshal([]byte(stateJSON), &newState); err != nil {
+				gonbui.Logf("Table(%s): invalid state %q: %+v", b.htmlId, stateJSON, err)
+				continue
+			}
+			page := b.renderPage(&newState)
+			dom.SetInnerHtml(b.bodyHtmlId(), page)
+			if normalized, _ := json.Marshal(newState); string(normalized) != stateJSON {
+				comms.Send(b.address, string(normalized))
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	data := struct {
+		Address, HtmlId string
+	}{
+		Address: b.address,
+		HtmlId:  b.htmlId,
+	}
+	if err := tmplInteractiveJs.Execute(&buf, data); err != nil {
+		common.Panicf("Table template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	dom.TransientJavascript(buf.String())
+	return b
+}
+
+// Close stops handling the interaction with the table in the front-end.
+func (b *InteractiveBuilder) Close() {
+	if b.listenUpdates != nil {
+		b.listenUpdates.Close()
+		b.listenUpdates = nil
+	}
+}
+
+// HtmlId returns the `id` used in the table HTML element created.
+func (b *InteractiveBuilder) HtmlId() string {
+	return b.htmlId
+}
+
+// Address returns the address used to communicate with the table HTML element.
+func (b *InteractiveBuilder) Address() string {
+	return b.address
+}
+
+// bodyHtmlId is the id of the element holding the current page of the table.
+func (b *InteractiveBuilder) bodyHtmlId() string {
+	return b.htmlId + "_body"
+}
+
+// renderPage returns the HTML of the page of the table selected by state.
+// The page in state is adjusted to be within the number of pages available.
+func (b *InteractiveBuilder) renderPage(state *interactiveState) string {
+	t := b.table
+	indices := t.view(state.Filter, state.Sort, state.Desc)
+	numMatches := len(indices)
+	numPages := max((numMatches+b.pageSize-1)/b.pageSize, 1)
+	state.Page = min(max(state.Page, 0), numPages-1)
+	start := state.Page * b.pageSize
+	end := min(start+b.pageSize, numMatches)
+	indices = indices[start:end]
+	rows := make([][]string, len(indices))
+	for ii, idx := range indices {
+		rows[ii] = t.Rows[idx]
+	}
+
+	var sb strings.Builder
+	pageTable := &Table{Columns: t.Columns} // No truncation of the page.
+	pageTable.writeHTMLTable(&sb, rows, indices, state.Sort, state.Desc)
+	fmt.Fprintf(&sb, `<div class="gonb-table-footer">Page %d of %d: rows %d–%d of %d`,
+		state.Page+1, numPages, min(start+1, end), end, numMatches)
+	if state.Filter != "" {
+		fmt.Fprintf(&sb, ` (filtered by %s from %d rows)`, html.EscapeString(fmt.Sprintf("%q", state.Filter)), len(t.Rows))
+	}
+	fmt.Fprintf(&sb, ` × %d columns</div>`, len(t.Columns))
+	return sb.String()
+}
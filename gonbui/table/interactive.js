@@ -0,0 +1,42 @@
+(() => {
+    let gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        console.error("Communication to GoNB not setup, table will not be interactive.")
+        return;
+    }
+    const el = document.getElementById("{{.HtmlId}}");
+    let state = {page: 0, sort: -1, desc: false, filter: ""};
+    let syncedState = gonb_comm.newSyncedVariable("{{.Address}}", JSON.stringify(state));
+    const send = () => syncedState.set(JSON.stringify(state));
+    syncedState.subscribe((value) => {
+        // GoNB sends back the normalized state (e.g.: page within range).
+        state = JSON.parse(value);
+    });
+
+    const filter = el.querySelector("input.gonb-table-filter");
+    filter.addEventListener("input", () => {
+        state.filter = filter.value;
+        state.page = 0;
+        send();
+    });
+    el.addEventListener("click", (event) => {
+        const button = event.target.closest("button[data-action]");
+        if (button) {
+            state.page += button.dataset.action === "next" ? 1 : -1;
+            send();
+            return;
+        }
+        const header = event.target.closest("th[data-col]");
+        if (header) {
+            const col = parseInt(header.dataset.col);
+            if (state.sort === col) {
+                state.desc = !state.desc;
+            } else {
+                state.sort = col;
+                state.desc = false;
+            }
+            state.page = 0;
+            send();
+        }
+    });
+})();
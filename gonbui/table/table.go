@@ -0,0 +1,465 @@
+// Package table displays tabular data in the notebook, as a styled HTML table (with a text/plain
+// fallback) similar to dataframes in other notebooks.
+//
+// Tables can be created from `[][]string` (FromStrings), slices of structs (FromStructs),
+// maps of columns (FromColumns) or CSV (FromCSV) -- or with New, which picks the right one for the value.
+//
+// Example:
+//
+//	%%
+//	type Point struct { X, Y float64; Label string }
+//	points := []Point{{1, 2, "a"}, {3, 4, "b"}}
+//	table.Display(points)
+//
+// Large tables are truncated to their first and last rows (see Table.WithMaxRows).
+// Use Table.Interactive to display a table that can be paged, sorted and filtered.
+//
+// A *Table implements `gonbui.MIMEBundler`, so it is also displayed with `gonbui.Display`, or if it is
+// the last expression of a cell.
+package table
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxRows is the default maximum number of rows displayed, before the table is truncated.
+var DefaultMaxRows = 20
+
+// maxTextCellWidth is the maximum width of a cell in the text/plain rendering.
+const maxTextCellWidth = 40
+
+// Table holds tabular data already formatted as strings, to be displayed.
+type Table struct {
+	// Columns names.
+	Columns []string
+
+	// Rows of the table, each with one value per column.
+	// Rows shorter than Columns are displayed padded with empty cells, and extra values are ignored.
+	Rows [][]string
+
+	maxRows int
+}
+
+// FromStrings creates a table from rows of strings: the first row is used as the column names.
+// Rows shorter than the header are padded with empty cells.
+func FromStrings(rows [][]string) *Table {
+	t := &Table{maxRows: DefaultMaxRows}
+	if len(rows) == 0 {
+		return t
+	}
+	t.Columns = rows[0]
+	t.Rows = make([][]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		t.Rows = append(t.Rows, t.padRow(row))
+	}
+	return t
+}
+
+// FromStructs creates a table from a slice of structs (or pointers to structs): each exported field
+// becomes a column, named after the field.
+//
+// Fields can be renamed with a `table:"name"` tag, or skipped with `table:"-"`.
+// Values are formatted with `%v`, and nil pointers are displayed as empty cells.
+func FromStructs(slice any) (*Table, error) {
+	sliceValue := reflect.ValueOf(slice)
+	if sliceValue.Kind() != reflect.Slice && sliceValue.Kind() != reflect.Array {
+		return nil, errors.Errorf("table.FromStructs requires a slice of structs, got %T", slice)
+	}
+	elemType := sliceValue.Type().Elem()
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, errors.Errorf("table.FromStructs requires a slice of structs, got %T", slice)
+	}
+
+	t := &Table{maxRows: DefaultMaxRows}
+	var fields []int
+	for ii := range elemType.NumField() {
+		field := elemType.Field(ii)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, found := field.Tag.Lookup("table"); found {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, ii)
+		t.Columns = append(t.Columns, name)
+	}
+
+	t.Rows = make([][]string, 0, sliceValue.Len())
+	for ii := range sliceValue.Len() {
+		elem := sliceValue.Index(ii)
+		row := make([]string, len(fields))
+		if elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				t.Rows = append(t.Rows, row)
+				continue
+			}
+			elem = elem.Elem()
+		}
+		for col, fieldIdx := range fields {
+			row[col] = formatValue(elem.Field(fieldIdx))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+// FromColumns creates a table from a map of column names to their values.
+// Columns are sorted by name, and shorter columns are padded with empty cells.
+func FromColumns[T any](columns map[string][]T) *Table {
+	t := &Table{maxRows: DefaultMaxRows}
+	t.Columns = common.SortedKeys(columns)
+	numRows := 0
+	for _, values := range columns {
+		numRows = max(numRows, len(values))
+	}
+	t.Rows = make([][]string, numRows)
+	for rowIdx := range t.Rows {
+		row := make([]string, len(t.Columns))
+		for col, name := range t.Columns {
+			if values := columns[name]; rowIdx < len(values) {
+				row[col] = fmt.Sprintf("%v", values[rowIdx])
+			}
+		}
+		t.Rows[rowIdx] = row
+	}
+	return t
+}
+
+// FromCSV creates a table from CSV content: the first record is used as the column names.
+func FromCSV(r io.Reader) (*Table, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Allow variable number of fields, missing ones are padded.
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read CSV for table")
+	}
+	return FromStrings(records), nil
+}
+
+// New creates a table from any of the supported types:
+// `*Table`, `[][]string`, slices of structs, `map[string][]T` (for common types T) or an `io.Reader` with CSV content.
+func New(value any) (*Table, error) {
+	switch v := value.(type) {
+	case *Table:
+		return v, nil
+	case [][]string:
+		return FromStrings(v), nil
+	case io.Reader:
+		return FromCSV(v)
+	case map[string][]string:
+		return FromColumns(v), nil
+	case map[string][]int:
+		return FromColumns(v), nil
+	case map[string][]int64:
+		return FromColumns(v), nil
+	case map[string][]float32:
+		return FromColumns(v), nil
+	case map[string][]float64:
+		return FromColumns(v), nil
+	case map[string][]bool:
+		return FromColumns(v), nil
+	case map[string][]any:
+		return FromColumns(v), nil
+	}
+	return FromStructs(value)
+}
+
+// Display the value as a table in the notebook, see New for the supported types.
+func Display(value any) error {
+	t, err := New(value)
+	if err != nil {
+		return err
+	}
+	t.Display()
+	return nil
+}
+
+// WithMaxRows sets the maximum number of rows displayed: larger tables are truncated to their first and last rows.
+// If maxRows <= 0 the table is never truncated. The default is DefaultMaxRows.
+//
+// It returns the table itself, so calls can be cascaded.
+func (t *Table) WithMaxRows(maxRows int) *Table {
+	t.maxRows = maxRows
+	return t
+}
+
+// Display the table in the notebook.
+func (t *Table) Display() {
+	gonbui.Display(t)
+}
+
+// GonbMIMEBundle implements gonbui.MIMEBundler, with the HTML and text renderings of the table.
+func (t *Table) GonbMIMEBundle() map[protocol.MIMEType]any {
+	return map[protocol.MIMEType]any{
+		protocol.MIMETextHTML:  t.HTML(),
+		protocol.MIMETextPlain: t.Text(),
+	}
+}
+
+// String implements fmt.Stringer, and returns the text rendering of the table.
+func (t *Table) String() string {
+	return t.Text()
+}
+
+// HTML renders the table (possibly truncated) as HTML.
+func (t *Table) HTML() string {
+	var sb strings.Builder
+	sb.WriteString(tableStyle)
+	sb.WriteString(`<div class="gonb-table">`)
+	t.writeHTMLTable(&sb, t.Rows, nil, -1, false)
+	fmt.Fprintf(&sb, `<div class="gonb-table-footer">%d rows × %d columns</div>`, len(t.Rows), len(t.Columns))
+	sb.WriteString("</div>")
+	return sb.String()
+}
+
+// tableStyle is included with the HTML of the tables.
+const tableStyle = `<style>
+.gonb-table table { border-collapse: collapse; font-size: 0.9em; }
+.gonb-table th, .gonb-table td { padding: 0.25em 0.6em; border-bottom: 1px solid rgba(128, 128, 128, 0.3); }
+.gonb-table th { text-align: left; font-weight: bold; }
+.gonb-table tbody tr:nth-child(even) { background: rgba(128, 128, 128, 0.08); }
+.gonb-table tbody tr:hover { background: rgba(66, 165, 245, 0.15); }
+.gonb-table td.gonb-table-number { text-align: right; font-variant-numeric: tabular-nums; }
+.gonb-table td.gonb-table-ellipsis { text-align: center; color: gray; }
+.gonb-table .gonb-table-index { color: gray; }
+.gonb-table-footer { color: gray; font-size: 0.8em; margin-top: 0.3em; }
+.gonb-table-controls { margin-bottom: 0.3em; }
+.gonb-table-controls button { margin: 0 0.2em; }
+.gonb-table th[data-col] { cursor: pointer; }
+</style>
+`
+
+// writeHTMLTable writes the `<table>` element with the given rows.
+//
+// If indices is given, it holds the original index of each row, otherwise rows are numbered from 0.
+// sortCol and sortDesc are used to mark the header of the column by which rows are sorted (if sortCol >= 0).
+// Rows are truncated to the head and tail rows, see WithMaxRows.
+func (t *Table) writeHTMLTable(sb *strings.Builder, rows [][]string, indices []int, sortCol int, sortDesc bool) {
+	numeric := t.numericColumns(rows)
+	sb.WriteString("<table><thead><tr><th></th>")
+	for col, name := range t.Columns {
+		var marker string
+		if col == sortCol {
+			marker = " ▲"
+			if sortDesc {
+				marker = " ▼"
+			}
+		}
+		fmt.Fprintf(sb, `<th data-col="%d">%s%s</th>`, col, html.EscapeString(name), marker)
+	}
+	sb.WriteString("</tr></thead><tbody>")
+	writeRow := func(idx int) {
+		rowIdx := idx
+		if indices != nil {
+			rowIdx = indices[idx]
+		}
+		fmt.Fprintf(sb, `<tr><td class="gonb-table-index">%d</td>`, rowIdx)
+		for col := range t.Columns {
+			cell := cellAt(rows[idx], col)
+			if numeric[col] {
+				fmt.Fprintf(sb, `<td class="gonb-table-number">%s</td>`, html.EscapeString(cell))
+			} else {
+				fmt.Fprintf(sb, "<td>%s</td>", html.EscapeString(cell))
+			}
+		}
+		sb.WriteString("</tr>")
+	}
+	head, tail := t.headAndTail(len(rows))
+	for idx := range head {
+		writeRow(idx)
+	}
+	if head+tail < len(rows) {
+		fmt.Fprintf(sb, `<tr><td class="gonb-table-ellipsis" colspan="%d">… %d more rows …</td></tr>`,
+			len(t.Columns)+1, len(rows)-head-tail)
+		for idx := len(rows) - tail; idx < len(rows); idx++ {
+			writeRow(idx)
+		}
+	}
+	sb.WriteString("</tbody></table>")
+}
+
+// Text renders the table (possibly truncated) as aligned plain text.
+func (t *Table) Text() string {
+	head, tail := t.headAndTail(len(t.Rows))
+	truncated := head+tail < len(t.Rows)
+	rowIndices := make([]int, 0, head+tail)
+	for idx := range head {
+		rowIndices = append(rowIndices, idx)
+	}
+	if truncated {
+		for idx := len(t.Rows) - tail; idx < len(t.Rows); idx++ {
+			rowIndices = append(rowIndices, idx)
+		}
+	}
+
+	// Column widths: the first column is the row index.
+	lines := make([][]string, 0, len(rowIndices)+1)
+	lines = append(lines, append([]string{""}, t.Columns...))
+	for _, idx := range rowIndices {
+		lines = append(lines, append([]string{strconv.Itoa(idx)}, t.padRow(t.Rows[idx])...))
+	}
+	widths := make([]int, len(t.Columns)+1)
+	for ii, line := range lines {
+		for col, cell := range line {
+			cell = truncateText(cell)
+			lines[ii][col] = cell
+			widths[col] = max(widths[col], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var sb strings.Builder
+	writeLine := func(line []string) {
+		var lineSB strings.Builder
+		for col, cell := range line {
+			if col > 0 {
+				lineSB.WriteString("  ")
+			}
+			lineSB.WriteString(cell)
+			lineSB.WriteString(strings.Repeat(" ", widths[col]-utf8.RuneCountInString(cell)))
+		}
+		sb.WriteString(strings.TrimRight(lineSB.String(), " "))
+		sb.WriteString("\n")
+	}
+	for ii, line := range lines {
+		if truncated && ii == head+1 {
+			sb.WriteString("...\n")
+		}
+		writeLine(line)
+	}
+	fmt.Fprintf(&sb, "[%d rows × %d columns]", len(t.Rows), len(t.Columns))
+	return sb.String()
+}
+
+// headAndTail returns the number of rows to display at the start and at the end of the table.
+// If all rows are to be displayed, tail is 0.
+func (t *Table) headAndTail(numRows int) (head, tail int) {
+	if t.maxRows <= 0 || numRows <= t.maxRows {
+		return numRows, 0
+	}
+	tail = t.maxRows / 2
+	head = t.maxRows - tail
+	return
+}
+
+// padRow returns row padded (or cut) to the number of columns.
+func (t *Table) padRow(row []string) []string {
+	if len(row) == len(t.Columns) {
+		return row
+	}
+	padded := make([]string, len(t.Columns))
+	copy(padded, row)
+	return padded
+}
+
+// numericColumns returns for each column whether all its non-empty values are numbers.
+func (t *Table) numericColumns(rows [][]string) []bool {
+	numeric := make([]bool, len(t.Columns))
+	for col := range t.Columns {
+		hasValues := false
+		numeric[col] = true
+		for _, row := range rows {
+			cell := cellAt(row, col)
+			if cell == "" {
+				continue
+			}
+			hasValues = true
+			if _, err := strconv.ParseFloat(cell, 64); err != nil {
+				numeric[col] = false
+				break
+			}
+		}
+		numeric[col] = numeric[col] && hasValues
+	}
+	return numeric
+}
+
+// cellAt returns the value of the row at column col, or an empty string if the row is too short.
+func cellAt(row []string, col int) string {
+	if col >= len(row) {
+		return ""
+	}
+	return row[col]
+}
+
+// truncateText cuts long cells for the text rendering.
+func truncateText(cell string) string {
+	cell = strings.ReplaceAll(cell, "\n", " ")
+	if utf8.RuneCountInString(cell) <= maxTextCellWidth {
+		return cell
+	}
+	runes := []rune(cell)
+	return string(runes[:maxTextCellWidth-1]) + "…"
+}
+
+// formatValue formats a struct field value for a cell.
+func formatValue(value reflect.Value) string {
+	if (value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface) && value.IsNil() {
+		return ""
+	}
+	if !value.CanInterface() {
+		return ""
+	}
+	return fmt.Sprintf("%v", value.Interface())
+}
+
+// compareCells compares two cells numerically if both are numbers, otherwise as strings.
+func compareCells(a, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// view returns the indices of the rows matching the filter (case-insensitive substring of any cell),
+// sorted by column sortCol (if >= 0). The sort is stable.
+func (t *Table) view(filter string, sortCol int, sortDesc bool) []int {
+	filter = strings.ToLower(filter)
+	indices := make([]int, 0, len(t.Rows))
+	for idx, row := range t.Rows {
+		if filter == "" || slices.ContainsFunc(row, func(cell string) bool {
+			return strings.Contains(strings.ToLower(cell), filter)
+		}) {
+			indices = append(indices, idx)
+		}
+	}
+	if sortCol >= 0 && sortCol < len(t.Columns) {
+		slices.SortStableFunc(indices, func(a, b int) int {
+			cmp := compareCells(cellAt(t.Rows[a], sortCol), cellAt(t.Rows[b], sortCol))
+			if sortDesc {
+				return -cmp
+			}
+			return cmp
+		})
+	}
+	return indices
+}
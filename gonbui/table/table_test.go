@@ -0,0 +1,75 @@
+package table
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type point struct {
+	X, Y   float64
+	Label  string `table:"Name"`
+	Hidden int    `table:"-"`
+	secret int
+}
+
+func TestNew(t *testing.T) {
+	tbl, err := New([]*point{{X: 1, Y: 2, Label: "a"}, nil, {X: 3, Y: 4, Label: "<b>"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"X", "Y", "Name"}, tbl.Columns)
+	assert.Equal(t, [][]string{{"1", "2", "a"}, {"", "", ""}, {"3", "4", "<b>"}}, tbl.Rows)
+	html := tbl.HTML()
+	assert.Contains(t, html, "<td>&lt;b&gt;</td>")
+	assert.Contains(t, html, `<td class="gonb-table-number">3</td>`)
+	assert.Contains(t, html, "3 rows × 3 columns")
+
+	tbl, err = New(map[string][]int{"b": {1, 2}, "a": {3}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, tbl.Columns)
+	assert.Equal(t, [][]string{{"3", "1"}, {"", "2"}}, tbl.Rows)
+
+	tbl, err = New(strings.NewReader("name,value\nx,1\ny\n"))
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"x", "1"}, {"y", ""}}, tbl.Rows)
+	assert.Equal(t, "   name  value\n0  x     1\n1  y\n[2 rows × 2 columns]", tbl.Text())
+
+	_, err = New(42)
+	require.Error(t, err)
+}
+
+func TestRaggedRows(t *testing.T) {
+	// Rows set directly may be shorter or longer than the columns.
+	tbl := &Table{Columns: []string{"a", "b"}, Rows: [][]string{{"1", "2"}, {"3"}, {"4", "5", "extra"}}}
+	html := tbl.HTML()
+	assert.Contains(t, html, `<td class="gonb-table-number">3</td><td class="gonb-table-number"></td></tr>`)
+	assert.NotContains(t, html, "extra")
+	assert.Equal(t, "   a  b\n0  1  2\n1  3\n2  4  5\n[3 rows × 2 columns]", tbl.Text())
+	assert.Equal(t, []int{1, 0, 2}, tbl.view("", 1, false))
+	assert.NotPanics(t, func() { tbl.Interactive().renderPage(&interactiveState{Sort: 1}) })
+}
+
+func TestTruncateAndView(t *testing.T) {
+	rows := [][]string{{"idx", "value"}}
+	for ii := range 100 {
+		rows = append(rows, []string{fmt.Sprint(ii), fmt.Sprint(ii % 7)})
+	}
+	tbl := FromStrings(rows).WithMaxRows(4)
+	text := tbl.Text()
+	assert.Contains(t, text, "\n1   1    1\n...\n98  98   0\n")
+	assert.Contains(t, tbl.HTML(), "… 96 more rows …")
+
+	// Filter and sort.
+	indices := tbl.view("9", 1, true)
+	assert.Equal(t, 19, len(indices)) // 9, 19, ..., 89 and 90..99.
+	assert.Equal(t, "6", tbl.Rows[indices[0]][1])
+
+	// Interactive pages are clamped.
+	b := tbl.Interactive().WithPageSize(30)
+	state := interactiveState{Page: 10, Sort: -1}
+	page := b.renderPage(&state)
+	assert.Equal(t, 3, state.Page)
+	assert.Contains(t, page, "Page 4 of 4: rows 91–100 of 100")
+}
@@ -0,0 +1,193 @@
+// Package capture writes the outputs of the execution of a cell as a self-contained report, used by
+// `%capture --format=<format>`.
+//
+// Reports can be written in HTML, Markdown or as a notebook (`.ipynb` JSON), so they can be shared with
+// people who don't run Jupyter. Rich outputs are kept: images are embedded as base64 data URIs or saved
+// in side files, HTML is included as is, markdown is converted (for HTML reports) and errors include their
+// tracebacks.
+package capture
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/pkg/errors"
+)
+
+// Format of the captured output.
+type Format string
+
+const (
+	// FormatText is the raw text written by the program, as in the original `%capture`. It is not handled
+	// by this package, but directly by goexec.
+	FormatText Format = "text"
+
+	// FormatHTML writes a standalone HTML document.
+	FormatHTML Format = "html"
+
+	// FormatMarkdown writes a markdown document.
+	FormatMarkdown Format = "md"
+
+	// FormatJSON writes a notebook (`.ipynb` JSON) with the cell and its outputs.
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the Format for the given name. It also accepts the aliases "markdown", "ipynb" and "txt".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text", "txt":
+		return FormatText, nil
+	case "html":
+		return FormatHTML, nil
+	case "md", "markdown":
+		return FormatMarkdown, nil
+	case "json", "ipynb":
+		return FormatJSON, nil
+	}
+	return "", errors.Errorf("unknown capture format %q, valid formats are text, html, md and json", name)
+}
+
+// Report configures where and how to write the outputs of a cell.
+type Report struct {
+	// Path of the file where to write the report.
+	Path string
+
+	Format Format
+
+	// Append the cell to an existing report, instead of overwriting it.
+	Append bool
+
+	// InlineImages embeds the images as base64 data URIs. Otherwise, they are saved in side files, in the
+	// directory returned by Report.FilesDir, and referenced by their relative path.
+	// Images in FormatJSON reports are always embedded, as in any notebook.
+	InlineImages bool
+}
+
+// Cell is the code and outputs of the execution of a cell.
+type Cell struct {
+	Code           string
+	ExecutionCount int
+	Outputs        []ipynb.Output
+}
+
+// FilesDir returns the directory where images are saved, if not inlined: it's the path of the report
+// without its extension, plus "_files".
+func (r *Report) FilesDir() string {
+	return strings.TrimSuffix(r.Path, filepath.Ext(r.Path)) + "_files"
+}
+
+// Write the cell to the report.
+func (r *Report) Write(cell *Cell) error {
+	var err error
+	switch r.Format {
+	case FormatHTML:
+		err = r.writeHTML(cell)
+	case FormatMarkdown:
+		err = r.writeMarkdown(cell)
+	case FormatJSON:
+		err = r.writeNotebook(cell)
+	default:
+		err = errors.Errorf("capture format %q is not supported in reports", r.Format)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "failed to write %q", r.Path)
+	}
+	return nil
+}
+
+// appendOrCreate writes contents to the report file, or appends it if r.Append is set.
+func (r *Report) appendOrCreate(contents string) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if r.Append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(r.Path, flags, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = f.WriteString(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.WithStack(err)
+}
+
+// imageMIMETypes are the image types supported, in order of preference, and the extension used for their files.
+var imageMIMETypes = []struct{ mimeType, ext string }{
+	{"image/png", ".png"},
+	{"image/jpeg", ".jpg"},
+	{"image/gif", ".gif"},
+	{"image/svg+xml", ".svg"},
+}
+
+// imageSource returns the "src" for an image: either a data URI, or the path (relative to the report) of a side
+// file where the image is saved.
+//
+// The name identifies the image within the report, and it's used for the file name.
+func (r *Report) imageSource(mimeType, ext, name string, content any) (string, error) {
+	text := dataString(content)
+	if r.InlineImages {
+		if mimeType == "image/svg+xml" {
+			text = base64.StdEncoding.EncodeToString([]byte(text))
+		}
+		return fmt.Sprintf("data:%s;base64,%s", mimeType, strings.Join(strings.Fields(text), "")), nil
+	}
+	var contents []byte
+	if mimeType == "image/svg+xml" {
+		contents = []byte(text)
+	} else {
+		var err error
+		contents, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid base64 encoding of %s", mimeType)
+		}
+	}
+	dir := r.FilesDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %q for images", dir)
+	}
+	fileName := name + ext
+	if err := os.WriteFile(filepath.Join(dir, fileName), contents, 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to write image")
+	}
+	return filepath.ToSlash(filepath.Join(filepath.Base(dir), fileName)), nil
+}
+
+// imageName returns the name used for the side file of the image in the output of the given cell.
+func imageName(cell *Cell, outputIdx int) string {
+	return fmt.Sprintf("cell%d_output%d", cell.ExecutionCount, outputIdx)
+}
+
+// dataString returns the content of a MIME type as a string: in notebooks, it can be either a string or a
+// list of lines.
+func dataString(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []any:
+		var sb strings.Builder
+		for _, line := range c {
+			sb.WriteString(fmt.Sprint(line))
+		}
+		return sb.String()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(content)
+}
+
+var reANSIEscape = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+
+// errorText returns the text of an "error" output: its traceback, without terminal colors, or its name and
+// value if there is no traceback.
+func errorText(output *ipynb.Output) string {
+	if len(output.Traceback) == 0 {
+		return output.EName + ": " + output.EValue
+	}
+	return reANSIEscape.ReplaceAllString(strings.Join(output.Traceback, "\n"), "")
+}
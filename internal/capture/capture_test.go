@@ -0,0 +1,85 @@
+package capture
+
+import (
+	"encoding/base64"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	pngContent = []byte("\x89PNG fake image")
+	testCell   = &Cell{
+		Code:           "%%\nfmt.Println(\"<hi>\")",
+		ExecutionCount: 3,
+		Outputs: []ipynb.Output{
+			{OutputType: "stream", Name: "stdout", Text: "<hi>\n"},
+			{OutputType: "display_data", Data: map[string]any{
+				"image/png":  base64.StdEncoding.EncodeToString(pngContent),
+				"text/plain": "image"}},
+			{OutputType: "display_data", Data: map[string]any{"text/markdown": "# Title\n\nSome **bold** text."}},
+			{OutputType: "error", EName: "ERROR", EValue: "failed", Traceback: []string{"\x1b[31mfailed\x1b[0m", "at line 2"}},
+		},
+	}
+)
+
+func TestHTML(t *testing.T) {
+	report := &Report{Path: path.Join(t.TempDir(), "report.html"), Format: FormatHTML, InlineImages: true}
+	require.NoError(t, report.Write(testCell))
+	report.Append = true
+	require.NoError(t, report.Write(&Cell{Code: "second cell", ExecutionCount: 4}))
+	contents, err := os.ReadFile(report.Path)
+	require.NoError(t, err)
+	got := string(contents)
+	assert.Contains(t, got, "In [3]:")
+	assert.Contains(t, got, `<pre class="gonb-stdout">&lt;hi&gt;`)
+	assert.Contains(t, got, `<img src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(pngContent)+`">`)
+	assert.Contains(t, got, "<h1>Title</h1>\n<p>Some <strong>bold</strong> text.</p>")
+	assert.Contains(t, got, "<pre class=\"gonb-error\">failed\nat line 2</pre>")
+	assert.Contains(t, got, "second cell</code></pre>\n</div>\n</body>\n</html>\n")
+	assert.NotContains(t, got, "\x1b")
+}
+
+func TestMarkdown(t *testing.T) {
+	report := &Report{Path: path.Join(t.TempDir(), "report.md"), Format: FormatMarkdown}
+	require.NoError(t, report.Write(testCell))
+	contents, err := os.ReadFile(report.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "**In [3]:**\n\n```go\n%%\nfmt.Println(\"<hi>\")\n```\n\n"+
+		"```\n<hi>\n```\n\n"+
+		"![cell3_output1](report_files/cell3_output1.png)\n\n"+
+		"# Title\n\nSome **bold** text.\n\n"+
+		"```\nfailed\nat line 2\n```\n\n---\n\n", string(contents))
+	image, err := os.ReadFile(path.Join(report.FilesDir(), "cell3_output1.png"))
+	require.NoError(t, err)
+	assert.Equal(t, pngContent, image)
+}
+
+func TestNotebook(t *testing.T) {
+	report := &Report{Path: path.Join(t.TempDir(), "report.ipynb"), Format: FormatJSON, Append: true}
+	require.NoError(t, report.Write(testCell))
+	require.NoError(t, report.Write(&Cell{Code: "second cell", ExecutionCount: 4}))
+	nb, err := ipynb.Read(report.Path)
+	require.NoError(t, err)
+	require.Len(t, nb.Cells, 2)
+	assert.Equal(t, 3, *nb.Cells[0].ExecutionCount)
+	require.Len(t, nb.Cells[0].Outputs, 4)
+	assert.JSONEq(t, `{"output_type": "display_data", "metadata": {},
+		"data": {"text/markdown": "# Title\n\nSome **bold** text."}}`, string(nb.Cells[0].Outputs[2]))
+	assert.Equal(t, "second cell", string(nb.Cells[1].Source))
+}
+
+func TestMarkdownToHTML(t *testing.T) {
+	assert.Equal(t, "<h2>Results</h2>\n"+
+		"<ul>\n<li>one <code>a*b*c</code></li>\n<li><a href=\"https://go.dev\">Go</a></li>\n</ul>\n"+
+		"<pre><code class=\"language-go\">x := 1 &lt; 2</code></pre>\n"+
+		"<p>some <em>italic</em> and snake_case_name\ncontinued</p>\n"+
+		"<hr>\n"+
+		"<div>raw html</div>\n",
+		markdownToHTML("## Results\n- one `a*b*c`\n- [Go](https://go.dev)\n```go\nx := 1 < 2\n```\n"+
+			"some *italic* and snake_case_name\ncontinued\n\n---\n<div>raw html</div>"))
+}
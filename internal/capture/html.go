@@ -0,0 +1,118 @@
+package capture
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/pkg/errors"
+)
+
+// htmlHeader and htmlFooter wrap the cells of an HTML report.
+const (
+	htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GoNB Output</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.gonb-cell { margin-bottom: 2em; }
+.gonb-prompt { color: #303f9f; font-family: monospace; }
+pre { padding: 0.5em; overflow-x: auto; }
+pre.gonb-code { background: #f5f5f5; border: 1px solid #e0e0e0; }
+pre.gonb-stderr { background: #fdd; }
+pre.gonb-error { background: #fdd; color: #b71c1c; }
+</style>
+</head>
+<body>
+`
+	htmlFooter = "</body>\n</html>\n"
+)
+
+// writeHTML writes the cell as a standalone HTML document, or inserts it at the end of an existing one if
+// r.Append is set.
+func (r *Report) writeHTML(cell *Cell) error {
+	cellHTML, err := r.cellHTML(cell)
+	if err != nil {
+		return err
+	}
+	document := htmlHeader + cellHTML + htmlFooter
+	if r.Append {
+		previous, err := os.ReadFile(r.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+		if len(previous) > 0 {
+			if idx := strings.LastIndex(string(previous), "</body>"); idx >= 0 {
+				document = string(previous[:idx]) + cellHTML + string(previous[idx:])
+			} else {
+				document = string(previous) + cellHTML
+			}
+		}
+	}
+	return errors.WithStack(os.WriteFile(r.Path, []byte(document), 0644))
+}
+
+// cellHTML renders the code and outputs of the cell.
+func (r *Report) cellHTML(cell *Cell) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("<div class=\"gonb-cell\">\n")
+	_, _ = fmt.Fprintf(&sb, "<div class=\"gonb-prompt\">In [%d]:</div>\n", cell.ExecutionCount)
+	_, _ = fmt.Fprintf(&sb, "<pre class=\"gonb-code\"><code>%s</code></pre>\n", html.EscapeString(cell.Code))
+	for ii := range cell.Outputs {
+		output := &cell.Outputs[ii]
+		switch output.OutputType {
+		case "stream":
+			_, _ = fmt.Fprintf(&sb, "<pre class=\"gonb-%s\">%s</pre>\n", output.Name, html.EscapeString(output.Text))
+		case "error":
+			_, _ = fmt.Fprintf(&sb, "<pre class=\"gonb-error\">%s</pre>\n", html.EscapeString(errorText(output)))
+		default:
+			dataHTML, err := r.dataHTML(output, imageName(cell, ii))
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("<div class=\"gonb-output\">\n")
+			sb.WriteString(dataHTML)
+			sb.WriteString("\n</div>\n")
+		}
+	}
+	sb.WriteString("</div>\n")
+	return sb.String(), nil
+}
+
+// dataHTML renders the richest MIME type available in a "display_data" or "execute_result" output.
+func (r *Report) dataHTML(output *ipynb.Output, name string) (string, error) {
+	data := output.Data
+	if content, found := data["text/html"]; found {
+		return dataString(content), nil
+	}
+	for _, image := range imageMIMETypes {
+		content, found := data[image.mimeType]
+		if !found {
+			continue
+		}
+		if image.mimeType == "image/svg+xml" && r.InlineImages {
+			return dataString(content), nil
+		}
+		src, err := r.imageSource(image.mimeType, image.ext, name, content)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("<img src=\"%s\">", src), nil
+	}
+	if content, found := data["text/markdown"]; found {
+		return markdownToHTML(dataString(content)), nil
+	}
+	for _, mimeType := range []string{"application/javascript", "text/javascript"} {
+		if content, found := data[mimeType]; found {
+			return fmt.Sprintf("<script>\n%s\n</script>", dataString(content)), nil
+		}
+	}
+	if content, found := data["text/plain"]; found {
+		return fmt.Sprintf("<pre>%s</pre>", html.EscapeString(dataString(content))), nil
+	}
+	return "", nil
+}
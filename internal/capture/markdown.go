@@ -0,0 +1,207 @@
+package capture
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+)
+
+// writeMarkdown writes (or appends) the cell as markdown.
+func (r *Report) writeMarkdown(cell *Cell) error {
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "**In [%d]:**\n\n", cell.ExecutionCount)
+	writeFenced(&sb, "go", cell.Code)
+	for ii := range cell.Outputs {
+		output := &cell.Outputs[ii]
+		switch output.OutputType {
+		case "stream":
+			writeFenced(&sb, "", output.Text)
+		case "error":
+			writeFenced(&sb, "", errorText(output))
+		default:
+			md, err := r.dataMarkdown(output, imageName(cell, ii))
+			if err != nil {
+				return err
+			}
+			if md != "" {
+				sb.WriteString(strings.TrimRight(md, "\n"))
+				sb.WriteString("\n\n")
+			}
+		}
+	}
+	sb.WriteString("---\n\n")
+	return r.appendOrCreate(sb.String())
+}
+
+// dataMarkdown renders the richest MIME type available in a "display_data" or "execute_result" output.
+// HTML is kept as is, since markdown accepts it.
+func (r *Report) dataMarkdown(output *ipynb.Output, name string) (string, error) {
+	data := output.Data
+	if content, found := data["text/markdown"]; found {
+		return dataString(content), nil
+	}
+	for _, image := range imageMIMETypes {
+		content, found := data[image.mimeType]
+		if !found {
+			continue
+		}
+		src, err := r.imageSource(image.mimeType, image.ext, name, content)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("![%s](%s)", name, src), nil
+	}
+	if content, found := data["text/html"]; found {
+		return dataString(content), nil
+	}
+	if content, found := data["text/plain"]; found {
+		var sb strings.Builder
+		writeFenced(&sb, "", dataString(content))
+		return sb.String(), nil
+	}
+	return "", nil
+}
+
+// writeFenced writes text as a fenced code block.
+func writeFenced(sb *strings.Builder, language, text string) {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	_, _ = fmt.Fprintf(sb, "%s%s\n%s\n%s\n\n", fence, language, strings.TrimRight(text, "\n"), fence)
+}
+
+var (
+	reMarkdownHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	reMarkdownRule     = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	reMarkdownBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	reMarkdownOrdered  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	reMarkdownQuote    = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	reMarkdownFence    = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([^`\\s]*)")
+	reMarkdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	reMarkdownLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	reMarkdownBold     = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	reMarkdownItalic   = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:.*?\S)?)[*_]([^\w*]|$)`)
+	reMarkdownHTMLLine = regexp.MustCompile(`^\s*</?[a-zA-Z][^>]*>`)
+)
+
+// markdownToHTML converts the most common markdown constructs to HTML: headings, paragraphs, lists,
+// block quotes, fenced code blocks, horizontal rules, emphasis, inline code, links and images.
+// Lines starting with an HTML tag are kept as is.
+//
+// It is used to render markdown outputs in HTML reports, and it is not a complete CommonMark implementation.
+func markdownToHTML(src string) string {
+	var (
+		sb        strings.Builder
+		paragraph []string
+		listTag   string // "ul" or "ol" if a list is open.
+		quote     []string
+	)
+	closeParagraph := func() {
+		if len(paragraph) > 0 {
+			_, _ = fmt.Fprintf(&sb, "<p>%s</p>\n", markdownInline(strings.Join(paragraph, "\n")))
+			paragraph = nil
+		}
+	}
+	closeList := func() {
+		if listTag != "" {
+			_, _ = fmt.Fprintf(&sb, "</%s>\n", listTag)
+			listTag = ""
+		}
+	}
+	closeQuote := func() {
+		if len(quote) > 0 {
+			_, _ = fmt.Fprintf(&sb, "<blockquote><p>%s</p></blockquote>\n", markdownInline(strings.Join(quote, "\n")))
+			quote = nil
+		}
+	}
+	closeAll := func() {
+		closeParagraph()
+		closeList()
+		closeQuote()
+	}
+	openList := func(tag string) {
+		if listTag != tag {
+			closeAll()
+			_, _ = fmt.Fprintf(&sb, "<%s>\n", tag)
+			listTag = tag
+		}
+	}
+
+	lines := strings.Split(src, "\n")
+	for ii := 0; ii < len(lines); ii++ {
+		line := lines[ii]
+		if m := reMarkdownFence.FindStringSubmatch(line); m != nil {
+			closeAll()
+			var code []string
+			for ii++; ii < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[ii]), m[1]); ii++ {
+				code = append(code, lines[ii])
+			}
+			class := ""
+			if m[2] != "" {
+				class = fmt.Sprintf(" class=\"language-%s\"", html.EscapeString(m[2]))
+			}
+			_, _ = fmt.Fprintf(&sb, "<pre><code%s>%s</code></pre>\n", class, html.EscapeString(strings.Join(code, "\n")))
+			continue
+		}
+		switch {
+		case strings.TrimSpace(line) == "":
+			closeAll()
+		case reMarkdownRule.MatchString(line):
+			closeAll()
+			sb.WriteString("<hr>\n")
+		case reMarkdownHeading.MatchString(line):
+			closeAll()
+			m := reMarkdownHeading.FindStringSubmatch(line)
+			_, _ = fmt.Fprintf(&sb, "<h%d>%s</h%d>\n", len(m[1]), markdownInline(m[2]), len(m[1]))
+		case reMarkdownBullet.MatchString(line):
+			openList("ul")
+			_, _ = fmt.Fprintf(&sb, "<li>%s</li>\n", markdownInline(reMarkdownBullet.FindStringSubmatch(line)[1]))
+		case reMarkdownOrdered.MatchString(line):
+			openList("ol")
+			_, _ = fmt.Fprintf(&sb, "<li>%s</li>\n", markdownInline(reMarkdownOrdered.FindStringSubmatch(line)[1]))
+		case reMarkdownQuote.MatchString(line):
+			closeParagraph()
+			closeList()
+			quote = append(quote, reMarkdownQuote.FindStringSubmatch(line)[1])
+		case reMarkdownHTMLLine.MatchString(line) && len(paragraph) == 0:
+			closeAll()
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		default:
+			closeList()
+			closeQuote()
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+	closeAll()
+	return sb.String()
+}
+
+// markdownInline converts the inline markdown constructs of text to HTML, escaping everything else.
+func markdownInline(text string) string {
+	// Odd parts are inside `code` spans, and are not further converted.
+	parts := strings.Split(text, "`")
+	if len(parts)%2 == 0 {
+		// Unbalanced backtick: the last one is taken literally.
+		parts[len(parts)-2] += "`" + parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+	var sb strings.Builder
+	for ii, part := range parts {
+		part = html.EscapeString(part)
+		if ii%2 == 1 {
+			_, _ = fmt.Fprintf(&sb, "<code>%s</code>", part)
+			continue
+		}
+		part = reMarkdownImage.ReplaceAllString(part, `<img src="$2" alt="$1">`)
+		part = reMarkdownLink.ReplaceAllString(part, `<a href="$2">$1</a>`)
+		part = reMarkdownBold.ReplaceAllString(part, "<strong>$2</strong>")
+		part = reMarkdownItalic.ReplaceAllString(part, "$1<em>$2</em>$3")
+		sb.WriteString(part)
+	}
+	return sb.String()
+}
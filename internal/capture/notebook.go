@@ -0,0 +1,40 @@
+package capture
+
+import (
+	"os"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+)
+
+// writeNotebook writes the cell as a notebook (`.ipynb`), or appends the cell to an existing notebook
+// if r.Append is set.
+func (r *Report) writeNotebook(cell *Cell) error {
+	var nb *ipynb.Notebook
+	if r.Append {
+		if _, err := os.Stat(r.Path); err == nil {
+			nb, err = ipynb.Read(r.Path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if nb == nil {
+		nb = ipynb.New()
+	}
+
+	nbCell := &ipynb.Cell{
+		CellType:       "code",
+		Metadata:       map[string]any{},
+		Source:         ipynb.Source(cell.Code),
+		ExecutionCount: &cell.ExecutionCount,
+	}
+	for ii := range cell.Outputs {
+		output, err := cell.Outputs[ii].Encode()
+		if err != nil {
+			return err
+		}
+		nbCell.Outputs = append(nbCell.Outputs, output)
+	}
+	nb.Cells = append(nb.Cells, nbCell)
+	return nb.Write(r.Path)
+}
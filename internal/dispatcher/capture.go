@@ -0,0 +1,38 @@
+package dispatcher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/capture"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/history"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+)
+
+// writeCaptureReport writes the code of the cell and the outputs recorded by recorder to the report requested
+// by `%capture --format=<format>`. The `%capture` line itself is not included in the report.
+//
+// Failures are reported to the notebook, but they don't change the status of the execution.
+func writeCaptureReport(report *capture.Report, recorder *history.Recorder, code string) {
+	var lines []string
+	for _, line := range strings.Split(code, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(goexec.TrimGonbCommentPrefix(line)), "%capture") {
+			lines = append(lines, line)
+		}
+	}
+	outputs, _ := recorder.Outputs()
+	cell := &capture.Cell{
+		Code:           strings.TrimSpace(strings.Join(lines, "\n")),
+		ExecutionCount: recorder.Kernel().ExecCounter,
+		Outputs:        outputs,
+	}
+	if err := report.Write(cell); err != nil {
+		klog.Errorf("%%capture: %+v", err)
+		err = kernel.PublishWriteStream(recorder.Message, kernel.StreamStderr, fmt.Sprintf("%%capture: %v\n", err))
+		if err != nil {
+			klog.Errorf("Failed to publish to Jupyter: %+v", err)
+		}
+	}
+}
@@ -36,6 +36,56 @@ func handleComms(msg kernel.Message, goExec *goexec.State) error {
 		klog.Infof("Comms message %q: %+v", msgType, msg.ComposedMsg())
 	}
 
+	// Comms of the variables inspector, of the environment, of the stale cells and of the diagnostics are handled
+	// separately.
+	content, _ := msg.ComposedMsg().Content.(map[string]any)
+	commId, _ := content["comm_id"].(string)
+	if msgType == "comm_open" && commId != "" {
+		switch targetName, _ := content["target_name"].(string); targetName {
+		case goexec.VariablesCommTarget:
+			return goExec.OpenVariablesComm(msg, commId)
+		case goexec.EnvironmentCommTarget:
+			return goExec.OpenEnvironmentComm(msg, commId)
+		case goexec.StaleCommTarget:
+			return goExec.OpenStaleComm(msg, commId)
+		case goexec.DiagnosticsCommTarget:
+			return goExec.OpenDiagnosticsComm(msg, commId)
+		}
+	} else if commId != "" && goExec.IsVariablesComm(commId) {
+		switch msgType {
+		case "comm_msg":
+			return goExec.HandleVariablesCommMsg(msg, commId)
+		case "comm_close":
+			goExec.CloseVariablesComm(commId)
+		}
+		return nil
+	} else if commId != "" && goExec.IsEnvironmentComm(commId) {
+		switch msgType {
+		case "comm_msg":
+			data, _ := content["data"].(map[string]any)
+			return goExec.HandleEnvironmentCommMsg(msg, commId, data)
+		case "comm_close":
+			goExec.CloseEnvironmentComm(commId)
+		}
+		return nil
+	} else if commId != "" && goExec.IsStaleComm(commId) {
+		switch msgType {
+		case "comm_msg":
+			return goExec.HandleStaleCommMsg(msg, commId)
+		case "comm_close":
+			goExec.CloseStaleComm(commId)
+		}
+		return nil
+	} else if commId != "" && goExec.IsDiagnosticsComm(commId) {
+		switch msgType {
+		case "comm_msg":
+			return goExec.HandleDiagnosticsCommMsg(msg, commId)
+		case "comm_close":
+			goExec.CloseDiagnosticsComm(commId)
+		}
+		return nil
+	}
+
 	switch msgType {
 	case "comm_info_request":
 		// https://jupyter-client.readthedocs.io/en/latest/messaging.html#comm-info
@@ -0,0 +1,24 @@
+package dispatcher
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+)
+
+// This file handles the "debug_request" messages, received on the control channel, used by the front-end
+// debugger (e.g.: JupyterLab's debugger panel).
+//
+// See details in:
+// https://jupyter-client.readthedocs.io/en/latest/messaging.html#debug-request
+
+// handleDebugRequest passes the Debug Adapter Protocol request to goexec.State, and replies with its response.
+func handleDebugRequest(msg kernel.Message, goExec *goexec.State) error {
+	request, ok := msg.ComposedMsg().Content.(map[string]any)
+	if !ok {
+		klog.Warningf("Ignoring debug_request with invalid content: %v", msg.ComposedMsg().Content)
+		return nil
+	}
+	response := goExec.HandleDebugRequest(request)
+	return msg.Reply("debug_reply", response)
+}
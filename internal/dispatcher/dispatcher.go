@@ -6,7 +6,9 @@ import (
 	"fmt"
 	. "github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/capture"
 	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/history"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"github.com/janpfeifer/gonb/internal/specialcmd"
 	"github.com/pkg/errors"
@@ -86,7 +88,7 @@ func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
 // is sent before previous one finishes).
 var BusyMessageTypes = []string{
 	"execute_request", "inspect_request", "complete_request",
-	"kernel_info_request",
+	"kernel_info_request", "history_request",
 	//"kernel_info_request", "shutdown_request",
 }
 
@@ -118,7 +120,7 @@ func handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 	if !slices.Contains(BusyMessageTypes, msgType) {
 		// Messages that are handled asynchronously and don't block kernel
 		switch msgType {
-		case "comm_open", "comm_msg", "comm_comm_close", "comm_info_request":
+		case "comm_open", "comm_msg", "comm_close", "comm_info_request":
 			// Handle in a separate goroutine.
 			go func() {
 				klog.V(1).Infof("Dispatcher: handling %q", msgType)
@@ -130,7 +132,14 @@ func handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 			}()
 
 		case "is_complete_request":
-			klog.V(2).Infof("Received is_complete_request: ignoring, since it's not a console like kernel.")
+			if err = handleIsCompleteRequest(msg); err != nil {
+				err = errors.WithMessagef(err, "replying to 'is_complete_request'")
+			}
+
+		case "debug_request":
+			if err = handleDebugRequest(msg, goExec); err != nil {
+				err = errors.WithMessagef(err, "replying to 'debug_request'")
+			}
 
 		case "shutdown_request":
 			if err = handleShutdownRequest(msg, goExec); err != nil {
@@ -202,12 +211,12 @@ func handleBusyMessage(msg kernel.Message, goExec *goexec.State) (err error) {
 
 	switch msgType {
 	case "kernel_info_request":
-		if err = kernel.SendKernelInfo(msg, Version); err != nil {
+		if err = kernel.SendKernelInfo(msg, Version, goexec.IsDebuggerAvailable()); err != nil {
 			err = errors.WithMessagef(err, "replying to 'kernel_info_request'")
 		}
 
 	case "execute_request":
-		if err = handleExecuteRequest(msg, goExec); err != nil {
+		if err = HandleExecuteRequest(msg, goExec); err != nil {
 			err = errors.WithMessagef(err, "replying to 'execute_request'")
 		}
 	case "inspect_request":
@@ -219,11 +228,18 @@ func handleBusyMessage(msg kernel.Message, goExec *goexec.State) (err error) {
 			klog.Fatal(err)
 		}
 
-	case "comm_open", "comm_msg", "comm_comm_close", "comm_info_request":
+	case "comm_open", "comm_msg", "comm_close", "comm_info_request":
 		err = handleComms(msg, goExec)
 
+	case "history_request":
+		if err = handleHistoryRequest(msg, goExec); err != nil {
+			err = errors.WithMessagef(err, "replying to 'history_request'")
+		}
+
 	case "is_complete_request":
-		klog.V(2).Infof("Received is_complete_request: ignoring, since it's not a console like kernel.")
+		if err = handleIsCompleteRequest(msg); err != nil {
+			err = errors.WithMessagef(err, "replying to 'is_complete_request'")
+		}
 
 	default:
 		// Log, ignore, and hope for the best.
@@ -261,9 +277,9 @@ type OutErr struct {
 	err io.Writer
 }
 
-// handleExecuteRequest runs code from an execute_request method,
+// HandleExecuteRequest runs code from an execute_request method,
 // and sends the various reply messages.
-func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
+func HandleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 	// Extract the data from the request.
 	content := msg.ComposedMsg().Content.(map[string]any)
 	code := content["code"].(string)
@@ -281,19 +297,32 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 		replyContent["execution_count"] = msg.Kernel().ExecCounter
 	}
 
+	// Record outputs for the history, if enabled.
+	var recorder *history.Recorder
+	if storeHistory && goExec.History != nil && goExec.History.RecordOutputs {
+		recorder = history.NewRecorder(msg)
+		msg = recorder
+	}
+
 	// Tell the front-end what the kernel is about to execute.
 	if !silent {
 		klog.V(1).Infof("> publish \"execute_input\" with code")
 		if err := kernel.PublishExecuteInput(msg, code); err != nil {
 			return errors.WithMessagef(err, "publishing execution input")
 		}
+		goExec.PublishPendingWarnings(msg)
 	}
 
 	// Dispatch to various executors.
 	msg.Kernel().Interrupted.Store(false)
+	goExec.ResetDiagnostics(msg)
 	lines := strings.Split(code, "\n")
 	specialLines := MakeSet[int]() // lines that are special commands and not Go.
 	var executionErr error
+	var (
+		report         *capture.Report
+		reportRecorder *history.Recorder
+	)
 
 	if specialCell, err := specialcmd.ExecuteSpecialCell(msg, goExec, lines); specialCell {
 		executionErr = err // err may be nil here, if magic cell command was executed correctly.
@@ -302,6 +331,14 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 		if err := specialcmd.Parse(msg, goExec, true, lines, specialLines); err != nil {
 			executionErr = errors.WithMessagef(err, "executing special commands in cell")
 		}
+		if goExec.CaptureReport != nil {
+			// Record outputs for the report requested with `%capture --format=...`.
+			report = goExec.CaptureReport
+			goExec.CaptureReport = nil
+			reportRecorder = history.NewRecorder(msg)
+			reportRecorder.MaxSize = 0
+			msg = reportRecorder
+		}
 		hasMoreToRun := !goexec.IsEmptyLines(lines, specialLines) || goExec.CellIsTest
 		if executionErr == nil && !msg.Kernel().Interrupted.Load() && hasMoreToRun {
 			executionErr = goExec.ExecuteCell(msg, msg.Kernel().ExecCounter, lines, specialLines)
@@ -309,6 +346,7 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 	}
 
 	// Final execution result.
+	var replyMetadata map[string]any
 	if executionErr == nil {
 		// if the only non-nil value should be auto-rendered graphically, render it
 		replyContent["status"] = "ok"
@@ -319,23 +357,47 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 		replyContent["ename"] = name
 		replyContent["evalue"] = value
 		replyContent["traceback"] = traceback
+		if diagnostics := goExec.Diagnostics(); len(diagnostics) > 0 {
+			replyMetadata = map[string]any{goexec.DiagnosticsMetadataKey: diagnostics}
+		}
 
 		// Publish an execution_error message.
 		if err := kernel.PublishExecutionError(msg, value, traceback, name); err != nil {
 			return errors.WithMessagef(err, "publishing back execution error")
 		}
 	}
+	if storeHistory && goExec.History != nil {
+		recordHistory(goExec, msg.Kernel().ExecCounter, recorder, code, replyContent["status"].(string))
+	}
+	if report != nil {
+		writeCaptureReport(report, reportRecorder, code)
+	}
 
 	// Send the output back to the notebook.
 	if klog.V(2).Enabled() {
 		klog.Infof("> execute_reply: %+v", replyContent)
 	}
-	if err := msg.Reply("execute_reply", replyContent); err != nil {
+	if err := msg.ReplyWithMetadata("execute_reply", replyContent, replyMetadata); err != nil {
 		return errors.WithMessagef(err, "publish 'execute_reply`")
 	}
+	goExec.PublishDiagnostics(msg)
 	return nil
 }
 
+// handleIsCompleteRequest replies whether the code is complete and can be executed, or whether more lines
+// are expected. It is used by console front-ends (e.g. `jupyter console`) to handle multi-line cells.
+func handleIsCompleteRequest(msg kernel.Message) error {
+	content := msg.ComposedMsg().Content.(map[string]any)
+	code, _ := content["code"].(string)
+	status, indent := specialcmd.IsComplete(strings.Split(code, "\n"))
+	replyContent := map[string]any{"status": status}
+	if status == goexec.CodeIncomplete {
+		replyContent["indent"] = indent
+	}
+	klog.V(2).Infof("> is_complete_reply: %+v", replyContent)
+	return msg.Reply("is_complete_reply", replyContent)
+}
+
 // HandleInspectRequest presents rich data (HTML?) with contextual information for the
 // contents under the cursor.
 func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
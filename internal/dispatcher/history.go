@@ -0,0 +1,88 @@
+package dispatcher
+
+import (
+	"time"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/history"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file handles the "history_request" messages, and the recording of the history of executed cells.
+//
+// See details in:
+// https://jupyter-client.readthedocs.io/en/latest/messaging.html#history
+
+// recordHistory adds the code executed, its status and the outputs captured by recorder (if not nil) to the
+// history.
+func recordHistory(goExec *goexec.State, execCount int, recorder *history.Recorder, code, status string) {
+	entry := &history.Entry{
+		Line:   execCount,
+		Time:   time.Now(),
+		Code:   code,
+		Status: status,
+	}
+	if recorder != nil {
+		entry.Outputs, entry.Truncated = recorder.Outputs()
+	}
+	if err := goExec.History.Add(entry); err != nil {
+		klog.Errorf("Failed to record cell execution in the history: %+v", err)
+	}
+}
+
+// handleHistoryRequest replies to a "history_request" message, with the "range", "tail" or "search"
+// access types.
+func handleHistoryRequest(msg kernel.Message, goExec *goexec.State) error {
+	content := msg.ComposedMsg().Content.(map[string]any)
+	accessType, _ := content["hist_access_type"].(string)
+	withOutput, _ := content["output"].(bool)
+	intField := func(key string) int {
+		// JSON numbers are decoded as float64.
+		value, _ := content[key].(float64)
+		return int(value)
+	}
+
+	var entries []*history.Entry
+	var err error
+	if goExec.History != nil {
+		switch accessType {
+		case "range":
+			entries, err = goExec.History.Range(intField("session"), intField("start"), intField("stop"))
+		case "tail":
+			entries, err = goExec.History.Tail(intField("n"))
+		case "search":
+			pattern, _ := content["pattern"].(string)
+			unique, _ := content["unique"].(bool)
+			entries, err = goExec.History.Search(pattern, intField("n"), unique)
+		default:
+			err = errors.Errorf("unknown hist_access_type %q", accessType)
+		}
+	}
+
+	replyContent := make(map[string]any)
+	if err != nil {
+		klog.Errorf("Failed to handle history_request: %+v", err)
+		replyContent["status"] = "error"
+		replyContent["ename"] = "HistoryError"
+		replyContent["evalue"] = err.Error()
+		replyContent["traceback"] = []string{}
+	} else {
+		historyReply := make([]any, 0, len(entries))
+		for _, entry := range entries {
+			if withOutput {
+				var output any // null if there is no output.
+				if text := entry.Text(); text != "" {
+					output = text
+				}
+				historyReply = append(historyReply, []any{entry.Session, entry.Line, []any{entry.Code, output}})
+			} else {
+				historyReply = append(historyReply, []any{entry.Session, entry.Line, entry.Code})
+			}
+		}
+		replyContent["status"] = "ok"
+		replyContent["history"] = historyReply
+	}
+	return msg.Reply("history_reply", replyContent)
+}
@@ -0,0 +1,98 @@
+package goexec
+
+import (
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+)
+
+// This file implements the check of whether a cell is complete, used by console front-ends (`jupyter console`)
+// to decide whether to execute the cell or to wait for more lines when the user presses enter.
+
+// Completeness status of the code of a cell, as defined by the Jupyter "is_complete_request" message.
+const (
+	CodeComplete   = "complete"
+	CodeIncomplete = "incomplete"
+	CodeInvalid    = "invalid"
+)
+
+// IsCompleteCode checks whether the Go code in lines (excluding skipLines, the special commands) is complete.
+//
+// The code is composed the same way as when the cell is executed: in particular, lines following `%%`
+// or `%main` are the body of the `main()` function. Since each statement in that body is complete on its own,
+// a `%%` block is only considered complete once it is terminated by an empty line.
+//
+// It returns the status (CodeComplete, CodeIncomplete or CodeInvalid) and, if incomplete, a suggested
+// indentation for the next line.
+func IsCompleteCode(lines []string, skipLines Set[int]) (status, indent string) {
+	var body strings.Builder // Only the code in the cell, used to calculate the indentation.
+	var src strings.Builder
+	src.WriteString("package main\n")
+	var isMainBlock bool
+	for ii, line := range lines {
+		trimmedLine := TrimGonbCommentPrefix(line)
+		if strings.HasPrefix(trimmedLine, "%main") || strings.HasPrefix(trimmedLine, "%%") {
+			src.WriteString("func main() {\n")
+			body.WriteString("\n")
+			isMainBlock = true
+			continue
+		}
+		if skipLines.Has(ii) || strings.HasPrefix(line, "package") {
+			// Keep line numbers in sync with the cell.
+			line = ""
+		}
+		src.WriteString(line + "\n")
+		body.WriteString(line + "\n")
+	}
+	if isMainBlock {
+		src.WriteString("}\n")
+	}
+
+	status = CodeComplete
+	_, err := parser.ParseFile(token.NewFileSet(), "", src.String(), 0)
+	if errList, ok := err.(scanner.ErrorList); ok && len(errList) > 0 {
+		status = CodeInvalid
+		if errList[0].Pos.Offset >= src.Len() {
+			// Parser reached the end of the code expecting more.
+			status = CodeIncomplete
+		}
+		for _, e := range errList {
+			if strings.Contains(e.Msg, "raw string literal not terminated") ||
+				strings.Contains(e.Msg, "comment not terminated") {
+				status = CodeIncomplete
+			}
+		}
+	} else if err != nil {
+		status = CodeInvalid
+	}
+	if status == CodeComplete && isMainBlock && len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+		status = CodeIncomplete
+	}
+	if status == CodeIncomplete {
+		indent = strings.Repeat("\t", openBrackets(body.String()))
+	}
+	return
+}
+
+// openBrackets returns the number of brackets (curly, round or square) open at the end of the code.
+func openBrackets(code string) int {
+	fileSet := token.NewFileSet()
+	file := fileSet.AddFile("", fileSet.Base(), len(code))
+	var s scanner.Scanner
+	s.Init(file, []byte(code), nil, 0) // Errors are ignored.
+	var depth int
+	for {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			return max(depth, 0)
+		case token.LBRACE, token.LPAREN, token.LBRACK:
+			depth++
+		case token.RBRACE, token.RPAREN, token.RBRACK:
+			depth--
+		}
+	}
+}
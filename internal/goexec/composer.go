@@ -7,6 +7,7 @@ import (
 	"io"
 	"k8s.io/klog/v2"
 	"os"
+	"path"
 	"sort"
 	"strings"
 )
@@ -202,7 +203,8 @@ func (d *Declarations) RenderFunctions(w *WriterWithCursor, fileToCellIdAndLine
 		funcDecl := d.Functions[key]
 
 		// First render the corresponding comments.
-		tmpCursor, fileToCellIdAndLine := funcDecl.Comments.Render(w, fileToCellIdAndLine)
+		var tmpCursor Cursor
+		tmpCursor, fileToCellIdAndLine = funcDecl.Comments.Render(w, fileToCellIdAndLine)
 		if tmpCursor != NoCursor {
 			// Cursor in comment, register it.
 			cursor = tmpCursor
@@ -376,7 +378,7 @@ func (s *State) createGoFileFromLines(filePath string, cellId int, lines []strin
 		}
 	}()
 
-	w.Write("package main\n\n")
+	w.Writef("package %s\n\n", s.codePackageName())
 	var needsClosingMain bool
 	for ii, line := range lines {
 		trimmedLine := TrimGonbCommentPrefix(line)
@@ -444,9 +446,37 @@ func (s *State) createGoFileFromLines(filePath string, cellId int, lines []strin
 // It returns the cursor position in the file as well as a mapping from the file Lines to the original cell ids and Lines.
 func (s *State) createCodeFileFromDecls(decls *Declarations, mainDecl *Function) (
 	cursor Cursor, fileToCellIdAndLine []CellIdAndLine, err error) {
+	if s.cellPackage != "" {
+		return s.createPackageFileFromDecls(decls)
+	}
 	if err = s.RemoveGeneratedCode(); err != nil {
 		return
 	}
+	persisted := s.persistedVariables(decls)
+	if err = s.writePersistFile(persisted); err != nil {
+		return
+	}
+	kept := s.sessionVariables(decls, persisted)
+	if err = s.writeSessionFile(decls, kept); err != nil {
+		return
+	}
+	reported := s.reportedVariables(decls)
+	if err = s.writeVarsFile(reported); err != nil {
+		return
+	}
+	decls = s.persistDecls(decls, persisted)
+	decls = s.sessionDecls(decls, kept)
+	mainDecl = s.persistMain(mainDecl, persisted)
+	mainDecl = s.varsMain(mainDecl, reported)
+	mainDecl, hasResult := s.resultMain(mainDecl)
+	if err = s.writeResultFile(hasResult); err != nil {
+		return
+	}
+	mainDecl, hasProfile := s.profileMain(mainDecl)
+	if err = s.writeProfileFile(hasProfile); err != nil {
+		return
+	}
+
 	var f *os.File
 	f, err = os.Create(s.CodePath())
 	if err != nil {
@@ -467,6 +497,44 @@ func (s *State) createCodeFileFromDecls(decls *Declarations, mainDecl *Function)
 	return
 }
 
+// injectIntoMain returns a copy of mainDecl with prefix inserted just after the opening "{" of `func main()`.
+// The prefix is inserted in the same line (it must have no new lines), so the mapping of the lines to the cells
+// is preserved, and the cursor is moved accordingly.
+func injectIntoMain(mainDecl *Function, prefix string) *Function {
+	if mainDecl == nil {
+		return nil
+	}
+	// `func main()` has no parameters or results, so the first "{" opens its body.
+	bracePos := strings.Index(mainDecl.Definition, "{")
+	if bracePos == -1 {
+		return mainDecl
+	}
+	bracePos++
+	newMain := *mainDecl // Shallow copy.
+	newMain.Definition = mainDecl.Definition[:bracePos] + prefix + mainDecl.Definition[bracePos:]
+	if newMain.HasCursor() && newMain.Cursor.Line == 0 && newMain.Cursor.Col >= bracePos {
+		newMain.Cursor.Col += len(prefix)
+	}
+	return &newMain
+}
+
+// writeOrRemoveGenerated writes the generated file name, in State.TempDir, with contents. If contents is empty,
+// the file is removed instead, if it exists.
+func (s *State) writeOrRemoveGenerated(name, contents string) error {
+	filePath := path.Join(s.TempDir, name)
+	if contents == "" {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %q", filePath)
+		}
+		return nil
+	}
+	if err := os.WriteFile(filePath, []byte(contents), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", filePath)
+	}
+	return nil
+}
+
 // createAlternativeFileFromDecls creates `other.go` and writes all memorized definitions.
 func (s *State) createAlternativeFileFromDecls(decls *Declarations) (err error) {
 	var f *os.File
@@ -498,7 +566,7 @@ func (s *State) createAlternativeFileFromDecls(decls *Declarations) (err error)
 func (s *State) createCodeFromDecls(writer io.Writer, decls *Declarations, mainDecl *Function) (cursor Cursor, fileToCellIdAndLine []CellIdAndLine, err error) {
 	cursor = NoCursor
 	w := NewWriterWithCursor(writer)
-	w.Writef("package main\n\n")
+	w.Writef("package %s\n\n", s.codePackageName())
 	if err != nil {
 		return
 	}
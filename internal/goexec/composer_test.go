@@ -76,3 +76,56 @@ func TestCreateGoFileFromLines(t *testing.T) {
 	require.Contains(t, content, "Hello")
 	require.NotContains(t, content, "xxx", "`package xxx` should have been discarded")
 }
+
+func TestRenderFunctionsCellLines(t *testing.T) {
+	// Lines of functions in `main.go` must map back to the lines of the cell that defined them.
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	cellLines := strings.Split(`// f returns 1.
+func f() int {
+	return 1
+}
+
+// g returns 2.
+func g() int {
+	return 2
+}
+
+%%
+fmt.Println(f() + g())`, "\n")
+	_, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	contentBytes, err := os.ReadFile(s.CodePath())
+	require.NoErrorf(t, err, "Failed os.ReadFile(%q)", s.CodePath())
+	fileLines := strings.Split(string(contentBytes), "\n")
+
+	numMapped := 0
+	for ii, fileLine := range fileLines {
+		if ii >= len(fileToCellIdAndLine) || fileToCellIdAndLine[ii].Line == NoCursorLine {
+			continue
+		}
+		cellLine := cellLines[fileToCellIdAndLine[ii].Line]
+		if cellLine == "%%" || strings.HasPrefix(cellLine, "fmt.") {
+			// Lines of `main()` are changed.
+			continue
+		}
+		require.Equalf(t, cellLine, fileLine, "Line mapping look wrong: file line %d --> cell line %d",
+			ii, fileToCellIdAndLine[ii].Line)
+		numMapped++
+	}
+	require.Equal(t, 8, numMapped, "All lines of the comments and functions should be mapped to the cell")
+}
+
+func TestInjectIntoMain(t *testing.T) {
+	require.Nil(t, injectIntoMain(nil, " x();"))
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { f()\n}"}
+	mainDecl.Cursor = Cursor{Line: 0, Col: 16}
+	newMain := injectIntoMain(mainDecl, " x();")
+	require.Equal(t, "func main() { x(); f()\n}", newMain.Definition)
+	require.Equal(t, Cursor{Line: 0, Col: 21}, newMain.Cursor)
+	require.Equal(t, "func main() { f()\n}", mainDecl.Definition, "The original declaration must not change")
+}
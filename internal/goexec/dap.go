@@ -0,0 +1,225 @@
+package goexec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the client side of the Debug Adapter Protocol (DAP), used to talk to `dlv dap`.
+// See https://microsoft.github.io/debug-adapter-protocol/specification
+//
+// Messages are kept generic (as decoded from JSON), since most of them are simply passed through
+// between the front-end and `dlv`, only with their source locations translated.
+
+// dapMessage is a DAP request, response or event.
+type dapMessage = map[string]any
+
+// dapTimeout is the maximum time to wait for `dlv` to connect or to respond to a request.
+var dapTimeout = 30 * time.Second
+
+// writeDAPMessage writes msg to w with the DAP base protocol framing ("Content-Length" header).
+func writeDAPMessage(w io.Writer, msg dapMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode DAP message")
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write DAP message")
+	}
+	return nil
+}
+
+// readDAPMessage reads one message with the DAP base protocol framing.
+func readDAPMessage(r *bufio.Reader) (dapMessage, error) {
+	contentLength := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if contentLength >= 0 {
+				break
+			}
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid DAP header %q", line)
+			}
+		}
+	}
+	data := make([]byte, contentLength)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.Wrapf(err, "failed to read DAP message content")
+	}
+	msg := make(dapMessage)
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode DAP message %q", data)
+	}
+	return msg, nil
+}
+
+// dapInt returns the value of the numeric field key, or 0 if it is not set.
+func dapInt(msg dapMessage, key string) int {
+	switch value := msg[key].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	}
+	return 0
+}
+
+// dapMap returns the field key as a map, or nil if it is not set.
+func dapMap(msg dapMessage, key string) dapMessage {
+	value, _ := msg[key].(map[string]any)
+	return value
+}
+
+// newDAPResponse creates the response to the given request.
+// If err is not nil, the response reports the failure with the error message.
+func newDAPResponse(request dapMessage, body any, err error) dapMessage {
+	response := dapMessage{
+		"type":        "response",
+		"request_seq": dapInt(request, "seq"),
+		"command":     request["command"],
+		"success":     err == nil,
+	}
+	if err != nil {
+		response["message"] = err.Error()
+	}
+	if body != nil {
+		response["body"] = body
+	}
+	return response
+}
+
+// dapSession is a connection to a `dlv dap` server, debugging the program of one cell execution.
+type dapSession struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	muWrite sync.Mutex
+
+	mu      sync.Mutex
+	seq     int
+	pending map[int]chan dapMessage
+
+	// onEvent is called, from the reading goroutine, for each event received.
+	onEvent func(event dapMessage)
+
+	// initialized is closed when the "initialized" event is received. done is closed when the connection is lost.
+	initialized, done         chan struct{}
+	initializedOnce, doneOnce sync.Once
+}
+
+// newDAPSession starts handling the connection conn to `dlv dap`.
+func newDAPSession(conn net.Conn, onEvent func(event dapMessage)) *dapSession {
+	d := &dapSession{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		pending:     make(map[int]chan dapMessage),
+		onEvent:     onEvent,
+		initialized: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go d.poll()
+	return d
+}
+
+// poll reads messages until the connection is closed, delivering responses to the pending requests.
+func (d *dapSession) poll() {
+	defer d.Close()
+	for {
+		msg, err := readDAPMessage(d.reader)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				klog.Warningf("Debugger connection failed: %+v", err)
+			}
+			return
+		}
+		klog.V(2).Infof("dlv> %v", msg)
+		switch msg["type"] {
+		case "response":
+			d.mu.Lock()
+			ch, found := d.pending[dapInt(msg, "request_seq")]
+			delete(d.pending, dapInt(msg, "request_seq"))
+			d.mu.Unlock()
+			if found {
+				ch <- msg
+			}
+		case "event":
+			if msg["event"] == "initialized" {
+				d.initializedOnce.Do(func() { close(d.initialized) })
+			}
+			if d.onEvent != nil {
+				d.onEvent(msg)
+			}
+		}
+	}
+}
+
+// Close the connection to `dlv`, which makes it exit.
+func (d *dapSession) Close() {
+	d.doneOnce.Do(func() {
+		close(d.done)
+		_ = d.conn.Close()
+	})
+}
+
+// send the request and waits for its response. The "seq" field of the request is set by send.
+func (d *dapSession) send(request dapMessage) (dapMessage, error) {
+	ch := make(chan dapMessage, 1)
+	d.mu.Lock()
+	d.seq++
+	seq := d.seq
+	d.pending[seq] = ch
+	d.mu.Unlock()
+
+	request["seq"] = seq
+	request["type"] = "request"
+	klog.V(2).Infof("dlv< %v", request)
+	d.muWrite.Lock()
+	err := writeDAPMessage(d.conn, request)
+	d.muWrite.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case response := <-ch:
+		return response, nil
+	case <-d.done:
+		return nil, errors.Errorf("debugger connection closed before responding to %q", request["command"])
+	case <-time.After(dapTimeout):
+		d.mu.Lock()
+		delete(d.pending, seq)
+		d.mu.Unlock()
+		return nil, errors.Errorf("timed out waiting for the debugger response to %q", request["command"])
+	}
+}
+
+// request sends the command with the given arguments, and returns an error if it fails.
+func (d *dapSession) request(command string, arguments any) (dapMessage, error) {
+	response, err := d.send(dapMessage{"command": command, "arguments": arguments})
+	if err != nil {
+		return nil, err
+	}
+	if success, _ := response["success"].(bool); !success {
+		return response, errors.Errorf("debugger request %q failed: %v", command, response["message"])
+	}
+	return response, nil
+}
@@ -0,0 +1,569 @@
+package goexec
+
+import (
+	"io"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the Jupyter debugger protocol (`debug_request`, `debug_reply` and `debug_event` messages),
+// bridged to `dlv dap`. See https://jupyter-client.readthedocs.io/en/latest/messaging.html#debug-request
+//
+// The front-end (JupyterLab) identifies each cell by a file path derived from the hash of its code: these
+// are the "cell sources". When debugging, the program is executed by `dlv`, and the breakpoints and locations
+// are translated between the cell sources and the generated `main.go` using the CellIdAndLine mapping.
+
+const (
+	// DebugCellsSubdir is the subdirectory of State.TempDir where the cell sources are written.
+	DebugCellsSubdir = "debug_cells"
+
+	// debugHashSeed is the seed of the Murmur2 hash used to name the cell sources, the same used by ipykernel.
+	debugHashSeed = 0xC70F6907
+
+	// debugGCFlags disables optimizations and inlining when compiling for the debugger.
+	debugGCFlags = "-gcflags=all=-N -l"
+)
+
+// debuggerInfo holds the state of the debugger.
+type debuggerInfo struct {
+	mu sync.Mutex
+
+	// started is set when the front-end attaches to the debugger, and reset when it disconnects.
+	started bool
+
+	// breakpoints set by the front-end, per cell source.
+	breakpoints map[string][]dapMessage
+
+	// cellSources maps cell ids to cell sources, for the cells executed while the debugger was started.
+	cellSources map[int]string
+
+	// stoppedThreads are the threads stopped in the program being debugged.
+	stoppedThreads Set[int]
+
+	// session is the connection to `dlv`, if a cell is being debugged.
+	session *dapSession
+
+	// codePath and fileToCellIdAndLine describe the code of the cell being debugged.
+	codePath            string
+	fileToCellIdAndLine []CellIdAndLine
+}
+
+func newDebuggerInfo() *debuggerInfo {
+	return &debuggerInfo{
+		breakpoints:    make(map[string][]dapMessage),
+		cellSources:    make(map[int]string),
+		stoppedThreads: MakeSet[int](),
+	}
+}
+
+// IsDebuggerAvailable returns whether `dlv` (Delve) is installed, which is required for the debugger.
+func IsDebuggerAvailable() bool {
+	_, err := exec.LookPath("dlv")
+	return err == nil
+}
+
+// IsDebuggerStarted returns whether the front-end is attached to the debugger: if so, cells are executed
+// under `dlv`.
+func (s *State) IsDebuggerStarted() bool {
+	s.debugger.mu.Lock()
+	defer s.debugger.mu.Unlock()
+	return s.debugger.started
+}
+
+// useDebugger returns whether the current cell is to be compiled and executed with the debugger.
+// Tests, wasm cells and session mode are not supported.
+func (s *State) useDebugger() bool {
+	return s.IsDebuggerStarted() && !s.CellIsTest && !s.CellIsWasm && !s.useSession()
+}
+
+// debugCellSourcePath returns the path of the cell source for the given code.
+// It must match the path calculated by the front-end, using the hash parameters given in the "debugInfo" reply.
+func (s *State) debugCellSourcePath(code string) string {
+	return path.Join(s.TempDir, DebugCellsSubdir, strconv.FormatUint(uint64(murmur2([]byte(code), debugHashSeed)), 10)+".go")
+}
+
+// dumpDebugCell writes the code to its cell source file, and returns its path.
+func (s *State) dumpDebugCell(code string) (string, error) {
+	sourcePath := s.debugCellSourcePath(code)
+	if err := os.MkdirAll(path.Dir(sourcePath), 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory for debugger cell sources")
+	}
+	if err := os.WriteFile(sourcePath, []byte(code), 0600); err != nil {
+		return "", errors.Wrapf(err, "failed to write debugger cell source %q", sourcePath)
+	}
+	return sourcePath, nil
+}
+
+// recordDebugCell associates the cell id with its source, if the debugger is started.
+func (s *State) recordDebugCell(cellId int, lines []string) {
+	if !s.IsDebuggerStarted() {
+		return
+	}
+	sourcePath, err := s.dumpDebugCell(strings.Join(lines, "\n"))
+	if err != nil {
+		klog.Warningf("Debugger: %+v", err)
+		return
+	}
+	s.debugger.mu.Lock()
+	defer s.debugger.mu.Unlock()
+	s.debugger.cellSources[cellId] = sourcePath
+}
+
+// HandleDebugRequest handles a DAP request sent by the front-end in a "debug_request" message, and returns
+// the DAP response to send back in the "debug_reply".
+//
+// Requests specific to Jupyter ("debugInfo", "dumpCell", etc.) and the configuration requests are handled
+// by GoNB. The others are forwarded to `dlv`, if a cell is being debugged.
+func (s *State) HandleDebugRequest(request dapMessage) dapMessage {
+	command, _ := request["command"].(string)
+	arguments := dapMap(request, "arguments")
+	d := s.debugger
+	klog.V(1).Infof("Debugger request %q", command)
+
+	switch command {
+	case "initialize":
+		return newDAPResponse(request, dapMessage{
+			"supportsConfigurationDoneRequest":  true,
+			"supportsConditionalBreakpoints":    true,
+			"supportsHitConditionalBreakpoints": true,
+			"supportsLogPoints":                 true,
+			"supportsEvaluateForHovers":         true,
+			"supportsDelayedStackTraceLoading":  true,
+		}, nil)
+
+	case "attach":
+		d.mu.Lock()
+		d.started = true
+		d.mu.Unlock()
+		return newDAPResponse(request, nil, nil)
+
+	case "disconnect":
+		d.mu.Lock()
+		d.started = false
+		clear(d.breakpoints)
+		session := d.session
+		isStopped := len(d.stoppedThreads) > 0
+		d.mu.Unlock()
+		if session != nil {
+			// Let the program continue without breakpoints.
+			s.setProgramBreakpoints()
+			if isStopped {
+				if _, err := session.request("continue", dapMessage{"threadId": 0}); err != nil {
+					klog.Warningf("Debugger: %+v", err)
+				}
+			}
+		}
+		return newDAPResponse(request, nil, nil)
+
+	case "configurationDone", "setExceptionBreakpoints":
+		return newDAPResponse(request, nil, nil)
+
+	case "debugInfo":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var breakpoints []dapMessage
+		for sourcePath, sourceBreakpoints := range d.breakpoints {
+			breakpoints = append(breakpoints, dapMessage{"source": sourcePath, "breakpoints": sourceBreakpoints})
+		}
+		stoppedThreads := slices.Sorted(maps.Keys(d.stoppedThreads))
+		return newDAPResponse(request, dapMessage{
+			"isStarted":      d.started,
+			"hashMethod":     "Murmur2",
+			"hashSeed":       debugHashSeed,
+			"tmpFilePrefix":  path.Join(s.TempDir, DebugCellsSubdir) + "/",
+			"tmpFileSuffix":  ".go",
+			"breakpoints":    breakpoints,
+			"stoppedThreads": stoppedThreads,
+			"richRendering":  false,
+			"exceptionPaths": []string{},
+		}, nil)
+
+	case "dumpCell":
+		code, _ := arguments["code"].(string)
+		sourcePath, err := s.dumpDebugCell(code)
+		if err != nil {
+			return newDAPResponse(request, nil, err)
+		}
+		return newDAPResponse(request, dapMessage{"sourcePath": sourcePath}, nil)
+
+	case "setBreakpoints":
+		return s.setDebugBreakpoints(request, arguments)
+
+	case "source":
+		sourcePath, _ := dapMap(arguments, "source")["path"].(string)
+		content, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return newDAPResponse(request, nil, errors.Wrapf(err, "source %q not available", sourcePath))
+		}
+		return newDAPResponse(request, dapMessage{"content": string(content)}, nil)
+
+	case "inspectVariables":
+		// Only variables of a stopped program are available, through the "variables" request.
+		return newDAPResponse(request, dapMessage{"variables": []any{}}, nil)
+
+	case "modules":
+		return newDAPResponse(request, dapMessage{"modules": []any{}, "totalModules": 0}, nil)
+	}
+
+	// Forward other requests to `dlv`.
+	d.mu.Lock()
+	session := d.session
+	d.mu.Unlock()
+	if session == nil {
+		if command == "threads" {
+			return newDAPResponse(request, dapMessage{"threads": []any{}}, nil)
+		}
+		return newDAPResponse(request, nil, errors.Errorf("%q not available: no cell is being debugged", command))
+	}
+	forward := make(dapMessage, len(request))
+	for key, value := range request {
+		forward[key] = value
+	}
+	s.translateDebugLocations(forward["arguments"], s.cellToProgramLocation)
+	response, err := session.send(forward)
+	if err != nil {
+		return newDAPResponse(request, nil, err)
+	}
+	s.translateDebugLocations(response["body"], s.programToCellLocation)
+	response["request_seq"] = dapInt(request, "seq")
+	return response
+}
+
+// setDebugBreakpoints handles the "setBreakpoints" request, which sets all the breakpoints of one cell source.
+func (s *State) setDebugBreakpoints(request, arguments dapMessage) dapMessage {
+	d := s.debugger
+	sourcePath, _ := dapMap(arguments, "source")["path"].(string)
+	var sourceBreakpoints []dapMessage
+	if list, ok := arguments["breakpoints"].([]any); ok {
+		for _, bp := range list {
+			if bpMap, ok := bp.(map[string]any); ok {
+				sourceBreakpoints = append(sourceBreakpoints, bpMap)
+			}
+		}
+	}
+	d.mu.Lock()
+	if len(sourceBreakpoints) == 0 {
+		delete(d.breakpoints, sourcePath)
+	} else {
+		d.breakpoints[sourcePath] = sourceBreakpoints
+	}
+	running := d.session != nil
+	d.mu.Unlock()
+	if running {
+		s.setProgramBreakpoints()
+	}
+
+	breakpoints := make([]dapMessage, 0, len(sourceBreakpoints))
+	for _, bp := range sourceBreakpoints {
+		line := dapInt(bp, "line")
+		verified := true
+		if running {
+			_, _, verified = s.cellToProgramLocation(sourcePath, line)
+		}
+		breakpoints = append(breakpoints, dapMessage{
+			"verified": verified,
+			"line":     line,
+			"source":   dapMessage{"path": sourcePath},
+		})
+	}
+	return newDAPResponse(request, dapMessage{"breakpoints": breakpoints}, nil)
+}
+
+// setProgramBreakpoints sets in `dlv` all the breakpoints of the cell sources that map to the program.
+func (s *State) setProgramBreakpoints() {
+	d := s.debugger
+	d.mu.Lock()
+	session, codePath := d.session, d.codePath
+	var programBreakpoints []dapMessage
+	for sourcePath, sourceBreakpoints := range d.breakpoints {
+		for _, bp := range sourceBreakpoints {
+			_, line, found := d.cellToProgramLocationLocked(sourcePath, dapInt(bp, "line"))
+			if !found {
+				continue
+			}
+			programBp := make(dapMessage, len(bp))
+			for key, value := range bp {
+				programBp[key] = value
+			}
+			programBp["line"] = line
+			programBreakpoints = append(programBreakpoints, programBp)
+		}
+	}
+	d.mu.Unlock()
+	if session == nil {
+		return
+	}
+	_, err := session.request("setBreakpoints", dapMessage{
+		"source":      dapMessage{"path": codePath},
+		"breakpoints": programBreakpoints,
+	})
+	if err != nil {
+		klog.Warningf("Debugger: %+v", err)
+	}
+}
+
+// cellToProgramLocation translates a line in a cell source to the corresponding line in the program code.
+func (s *State) cellToProgramLocation(sourcePath string, line int) (string, int, bool) {
+	s.debugger.mu.Lock()
+	defer s.debugger.mu.Unlock()
+	return s.debugger.cellToProgramLocationLocked(sourcePath, line)
+}
+
+func (d *debuggerInfo) cellToProgramLocationLocked(sourcePath string, line int) (string, int, bool) {
+	for fileLine, cellLine := range d.fileToCellIdAndLine {
+		if cellLine.Line == line-1 && cellLine.Id != NoCursorLine && d.cellSources[cellLine.Id] == sourcePath {
+			return d.codePath, fileLine + 1, true
+		}
+	}
+	return sourcePath, line, false
+}
+
+// programToCellLocation translates a line in the program code to the corresponding cell source and line.
+func (s *State) programToCellLocation(filePath string, line int) (string, int, bool) {
+	d := s.debugger
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if filePath != d.codePath || line < 1 || line > len(d.fileToCellIdAndLine) {
+		return filePath, line, false
+	}
+	cellLine := d.fileToCellIdAndLine[line-1]
+	sourcePath, found := d.cellSources[cellLine.Id]
+	if !found || cellLine.Line == NoCursorLine {
+		return filePath, line, false
+	}
+	return sourcePath, cellLine.Line + 1, true
+}
+
+// translateDebugLocations walks over the DAP message body (or arguments) v, and translates all locations,
+// objects with a "source" (with a "path") and a "line", using the translate function.
+func (s *State) translateDebugLocations(v any, translate func(filePath string, line int) (string, int, bool)) {
+	switch value := v.(type) {
+	case map[string]any:
+		if source := dapMap(value, "source"); source != nil {
+			if filePath, ok := source["path"].(string); ok && dapInt(value, "line") > 0 {
+				if newPath, newLine, found := translate(filePath, dapInt(value, "line")); found {
+					newSource := make(dapMessage, len(source))
+					for key, field := range source {
+						newSource[key] = field
+					}
+					newSource["path"] = newPath
+					newSource["name"] = path.Base(newPath)
+					value["source"] = newSource
+					value["line"] = newLine
+					if _, hasEndLine := value["endLine"]; hasEndLine {
+						value["endLine"] = newLine
+					}
+				}
+			}
+		}
+		for key, field := range value {
+			if key != "source" {
+				s.translateDebugLocations(field, translate)
+			}
+		}
+	case []any:
+		for _, field := range value {
+			s.translateDebugLocations(field, translate)
+		}
+	}
+}
+
+// handleDebugEvent is called for the events sent by `dlv`: it keeps track of stopped threads, writes
+// the program output, and forwards the event to the front-end.
+func (s *State) handleDebugEvent(msg kernel.Message, stdout, stderr io.Writer, event dapMessage) {
+	d := s.debugger
+	body := dapMap(event, "body")
+	switch event["event"] {
+	case "stopped":
+		d.mu.Lock()
+		d.stoppedThreads.Insert(dapInt(body, "threadId"))
+		d.mu.Unlock()
+	case "continued":
+		d.mu.Lock()
+		if allContinued, _ := body["allThreadsContinued"].(bool); allContinued {
+			clear(d.stoppedThreads)
+		} else {
+			d.stoppedThreads.Delete(dapInt(body, "threadId"))
+		}
+		d.mu.Unlock()
+	case "output":
+		output, _ := body["output"].(string)
+		switch body["category"] {
+		case "stdout":
+			_, _ = stdout.Write([]byte(output))
+		case "stderr":
+			_, _ = stderr.Write([]byte(output))
+		}
+	case "terminated":
+		// Program finished, `dlv` exits once disconnected.
+		d.mu.Lock()
+		session := d.session
+		d.mu.Unlock()
+		if session != nil {
+			go func() {
+				if _, err := session.send(dapMessage{"command": "disconnect"}); err != nil {
+					klog.V(1).Infof("Debugger: %+v", err)
+				}
+				session.Close()
+			}()
+		}
+	}
+	s.translateDebugLocations(body, s.programToCellLocation)
+	if err := msg.Publish("debug_event", event); err != nil {
+		klog.Errorf("Failed to publish debug_event: %+v", err)
+	}
+}
+
+// executeInDebugger executes the compiled cell program under `dlv dap`, connecting it to the front-end debugger.
+// It returns when the program finishes.
+//
+// The timeout of State.Limits is enforced by stopping `dlv` (including the time stopped in breakpoints). The
+// memory and CPU limits would also apply to `dlv`, so they are not enforced.
+func (s *State) executeInDebugger(msg kernel.Message, args []string, stdout, stderr io.Writer,
+	fileToCellIdAndLine []CellIdAndLine) error {
+	if !IsDebuggerAvailable() {
+		return errors.New("debugger requires `dlv` (Delve) to be installed, see https://github.com/go-delve/delve")
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get current directory")
+	}
+
+	// `dlv` connects back to GoNB.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen for the debugger connection")
+	}
+	defer func() { _ = listener.Close() }()
+	warnUnenforcedLimits(msg, s.Limits, "the debugger")
+	executor := jpyexec.New(msg, "dlv", "dap", "--client-addr="+listener.Addr().String()).
+		UseNamedPipes(s.Comms).
+		ExecutionCount(msg.Kernel().ExecCounter).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		CaptureDisplayDataOutput(s.CaptureFile).
+		WithLimits(jpyexec.Limits{Timeout: s.Limits.Timeout})
+	execDone := make(chan error, 1)
+	go func() { execDone <- executor.Exec() }()
+
+	connChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			klog.V(1).Infof("Debugger: %+v", err)
+			return
+		}
+		connChan <- conn
+	}()
+	var conn net.Conn
+	select {
+	case conn = <-connChan:
+	case err = <-execDone:
+		return errors.WithMessagef(err, "`dlv dap` exited before connecting")
+	case <-time.After(dapTimeout):
+		_ = listener.Close()
+		msg.Kernel().CallInterruptSubscribers()
+		<-execDone
+		return errors.Errorf("timed out waiting for `dlv dap` to connect")
+	}
+
+	d := s.debugger
+	session := newDAPSession(conn, func(event dapMessage) {
+		s.handleDebugEvent(msg, stdout, stderr, event)
+	})
+	d.mu.Lock()
+	d.session = session
+	d.codePath = s.CodePath()
+	d.fileToCellIdAndLine = fileToCellIdAndLine
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.session = nil
+		clear(d.stoppedThreads)
+		d.mu.Unlock()
+	}()
+
+	if err = s.launchInDebugger(session, args, cwd); err != nil {
+		session.Close()
+		<-execDone
+		return err
+	}
+	err = <-execDone
+	session.Close()
+	return err
+}
+
+// launchInDebugger configures `dlv` to execute the program and sets the breakpoints.
+func (s *State) launchInDebugger(session *dapSession, args []string, cwd string) error {
+	_, err := session.request("initialize", dapMessage{
+		"clientID":        "gonb",
+		"adapterID":       "go",
+		"linesStartAt1":   true,
+		"columnsStartAt1": true,
+		"pathFormat":      "path",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = session.request("launch", dapMessage{
+		"mode":    "exec",
+		"program": s.BinaryPath(),
+		"args":    args,
+		"cwd":     cwd,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-session.initialized:
+	case <-session.done:
+		return errors.New("debugger connection closed before initialization")
+	case <-time.After(dapTimeout):
+		return errors.New("timed out waiting for the debugger initialization")
+	}
+	s.setProgramBreakpoints()
+	_, err = session.request("configurationDone", nil)
+	return err
+}
+
+// murmur2 implements the 32 bits MurmurHash2 hash, used by the front-end to name the cell sources.
+func murmur2(data []byte, seed uint32) uint32 {
+	const m = 0x5bd1e995
+	h := seed ^ uint32(len(data))
+	for ; len(data) >= 4; data = data[4:] {
+		k := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h = h*m ^ k
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,143 @@
+package goexec
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Values generated with the implementation used by JupyterLab's debugger.
+	for code, want := range map[string]uint32{
+		"":     3990065800,
+		"a":    2167009006,
+		"ab":   2805137849,
+		"abc":  3350977461,
+		"abcd": 804720481,
+		"func main() {\n\tfmt.Println(\"héllo\")\n}": 3052885301,
+	} {
+		assert.Equalf(t, want, murmur2([]byte(code), debugHashSeed), "murmur2(%q)", code)
+	}
+}
+
+func TestDAPMessages(t *testing.T) {
+	var sb strings.Builder
+	require.NoError(t, writeDAPMessage(&sb, dapMessage{"seq": 1, "type": "request", "command": "threads"}))
+	require.NoError(t, writeDAPMessage(&sb, dapMessage{"seq": 2, "type": "event", "event": "initialized"}))
+	r := bufio.NewReader(strings.NewReader(sb.String()))
+	msg, err := readDAPMessage(r)
+	require.NoError(t, err)
+	assert.Equal(t, "threads", msg["command"])
+	assert.Equal(t, 1, dapInt(msg, "seq"))
+	msg, err = readDAPMessage(r)
+	require.NoError(t, err)
+	assert.Equal(t, "initialized", msg["event"])
+}
+
+func TestDebugger(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() { require.NoError(t, s.Stop()) }()
+
+	// Attach and dump a cell.
+	response := s.HandleDebugRequest(dapMessage{"seq": 1.0, "command": "attach"})
+	require.Equal(t, true, response["success"])
+	require.True(t, s.IsDebuggerStarted())
+	code := "func f() int {\n\treturn 1\n}\n%%\nfmt.Println(f())"
+	response = s.HandleDebugRequest(dapMessage{"seq": 2.0, "command": "dumpCell",
+		"arguments": map[string]any{"code": code}})
+	require.Equal(t, true, response["success"])
+	sourcePath := dapMap(response, "body")["sourcePath"].(string)
+	assert.Equal(t, s.debugCellSourcePath(code), sourcePath)
+	contents, err := os.ReadFile(sourcePath)
+	require.NoError(t, err)
+	assert.Equal(t, code, string(contents))
+
+	// Set breakpoint and check debugInfo.
+	response = s.HandleDebugRequest(dapMessage{"seq": 3.0, "command": "setBreakpoints",
+		"arguments": map[string]any{
+			"source":      map[string]any{"path": sourcePath},
+			"breakpoints": []any{map[string]any{"line": 2.0}},
+		}})
+	require.Equal(t, true, response["success"])
+	response = s.HandleDebugRequest(dapMessage{"seq": 4.0, "command": "debugInfo"})
+	body := dapMap(response, "body")
+	assert.Equal(t, true, body["isStarted"])
+	assert.Equal(t, "Murmur2", body["hashMethod"])
+	require.Len(t, body["breakpoints"], 1)
+
+	// Forwarded requests fail if no cell is being debugged.
+	response = s.HandleDebugRequest(dapMessage{"seq": 5.0, "command": "stackTrace"})
+	assert.Equal(t, false, response["success"])
+
+	// Simulates the execution of the cell as id 7: the cell line 1 ("\treturn 1") is in line 11 of main.go.
+	s.recordDebugCell(7, strings.Split(code, "\n"))
+	fileToCellIdAndLine := make([]CellIdAndLine, 20)
+	for ii := range fileToCellIdAndLine {
+		fileToCellIdAndLine[ii] = CellIdAndLine{NoCursorLine, NoCursorLine}
+	}
+	fileToCellIdAndLine[10] = CellIdAndLine{7, 1}
+	clientConn, serverConn := net.Pipe()
+	defer func() { _ = serverConn.Close() }()
+	session := newDAPSession(clientConn, nil)
+	defer session.Close()
+	s.debugger.session = session
+	s.debugger.codePath = s.CodePath()
+	s.debugger.fileToCellIdAndLine = fileToCellIdAndLine
+
+	// Fake dlv server: it checks the breakpoints and replies to the stackTrace requests.
+	go func() {
+		r := bufio.NewReader(serverConn)
+		for {
+			request, err := readDAPMessage(r)
+			if err != nil {
+				return
+			}
+			var body dapMessage
+			switch request["command"] {
+			case "setBreakpoints":
+				arguments := dapMap(request, "arguments")
+				assert.Equal(t, s.CodePath(), dapMap(arguments, "source")["path"])
+				bps := arguments["breakpoints"].([]any)
+				if assert.Len(t, bps, 1) {
+					assert.Equal(t, 11, dapInt(bps[0].(map[string]any), "line"))
+				}
+			case "stackTrace":
+				body = dapMessage{"stackFrames": []any{
+					dapMessage{"id": 1, "name": "main.f", "line": 11, "source": dapMessage{"path": s.CodePath()}},
+					dapMessage{"id": 2, "name": "runtime.main", "line": 3, "source": dapMessage{"path": "/go/proc.go"}},
+				}}
+			}
+			if err = writeDAPMessage(serverConn, newDAPResponse(request, body, nil)); err != nil {
+				return
+			}
+		}
+	}()
+
+	response = s.HandleDebugRequest(dapMessage{"seq": 6.0, "command": "setBreakpoints",
+		"arguments": map[string]any{
+			"source":      map[string]any{"path": sourcePath},
+			"breakpoints": []any{map[string]any{"line": 2.0}},
+		}})
+	require.Equal(t, true, response["success"])
+	bps := dapMap(response, "body")["breakpoints"].([]dapMessage)
+	require.Len(t, bps, 1)
+	assert.Equal(t, true, bps[0]["verified"])
+
+	response = s.HandleDebugRequest(dapMessage{"seq": 8.0, "command": "stackTrace",
+		"arguments": map[string]any{"threadId": 1.0}})
+	require.Equal(t, true, response["success"])
+	assert.Equal(t, 8, dapInt(response, "request_seq"))
+	frames := dapMap(response, "body")["stackFrames"].([]any)
+	require.Len(t, frames, 2)
+	frame := frames[0].(map[string]any)
+	assert.Equal(t, sourcePath, dapMap(frame, "source")["path"])
+	assert.Equal(t, 2, dapInt(frame, "line"))
+	frame = frames[1].(map[string]any)
+	assert.Equal(t, "/go/proc.go", dapMap(frame, "source")["path"])
+	assert.Equal(t, 3, dapInt(frame, "line"))
+}
@@ -0,0 +1,380 @@
+package goexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements structured diagnostics: the errors reported by the Go tools (`go build`, `goimports`,
+// `go get`) and the findings of `go vet` (see `%vet`), located in the cells.
+//
+// The diagnostics of an execution are included in the metadata of the "execute_reply" (under the key
+// DiagnosticsMetadataKey) if it failed, and they are sent to the front-end comms opened with the target
+// DiagnosticsCommTarget, so they can be drawn as markers in the cells -- like those of a language server.
+
+const (
+	// DiagnosticsCommTarget is the "target_name" of the comms opened by the front-end to receive the diagnostics.
+	//
+	// After every execution (and as a reply to any message sent by the front-end), the diagnostics of the last
+	// execution are sent in the data of a "comm_msg", as {"execution_count": ..., "cell_id": ..., "diagnostics":
+	// [...]}, where "cell_id" is the id of the cell executed, given by the front-end, if available.
+	// The list of diagnostics is empty if there were none, so previous markers can be cleared.
+	DiagnosticsCommTarget = "gonb_diagnostics"
+
+	// DiagnosticsMetadataKey is the key, in the metadata of the "execute_reply" of a failed execution, holding the
+	// list of diagnostics.
+	DiagnosticsMetadataKey = "gonb_diagnostics"
+)
+
+// DiagnosticSeverity is the severity of a Diagnostic.
+type DiagnosticSeverity string
+
+const (
+	// DiagnosticError is the severity of errors, that prevent the execution of the cell.
+	DiagnosticError DiagnosticSeverity = "error"
+
+	// DiagnosticWarning is the severity of the findings of `go vet`, which don't stop the execution.
+	DiagnosticWarning DiagnosticSeverity = "warning"
+)
+
+// Diagnostic is an error or warning located in a cell.
+type Diagnostic struct {
+	// ExecutionCount is the execution number of the cell, as in "Cell[3]" in the error messages, or -1 if not known.
+	ExecutionCount int `json:"execution_count"`
+
+	// CellId is the id of the cell given by the front-end (JupyterLab's "cellId" in the metadata of the
+	// "execute_request"), if available.
+	CellId string `json:"cell_id,omitempty"`
+
+	// File is set if the declaration was loaded from a file with `%load`: Line is then a line of this file.
+	File string `json:"file,omitempty"`
+
+	// Line and Column (in bytes) of the diagnostic in the cell, both starting from 0, as in the
+	// Language Server Protocol. Column is -1 if the diagnostic is in code generated by GoNB in the line,
+	// e.g. when a trailing expression is wrapped to display its result.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+
+	Severity DiagnosticSeverity `json:"severity"`
+	Message  string             `json:"message"`
+
+	// Code identifies the kind of diagnostic, if available: for `go vet`, it's the name of the analyzer
+	// (e.g. "printf"). The Go compiler doesn't report codes.
+	Code string `json:"code,omitempty"`
+}
+
+// String implements fmt.Stringer, in the same format used by the error reports.
+func (d Diagnostic) String() string {
+	var location string
+	switch {
+	case d.File != "":
+		location = fmt.Sprintf("%s:%d", d.File, d.Line+1)
+	case d.ExecutionCount != -1:
+		location = fmt.Sprintf("Cell[%d]: Line %d", d.ExecutionCount, d.Line+1)
+	default:
+		location = fmt.Sprintf("Cell Line %d", d.Line+1)
+	}
+	if d.Code != "" {
+		return fmt.Sprintf("%s: %s (%s)", location, d.Message, d.Code)
+	}
+	return fmt.Sprintf("%s: %s", location, d.Message)
+}
+
+// diagnosticsInfo holds the diagnostics of the current (or last) execution.
+// It is accessed concurrently by the comms handlers, hence the mutex.
+type diagnosticsInfo struct {
+	mu          sync.Mutex
+	diagnostics []Diagnostic
+
+	// executionCount and cellId identify the execution the diagnostics belong to.
+	executionCount int
+	cellId         string
+
+	// comms holds the ids of the open comms with the target DiagnosticsCommTarget.
+	comms Set[string]
+}
+
+func newDiagnosticsInfo() *diagnosticsInfo {
+	return &diagnosticsInfo{executionCount: -1, comms: MakeSet[string]()}
+}
+
+// ResetDiagnostics discards the diagnostics of the previous execution. It is called by the dispatcher before
+// executing a cell.
+func (s *State) ResetDiagnostics(msg kernel.Message) {
+	s.diagnostics.mu.Lock()
+	defer s.diagnostics.mu.Unlock()
+	s.diagnostics.diagnostics = nil
+	s.diagnostics.executionCount = -1
+	if msg != nil && msg.Kernel() != nil {
+		s.diagnostics.executionCount = msg.Kernel().ExecCounter
+	}
+	s.diagnostics.cellId = frontEndCellId(msg)
+}
+
+// Diagnostics returns the diagnostics of the current (or last) execution.
+func (s *State) Diagnostics() []Diagnostic {
+	s.diagnostics.mu.Lock()
+	defer s.diagnostics.mu.Unlock()
+	return slices.Clone(s.diagnostics.diagnostics)
+}
+
+// addDiagnostics to the ones of the current execution.
+func (s *State) addDiagnostics(diagnostics []Diagnostic) {
+	s.diagnostics.mu.Lock()
+	defer s.diagnostics.mu.Unlock()
+	s.diagnostics.diagnostics = append(s.diagnostics.diagnostics, diagnostics...)
+}
+
+// locateDiagnostics sets the CellId of the diagnostics: the one of the current execution, or the one recorded
+// for the execution of other cells (see recordDependencies).
+func (s *State) locateDiagnostics(diagnostics []*Diagnostic) {
+	s.diagnostics.mu.Lock()
+	executionCount, cellId := s.diagnostics.executionCount, s.diagnostics.cellId
+	s.diagnostics.mu.Unlock()
+	s.stale.mu.Lock()
+	defer s.stale.mu.Unlock()
+	for _, d := range diagnostics {
+		if d.ExecutionCount == executionCount {
+			d.CellId = cellId
+		} else if deps, found := s.stale.cells[d.ExecutionCount]; found {
+			d.CellId = deps.frontEndCellId
+		}
+	}
+}
+
+// PublishDiagnostics sends the diagnostics of the last execution to the open comms with the target
+// DiagnosticsCommTarget.
+func (s *State) PublishDiagnostics(msg kernel.Message) {
+	s.diagnostics.mu.Lock()
+	commIds := SortedKeys(s.diagnostics.comms)
+	s.diagnostics.mu.Unlock()
+	for _, commId := range commIds {
+		if err := s.publishDiagnosticsTo(msg, commId); err != nil {
+			klog.Warningf("Failed to send the diagnostics to the front-end: %+v", err)
+		}
+	}
+}
+
+// publishDiagnosticsTo sends the diagnostics of the last execution to the comm commId.
+func (s *State) publishDiagnosticsTo(msg kernel.Message, commId string) error {
+	s.diagnostics.mu.Lock()
+	diagnostics := slices.Clone(s.diagnostics.diagnostics)
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	data := map[string]any{
+		"execution_count": s.diagnostics.executionCount,
+		"diagnostics":     diagnostics,
+	}
+	if s.diagnostics.cellId != "" {
+		data["cell_id"] = s.diagnostics.cellId
+	}
+	s.diagnostics.mu.Unlock()
+	return msg.Publish("comm_msg", map[string]any{
+		"comm_id": commId,
+		"data":    data,
+	})
+}
+
+// OpenDiagnosticsComm registers a comm opened by the front-end with the target DiagnosticsCommTarget,
+// and sends it the diagnostics of the last execution.
+func (s *State) OpenDiagnosticsComm(msg kernel.Message, commId string) error {
+	s.diagnostics.mu.Lock()
+	s.diagnostics.comms.Insert(commId)
+	s.diagnostics.mu.Unlock()
+	return s.publishDiagnosticsTo(msg, commId)
+}
+
+// IsDiagnosticsComm returns whether commId was opened with the target DiagnosticsCommTarget.
+func (s *State) IsDiagnosticsComm(commId string) bool {
+	s.diagnostics.mu.Lock()
+	defer s.diagnostics.mu.Unlock()
+	return s.diagnostics.comms.Has(commId)
+}
+
+// HandleDiagnosticsCommMsg handles a message sent by the front-end: it replies with the diagnostics of the
+// last execution.
+func (s *State) HandleDiagnosticsCommMsg(msg kernel.Message, commId string) error {
+	return s.publishDiagnosticsTo(msg, commId)
+}
+
+// CloseDiagnosticsComm unregisters a comm with the target DiagnosticsCommTarget.
+func (s *State) CloseDiagnosticsComm(commId string) {
+	s.diagnostics.mu.Lock()
+	defer s.diagnostics.mu.Unlock()
+	s.diagnostics.comms.Delete(commId)
+}
+
+// cellColumn maps the column col (in bytes, starting from 0) of a line of the generated code to the line of the
+// cell (or loaded file) it came from.
+//
+// GoNB rewrites some lines of the cells: e.g. it wraps trailing expressions with `gonbDisplayResult(...)`, the
+// values of `%persist` variables, or injects code at the start of `func main()`. So the generated line is compared
+// to the one in the cell, and col is shifted by the position of the cell text in it. It returns -1 if col
+// falls in the code inserted by GoNB, or if the cell text is not found in the generated line.
+func (s *State) cellColumn(cell CellIdAndLine, generatedLine string, col int) int {
+	lines, found := s.sourceLines[cell.Id]
+	if !found || cell.Line < 0 || cell.Line >= len(lines) {
+		return col
+	}
+	cellLine := lines[cell.Line]
+	if generatedLine == cellLine {
+		return col
+	}
+	prefixLen := 0
+	for prefixLen < min(len(generatedLine), len(cellLine)) && generatedLine[prefixLen] == cellLine[prefixLen] {
+		prefixLen++
+	}
+	if col < prefixLen {
+		return col
+	}
+	// The remaining of the cell line is searched from the end, since the code inserted by GoNB is mostly a
+	// prefix (the suffixes are just closing parenthesis and braces).
+	rest := cellLine[prefixLen:]
+	offset := strings.LastIndex(generatedLine[prefixLen:], rest)
+	if rest == "" || offset == -1 {
+		return -1
+	}
+	col -= offset
+	if col < prefixLen || col > len(cellLine) {
+		return -1
+	}
+	return col
+}
+
+// vetFinding is a diagnostic reported by `go vet -json`.
+type vetFinding struct {
+	Posn    string `json:"posn"`
+	Message string `json:"message"`
+}
+
+// rePosition matches the positions reported by `go vet -json`: "<file>:<line>:<column>".
+var rePosition = regexp.MustCompile(`^(.+):(\d+):(\d+)$`)
+
+// vet runs `go vet` on the program (`%vet`), or on the package of a `%%package` cell, and reports its findings in
+// the cells as warnings: they don't stop the execution of the cell.
+func (s *State) vet(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine) {
+	start := time.Now()
+	target := "."
+	if s.cellPackage != "" {
+		target = "./" + s.cellPackage
+	}
+	cmd := exec.Command("go", "vet", "-json", target)
+	cmd.Dir = s.TempDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	klog.V(2).Infof("Executing %s", cmd)
+	output, err := cmd.Output()
+	s.recordTiming("go vet", start)
+	if err != nil {
+		klog.Warningf("Failed %q: %v\n%s", cmd, err, stderr.String())
+		return
+	}
+	diagnostics, err := s.parseVetOutput(output, fileToCellIdAndLine)
+	if err != nil {
+		klog.Warningf("Failed to parse the output of %q: %+v", cmd, err)
+		return
+	}
+	if len(diagnostics) == 0 {
+		return
+	}
+	located := make([]*Diagnostic, 0, len(diagnostics))
+	var sb strings.Builder
+	for ii := range diagnostics {
+		located = append(located, &diagnostics[ii])
+		sb.WriteString(fmt.Sprintf("go vet: %s\n", diagnostics[ii]))
+	}
+	s.locateDiagnostics(located)
+	s.addDiagnostics(diagnostics)
+	if msg != nil {
+		if err = kernel.PublishWriteStream(msg, kernel.StreamStderr, sb.String()); err != nil {
+			klog.Errorf("Failed to publish `go vet` warnings: %+v", err)
+		}
+	}
+}
+
+// parseVetOutput parses the output of `go vet -json`, and returns the findings in the code of the cells
+// (State.CodePath), mapped to the cells with fileToCellIdAndLine.
+func (s *State) parseVetOutput(output []byte, fileToCellIdAndLine []CellIdAndLine) ([]Diagnostic, error) {
+	code, err := os.ReadFile(s.CodePath())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", s.CodePath())
+	}
+	codeLines := strings.Split(string(code), "\n")
+	var diagnostics []Diagnostic
+	seen := MakeSet[Diagnostic]()
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		// Results are indexed by package and analyzer. There may be more than one result, e.g. for the
+		// package with and without its tests.
+		var results map[string]map[string]json.RawMessage
+		err := decoder.Decode(&results)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode `go vet -json` output")
+		}
+		for _, pkg := range SortedKeys(results) {
+			for _, analyzer := range SortedKeys(results[pkg]) {
+				var findings []vetFinding
+				if err = json.Unmarshal(results[pkg][analyzer], &findings); err != nil {
+					// Analyzers that fail report an object with an "error" instead.
+					klog.V(1).Infof("`go vet` analyzer %q: %s", analyzer, results[pkg][analyzer])
+					continue
+				}
+				for _, finding := range findings {
+					d, found := s.vetDiagnostic(finding, codeLines, fileToCellIdAndLine)
+					if !found {
+						continue
+					}
+					d.Code = analyzer
+					if seen.Has(d) {
+						continue
+					}
+					seen.Insert(d)
+					diagnostics = append(diagnostics, d)
+				}
+			}
+		}
+	}
+	return diagnostics, nil
+}
+
+// vetDiagnostic converts a finding of `go vet` to a Diagnostic, if it is located in a cell.
+// codeLines are the lines of the code in State.CodePath.
+func (s *State) vetDiagnostic(finding vetFinding, codeLines []string, fileToCellIdAndLine []CellIdAndLine) (d Diagnostic, found bool) {
+	matches := rePosition.FindStringSubmatch(finding.Posn)
+	if len(matches) != 4 || matches[1] != s.CodePath() {
+		return
+	}
+	lineNum, _ := strconv.Atoi(matches[2])
+	colNum, _ := strconv.Atoi(matches[3])
+	lineNum -= 1 // Positions start at line 1.
+	if lineNum < 0 || lineNum >= len(fileToCellIdAndLine) || lineNum >= len(codeLines) ||
+		fileToCellIdAndLine[lineNum].Line == NoCursorLine {
+		return
+	}
+	cell := fileToCellIdAndLine[lineNum]
+	d = Diagnostic{ExecutionCount: cell.Id, Line: cell.Line, Column: s.cellColumn(cell, codeLines[lineNum], max(colNum-1, 0)),
+		Severity: DiagnosticWarning, Message: finding.Message}
+	if filePath, isLoaded := s.LoadedFile(cell.Id); isLoaded {
+		d.File = filePath
+	}
+	return d, true
+}
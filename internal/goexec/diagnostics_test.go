@@ -0,0 +1,136 @@
+package goexec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnostics(t *testing.T) {
+	s := newEmptyStateWithRawError(t, true)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.GoModInit())
+	s.ResetDiagnostics(nil)
+
+	// Compile a cell with an undefined name: `go build` doesn't need `goimports`, the cell imports what it uses.
+	cell := "import (\n\t\"flag\"\n\t\"fmt\"\n)\n%%\nfmt.Println(undefinedName)"
+	_, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 3, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	err = s.Compile(nil, fileToCellIdAndLine)
+	require.Error(t, err)
+	var nbErr *GonbError
+	require.True(t, errors.As(err, &nbErr), "Expected a *GonbError, got %T", err)
+	diagnostics := nbErr.Diagnostics()
+	require.Len(t, diagnostics, 1)
+	d := diagnostics[0]
+	assert.Equal(t, 3, d.ExecutionCount)
+	assert.Equal(t, 5, d.Line)
+	assert.Equal(t, 12, d.Column)
+	assert.Equal(t, DiagnosticError, d.Severity)
+	assert.Contains(t, d.Message, "undefined: undefinedName")
+	assert.Equal(t, diagnostics, s.Diagnostics())
+
+	// A new execution discards the diagnostics of the previous one.
+	s.ResetDiagnostics(nil)
+	assert.Empty(t, s.Diagnostics())
+
+	// Findings of `go vet`: only the ones located in the cells are kept, and duplicates (e.g. of the package
+	// with and without its tests) are dropped.
+	vetOutput := fmt.Sprintf(`{
+	"gonb": {
+		"printf": [
+			{"posn": "%[1]s:%[2]d:2", "message": "fmt.Printf format %%d has arg \"x\" of wrong type string"},
+			{"posn": "/some/other/file.go:10:2", "message": "not in a cell"}
+		],
+		"copylocks": {"error": "analysis failed"}
+	}
+}
+{
+	"gonb [gonb.test]": {
+		"printf": [
+			{"posn": "%[1]s:%[2]d:2", "message": "fmt.Printf format %%d has arg \"x\" of wrong type string"}
+		]
+	}
+}
+`, s.CodePath(), mainLine(t, fileToCellIdAndLine, 3, 5)+1)
+	diagnostics, err = s.parseVetOutput([]byte(vetOutput), fileToCellIdAndLine)
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 1)
+	d = diagnostics[0]
+	assert.Equal(t, 3, d.ExecutionCount)
+	assert.Equal(t, 5, d.Line)
+	assert.Equal(t, 1, d.Column)
+	assert.Equal(t, DiagnosticWarning, d.Severity)
+	assert.Equal(t, "printf", d.Code)
+	assert.Equal(t, "Cell[3]: Line 6: fmt.Printf format %d has arg \"x\" of wrong type string (printf)", d.String())
+
+	// Errors in a trailing expression, wrapped to display its result, are located in the cell.
+	useLocalGonb(t, s)
+	cell = "import \"flag\"\n%%\nx := 1\n  undefinedX + x"
+	_, _, _, fileToCellIdAndLine, err = s.parseLinesAndComposeMain(nil, 4, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	err = s.Compile(nil, fileToCellIdAndLine)
+	require.Error(t, err)
+	require.True(t, errors.As(err, &nbErr), "Expected a *GonbError, got %T", err)
+	diagnostics = nbErr.Diagnostics()
+	require.Len(t, diagnostics, 1)
+	assert.Contains(t, diagnostics[0].Message, "undefined: undefinedX")
+	assert.Equal(t, 3, diagnostics[0].Line)
+	assert.Equal(t, 2, diagnostics[0].Column)
+}
+
+func TestCellColumn(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	s.sourceLines[1] = []string{
+		"var counter = 10",
+		"func main() { x := 1",
+		"%%",
+		"\tstrings.ToUpper(s)",
+	}
+	for _, testCase := range []struct {
+		line          int
+		generatedLine string
+		col, want     int
+	}{
+		{0, "var counter = 10", 14, 14},
+		{3, "\tgonbDisplayResult(strings.ToUpper(s))", 19, 1},
+		{3, "\tgonbDisplayResult(strings.ToUpper(s))", 35, 17},
+		{3, "\tgonbDisplayResult(strings.ToUpper(s))", 5, -1},
+		{0, "var counter = gonbPersistLoad(\"counter\", func() int { return 10 })", 4, 4},
+		{0, "var counter = gonbPersistLoad(\"counter\", func() int { return 10 })", 61, 14},
+		{0, "var counter = gonbPersistLoad(\"counter\", func() int { return 10 })", 20, -1},
+		{1, "func main() { defer gonbPersistSave(); x := 1", 39, 14},
+		{1, "func main() { defer gonbPersistSave(); x := 1", 20, -1},
+		{2, "func main() {", 5, -1},
+	} {
+		cell := CellIdAndLine{Id: 1, Line: testCase.line}
+		assert.Equalf(t, testCase.want, s.cellColumn(cell, testCase.generatedLine, testCase.col),
+			"column %d of %q", testCase.col, testCase.generatedLine)
+	}
+
+	// Lines of unknown cells are not changed.
+	assert.Equal(t, 5, s.cellColumn(CellIdAndLine{Id: 2, Line: 0}, "gonbDisplayResult(x)", 5))
+}
+
+// mainLine returns the line (starting from 0) of `main.go` of the given cell line.
+func mainLine(t *testing.T, fileToCellIdAndLine []CellIdAndLine, cellId, cellLine int) int {
+	for ii, cell := range fileToCellIdAndLine {
+		if cell.Id == cellId && cell.Line == cellLine {
+			return ii
+		}
+	}
+	t.Fatalf("Cell[%d] line %d not found in main.go", cellId, cellLine)
+	return -1
+}
@@ -0,0 +1,272 @@
+package goexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/version"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the saving and restoring of the module environment of the notebook (`go.mod`, `go.sum`
+// and the versions of the tools), so the same notebook resolves the same module versions later on.
+//
+// The environment is recorded in the notebook metadata under EnvironmentMetadataKey by `gonb run`, and in the
+// metadata of the output of `%save_env`, since Jupyter doesn't allow the kernel to change the notebook metadata.
+// Front-ends can also request it or restore it through comms opened with the target EnvironmentCommTarget.
+
+const (
+	// EnvironmentMetadataKey is the key, under the "gonb" object of the notebook metadata (or of the metadata of
+	// the output of `%save_env`), holding the saved Environment.
+	EnvironmentMetadataKey = "environment"
+
+	// EnvironmentCommTarget is the "target_name" of the comms opened by the front-end to save or restore the
+	// environment.
+	//
+	// Messages with the field "restore" in the data (holding an Environment) restore it. Any message is replied
+	// with the current environment in the field "environment" of the data, plus the list of warnings of the
+	// restoration in the field "warnings", if any.
+	EnvironmentCommTarget = "gonb_environment"
+)
+
+// Environment of the notebook, saved with `%save_env` and restored with `%restore_env`.
+type Environment struct {
+	// GoMod and GoSum are the contents of `go.mod` and `go.sum`.
+	GoMod string `json:"go_mod"`
+	GoSum string `json:"go_sum,omitempty"`
+
+	// GoVersion is the version of the Go toolchain, as reported by `go env GOVERSION`.
+	GoVersion string `json:"go_version"`
+
+	// GonbVersion is the version of GoNB (version.AppVersion).
+	GonbVersion string `json:"gonb_version"`
+}
+
+// environmentComms holds the ids of the comms opened with the target EnvironmentCommTarget.
+// It is accessed concurrently by the comms handlers, hence the mutex.
+type environmentComms struct {
+	mu  sync.Mutex
+	ids Set[string]
+}
+
+func newEnvironmentComms() *environmentComms {
+	return &environmentComms{ids: MakeSet[string]()}
+}
+
+var goVersion string
+
+// GoVersion returns the version of the Go toolchain used to compile the cells, as reported by `go env GOVERSION`.
+func GoVersion() (string, error) {
+	if goVersion != "" {
+		return goVersion, nil
+	}
+	cmd := exec.Command("go", "env", "GOVERSION")
+	klog.V(1).Infof("Executing %q", cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to find the Go version")
+	}
+	goVersion = strings.TrimSpace(string(output))
+	return goVersion, nil
+}
+
+// SaveEnvironment returns the current environment of the notebook.
+func (s *State) SaveEnvironment() (*Environment, error) {
+	goMod, err := os.ReadFile(path.Join(s.TempDir, "go.mod"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read go.mod")
+	}
+	goSum, err := os.ReadFile(path.Join(s.TempDir, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read go.sum")
+	}
+	env := &Environment{
+		GoMod:       string(goMod),
+		GoSum:       string(goSum),
+		GonbVersion: version.AppVersion.Version,
+	}
+	if env.GoVersion, err = GoVersion(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// RestoreEnvironment replaces `go.mod` and `go.sum` with the ones in env, and re-applies the module versions
+// pinned with `%require`.
+//
+// It returns warnings if the versions of the Go toolchain or of GoNB differ from the ones where env was saved.
+func (s *State) RestoreEnvironment(env *Environment) (warnings []string, err error) {
+	goModPath := path.Join(s.TempDir, "go.mod")
+	modFile, err := modfile.Parse(goModPath, []byte(env.GoMod), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid go.mod in the saved environment")
+	}
+	// The module name is unique to each kernel.
+	if err = modFile.AddModuleStmt(s.Package); err != nil {
+		return nil, errors.Wrapf(err, "failed to set the module name in go.mod")
+	}
+	if err = s.writeGoMod(modFile); err != nil {
+		return nil, err
+	}
+	goSumPath := path.Join(s.TempDir, "go.sum")
+	if env.GoSum == "" {
+		err = os.Remove(goSumPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to remove %q", goSumPath)
+		}
+	} else if err = os.WriteFile(goSumPath, []byte(env.GoSum), 0600); err != nil {
+		return nil, errors.Wrapf(err, "failed to write %q", goSumPath)
+	}
+	if _, err = s.applyRequirements(); err != nil {
+		return nil, err
+	}
+
+	current, err := GoVersion()
+	if err != nil {
+		return nil, err
+	}
+	if env.GoVersion != "" && env.GoVersion != current {
+		warnings = append(warnings, fmt.Sprintf("the environment was saved with Go version %s, but %s is being used",
+			env.GoVersion, current))
+	}
+	if env.GonbVersion != "" && env.GonbVersion != version.AppVersion.Version {
+		warnings = append(warnings, fmt.Sprintf("the environment was saved with GoNB version %s, but %s is being used",
+			env.GonbVersion, version.AppVersion.Version))
+	}
+	return warnings, nil
+}
+
+// AddPendingWarnings adds warnings to be published (to the stderr) at the start of the next execution.
+// It is used for warnings found when there is no message to reply to, e.g. when restoring the environment
+// at the start of the kernel.
+func (s *State) AddPendingWarnings(warnings ...string) {
+	s.pendingWarnings = append(s.pendingWarnings, warnings...)
+}
+
+// PublishPendingWarnings publishes and clears the warnings added with AddPendingWarnings.
+func (s *State) PublishPendingWarnings(msg kernel.Message) {
+	if len(s.pendingWarnings) == 0 {
+		return
+	}
+	var sb strings.Builder
+	for _, warning := range s.pendingWarnings {
+		sb.WriteString(fmt.Sprintf("Warning: %s\n", warning))
+	}
+	s.pendingWarnings = nil
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStderr, sb.String()); err != nil {
+		klog.Errorf("Failed to publish warnings: %+v", err)
+	}
+}
+
+// PublishEnvironment sends env (and the warnings of its restoration, if any) to the open comms of the
+// EnvironmentCommTarget.
+func (s *State) PublishEnvironment(msg kernel.Message, env *Environment, warnings []string) error {
+	s.envComms.mu.Lock()
+	commIds := SortedKeys(s.envComms.ids)
+	s.envComms.mu.Unlock()
+	for _, commId := range commIds {
+		if err := publishEnvironmentTo(msg, commId, env, warnings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishEnvironmentTo sends env to the given comm.
+func publishEnvironmentTo(msg kernel.Message, commId string, env *Environment, warnings []string) error {
+	data := map[string]any{"environment": env}
+	if len(warnings) > 0 {
+		data["warnings"] = warnings
+	}
+	return msg.Publish("comm_msg", map[string]any{
+		"comm_id": commId,
+		"data":    data,
+	})
+}
+
+// OpenEnvironmentComm registers a comm opened by the front-end with the target EnvironmentCommTarget,
+// and sends it the current environment.
+func (s *State) OpenEnvironmentComm(msg kernel.Message, commId string) error {
+	s.envComms.mu.Lock()
+	s.envComms.ids.Insert(commId)
+	s.envComms.mu.Unlock()
+	env, err := s.SaveEnvironment()
+	if err != nil {
+		return err
+	}
+	return publishEnvironmentTo(msg, commId, env, nil)
+}
+
+// IsEnvironmentComm returns whether commId was opened with the target EnvironmentCommTarget.
+func (s *State) IsEnvironmentComm(commId string) bool {
+	s.envComms.mu.Lock()
+	defer s.envComms.mu.Unlock()
+	return s.envComms.ids.Has(commId)
+}
+
+// HandleEnvironmentCommMsg handles a message sent through a comm of the EnvironmentCommTarget: if it has
+// an environment in the field "restore", it is restored. It replies with the current environment.
+func (s *State) HandleEnvironmentCommMsg(msg kernel.Message, commId string, data map[string]any) error {
+	var warnings []string
+	if restore, found := data["restore"]; found {
+		env, err := EnvironmentFromJSON(restore)
+		if err != nil {
+			return err
+		}
+		if warnings, err = s.RestoreEnvironment(env); err != nil {
+			return err
+		}
+	}
+	env, err := s.SaveEnvironment()
+	if err != nil {
+		return err
+	}
+	return publishEnvironmentTo(msg, commId, env, warnings)
+}
+
+// CloseEnvironmentComm unregisters a comm of the EnvironmentCommTarget.
+func (s *State) CloseEnvironmentComm(commId string) {
+	s.envComms.mu.Lock()
+	defer s.envComms.mu.Unlock()
+	s.envComms.ids.Delete(commId)
+}
+
+// NotebookEnvironment returns the environment recorded in the metadata of the last output of `%save_env` in
+// the notebook or, if there is none, the one recorded in the notebook metadata. It returns nil if there is none.
+func NotebookEnvironment(nb *ipynb.Notebook) (*Environment, error) {
+	value := nb.OutputsGonbMetadata(EnvironmentMetadataKey)
+	if value == nil {
+		value = nb.GonbMetadata(EnvironmentMetadataKey)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return EnvironmentFromJSON(value)
+}
+
+// EnvironmentFromJSON converts the decoded JSON value of an Environment (as found in the notebook metadata, or in
+// comm messages) to an Environment.
+func EnvironmentFromJSON(value any) (*Environment, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid environment")
+	}
+	env := &Environment{}
+	if err = json.Unmarshal(encoded, env); err != nil {
+		return nil, errors.Wrapf(err, "invalid environment")
+	}
+	if env.GoMod == "" {
+		return nil, errors.Errorf("invalid environment: missing go.mod")
+	}
+	return env, nil
+}
@@ -0,0 +1,76 @@
+package goexec
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	goSumPath := path.Join(s.TempDir, "go.sum")
+
+	require.NoError(t, s.Require("github.com/janpfeifer/must@v0.2.0"))
+	goSum := "github.com/janpfeifer/must v0.2.0 h1:fake=\n"
+	require.NoError(t, os.WriteFile(goSumPath, []byte(goSum), 0600))
+	env, err := s.SaveEnvironment()
+	require.NoError(t, err)
+	assert.Contains(t, env.GoMod, "require github.com/janpfeifer/must v0.2.0\n")
+	assert.Equal(t, goSum, env.GoSum)
+	assert.NotEmpty(t, env.GoVersion)
+
+	// Restore the environment in a fresh go.mod, saved by another kernel, with a different toolchain.
+	require.NoError(t, s.Drop("github.com/janpfeifer/must"))
+	require.NoError(t, os.Remove(goSumPath))
+	env.GoMod = "module gonb_other\n\ngo 1.23\n\nrequire github.com/janpfeifer/must v0.2.0\n"
+	env.GoVersion = "go1.0"
+	warnings, err := s.RestoreEnvironment(env)
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "go1.0")
+	goMod, err := os.ReadFile(path.Join(s.TempDir, "go.mod"))
+	require.NoError(t, err)
+	assert.Equal(t, "module "+s.Package+"\n\ngo 1.23\n\nrequire github.com/janpfeifer/must v0.2.0\n", string(goMod))
+	contents, err := os.ReadFile(goSumPath)
+	require.NoError(t, err)
+	assert.Equal(t, goSum, string(contents))
+
+	_, err = s.RestoreEnvironment(&Environment{GoMod: "invalid go.mod"})
+	require.Error(t, err)
+}
+
+func TestNotebookEnvironment(t *testing.T) {
+	nb := ipynb.New()
+	env, err := NotebookEnvironment(nb)
+	require.NoError(t, err)
+	assert.Nil(t, env)
+
+	nb.SetGonbMetadata(EnvironmentMetadataKey, map[string]any{"go_mod": "module a\n", "go_version": "go1.0"})
+	env, err = NotebookEnvironment(nb)
+	require.NoError(t, err)
+	assert.Equal(t, &Environment{GoMod: "module a\n", GoVersion: "go1.0"}, env)
+
+	// The output of `%save_env` takes precedence.
+	output, err := json.Marshal(map[string]any{
+		"output_type": "display_data",
+		"data":        map[string]any{"text/html": "Environment"},
+		"metadata":    map[string]any{"gonb": map[string]any{"environment": map[string]any{"go_mod": "module b\n"}}},
+	})
+	require.NoError(t, err)
+	nb.Cells = append(nb.Cells, &ipynb.Cell{CellType: "code", Source: "%save_env", Outputs: []json.RawMessage{output}})
+	env, err = NotebookEnvironment(nb)
+	require.NoError(t, err)
+	assert.Equal(t, &Environment{GoMod: "module b\n"}, env)
+
+	nb.SetGonbMetadata(EnvironmentMetadataKey, nil)
+	assert.NotContains(t, nb.Metadata, ipynb.GonbMetadataKey)
+}
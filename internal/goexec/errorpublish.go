@@ -84,6 +84,16 @@ var templateErrorReport = template.Must(template.New("error_report").Parse(`
 // used to report errors.
 func (s *State) DisplayErrorWithContext(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine, errorMsg string, err error) error {
 	nbErr := newGonbErrors(s, fileToCellIdAndLine, errorMsg, err)
+	if nbErr != nil {
+		var located []*Diagnostic
+		for _, line := range nbErr.Lines {
+			if line.diagnostic != nil {
+				located = append(located, line.diagnostic)
+			}
+		}
+		s.locateDiagnostics(located)
+		s.addDiagnostics(nbErr.Diagnostics())
+	}
 	if s.rawError {
 		return nbErr
 	} else {
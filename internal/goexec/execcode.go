@@ -16,6 +16,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // cellExecParams are the parameters of ExecuteCell, packaged so they
@@ -26,6 +27,9 @@ type cellExecParams struct {
 	lines     []string
 	skipLines Set[int]
 	done      *LatchWithValue[error]
+
+	// packageName is set for `%%package` cells, see State.ExecutePackageCell.
+	packageName string
 }
 
 // ExecuteCell takes the contents of a cell, parses it, merges new declarations with the ones
@@ -59,7 +63,12 @@ func (s *State) serializeExecuteCell() {
 		select {
 		case params := <-s.cellExecChan:
 			// New execution request: execute it, and report back error in the params.done latch.
-			err := s.executeCellImpl(params.msg, params.cellId, params.lines, params.skipLines)
+			var err error
+			if params.packageName != "" {
+				err = s.executePackageCellImpl(params.msg, params.cellId, params.packageName, params.lines)
+			} else {
+				err = s.executeCellImpl(params.msg, params.cellId, params.lines, params.skipLines)
+			}
 			params.done.Trigger(err)
 
 		case <-stopC:
@@ -76,6 +85,7 @@ func (s *State) serializeExecuteCell() {
 func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string, skipLines Set[int]) error {
 	// Makes sure at exit state is reset of any "one-shot" state.
 	defer s.PostExecuteCell()
+	defer s.publishTimings(msg)
 
 	klog.V(1).Infof("ExecuteCell: %q", lines)
 
@@ -83,6 +93,9 @@ func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string,
 	if s.CellIsTest && s.CellIsWasm {
 		return errors.Errorf("Cannot execute test in a %%wasm cell. Please, choose either `%%wasm` or `%%test`.")
 	}
+	if s.CellProfile != ProfileNone && (s.CellIsTest || s.CellIsWasm) {
+		return errors.Errorf("Profiling (`%%pprof` or `%%trace`) is not supported in `%%test` or `%%wasm` cells.")
+	}
 
 	// Runs AutoTrack: makes sure redirects in go.mod and use clauses in go.work are tracked.
 	err := s.AutoTrack()
@@ -91,13 +104,19 @@ func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string,
 	}
 
 	klog.V(2).Infof("ExecuteCell: after AutoTrack")
+	s.recordDebugCell(cellId, lines)
 
+	previousDecls := s.Definitions
 	updatedDecls, mainDecl, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(msg, cellId, lines, skipLines, NoCursor)
 	if err != nil {
 		klog.Infof("goexec.ExecuteCell() failed to parse the cell: %+v", err)
 		return err
 	}
 	klog.V(2).Infof("ExecuteCell: after s.parseLinesAndComposeMain()")
+	mergeReport := s.mergeReport(msg, cellId, previousDecls, updatedDecls)
+	if err = s.checkStrict(mergeReport); err != nil {
+		return err
+	}
 
 	// ProgramExecutor `goimports` (or the code that implements it) -- it updates `updatedDecls` with
 	// the new imports, if there are any.
@@ -109,20 +128,48 @@ func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string,
 		klog.Infof("goexec.ExecuteCell() failed to run `go imports` and `go get`: %+v", err)
 		return err
 	}
+	if err = s.displayTrailingCall(); err != nil {
+		return err
+	}
 
 	// And then compile it.
-	if err := s.Compile(msg, fileToCellIdAndLine); err != nil {
+	start := time.Now()
+	if err := s.compile(msg, cellId, fileToCellIdAndLine); err != nil {
 		klog.Infof("goexec.ExecuteCell() failed to compile cell: %+v", err)
 		return err
 	}
+	if moved, _ := s.IncrementalStats(); moved > 0 {
+		s.recordTiming("Compile (incremental)", start)
+	} else {
+		s.recordTiming("Compile", start)
+	}
 
 	klog.V(2).Infof("ExecuteCell: after s.Compile()")
+	if s.Vet && !s.CellIsWasm {
+		s.vet(msg, fileToCellIdAndLine)
+	}
 
 	// Compilation successful: save merged declarations into current State.
 	s.Definitions = updatedDecls
+	publishMergeReport(msg, mergeReport)
+	s.recordExport(cellId, mainDecl)
+	s.recordDependencies(msg, cellId, mainDecl)
+	s.persistPreExecute(msg, updatedDecls)
 
 	// Execute compiled code.
-	return s.Execute(msg, fileToCellIdAndLine)
+	if err = s.removeProfile(); err != nil {
+		return err
+	}
+	if err = s.removeVarsJson(); err != nil {
+		return err
+	}
+	start = time.Now()
+	err = s.Execute(msg, fileToCellIdAndLine)
+	s.recordTiming("Execute", start)
+	s.publishProfile(msg)
+	s.updateVariables(msg, err == nil)
+	s.PublishStaleCells(msg)
+	return err
 }
 
 // PostExecuteCell reset state that is valid only for the duration of a cell.
@@ -140,6 +187,9 @@ func (s *State) PostExecuteCell() {
 	s.CellHasBenchmarks = false
 	s.CellIsWasm = false
 	s.WasmDivId = ""
+	s.CellTiming = false
+	s.CellProfile = ProfileNone
+	s.CellConfirmed = false
 	if s.CaptureFile != nil {
 		err := s.CaptureFile.Close()
 		if err != nil {
@@ -147,6 +197,7 @@ func (s *State) PostExecuteCell() {
 		}
 		s.CaptureFile = nil
 	}
+	s.CaptureReport = nil
 }
 
 // BinaryPath is the path to the generated binary file.
@@ -160,14 +211,27 @@ const (
 )
 
 // CodePath is the path to where the code is going to be saved. Either `main.go` or `main_test.go` file.
+//
+// For `%%package` cells, it is the `main.go` in the directory of the package, see State.PackageDir.
 func (s *State) CodePath() string {
 	name := MainGo
 	if s.CellIsTest {
 		name = MainTestGo
 	}
+	if s.cellPackage != "" {
+		return path.Join(s.PackageDir(s.cellPackage), name)
+	}
 	return path.Join(s.TempDir, name)
 }
 
+// codePackageName is the name of the package of the code being composed: "main", except for `%%package` cells.
+func (s *State) codePackageName() string {
+	if s.cellPackage != "" {
+		return s.cellPackage
+	}
+	return "main"
+}
+
 // RemoveGeneratedCode removes the code files (`main.go` or `main_test.go`).
 // Usually, it is used just before creating a new version.
 func (s *State) RemoveGeneratedCode() error {
@@ -204,18 +268,26 @@ func (s *State) Execute(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine)
 
 	// Create stdout and stderr pipes that write to Jupyter stdout/stderr streams.
 	stdout := kernel.NewJupyterStreamWriter(msg, kernel.StreamStdout)
-	stderrWithAnnotator := newJupyterStackTraceMapperWriter(msg, "stderr", s.CodePath(), fileToCellIdAndLine)
+	stderrWithAnnotator := newJupyterStackTraceMapperWriter(msg, "stderr", s.CodePath(), fileToCellIdAndLine, s.loadedFiles)
 	if s.CaptureFile != nil {
 		stdout = io.MultiWriter(stdout, s.CaptureFile)
 		stderrWithAnnotator = io.MultiWriter(stderrWithAnnotator, s.CaptureFile)
 	}
 
+	if s.useSession() {
+		return s.executeInSession(msg, args, stdout, stderrWithAnnotator)
+	}
+	if s.useDebugger() {
+		return s.executeInDebugger(msg, args, stdout, stderrWithAnnotator, fileToCellIdAndLine)
+	}
+
 	err := jpyexec.New(msg, s.BinaryPath(), args...).
 		UseNamedPipes(s.Comms).
 		ExecutionCount(msg.Kernel().ExecCounter).
 		WithStdout(stdout).
 		WithStderr(stderrWithAnnotator).
 		CaptureDisplayDataOutput(s.CaptureFile).
+		WithLimits(s.Limits).
 		Exec()
 	if err != nil {
 		klog.Infof("goexec.Execute(): failed to run the compiled cell: %+v", msg)
@@ -223,6 +295,18 @@ func (s *State) Execute(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine)
 	return err
 }
 
+// warnUnenforcedLimits warns that the memory and CPU limits are not enforced when executing in the given mode.
+func warnUnenforcedLimits(msg kernel.Message, limits jpyexec.Limits, mode string) {
+	if limits.Memory == 0 && limits.CPU == 0 {
+		return
+	}
+	err := kernel.PublishWriteStream(msg, kernel.StreamStderr,
+		fmt.Sprintf("Warning: memory and CPU limits (%s) are not enforced in %s, only the timeout is.\n", limits, mode))
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+}
+
 // Compile compiles the currently generate go files in State.TempDir to a binary named State.Package.
 //
 // If errors in compilation happen, linesPos is used to adjust line numbers to their content in the
@@ -233,10 +317,28 @@ func (s *State) Compile(msg kernel.Message, fileToCellIdAndLines []CellIdAndLine
 		args = []string{"test", "-c", "-o", s.BinaryPath()}
 	} else if s.CellIsWasm {
 		args = []string{"build", "-o", path.Join(s.WasmDir, CompiledWasmName)}
+	} else if s.useSession() {
+		args = []string{"build", "-buildmode=plugin", "-o", s.sessionPluginPath()}
+	} else if s.cellPackage != "" {
+		// Packages are only built to check for errors: they are compiled into the programs that import them.
+		args = []string{"build"}
 	} else {
 		args = []string{"build", "-o", s.BinaryPath()}
+		if s.useDebugger() {
+			args = append(args, debugGCFlags)
+		}
 	}
 	args = append(args, s.GoBuildFlags...)
+	if s.useSession() {
+		files, err := s.sessionGoFiles()
+		if err != nil {
+			return err
+		}
+		args = append(args, files...)
+	}
+	if s.cellPackage != "" {
+		args = append(args, "./"+s.cellPackage)
+	}
 	cmd := exec.Command("go", args...)
 	cmd.Dir = s.TempDir
 	if s.CellIsWasm {
@@ -269,6 +371,7 @@ func (s *State) Compile(msg kernel.Message, fileToCellIdAndLines []CellIdAndLine
 func (s *State) GoImports(msg kernel.Message, decls *Declarations, mainDecl *Function, fileToCellIdAndLine []CellIdAndLine) (cursorInFile Cursor, updatedFileToCellIdAndLine []CellIdAndLine, err error) {
 	klog.V(2).Infof("GoImports():")
 	cursorInFile = NoCursor
+	start := time.Now()
 	goimportsPath, err := exec.LookPath("goimports")
 	if err != nil {
 		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, `
@@ -325,6 +428,7 @@ can install it from the notebook with:
 		return
 	}
 	klog.V(2).Infof("GoImports(): cursorInFile=%s", cursorInFile)
+	s.recordTiming("GoImports", start)
 
 	// Download missing dependencies.
 	if !s.AutoGet {
@@ -335,17 +439,21 @@ can install it from the notebook with:
 	if s.CellIsTest {
 		args = append(args, "-t")
 	}
+	start = time.Now()
 	cmd = exec.Command("go", args...)
-	cmd.Dir = s.TempDir
+	cmd.Dir = path.Dir(s.CodePath()) // `go get` without arguments works on the package of the current directory.
 	klog.V(2).Infof("Executing %s", cmd)
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		err = errors.Wrapf(err, "failed to run %q", cmd.String())
 		strOutput := fmt.Sprintf("%v\n\n%s", err, output)
 		strOutput = s.filterGoGetError(strOutput)
+		strOutput = s.filterOfflineError(strOutput)
 		err = s.DisplayErrorWithContext(msg, fileToCellIdAndLine, strOutput, err)
 		return
 	}
+	s.recordTiming("go get", start)
+	err = s.reapplyRequirements(msg)
 	return
 }
 
@@ -356,11 +464,14 @@ type jupyterStackTraceMapperWriter struct {
 	mainPath            string
 	fileToCellIdAndLine []CellIdAndLine
 	regexpMainPath      *regexp.Regexp
+	loadedFiles         map[int]string
 }
 
 // newJupyterStackTraceMapperWriter creates an io.Writer that allows for mapping of references to the `main.go`
 // to its corresponding position in a cell.
-func newJupyterStackTraceMapperWriter(msg kernel.Message, stream string, mainPath string, fileToCellIdAndLine []CellIdAndLine) io.Writer {
+//
+// loadedFiles maps the ids of declarations loaded with `%load` to their files, see State.LoadedFile.
+func newJupyterStackTraceMapperWriter(msg kernel.Message, stream string, mainPath string, fileToCellIdAndLine []CellIdAndLine, loadedFiles map[int]string) io.Writer {
 	r, err := regexp.Compile(fmt.Sprintf("%s:(\\d+)", regexp.QuoteMeta(mainPath)))
 	if err != nil {
 		klog.Errorf("Failed to compile expression to match %q: won't be able to map stack traces with cell Lines", mainPath)
@@ -371,6 +482,7 @@ func newJupyterStackTraceMapperWriter(msg kernel.Message, stream string, mainPat
 		mainPath:            mainPath,
 		regexpMainPath:      r,
 		fileToCellIdAndLine: fileToCellIdAndLine,
+		loadedFiles:         loadedFiles,
 	}
 }
 
@@ -398,7 +510,9 @@ func (w *jupyterStackTraceMapperWriter) Write(p []byte) (int, error) {
 		const invertColor = "\033[7m"
 		const resetColor = "\033[0m"
 		// Since line reports usually start with 1, we report cellLineNum+1
-		if cellId == -1 {
+		if filePath, found := w.loadedFiles[cellId]; found {
+			cellText = []byte(fmt.Sprintf(" %s[[ %s:%d ]]%s ", invertColor, filePath, cellLineNum+1, resetColor))
+		} else if cellId == -1 {
 			cellText = []byte(fmt.Sprintf(" %s[[ Cell Line %d ]]%s ", invertColor, cellLineNum+1, resetColor))
 		} else {
 			cellText = []byte(fmt.Sprintf(" %s[[ Cell [%d] Line %d ]]%s ", invertColor, cellId, cellLineNum+1, resetColor))
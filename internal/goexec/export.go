@@ -0,0 +1,306 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the export of the memorized declarations as a standalone Go module (`%export` and
+// `gonb export`): the declarations are rendered without any of the code GoNB generates to execute cells,
+// and `go.mod` and `go.sum` are copied from `State.TempDir`. Packages defined with `%%package` are exported
+// to their own subdirectories.
+
+// ExportOptions configure State.Export.
+type ExportOptions struct {
+	// SplitByCell writes the declarations of each cell in its own file, named after the cell id.
+	// Otherwise, everything goes to `main.go` (and `main_test.go`).
+	SplitByCell bool
+
+	// Module, if set, replaces the module path in the exported `go.mod`.
+	Module string
+
+	// Force export to a non-empty directory, overwriting files with the same name.
+	Force bool
+}
+
+// exportInfo holds the information needed to export the notebook, that is not part of the memorized declarations.
+type exportInfo struct {
+	// mainDecl is the `main()` of the last cell that defined one, excluding `%test` and `%wasm` cells.
+	mainDecl *Function
+
+	// testCells are the ids of the cells executed with `%test`: their declarations are exported to `_test.go` files.
+	testCells Set[int]
+}
+
+func newExportInfo() *exportInfo {
+	return &exportInfo{testCells: MakeSet[int]()}
+}
+
+// recordExport is called after a cell is successfully compiled, to keep track of what is needed to export it.
+func (s *State) recordExport(cellId int, mainDecl *Function) {
+	if s.CellIsTest {
+		s.export.testCells.Insert(cellId)
+		return
+	}
+	if s.CellIsWasm || mainDecl == nil || len(mainDecl.CellLines.Lines) == 0 {
+		// Cells without a `main()` get a stub one, which we don't want to export.
+		return
+	}
+	exportMain := *mainDecl // Shallow copy.
+	exportMain.ClearCursor()
+	s.export.mainDecl = &exportMain
+}
+
+// DeclareCell parses the cell and merges its declarations into State.Definitions, as if it had been executed,
+// but without compiling or executing it.
+//
+// It still runs `goimports` and `go get`, so `go.mod` is updated with the dependencies of the cell. It is used to
+// export notebooks (`gonb export`) outside of Jupyter.
+func (s *State) DeclareCell(msg kernel.Message, cellId int, lines []string, skipLines Set[int]) error {
+	defer s.PostExecuteCell()
+	updatedDecls, mainDecl, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(msg, cellId, lines, skipLines, NoCursor)
+	if err != nil {
+		return err
+	}
+	if _, _, err = s.GoImports(msg, updatedDecls, mainDecl, fileToCellIdAndLine); err != nil {
+		return err
+	}
+	s.Definitions = updatedDecls
+	s.recordExport(cellId, mainDecl)
+	return nil
+}
+
+// DeclarePackageCell is the equivalent of DeclareCell for a `%%package <name>` cell: its declarations are merged
+// with the ones of the package name, which is written but not compiled.
+//
+// The first line of lines is the `%%package` command.
+func (s *State) DeclarePackageCell(msg kernel.Message, cellId int, name string, lines []string) (err error) {
+	if err = validatePackageName(name); err != nil {
+		return err
+	}
+	s.cellPackage = name
+	defer func() {
+		s.cellPackage = ""
+		if err != nil {
+			s.restorePackage(name)
+		}
+	}()
+	updatedDecls, fileToCellIdAndLine, err := s.parseLinesAndComposePackage(msg, cellId, lines)
+	if err != nil {
+		return err
+	}
+	if _, _, err = s.GoImports(msg, updatedDecls, nil, fileToCellIdAndLine); err != nil {
+		return err
+	}
+	code, err := os.ReadFile(s.CodePath())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the code of package %q", name)
+	}
+	s.packages[name] = &cellPackage{definitions: updatedDecls, code: code}
+	return nil
+}
+
+// Export writes the memorized declarations, the last `main()` defined and the `go.mod` and `go.sum` files as a
+// standalone Go module in dir.
+//
+// Declarations of cells executed with `%test` are written to `_test.go` files. The `func init_*()` functions are
+// exported as `func init()`. Packages defined with `%%package` are written to subdirectories of dir, and
+// their import paths are updated if opts.Module is set. Finally, `goimports` is run on the exported files, to remove unused imports.
+//
+// It returns the paths of the files written.
+func (s *State) Export(dir string, opts ExportOptions) (files []string, err error) {
+	goimportsPath, err := exec.LookPath("goimports")
+	if err != nil {
+		err = errors.Wrapf(err, "`goimports` is required to export, install it with `go install golang.org/x/tools/cmd/goimports@latest`")
+		return
+	}
+	if err = checkExportDir(dir, opts.Force); err != nil {
+		return
+	}
+	contents, err := s.exportFiles(opts)
+	if err != nil {
+		return
+	}
+	goMod, err := s.exportGoMod(opts.Module)
+	if err != nil {
+		return
+	}
+	contents["go.mod"] = goMod
+	if goSum, readErr := os.ReadFile(path.Join(s.TempDir, "go.sum")); readErr == nil {
+		contents["go.sum"] = goSum
+	}
+
+	var goFiles []string
+	for _, name := range SortedKeys(contents) {
+		filePath := path.Join(dir, name)
+		if err = os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+			err = errors.Wrapf(err, "failed to create directory for %q", filePath)
+			return
+		}
+		if err = os.WriteFile(filePath, contents[name], 0644); err != nil {
+			err = errors.Wrapf(err, "failed to write %q", filePath)
+			return
+		}
+		files = append(files, filePath)
+		if filepath.Ext(name) == ".go" {
+			goFiles = append(goFiles, name)
+		}
+	}
+
+	cmd := exec.Command(goimportsPath, append([]string{"-w"}, goFiles...)...)
+	cmd.Dir = dir
+	klog.V(2).Infof("Executing %s", cmd)
+	if output, cmdErr := cmd.CombinedOutput(); cmdErr != nil {
+		err = errors.Wrapf(cmdErr, "failed to run %q in %q:\n%s", cmd, dir, output)
+	}
+	return
+}
+
+// checkExportDir creates dir if it doesn't exist, and makes sure it is empty, unless force is set.
+func checkExportDir(dir string, force bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create directory %q", dir)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read directory %q", dir)
+	}
+	if len(entries) > 0 && !force {
+		return errors.Errorf("directory %q is not empty, use force to overwrite its files", dir)
+	}
+	return nil
+}
+
+// exportFiles renders the Go files of the export, indexed by their name.
+//
+// Every file includes all imports: the unused ones are removed by `goimports` later. The packages defined with
+// `%%package` are indexed by "<name>/main.go".
+func (s *State) exportFiles(opts ExportOptions) (map[string][]byte, error) {
+	fileName := func(cellId int) string {
+		isTest := s.export.testCells.Has(cellId)
+		switch {
+		case opts.SplitByCell && cellId > 0 && isTest:
+			return fmt.Sprintf("cell_%03d_test.go", cellId)
+		case opts.SplitByCell && cellId > 0:
+			return fmt.Sprintf("cell_%03d.go", cellId)
+		case isTest:
+			return "main_test.go"
+		default:
+			return "main.go"
+		}
+	}
+	groups := make(map[string]*Declarations)
+	group := func(cellId int) *Declarations {
+		name := fileName(cellId)
+		decls, found := groups[name]
+		if !found {
+			decls = NewDeclarations()
+			decls.Imports = s.Definitions.Imports
+			groups[name] = decls
+		}
+		return decls
+	}
+	for key, funcDecl := range s.Definitions.Functions {
+		group(funcDecl.Id).Functions[key] = funcDecl
+	}
+	for key, varDecl := range s.Definitions.Variables {
+		cellId := varDecl.Id
+		if len(varDecl.TupleDefinitions) > 0 {
+			// Tuples are rendered by their first element, so they must all be in the same file.
+			cellId = varDecl.TupleDefinitions[0].Id
+		}
+		group(cellId).Variables[key] = varDecl
+	}
+	for key, typeDecl := range s.Definitions.Types {
+		group(typeDecl.Id).Types[key] = typeDecl
+	}
+	for key, constDecl := range s.Definitions.Constants {
+		// Blocks of constants are rendered from their first element, so they must all be in the same file.
+		head := constDecl
+		for head.Prev != nil {
+			head = head.Prev
+		}
+		group(head.Id).Constants[key] = constDecl
+	}
+
+	mainDecl := s.export.mainDecl
+	if mainDecl == nil {
+		mainDecl = &Function{
+			Cursor:     NoCursor,
+			Key:        "main",
+			Name:       "main",
+			Definition: "func main() { flag.Parse() }",
+		}
+	}
+	mainFile := fileName(mainDecl.Id)
+	group(mainDecl.Id) // Makes sure the file for main exists.
+
+	files := make(map[string][]byte, len(groups))
+	for name, decls := range groups {
+		var fileMain *Function
+		if name == mainFile {
+			fileMain = mainDecl
+		}
+		var buf bytes.Buffer
+		if _, _, err := s.createCodeFromDecls(&buf, decls, fileMain); err != nil {
+			return nil, errors.WithMessagef(err, "while rendering %q", name)
+		}
+		files[name] = buf.Bytes()
+	}
+	for name, pkg := range s.packages {
+		files[path.Join(name, MainGo)] = pkg.code
+	}
+	if opts.Module != "" {
+		// Imports of the packages refer to the module path of State.TempDir.
+		oldPrefix, newPrefix := []byte(`"`+s.Package+"/"), []byte(`"`+opts.Module+"/")
+		for name, contents := range files {
+			files[name] = bytes.ReplaceAll(contents, oldPrefix, newPrefix)
+		}
+	}
+	return files, nil
+}
+
+// exportGoMod returns the contents of `go.mod` to export. If module is set, it replaces the module path.
+// Local `replace` directives with relative paths are converted to absolute paths, since the exported
+// module is in a different directory.
+func (s *State) exportGoMod(module string) ([]byte, error) {
+	goModPath := path.Join(s.TempDir, "go.mod")
+	contents, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", goModPath)
+	}
+	modFile, err := modfile.Parse(goModPath, contents, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %q", goModPath)
+	}
+	if module != "" {
+		if err = modFile.AddModuleStmt(module); err != nil {
+			return nil, errors.Wrapf(err, "failed to set module to %q", module)
+		}
+	}
+	for _, replace := range slices.Clone(modFile.Replace) {
+		if replace.New.Version != "" || filepath.IsAbs(replace.New.Path) {
+			continue
+		}
+		newPath := filepath.Join(s.TempDir, replace.New.Path)
+		if err = modFile.AddReplace(replace.Old.Path, replace.Old.Version, newPath, ""); err != nil {
+			return nil, errors.Wrapf(err, "failed to update replace rule for %q", replace.Old.Path)
+		}
+	}
+	modFile.Cleanup()
+	return modfile.Format(modFile.Syntax), nil
+}
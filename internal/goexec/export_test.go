@@ -0,0 +1,102 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportFiles(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	declare := func(cellId int, isTest bool, code string) {
+		s.CellIsTest = isTest
+		lines := strings.Split(code, "\n")
+		skipLines := MakeSet[int]()
+		for ii, line := range lines {
+			if strings.HasPrefix(line, "%test") {
+				skipLines.Insert(ii)
+			}
+		}
+		updatedDecls, mainDecl, _, _, err := s.parseLinesAndComposeMain(nil, cellId, lines, skipLines, NoCursor)
+		require.NoError(t, err)
+		s.Definitions = updatedDecls
+		s.recordExport(cellId, mainDecl)
+		s.PostExecuteCell()
+	}
+	declare(1, false, "import \"fmt\"\n\nfunc init_a() { fmt.Println(\"init\") }\n\nfunc Double(x int) int { return 2*x }")
+	declare(2, false, "%%\nfmt.Println(Double(2))")
+	declare(3, true, "%test\nfunc TestDouble(t *testing.T) {\n\tif Double(1) != 2 { t.Fail() }\n}")
+
+	files, err := s.exportFiles(ExportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"main.go", "main_test.go"}, SortedKeys(files))
+	mainGo := string(files["main.go"])
+	assert.Contains(t, mainGo, "func init() {")
+	assert.NotContains(t, mainGo, "init_a")
+	assert.Contains(t, mainGo, "func Double(x int) int")
+	assert.Contains(t, mainGo, "func main() {\n\tflag.Parse()\nfmt.Println(Double(2))")
+	assert.NotContains(t, mainGo, "TestDouble")
+	assert.NotContains(t, mainGo, "%%")
+	testGo := string(files["main_test.go"])
+	assert.Contains(t, testGo, "func TestDouble(t *testing.T)")
+	assert.NotContains(t, testGo, "%test")
+	assert.NotContains(t, testGo, "func main()")
+
+	files, err = s.exportFiles(ExportOptions{SplitByCell: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cell_001.go", "cell_002.go", "cell_003_test.go"}, SortedKeys(files))
+	assert.Contains(t, string(files["cell_001.go"]), "func Double(x int) int")
+	assert.Contains(t, string(files["cell_002.go"]), "func main() {")
+	assert.Contains(t, string(files["cell_003_test.go"]), "func TestDouble(t *testing.T)")
+
+	// Packages defined with `%%package` are exported to their subdirectory, with their import path updated.
+	s.cellPackage = "mypkg"
+	pkgDecls, _, err := s.parseLinesAndComposePackage(nil, 4, []string{"%%package mypkg", "func Triple(x int) int { return 3*x }"})
+	s.cellPackage = ""
+	require.NoError(t, err)
+	pkgCode, err := os.ReadFile(path.Join(s.PackageDir("mypkg"), MainGo))
+	require.NoError(t, err)
+	s.packages["mypkg"] = &cellPackage{definitions: pkgDecls, code: pkgCode}
+	declare(5, false, fmt.Sprintf("import \"%s\"\n%%%%\nfmt.Println(mypkg.Triple(2))", s.PackageImportPath("mypkg")))
+
+	files, err = s.exportFiles(ExportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"main.go", "main_test.go", "mypkg/main.go"}, SortedKeys(files))
+	assert.Contains(t, string(files["mypkg/main.go"]), "package mypkg")
+	assert.Contains(t, string(files["mypkg/main.go"]), "func Triple(x int) int")
+	assert.Contains(t, string(files["main.go"]), fmt.Sprintf("%q", s.PackageImportPath("mypkg")))
+
+	files, err = s.exportFiles(ExportOptions{Module: "example.com/myprogram"})
+	require.NoError(t, err)
+	assert.Contains(t, string(files["main.go"]), "\"example.com/myprogram/mypkg\"")
+	assert.NotContains(t, string(files["main.go"]), s.Package)
+}
+
+func TestExportGoMod(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	goModPath := path.Join(s.TempDir, "go.mod")
+	goMod, err := os.ReadFile(goModPath)
+	require.NoError(t, err)
+	goMod = append(goMod, []byte("\nreplace example.com/lib => ../lib\n")...)
+	require.NoError(t, os.WriteFile(goModPath, goMod, 0644))
+
+	goMod, err = s.exportGoMod("example.com/myprogram")
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "module example.com/myprogram\n")
+	assert.Contains(t, string(goMod), "example.com/lib => "+path.Join(path.Dir(s.TempDir), "lib"))
+}
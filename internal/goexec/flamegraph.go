@@ -0,0 +1,177 @@
+package goexec
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pprofTrace is one sample (or aggregated samples) of a profile, as output by `go tool pprof -traces`.
+type pprofTrace struct {
+	value float64
+	// stack of function names, starting from the leaf (where the sample was taken).
+	stack []string
+}
+
+// pprofTracesSeparator separates the traces in the output of `go tool pprof -traces`.
+const pprofTracesSeparator = "-----------+"
+
+// parsePProfTraces parses the output of `go tool pprof -traces`. Values are converted to a common
+// unit (nanoseconds or bytes) -- see parsePProfValue.
+func parsePProfTraces(output string) (traces []pprofTrace) {
+	var current *pprofTrace
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, pprofTracesSeparator) {
+			if current != nil && len(current.stack) > 0 {
+				traces = append(traces, *current)
+			}
+			current = &pprofTrace{}
+			continue
+		}
+		if current == nil || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if strings.HasSuffix(fields[0], ":") {
+			// Label line, e.g.: "bytes:  64kB".
+			continue
+		}
+		if !strings.HasPrefix(line, strings.Repeat(" ", 11)) {
+			// Line with the value followed by the leaf function.
+			value, err := parsePProfValue(fields[0])
+			if err != nil || len(fields) < 2 {
+				continue
+			}
+			current.value += value
+			current.stack = append(current.stack, strings.Join(fields[1:], " "))
+			continue
+		}
+		current.stack = append(current.stack, strings.TrimSpace(line))
+	}
+	return traces
+}
+
+// pprofUnits maps the units used by `go tool pprof` to nanoseconds or bytes.
+var pprofUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	// Longer suffixes first.
+	{"mins", 60e9}, {"hrs", 3600e9}, {"ns", 1}, {"us", 1e3}, {"µs", 1e3}, {"ms", 1e6},
+	{"kB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40}, {"PB", 1 << 50},
+	{"s", 1e9}, {"B", 1}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9},
+}
+
+// parsePProfValue parses values like "10ms", "1.06MB" or "42".
+func parsePProfValue(value string) (float64, error) {
+	for _, unit := range pprofUnits {
+		if number, found := strings.CutSuffix(value, unit.suffix); found {
+			f, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, errors.Wrapf(err, "invalid pprof value %q", value)
+			}
+			return f * unit.multiplier, nil
+		}
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid pprof value %q", value)
+	}
+	return f, nil
+}
+
+// flameNode is a node in the tree of calls of a flame graph.
+type flameNode struct {
+	name     string
+	value    float64
+	children []*flameNode
+}
+
+// child returns the child node with the given name, creating it if needed.
+func (n *flameNode) child(name string) *flameNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &flameNode{name: name}
+	n.children = append(n.children, c)
+	return c
+}
+
+// Dimensions of the flame graph.
+const (
+	flameGraphWidth     = 1000.0
+	flameGraphRowHeight = 18.0
+	flameGraphMinWidth  = 0.5 // Nodes narrower than this (in pixels) are not drawn.
+)
+
+// flameGraphSVG renders the traces as a flame graph (in the "icicle" orientation, with the root at the top)
+// in SVG. Hovering over a box shows the full function name and its share of the total.
+func flameGraphSVG(traces []pprofTrace) (string, error) {
+	root := &flameNode{name: "all"}
+	for _, trace := range traces {
+		if trace.value <= 0 {
+			continue
+		}
+		root.value += trace.value
+		node := root
+		for _, name := range slices.Backward(trace.stack) {
+			node = node.child(name)
+			node.value += trace.value
+		}
+	}
+	if root.value <= 0 {
+		return "", errors.New("profile has no samples")
+	}
+
+	var body strings.Builder
+	maxDepth := 0
+	var draw func(node *flameNode, x float64, depth int)
+	draw = func(node *flameNode, x float64, depth int) {
+		width := node.value / root.value * flameGraphWidth
+		if width < flameGraphMinWidth {
+			return
+		}
+		maxDepth = max(maxDepth, depth)
+		y := float64(depth) * flameGraphRowHeight
+		title := fmt.Sprintf("%s (%.2f%%)", node.name, 100*node.value/root.value)
+		fmt.Fprintf(&body, `<g><title>%s</title><rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" rx="2"/>`,
+			html.EscapeString(title), x, y, width, flameGraphRowHeight-1, flameColor(node.name))
+		// Approximately 7 pixels per character.
+		if maxChars := int(width / 7); maxChars >= 3 {
+			label := node.name
+			if len(label) > maxChars {
+				label = label[:maxChars-2] + ".."
+			}
+			fmt.Fprintf(&body, `<text x="%.1f" y="%.1f">%s</text>`, x+3, y+flameGraphRowHeight-5, html.EscapeString(label))
+		}
+		body.WriteString("</g>\n")
+		slices.SortFunc(node.children, func(a, b *flameNode) int { return strings.Compare(a.name, b.name) })
+		for _, c := range node.children {
+			draw(c, x, depth+1)
+			x += c.value / root.value * flameGraphWidth
+		}
+	}
+	draw(root, 0, 0)
+	height := float64(maxDepth+1) * flameGraphRowHeight
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" `+
+		`style="font-family: monospace; font-size: 11px">
+%s</svg>`, flameGraphWidth, height, flameGraphWidth, height, body.String()), nil
+}
+
+// flameColor returns a warm color for the function name, stable across renderings.
+func flameColor(name string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	v := h.Sum32()
+	r := 205 + v%50
+	g := 80 + (v>>8)%150
+	b := (v >> 16) % 55
+	return fmt.Sprintf("rgb(%d,%d,%d)", r, g, b)
+}
@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/capture"
 	"github.com/janpfeifer/gonb/internal/comms"
 	"github.com/janpfeifer/gonb/internal/goexec/goplsclient"
+	"github.com/janpfeifer/gonb/internal/history"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"github.com/pkg/errors"
 	"io"
@@ -51,9 +54,31 @@ type State struct {
 	GoBuildFlags []string // Flags to be passed to `go build`, in State.Compile.
 	AutoGet      bool     // Whether to do a "go get" before compiling, to fetch missing external modules.
 
+	// Strict refuses cells that ambiguously replace, remove or shadow memorized declarations, unless
+	// the cell is confirmed (CellConfirmed). See `%strict`, `%confirm` and MergeReport.
+	Strict bool
+
+	// Vet runs `go vet` after compiling the cells, and reports its findings as warnings. See `%vet`.
+	Vet bool
+
+	// OfflineCache is the module cache used in offline mode, or empty if not in offline mode.
+	// Set with State.SetOfflineCache.
+	OfflineCache string
+
+	// offlineSavedEnv holds the values of the environment variables changed by the offline mode, to be restored.
+	offlineSavedEnv map[string]string
+
 	// Global elements defined mapped by their keys.
 	Definitions *Declarations
 
+	// namespace is the name of the current namespace, and namespaces holds the state of the other ones.
+	// See `%ns`.
+	namespace  string
+	namespaces map[string]*namespace
+
+	// packages holds the packages defined with `%%package` cells, by name.
+	packages map[string]*cellPackage
+
 	// gopls client
 	gopls *goplsclient.Client
 
@@ -95,6 +120,21 @@ type State struct {
 	CellIsWasm                  bool
 	WasmDir, WasmUrl, WasmDivId string
 
+	// CellTiming indicates the durations of each step of the execution of the current cell are to be
+	// reported (`%time`). CellProfile is the kind of profile to collect while executing the cell
+	// (`%pprof` and `%trace`), or empty for none. Both are reset after the execution.
+	CellTiming  bool
+	CellProfile ProfileKind
+	timings     []cellTiming
+
+	// cellPackage is the name of the package defined by the current `%%package` cell, or empty for the
+	// usual cells, that are part of the main package. See State.CodePath.
+	cellPackage string
+
+	// CellConfirmed accepts the changes to the memorized declarations made by the current cell, when
+	// Strict is set (`%confirm`). It is reset after the execution.
+	CellConfirmed bool
+
 	// Comms represents the communication with the front-end.
 	Comms *comms.State
 
@@ -102,6 +142,58 @@ type State struct {
 	// executions.
 	// If nil, no output is to be captured.
 	CaptureFile io.WriteCloser
+
+	// CaptureReport is set by `%capture --format=<format>`, to write the outputs of the cell as a report.
+	// It is handled (and reset) by the dispatcher, which has access to all the outputs of the cell, including
+	// errors.
+	CaptureReport *capture.Report
+
+	// persist holds the variables marked as persistent with `%persist`.
+	persist *persistInfo
+
+	// requirements holds the pinned module versions (module path to version), see `%require`.
+	requirements map[string]string
+
+	// envComms holds the comms opened by the front-end to save or restore the environment, see `%save_env`.
+	envComms *environmentComms
+
+	// pendingWarnings are published at the start of the next execution, see State.AddPendingWarnings.
+	pendingWarnings []string
+
+	// incremental holds the state of the incremental compilation, see `%incremental`.
+	incremental incrementalInfo
+
+	// variables holds the values of the variables reported by the program, see `%vars`.
+	variables *variablesInfo
+
+	// stale holds the dependencies of the executed cells, see `%stale`.
+	stale *staleInfo
+
+	// diagnostics holds the errors and warnings located in the cells by the last execution, see Diagnostic.
+	diagnostics *diagnosticsInfo
+
+	// session holds the state of the session execution mode, see `%session`.
+	session *sessionInfo
+
+	// debugger holds the state of the debugger, see State.HandleDebugRequest.
+	debugger *debuggerInfo
+
+	// loadedFiles maps the ids used for the declarations loaded with `%load` to the path of their files.
+	loadedFiles map[int]string
+
+	// sourceLines maps the ids of the cells (and loaded files) parsed to their lines, used to locate the
+	// diagnostics in the cells, see State.cellColumn.
+	sourceLines map[int][]string
+
+	// export holds what is needed to export the notebook as a Go module, see `%export`.
+	export *exportInfo
+
+	// History of the executed cells. It is set at start up, and it is nil if history is disabled.
+	History *history.Store
+
+	// Limits to the resources used when executing cells. They can be changed with `%timeout` and `%limits`,
+	// and the defaults are set at start up.
+	Limits jpyexec.Limits
 }
 
 // Declarations is a collection of declarations that we carry over from one cell to another.
@@ -135,6 +227,20 @@ func New(k *kernel.Kernel, uniqueID string, preserveTempDir, rawError bool) (*St
 		rawError:        rawError,
 		Comms:           comms.New(),
 		cellExecChan:    make(chan *cellExecParams),
+		persist:         newPersistInfo(),
+		variables:       newVariablesInfo(),
+		stale:           newStaleInfo(),
+		diagnostics:     newDiagnosticsInfo(),
+		namespace:       DefaultNamespace,
+		namespaces:      make(map[string]*namespace),
+		packages:        make(map[string]*cellPackage),
+		requirements:    make(map[string]string),
+		envComms:        newEnvironmentComms(),
+		session:         &sessionInfo{},
+		debugger:        newDebuggerInfo(),
+		export:          newExportInfo(),
+		loadedFiles:     make(map[int]string),
+		sourceLines:     make(map[int][]string),
 	}
 
 	// Goroutine that processes incoming ExecuteCell requests.
@@ -210,6 +316,7 @@ with:
 }
 
 // GoModInit removes current `go.mod` if it already exists, and recreate it with `go mod init`.
+// Module versions pinned with `%require` are re-applied.
 func (s *State) GoModInit() error {
 	err := os.Remove(path.Join(s.TempDir, "go.mod"))
 	if err != nil && !os.IsNotExist(err) {
@@ -225,11 +332,14 @@ func (s *State) GoModInit() error {
 		klog.Errorf("Failed to run `go mod init %s`:\n%s", s.Package, output)
 		return errors.Wrapf(err, "failed to run %q", cmd.String())
 	}
-	return nil
+	// Module versions pinned with `%require` survive the re-creation of `go.mod`.
+	_, err = s.applyRequirements()
+	return err
 }
 
 // Stop stops gopls and removes temporary files and directories.
 func (s *State) Stop() error {
+	s.StopSession()
 	if s.gopls != nil {
 		s.gopls.Shutdown()
 		s.gopls = nil
@@ -410,6 +520,9 @@ type Function struct {
 
 	// Comments preceding the function, if any.
 	Comments *Comments
+
+	// Uses lists the identifiers referenced by the declaration, used to find stale cells (see State.StaleCells).
+	Uses []string
 }
 
 // Comments block definition: these are comments that precedes a declaration, like a function or variable.
@@ -432,6 +545,7 @@ type Comments struct {
 //
 // This means that if "a" is redefined, "b" and "c" disappear. And that if "b" or "c" are redefined, it will
 // yield and error, that is subtle to track.
+// The disappeared variables are listed as removed in the MergeReport displayed under the cell.
 type Variable struct {
 	Cursor
 	CellLines
@@ -442,6 +556,9 @@ type Variable struct {
 
 	// TupleDefinitions are present when multiple variables are tied to the same definition as in `var a, b, c = someFunc()`.
 	TupleDefinitions []*Variable
+
+	// Uses lists the identifiers referenced by the declaration, used to find stale cells (see State.StaleCells).
+	Uses []string
 }
 
 // TypeDecl definition, parsed from a notebook cell.
@@ -452,6 +569,9 @@ type TypeDecl struct {
 	Key            string // Same as the name here.
 	TypeDefinition string // Type definition which includes the name.
 	CursorInType   bool
+
+	// Uses lists the identifiers referenced by the declaration, used to find stale cells (see State.StaleCells).
+	Uses []string
 }
 
 // Constant represents the declaration of a constant. Because when appearing in block
@@ -465,6 +585,9 @@ type Constant struct {
 	TypeDefinition, ValueDefinition          string // Can be empty, if used as iota.
 	CursorInKey, CursorInType, CursorInValue bool
 	Next, Prev                               *Constant // Next and previous declaration in same Const block.
+
+	// Uses lists the identifiers referenced by the declaration, used to find stale cells (see State.StaleCells).
+	Uses []string
 }
 
 // Import represents an import to be included -- if not used it's automatically removed by
@@ -486,4 +609,13 @@ var reDefaultImportPathAlias = regexp.MustCompile(`^.*?(\w[\w0-9_]*)\s*$`)
 // It is connected to the special command `%reset`.
 func (s *State) Reset() {
 	s.Definitions = NewDeclarations()
+	s.persist = newPersistInfo()
+	s.export = newExportInfo()
+	s.resetVariables()
+	s.resetStale()
+	s.resetPackages()
+	s.resetLoadedFiles()
+	if err := os.RemoveAll(s.PersistDir()); err != nil {
+		klog.Errorf("Failed to remove saved persistent variables in %q: %+v", s.PersistDir(), err)
+	}
 }
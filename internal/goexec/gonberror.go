@@ -71,6 +71,18 @@ func (nbErr *GonbError) Traceback() []string {
 	return traceback
 }
 
+// Diagnostics returns the errors that could be located in the cells (or in files loaded with `%load`),
+// as structured diagnostics.
+func (nbErr *GonbError) Diagnostics() []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range nbErr.Lines {
+		if line.diagnostic != nil {
+			diagnostics = append(diagnostics, *line.diagnostic)
+		}
+	}
+	return diagnostics
+}
+
 // Name corresponds to field "ename" in Jupyter. Hardcoded in "ERROR" for now.
 func (nbErr *GonbError) Name() string {
 	return "ERROR"
@@ -106,6 +118,9 @@ type errorLine struct {
 
 	HasCellInfo bool
 	CellInfo    string
+
+	// diagnostic is set if the error could be located in a cell (HasCellInfo).
+	diagnostic *Diagnostic
 }
 
 // getTraceback renders the colored traceback sent to Jupyter for this errorLine.
@@ -158,7 +173,6 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string, fileToCellIdA
 
 	lineNum, _ := strconv.Atoi(matches[3])
 	lineNum -= 1 // Error messages start at line 1 (as opposed to 0)
-	//colNum, _ := strconv.Atoi(matches[4])
 	fromLines := lineNum - LinesForErrorContext
 	fromLines = inBetween(fromLines, 0, len(codeLines)-1)
 	toLines := lineNum + LinesForErrorContext
@@ -185,7 +199,17 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string, fileToCellIdA
 		cell := fileToCellIdAndLine[lineNum]
 		l.HasCellInfo = true
 		// Notice GoNB store Lines starting at 0, but Jupyter display Lines starting at 1, so we add 1 here.
-		if cell.Id != -1 {
+		colNum, _ := strconv.Atoi(matches[4])
+		colNum = max(colNum-1, 0)
+		if lineNum < len(codeLines) {
+			colNum = s.cellColumn(cell, codeLines[lineNum], colNum)
+		}
+		l.diagnostic = &Diagnostic{ExecutionCount: cell.Id, Line: cell.Line, Column: colNum,
+			Severity: DiagnosticError, Message: l.Message}
+		if filePath, found := s.LoadedFile(cell.Id); found {
+			l.CellInfo = fmt.Sprintf("%s:%d", filePath, cell.Line+1)
+			l.diagnostic.File = filePath
+		} else if cell.Id != -1 {
 			l.CellInfo = fmt.Sprintf("Cell[%d]: Line %d", cell.Id, cell.Line+1)
 		} else {
 			l.CellInfo = fmt.Sprintf("Cell Line %d", cell.Line+1)
@@ -0,0 +1,756 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the incremental compilation (`%incremental`): the declarations memorized from
+// previous cells, that don't depend on the declarations of the current cell, are moved to a separate
+// package ("defs"), dot-imported by the main package. The Go build cache reuses the compiled "defs"
+// package while those declarations don't change, so only the code of the current cell (and the
+// declarations that depend on it) is recompiled.
+//
+// Since only exported identifiers can be used from another package, unexported functions, variables and
+// constants moved to "defs" are renamed with the IncrementalPrefix, in all files of the program. Types, fields
+// and methods are never renamed, since that would change what the program does (e.g. what "%T" prints, or
+// what "encoding/json" marshals): unexported types, and declarations with unexported fields or methods, are kept
+// in the main package -- and so are the declarations that depend on them.
+//
+// Without type information, identifiers are resolved syntactically: when that goes wrong, the program fails
+// to compile, and it is compiled again the normal way (State.Compile). Lines of `main.go` are preserved, so
+// errors are still reported in the cells.
+
+const (
+	// IncrementalDir is the subdirectory of State.TempDir where the packages of the incremental
+	// compilation are written.
+	IncrementalDir = "gonb_incremental"
+
+	// IncrementalPrefix is prepended to the unexported functions, variables and constants moved to the "defs"
+	// package, so they are exported. It shows up in stack traces.
+	IncrementalPrefix = "D_"
+
+	incrementalMainDir = "main"
+	incrementalDefsDir = "defs"
+
+	// incrementalMaxPending is the number of stable declarations that are kept in the main package, while the
+	// previous contents of "defs" are still valid. Above that, "defs" is re-generated (and re-compiled) with
+	// all stable declarations. Without it, "defs" would change (and need re-compilation) after every cell that
+	// declares something.
+	incrementalMaxPending = 32
+)
+
+// incrementalInfo holds the state of the incremental compilation, see `%incremental`.
+type incrementalInfo struct {
+	enabled bool
+
+	// moved and total are the number of declarations moved to the "defs" package, and the total number
+	// of declarations, in the last incremental compilation.
+	moved, total int
+
+	// defsKeys identify the declarations in the "defs" package, see incrementalUnit.key.
+	defsKeys Set[string]
+}
+
+// SetIncremental enables or disables the incremental compilation, see `%incremental`.
+func (s *State) SetIncremental(enabled bool) {
+	s.incremental.enabled = enabled
+}
+
+// IsIncremental returns whether the incremental compilation is enabled.
+func (s *State) IsIncremental() bool {
+	return s.incremental.enabled
+}
+
+// IncrementalStats returns the number of declarations moved to the cached "defs" package, and the total
+// number of declarations, in the last compilation. They are 0 if the last compilation wasn't incremental.
+func (s *State) IncrementalStats() (moved, total int) {
+	return s.incremental.moved, s.incremental.total
+}
+
+// useIncremental returns whether the current cell is compiled incrementally. Tests, WASM, cells executed in a
+// session and in the debugger are always compiled the normal way.
+func (s *State) useIncremental() bool {
+	return s.incremental.enabled && !s.CellIsTest && !s.CellIsWasm && !s.useSession() && !s.useDebugger()
+}
+
+// compileIncremental compiles the program to State.BinaryPath(), moving the declarations of `main.go` not
+// from cellId (according to fileToCellIdAndLine) to the "defs" package, see IncrementalDir.
+//
+// Errors are not displayed: the caller is expected to fall back to State.Compile.
+func (s *State) compileIncremental(cellId int, fileToCellIdAndLine []CellIdAndLine) error {
+	s.incremental.moved, s.incremental.total = 0, 0
+	previous := s.incremental.defsKeys
+	s.incremental.defsKeys = nil
+	files, err := s.mainPackageFiles()
+	if err != nil {
+		return err
+	}
+	stableLine := func(line int) bool {
+		if line < 0 || line >= len(fileToCellIdAndLine) {
+			return false
+		}
+		id := fileToCellIdAndLine[line].Id
+		return id != cellId && id != NoCursorLine
+	}
+	defsImportPath := path.Join(s.Package, IncrementalDir, incrementalDefsDir)
+	split, err := splitForIncremental(defsImportPath, files, stableLine, previous)
+	if err != nil {
+		return err
+	}
+
+	mainDir := path.Join(s.TempDir, IncrementalDir, incrementalMainDir)
+	defsDir := path.Join(s.TempDir, IncrementalDir, incrementalDefsDir)
+	if err = os.RemoveAll(mainDir); err != nil {
+		return errors.Wrapf(err, "failed to remove %q", mainDir)
+	}
+	for _, dir := range []string{mainDir, defsDir} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return errors.Wrapf(err, "failed to create %q", dir)
+		}
+	}
+	for name, contents := range split.mainFiles {
+		if err = os.WriteFile(path.Join(mainDir, name), contents, 0600); err != nil {
+			return errors.Wrapf(err, "failed to write %q", name)
+		}
+	}
+	if err = os.WriteFile(path.Join(defsDir, "defs.go"), split.defs, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write defs.go")
+	}
+
+	args := []string{"build", "-o", s.BinaryPath()}
+	args = append(args, s.GoBuildFlags...)
+	args = append(args, "./"+path.Join(IncrementalDir, incrementalMainDir))
+	cmd := exec.Command("go", args...)
+	cmd.Dir = s.TempDir
+	klog.V(2).Infof("Executing %s", cmd)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to run %q:\n%s", cmd, output)
+	}
+	s.incremental.moved, s.incremental.total = split.moved, split.total
+	s.incremental.defsKeys = split.defsKeys
+	klog.V(1).Infof("Incremental compilation: %d of %d declarations in the cached package", split.moved, split.total)
+	return nil
+}
+
+// compile compiles the program incrementally, if enabled (see useIncremental), falling back to State.Compile
+// if it fails.
+func (s *State) compile(msg kernel.Message, cellId int, fileToCellIdAndLine []CellIdAndLine) error {
+	if s.useIncremental() {
+		err := s.compileIncremental(cellId, fileToCellIdAndLine)
+		if err == nil {
+			return nil
+		}
+		klog.V(1).Infof("Incremental compilation failed, compiling the normal way: %+v", err)
+	}
+	return s.Compile(msg, fileToCellIdAndLine)
+}
+
+// mainPackageFiles returns the contents of the Go files of the main package in State.TempDir, by file name.
+func (s *State) mainPackageFiles() (map[string][]byte, error) {
+	entries, err := os.ReadDir(s.TempDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files in %q", s.TempDir)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		contents, err := os.ReadFile(path.Join(s.TempDir, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %q", name)
+		}
+		files[name] = contents
+	}
+	if _, found := files[MainGo]; !found {
+		return nil, errors.Errorf("%s not found in %q", MainGo, s.TempDir)
+	}
+	return files, nil
+}
+
+// incrementalSplit is the result of splitForIncremental.
+type incrementalSplit struct {
+	// mainFiles are the rewritten files of the main package, by file name.
+	mainFiles map[string][]byte
+
+	// defs is the contents of the "defs" package.
+	defs []byte
+
+	// moved and total are the number of declarations moved to defs, and the total number of declarations
+	// in `main.go`.
+	moved, total int
+
+	// defsKeys identify the declarations moved to defs.
+	defsKeys Set[string]
+}
+
+// incrementalUnit is a declaration of `main.go` that can be moved to the "defs" package: a top-level
+// declaration, or a spec of a parenthesized `var` or `type` declaration. A type is grouped with its methods.
+type incrementalUnit struct {
+	names  []string
+	nodes  []ast.Node
+	prefix []string // Keyword prepended to each node in "defs" (for specs), or "".
+
+	// key is the source of the declaration, and it identifies it across compilations.
+	key string
+
+	candidate, stable bool
+	refs              Set[string]
+}
+
+// splitForIncremental moves the declarations of `main.go` (in files) whose lines are all stable, and that
+// only depend on other stable declarations, to a "defs" package, to be imported as defsImportPath.
+// See the description of the incremental compilation at the top of this file.
+//
+// The lines of `main.go` (0-based) are checked with stableLine.
+//
+// If the declarations previously moved to "defs" (identified by their keys in previous) are all still stable,
+// and there are no more than incrementalMaxPending other stable declarations, only the previous declarations are
+// moved, so the "defs" package is not changed.
+func splitForIncremental(defsImportPath string, files map[string][]byte, stableLine func(line int) bool,
+	previous Set[string]) (*incrementalSplit, error) {
+	fset := token.NewFileSet()
+	parsed := make(map[string]*ast.File, len(files))
+	for _, name := range SortedKeys(files) {
+		f, err := parser.ParseFile(fset, name, files[name], 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %q", name)
+		}
+		for _, spec := range f.Imports {
+			if spec.Path.Value == `"C"` || (spec.Name != nil && spec.Name.Name == ".") {
+				return nil, errors.Errorf("%s: cgo and dot imports are not supported by the incremental compilation", name)
+			}
+		}
+		var prefixFound bool
+		ast.Inspect(f, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Ident); ok && strings.HasPrefix(ident.Name, IncrementalPrefix) {
+				prefixFound = true
+			}
+			return !prefixFound
+		})
+		if prefixFound {
+			return nil, errors.Errorf("%s: identifiers prefixed with %q are not supported by the incremental compilation",
+				name, IncrementalPrefix)
+		}
+		parsed[name] = f
+	}
+	mainFile := parsed[MainGo]
+	mainSrc := files[MainGo]
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	lineOf := func(pos token.Pos) int { return fset.Position(pos).Line - 1 }
+
+	// Package level names, of all files.
+	pkgNames := MakeSet[string]()
+	for _, f := range parsed {
+		for _, decl := range f.Decls {
+			for _, name := range declNames(decl) {
+				pkgNames.Insert(name)
+			}
+		}
+	}
+	importNames := make(map[string]*ast.ImportSpec, len(mainFile.Imports))
+	for _, spec := range mainFile.Imports {
+		importNames[importName(spec)] = spec
+	}
+
+	// Units of main.go.
+	var units []*incrementalUnit
+	unitByName := make(map[string]*incrementalUnit)
+	var methods []*ast.FuncDecl
+	addUnit := func(names []string, node ast.Node, prefix string) {
+		u := &incrementalUnit{names: names, nodes: []ast.Node{node}, prefix: []string{prefix}, refs: MakeSet[string]()}
+		u.candidate = !slices.Contains(names, "main")
+		units = append(units, u)
+		for _, name := range names {
+			unitByName[name] = u
+		}
+	}
+	for _, decl := range mainFile.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			switch {
+			case decl.Tok == token.IMPORT:
+				continue
+			case decl.Tok == token.CONST || !decl.Lparen.IsValid():
+				addUnit(declNames(decl), decl, "")
+			default:
+				for _, spec := range decl.Specs {
+					addUnit(specNames(spec), spec, decl.Tok.String()+" ")
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				methods = append(methods, decl)
+			} else {
+				addUnit(declNames(decl), decl, "")
+			}
+		}
+	}
+	for _, method := range methods {
+		u := unitByName[receiverTypeName(method)]
+		if u == nil {
+			addUnit(nil, method, "")
+			units[len(units)-1].candidate = false
+			continue
+		}
+		u.nodes = append(u.nodes, method)
+		u.prefix = append(u.prefix, "")
+	}
+
+	// Find the stable units: all their lines are stable, and they only refer to stable units.
+	isPkgRef := func(f *ast.File, ident *ast.Ident) bool {
+		return pkgNames.Has(ident.Name) && (ident.Obj == nil || f.Scope.Lookup(ident.Name) == ident.Obj)
+	}
+	for _, u := range units {
+		var keyParts []string
+		for _, node := range u.nodes {
+			keyParts = append(keyParts, string(mainSrc[offset(node.Pos()):offset(node.End())]))
+			for line := lineOf(node.Pos()); line <= lineOf(node.End()); line++ {
+				u.candidate = u.candidate && stableLine(line)
+			}
+			u.candidate = u.candidate && !declaresUnexportedType(node) && !declaresUnexportedMembers(node)
+			inspectIdents(node, func(ident *ast.Ident, kind identKind) {
+				if kind != identMember && kind != identOther && isPkgRef(mainFile, ident) {
+					u.refs.Insert(ident.Name)
+				}
+			})
+		}
+		u.key = strings.Join(keyParts, "\n")
+		u.stable = u.candidate
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, u := range units {
+			if !u.stable {
+				continue
+			}
+			for ref := range u.refs {
+				if refUnit := unitByName[ref]; refUnit == nil || !refUnit.stable {
+					u.stable = false
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	if len(previous) > 0 {
+		stableKeys := MakeSet[string]()
+		for _, u := range units {
+			if u.stable {
+				stableKeys.Insert(u.key)
+			}
+		}
+		var previousFound int
+		for key := range previous {
+			if stableKeys.Has(key) {
+				previousFound++
+			}
+		}
+		if previousFound == len(previous) && len(stableKeys)-previousFound <= incrementalMaxPending {
+			for _, u := range units {
+				u.stable = u.stable && previous.Has(u.key)
+			}
+		}
+	}
+
+	// Names to rename: unexported names moved to "defs".
+	moved := MakeSet[string]()
+	split := &incrementalSplit{mainFiles: make(map[string][]byte, len(files)), total: len(units), defsKeys: MakeSet[string]()}
+	for _, u := range units {
+		if u.stable {
+			split.defsKeys.Insert(u.key)
+			split.moved++
+			for _, name := range u.names {
+				moved.Insert(name)
+			}
+		}
+	}
+	if split.moved == 0 {
+		return nil, errors.Errorf("no declarations to move to the cached package")
+	}
+	// Unexported fields and methods of the program: keys of composite literals with these names are assumed to
+	// be field names, and are not renamed.
+	members := MakeSet[string]()
+	for _, f := range parsed {
+		inspectIdents(f, func(ident *ast.Ident, kind identKind) {
+			if kind == identMember && !isExported(ident.Name) {
+				members.Insert(ident.Name)
+			}
+		})
+	}
+
+	// Rename identifiers in all files, and rewrite them.
+	defsRefs := make(map[string][]int) // Offsets of references to "defs", per file.
+	usedImports := MakeSet[string]()
+	editsByFile := make(map[string][]textEdit)
+	for name, f := range parsed {
+		var edits []textEdit
+		inspectIdents(f, func(ident *ast.Ident, kind identKind) {
+			var rename bool
+			switch kind {
+			case identKey:
+				if members.Has(ident.Name) {
+					return
+				}
+				fallthrough
+			case identPlain, identPackage:
+				if moved.Has(ident.Name) && isPkgRef(f, ident) {
+					defsRefs[name] = append(defsRefs[name], offset(ident.Pos()))
+					rename = !isExported(ident.Name)
+				}
+			default:
+				return
+			}
+			if rename {
+				edits = append(edits, textEdit{offset: offset(ident.Pos()), length: len(ident.Name),
+					text: IncrementalPrefix + ident.Name})
+			}
+		})
+		editsByFile[name] = edits
+	}
+
+	// Contents of "defs".
+	var defs bytes.Buffer
+	var defsBody bytes.Buffer
+	mainEdits := editsByFile[MainGo]
+	var blanked [][2]int
+	for _, u := range units {
+		if !u.stable {
+			continue
+		}
+		for ii, node := range u.nodes {
+			from, to := offset(node.Pos()), offset(node.End())
+			blanked = append(blanked, [2]int{from, to})
+			defsBody.WriteString(u.prefix[ii])
+			defsBody.Write(applyEdits(mainSrc, from, to, mainEdits))
+			defsBody.WriteString("\n\n")
+			inspectIdents(node, func(ident *ast.Ident, kind identKind) {
+				if kind == identPackage {
+					usedImports.Insert(ident.Name)
+				}
+			})
+		}
+	}
+	defs.WriteString("package defs\n\n")
+	for _, importName := range SortedKeys(importNames) {
+		if usedImports.Has(importName) {
+			spec := importNames[importName]
+			defs.WriteString(fmt.Sprintf("import %s %s\n", importName, spec.Path.Value))
+		}
+	}
+	defs.WriteString("\n")
+	defs.Write(defsBody.Bytes())
+	split.defs = defs.Bytes()
+
+	// Contents of main.go: stable units are replaced by blank lines, and imports no longer used
+	// are changed to "_".
+	isBlanked := func(pos int) bool {
+		return slices.ContainsFunc(blanked, func(r [2]int) bool { return pos >= r[0] && pos < r[1] })
+	}
+	usedInMain := MakeSet[string]()
+	inspectIdents(mainFile, func(ident *ast.Ident, kind identKind) {
+		if kind == identPackage && !isBlanked(offset(ident.Pos())) {
+			usedInMain.Insert(ident.Name)
+		}
+	})
+	defsRefs[MainGo] = slices.DeleteFunc(defsRefs[MainGo], isBlanked)
+	for importName, spec := range importNames {
+		if usedInMain.Has(importName) || importName == "_" {
+			continue
+		}
+		if spec.Name != nil {
+			mainEdits = append(mainEdits, textEdit{offset: offset(spec.Name.Pos()), length: len(spec.Name.Name), text: "_"})
+		} else {
+			mainEdits = append(mainEdits, textEdit{offset: offset(spec.Path.Pos()), text: "_ "})
+		}
+	}
+	for _, r := range blanked {
+		mainEdits = append(mainEdits, textEdit{offset: r[0], length: r[1] - r[0],
+			text: strings.Repeat("\n", bytes.Count(mainSrc[r[0]:r[1]], []byte("\n")))})
+	}
+	editsByFile[MainGo] = mainEdits
+	for name, f := range parsed {
+		edits := editsByFile[name]
+		if len(defsRefs[name]) > 0 {
+			// Dot-import "defs" in the same line as the package clause, to preserve the line numbers.
+			edits = append(edits, textEdit{offset: offset(f.Name.End()), text: fmt.Sprintf("; import . %q", defsImportPath)})
+		}
+		split.mainFiles[name] = applyEdits(files[name], 0, len(files[name]), edits)
+	}
+	return split, nil
+}
+
+// textEdit replaces length bytes at offset by text.
+type textEdit struct {
+	offset, length int
+	text           string
+}
+
+// applyEdits returns src[from:to] with the edits in that range applied. Edits contained in other
+// edits are ignored.
+func applyEdits(src []byte, from, to int, edits []textEdit) []byte {
+	edits = slices.Clone(edits)
+	slices.SortStableFunc(edits, func(a, b textEdit) int {
+		if a.offset != b.offset {
+			return a.offset - b.offset
+		}
+		return b.length - a.length
+	})
+	var buf bytes.Buffer
+	pos := from
+	for _, edit := range edits {
+		if edit.offset < pos || edit.offset+edit.length > to {
+			continue
+		}
+		buf.Write(src[pos:edit.offset])
+		buf.WriteString(edit.text)
+		pos = edit.offset + edit.length
+	}
+	buf.Write(src[pos:to])
+	return buf.Bytes()
+}
+
+// identKind is the role of an identifier, for the renaming of the incremental compilation.
+type identKind int
+
+const (
+	// identPlain may refer to a package level declaration.
+	identPlain identKind = iota
+
+	// identMember is the name of a field or method, in its declaration or in a selector.
+	identMember
+
+	// identKey is the key of a composite literal: either a field name, or an expression.
+	identKey
+
+	// identPackage is the left side of a selector not resolved to a declaration of the same file: usually
+	// a package (e.g. "fmt" in "fmt.Println"), but it may also be a declaration of another file.
+	identPackage
+
+	// identOther are identifiers that are never renamed: labels, the package name, and import names.
+	identOther
+)
+
+// inspectIdents calls fn for every identifier under node, with its role.
+func inspectIdents(node ast.Node, fn func(ident *ast.Ident, kind identKind)) {
+	kinds := make(map[*ast.Ident]identKind)
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.File:
+			kinds[n.Name] = identOther
+		case *ast.ImportSpec:
+			return false
+		case *ast.SelectorExpr:
+			kinds[n.Sel] = identMember
+			if x, ok := n.X.(*ast.Ident); ok && x.Obj == nil {
+				kinds[x] = identPackage
+			}
+		case *ast.StructType:
+			for _, field := range n.Fields.List {
+				for _, name := range field.Names {
+					kinds[name] = identMember
+				}
+			}
+		case *ast.InterfaceType:
+			for _, field := range n.Methods.List {
+				for _, name := range field.Names {
+					kinds[name] = identMember
+				}
+			}
+		case *ast.FuncDecl:
+			if n.Recv != nil {
+				kinds[n.Name] = identMember
+			}
+		case *ast.CompositeLit:
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						kinds[key] = identKey
+					}
+				}
+			}
+		case *ast.LabeledStmt:
+			kinds[n.Label] = identOther
+		case *ast.BranchStmt:
+			if n.Label != nil {
+				kinds[n.Label] = identOther
+			}
+		case *ast.Ident:
+			fn(n, kinds[n])
+		}
+		return true
+	})
+}
+
+// declaresUnexportedType returns whether node is a top-level declaration (or spec) of an unexported type.
+func declaresUnexportedType(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.GenDecl:
+		for _, spec := range n.Specs {
+			if declaresUnexportedType(spec) {
+				return true
+			}
+		}
+	case *ast.TypeSpec:
+		return !isExported(n.Name.Name)
+	}
+	return false
+}
+
+// declaresUnexportedMembers returns whether node declares unexported fields (including embedded ones) or
+// methods, which can't be used from another package.
+func declaresUnexportedMembers(node ast.Node) (found bool) {
+	unexported := func(ident *ast.Ident) bool {
+		found = found || (ident != nil && !isExported(ident.Name))
+		return found
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.StructType:
+			for _, field := range n.Fields.List {
+				if len(field.Names) == 0 {
+					unexported(embeddedFieldName(field.Type))
+				}
+				for _, name := range field.Names {
+					unexported(name)
+				}
+			}
+		case *ast.InterfaceType:
+			for _, field := range n.Methods.List {
+				for _, name := range field.Names {
+					unexported(name)
+				}
+			}
+		case *ast.FuncDecl:
+			if n.Recv != nil {
+				unexported(n.Name)
+			}
+		}
+		return !found
+	})
+	return
+}
+
+// embeddedFieldName returns the identifier naming an embedded field of type expr, or nil if not known.
+func embeddedFieldName(expr ast.Expr) *ast.Ident {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			return e.Sel
+		case *ast.Ident:
+			return e
+		default:
+			return nil
+		}
+	}
+}
+
+// declNames returns the names declared by a top-level declaration.
+func declNames(decl ast.Decl) []string {
+	switch decl := decl.(type) {
+	case *ast.GenDecl:
+		var names []string
+		for _, spec := range decl.Specs {
+			names = append(names, specNames(spec)...)
+		}
+		return names
+	case *ast.FuncDecl:
+		if decl.Recv == nil && decl.Name.Name != "init" {
+			return []string{decl.Name.Name}
+		}
+	}
+	return nil
+}
+
+// specNames returns the names declared by a `var`, `const` or `type` spec.
+func specNames(spec ast.Spec) []string {
+	var names []string
+	switch spec := spec.(type) {
+	case *ast.ValueSpec:
+		for _, name := range spec.Names {
+			if name.Name != "_" {
+				names = append(names, name.Name)
+			}
+		}
+	case *ast.TypeSpec:
+		names = append(names, spec.Name.Name)
+	}
+	return names
+}
+
+// receiverTypeName returns the name of the type of the receiver of a method.
+func receiverTypeName(method *ast.FuncDecl) string {
+	expr := method.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// importName returns the name by which an import is referred to: its explicit name, or the one
+// assumed from its path (the same way `goimports` does).
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	importPath, _ := strconv.Unquote(spec.Path.Value)
+	base := path.Base(importPath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := path.Dir(importPath); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '_' && !unicode.IsDigit(r)
+	}); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// isExported returns whether name starts with an upper case letter.
+func isExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
@@ -0,0 +1,179 @@
+package goexec
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncremental(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	s.SetIncremental(true)
+
+	cell1 := `import ("encoding/json"; "flag"; "fmt"; "strings")
+var _ = flag.Parse
+type mover interface { move() int }
+type point struct { x, Y int; name string }
+func (p *point) sum() int { return p.x + p.Y + len(p.name) }
+func (p *point) move() int { return p.sum() }
+type Pair struct { A, B int }
+func (p Pair) Sum() int { return p.A + p.B }
+func newPair(a int) Pair { return Pair{A: a, B: strings.Count("aa", "a")} }
+var first = newPair(1)
+func describe(m mover) string { return fmt.Sprint(m.move()) }
+func marshal(v any) string { out, _ := json.Marshal(v); return string(out) }
+type celsius float64
+func boiling() celsius { return 100 }`
+	updatedDecls, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, strings.Split(cell1, "\n"), MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	s.Definitions = updatedDecls
+
+	// Cell 2 redefines `describe` and declares `twice`, which are kept in the main package. The unexported types
+	// and the types with unexported fields or methods (`mover`, `point` and `celsius`) are also kept in the main
+	// package, with the declarations that use them (`boiling`), everything else is moved to "defs".
+	cell2 := "func describe(m mover) string { return fmt.Sprintf(\"moved %d\", m.move()) }\n" +
+		"func twice(x int) int { return 2 * x }\n%%\n" +
+		"p := &point{x: 2, Y: 1, name: \"aa\"}\n" +
+		"fmt.Printf(\"%s %d %d %+v %s %s %T\\n\", describe(p), p.x, twice(first.Sum()), *p, marshal(p), marshal(first), boiling())"
+	updatedDecls, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 2, strings.Split(cell2, "\n"), MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	require.NoError(t, s.compileIncremental(2, fileToCellIdAndLine))
+	moved, total := s.IncrementalStats()
+	assert.Equal(t, 5, moved) // Pair (with its method), newPair, first, marshal and the blank variable.
+	assert.Equal(t, 12, total)
+
+	defs, err := os.ReadFile(path.Join(s.TempDir, IncrementalDir, incrementalDefsDir, "defs.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(defs), "func (p Pair) Sum() int { return p.A + p.B }")
+	assert.Contains(t, string(defs), "func D_newPair(a int) Pair {")
+	assert.Contains(t, string(defs), `import strings "strings"`)
+	assert.NotContains(t, string(defs), "point")
+	assert.NotContains(t, string(defs), "celsius")
+	assert.NotContains(t, string(defs), "describe")
+
+	// Line numbers of main.go are preserved, and fields and methods are not renamed.
+	mainGo, err := os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	mainIncremental, err := os.ReadFile(path.Join(s.TempDir, IncrementalDir, incrementalMainDir, MainGo))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Count(string(mainGo), "\n"), strings.Count(string(mainIncremental), "\n"))
+	assert.Contains(t, string(mainIncremental), "func (p *point) sum() int { return p.x + p.Y + len(p.name) }")
+	assert.Contains(t, string(mainIncremental), "twice(D_first.Sum()), *p, D_marshal(p), D_marshal(D_first), boiling())")
+
+	output, err := exec.Command(s.BinaryPath()).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "moved 5 2 6 {x:2 Y:1 name:aa} {\"Y\":1} {\"A\":1,\"B\":2} main.celsius\n", string(output))
+
+	// Cell 3 doesn't change "defs": `twice` is now stable, but it is kept in the main package.
+	s.Definitions = updatedDecls
+	_, _, _, fileToCellIdAndLine, err = s.parseLinesAndComposeMain(nil, 3, []string{"%%", "fmt.Println(twice(first.A))"}, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	require.NoError(t, s.compileIncremental(3, fileToCellIdAndLine))
+	moved, _ = s.IncrementalStats()
+	assert.Equal(t, 5, moved)
+	newDefs, err := os.ReadFile(path.Join(s.TempDir, IncrementalDir, incrementalDefsDir, "defs.go"))
+	require.NoError(t, err)
+	assert.Equal(t, string(defs), string(newDefs))
+	output, err = exec.Command(s.BinaryPath()).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(output))
+
+	// Declarations of unexported types, and with unexported members (including embedded fields and anonymous
+	// structs), are detected.
+	for src, want := range map[string]bool{
+		"type T int":                     false,
+		"type t int":                     true,
+		"type (\n\tT int\n\tt string\n)": true,
+		"func f() { type t int }":        false,
+	} {
+		f, err := parser.ParseFile(token.NewFileSet(), "", "package main\n"+src, 0)
+		require.NoError(t, err)
+		assert.Equal(t, want, declaresUnexportedType(f.Decls[0]), src)
+	}
+	for src, want := range map[string]bool{
+		"type T struct { A int }":         false,
+		"type T struct { a int }":         true,
+		"type T struct { *bytes.Buffer }": false,
+		"type T struct { *point }":        true,
+		"type T interface { m() }":        true,
+		"func (T) m() {}":                 true,
+		"var v = struct{ n int }{1}":      true,
+		"func f() int { return p.x }":     false,
+	} {
+		f, err := parser.ParseFile(token.NewFileSet(), "", "package main\n"+src, 0)
+		require.NoError(t, err)
+		assert.Equal(t, want, declaresUnexportedMembers(f.Decls[0]), src)
+	}
+
+	// Declarations using the prefix are not supported.
+	_, err = splitForIncremental("x/defs", map[string][]byte{MainGo: []byte("package main\nvar D_x = 1\nfunc main() {}\n")},
+		func(int) bool { return true }, nil)
+	require.Error(t, err)
+}
+
+// BenchmarkIncremental compares the normal and the incremental compilation of cells that don't change the
+// memorized declarations (150 types with methods, and 150 functions). Run with:
+//
+//	go test ./internal/goexec/ -run=NONE -bench=Incremental
+func BenchmarkIncremental(b *testing.B) {
+	const numDecls = 150
+	var sb strings.Builder
+	sb.WriteString("import (\"flag\"; \"fmt\"; \"sort\"; \"strings\")\nvar _ = flag.Parse\n")
+	for ii := range numDecls {
+		fmt.Fprintf(&sb, "type Record%d struct { Name string; Values []float64 }\n", ii)
+		fmt.Fprintf(&sb, "func (r *Record%d) Summary() string { v := append([]float64(nil), r.Values...); "+
+			"sort.Float64s(v); parts := make([]string, 0, len(v)); for _, x := range v { parts = append(parts, "+
+			"fmt.Sprintf(\"%%.2f\", x)) }; return strings.ToUpper(r.Name) + \": \" + strings.Join(parts, \",\") }\n", ii)
+		fmt.Fprintf(&sb, "func newRecord%d(name string, n int) *Record%d { r := &Record%d{Name: name}; "+
+			"for i := range n { r.Values = append(r.Values, float64((i*7919)%%n)) }; return r }\n", ii, ii, ii)
+	}
+
+	for _, incremental := range []bool{false, true} {
+		b.Run(fmt.Sprintf("incremental=%v", incremental), func(b *testing.B) {
+			s := newEmptyState(b)
+			defer func() {
+				err := s.Stop()
+				require.NoError(b, err, "Failed to finalized state")
+			}()
+			require.NoError(b, s.GoModInit())
+			s.SetIncremental(incremental)
+			updatedDecls, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, strings.Split(sb.String(), "\n"), MakeSet[int](), NoCursor)
+			require.NoError(b, err)
+			s.Definitions = updatedDecls
+
+			// Each cell is different, so the main package is always re-compiled.
+			compileCell := func(cellId int) {
+				cell := fmt.Sprintf("%%%%\nfmt.Println(newRecord0(\"cell\", %d).Summary())", cellId)
+				_, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, cellId, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+				require.NoError(b, err)
+				if incremental {
+					require.NoError(b, s.compileIncremental(cellId, fileToCellIdAndLine))
+				} else {
+					require.NoError(b, s.Compile(nil, fileToCellIdAndLine))
+				}
+			}
+			compileCell(2) // Fills the build cache with the packages imported.
+			b.ResetTimer()
+			for ii := range b.N {
+				compileCell(ii + 3)
+			}
+			if incremental {
+				moved, _ := s.IncrementalStats()
+				b.ReportMetric(float64(moved), "moved_decls")
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package goexec
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements `%load`: it parses existing Go source files and merges their declarations into
+// State.Definitions, as if they had been typed in a cell.
+//
+// Declarations loaded from a file are identified by a negative "cell id" (below -1, which is used for
+// generated code), associated with the file path. So errors in loaded declarations are reported with the
+// path and line of the original file.
+
+// firstLoadedFileId is the id used for the first loaded file: the following ones are decremented from it.
+const firstLoadedFileId = -2
+
+// loadedFileId returns the id associated with the loaded file, creating a new one if needed.
+func (s *State) loadedFileId(filePath string) int {
+	for id, loadedPath := range s.loadedFiles {
+		if loadedPath == filePath {
+			return id
+		}
+	}
+	id := firstLoadedFileId
+	for loadedId := range s.loadedFiles {
+		id = min(id, loadedId-1)
+	}
+	s.loadedFiles[id] = filePath
+	return id
+}
+
+// resetLoadedFiles forgets the files loaded with `%load`, except those with declarations memorized in
+// other namespaces. Called by State.Reset.
+func (s *State) resetLoadedFiles() {
+	used := MakeSet[int]()
+	for _, ns := range s.namespaces {
+		ns.definitions.collectCellIds(used)
+	}
+	for id := range s.loadedFiles {
+		if !used.Has(id) {
+			delete(s.loadedFiles, id)
+		}
+	}
+}
+
+// LoadedFile returns the path of the file loaded with `%load` associated with the id, if there is one.
+func (s *State) LoadedFile(cellId int) (filePath string, found bool) {
+	filePath, found = s.loadedFiles[cellId]
+	return
+}
+
+// LoadResult reports the declarations loaded by State.Load.
+type LoadResult struct {
+	// Files loaded.
+	Files []string
+
+	// Keys of the declarations loaded.
+	Keys []string
+
+	// Replaced are the keys of previously memorized declarations that were replaced.
+	Replaced []string
+
+	// Dropped are the keys of the declarations in the files that are not loaded (e.g.: `main`).
+	Dropped []string
+}
+
+// Load parses the Go files in the given paths (files or directories), and merges their declarations
+// into State.Definitions, as if they had been typed in a cell.
+//
+// For directories, all Go files that match the current platform, except tests, are loaded. The `package`
+// clause is ignored, `func main()` is not loaded, and `func init()` is kept as an init function.
+//
+// If the loaded declarations conflict with the memorized ones, it fails listing the conflicts, unless
+// force is set, in which case the memorized declarations are replaced.
+//
+// Like with the execution of a cell, the merged declarations are compiled (but not executed) before
+// being memorized, and errors are reported with the path and line of the original file.
+func (s *State) Load(msg kernel.Message, paths []string, force bool) (result *LoadResult, err error) {
+	files, err := goFilesToLoad(paths)
+	if err != nil {
+		return
+	}
+	result = &LoadResult{Files: files}
+	loaded, err := s.parseGoFiles(files, result)
+	if err != nil {
+		return
+	}
+
+	// Declarations previously loaded from the same files are replaced, so removed declarations are dropped.
+	updatedDecls := s.Definitions.Copy()
+	updatedDecls.ClearCursor()
+	loadedIds := MakeSet[int]()
+	for _, filePath := range files {
+		loadedIds.Insert(s.loadedFileId(filePath))
+	}
+	updatedDecls.dropCells(loadedIds)
+
+	// Check for conflicts with memorized definitions.
+	result.Replaced = conflicts(updatedDecls, loaded)
+	if len(result.Replaced) > 0 && !force {
+		err = errors.Errorf("declarations loaded conflict with memorized ones (use --force to replace them): %s",
+			strings.Join(result.Replaced, ", "))
+		return
+	}
+	result.Keys = loaded.keys()
+
+	// Compile memorized and loaded declarations, before committing them.
+	updatedDecls.MergeFrom(loaded)
+	mainDecl := &Function{
+		Cursor:     NoCursor,
+		Key:        "main",
+		Name:       "main",
+		Definition: "func main() { flag.Parse() }",
+	}
+	_, fileToCellIdAndLine, err := s.createCodeFileFromDecls(updatedDecls, mainDecl)
+	if err != nil {
+		err = errors.WithMessagef(err, "while composing main.go with all declarations")
+		return
+	}
+	_, fileToCellIdAndLine, err = s.GoImports(msg, updatedDecls, mainDecl, fileToCellIdAndLine)
+	if err != nil {
+		return
+	}
+	if err = s.Compile(msg, fileToCellIdAndLine); err != nil {
+		return
+	}
+	s.Definitions = updatedDecls
+	return
+}
+
+// goFilesToLoad returns the list of Go files to load, given the paths to files or directories.
+func goFilesToLoad(paths []string) (files []string, err error) {
+	for _, p := range paths {
+		var info os.FileInfo
+		info, err = os.Stat(p)
+		if err != nil {
+			err = errors.Wrapf(err, "cannot load %q", p)
+			return
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		var entries []os.DirEntry
+		entries, err = os.ReadDir(p)
+		if err != nil {
+			err = errors.Wrapf(err, "cannot read directory %q", p)
+			return
+		}
+		var dirFiles []string
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || filepath.Ext(name) != ".go" || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			match, matchErr := build.Default.MatchFile(p, name)
+			if matchErr != nil {
+				klog.Warningf("%%load: skipping %q: %v", filepath.Join(p, name), matchErr)
+				continue
+			}
+			if match {
+				dirFiles = append(dirFiles, filepath.Join(p, name))
+			}
+		}
+		if len(dirFiles) == 0 {
+			err = errors.Errorf("no Go files to load in directory %q", p)
+			return
+		}
+		files = append(files, dirFiles...)
+	}
+	for ii, filePath := range files {
+		files[ii], err = filepath.Abs(filePath)
+		if err != nil {
+			err = errors.Wrapf(err, "cannot find absolute path of %q", filePath)
+			return
+		}
+	}
+	return
+}
+
+// parseGoFiles parses the declarations of the given files, each one identified by its own loaded file id.
+// It fails if the same declaration appears in more than one file, or if the files belong to different packages.
+func (s *State) parseGoFiles(files []string, result *LoadResult) (decls *Declarations, err error) {
+	decls = NewDeclarations()
+	fileSet := token.NewFileSet()
+	var packageName string
+	for _, filePath := range files {
+		var contents []byte
+		contents, err = os.ReadFile(filePath)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read %q", filePath)
+			return
+		}
+		var fileObj *ast.File
+		fileObj, err = parser.ParseFile(fileSet, filePath, contents, parser.SkipObjectResolution|parser.ParseComments)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse %q", filePath)
+			return
+		}
+		if packageName == "" {
+			packageName = fileObj.Name.Name
+		} else if fileObj.Name.Name != packageName {
+			err = errors.Errorf("files loaded belong to different packages (%q and %q): load one package at a time",
+				packageName, fileObj.Name.Name)
+			return
+		}
+
+		cellId := s.loadedFileId(filePath)
+		s.sourceLines[cellId] = strings.Split(string(contents), "\n")
+		numLines := fileSet.File(fileObj.Pos()).LineCount()
+		fileToCellLine := make([]int, numLines+1)
+		for ii := range fileToCellLine {
+			fileToCellLine[ii] = ii
+		}
+		pi := &parseInfo{
+			cursor:              NoCursor,
+			cellId:              cellId,
+			fileSet:             fileSet,
+			filesContents:       make(map[string]string),
+			fileToCellIdAndLine: MakeFileToCellIdAndLine(cellId, fileToCellLine),
+		}
+		renameLoadedInit(fileObj, filePath, decls)
+		fileDecls := NewDeclarations()
+		if err = pi.parseFile(fileDecls, filePath, fileObj); err != nil {
+			return
+		}
+		if _, found := fileDecls.Functions["main"]; found {
+			delete(fileDecls.Functions, "main")
+			result.Dropped = append(result.Dropped, "func main")
+		}
+		if duplicates := conflicts(decls, fileDecls); len(duplicates) > 0 {
+			err = errors.Errorf("%s declared more than once, the second time in %q", strings.Join(duplicates, ", "), filePath)
+			return
+		}
+		decls.MergeFrom(fileDecls)
+	}
+	return
+}
+
+// reNonIdentifier matches characters that can't be part of a Go identifier.
+var reNonIdentifier = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// renameLoadedInit renames the `func init()` declarations of a loaded file to `init_<file>_<n>`, so they are
+// memorized separately. Their definitions are not changed, so they are still rendered as `func init()`.
+func renameLoadedInit(fileObj *ast.File, filePath string, decls *Declarations) {
+	base := reNonIdentifier.ReplaceAllString(strings.TrimSuffix(filepath.Base(filePath), ".go"), "_")
+	count := 0
+	for _, decl := range fileObj.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv != nil || funcDecl.Name.Name != "init" {
+			continue
+		}
+		for {
+			count++
+			key := fmt.Sprintf("%s%s_%d", InitFunctionPrefix, base, count)
+			if _, found := decls.Functions[key]; !found {
+				funcDecl.Name.Name = key
+				break
+			}
+		}
+	}
+}
+
+// keys returns the keys of all declarations, prefixed by their kind (e.g.: "func f"), sorted.
+func (d *Declarations) keys() []string {
+	var keys []string
+	for key := range d.Imports {
+		keys = append(keys, "import "+key)
+	}
+	for key := range d.Constants {
+		keys = append(keys, "const "+key)
+	}
+	for key := range d.Types {
+		keys = append(keys, "type "+key)
+	}
+	for key := range d.Variables {
+		keys = append(keys, "var "+key)
+	}
+	for key := range d.Functions {
+		keys = append(keys, "func "+key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// conflicts returns the keys (as in Declarations.keys) of the declarations in newDecls that would replace
+// a different declaration in decls.
+//
+// Imports only conflict if the same name is used for different packages.
+func conflicts(decls, newDecls *Declarations) []string {
+	var keys []string
+	for key, importDecl := range newDecls.Imports {
+		if previous, found := decls.Imports[key]; found && previous.Path != importDecl.Path {
+			keys = append(keys, "import "+key)
+		}
+	}
+	keys = appendConflicts(keys, "const", decls.Constants, newDecls.Constants)
+	keys = appendConflicts(keys, "type", decls.Types, newDecls.Types)
+	keys = appendConflicts(keys, "var", decls.Variables, newDecls.Variables)
+	keys = appendConflicts(keys, "func", decls.Functions, newDecls.Functions)
+	sort.Strings(keys)
+	return keys
+}
+
+func appendConflicts[T any](keys []string, kind string, decls, newDecls map[string]*T) []string {
+	for key := range newDecls {
+		if _, found := decls[key]; found {
+			keys = append(keys, kind+" "+key)
+		}
+	}
+	return keys
+}
+
+// dropCells removes the declarations that came from the given cell ids.
+func (d *Declarations) dropCells(cellIds Set[int]) {
+	dropFromMap(d.Imports, cellIds)
+	dropFromMap(d.Constants, cellIds)
+	dropFromMap(d.Types, cellIds)
+	dropFromMap(d.Variables, cellIds)
+	dropFromMap(d.Functions, cellIds)
+}
+
+// collectCellIds inserts in cellIds the ids of the cells (or loaded files) of all declarations.
+func (d *Declarations) collectCellIds(cellIds Set[int]) {
+	collectFromMap(d.Imports, cellIds)
+	collectFromMap(d.Constants, cellIds)
+	collectFromMap(d.Types, cellIds)
+	collectFromMap(d.Variables, cellIds)
+	collectFromMap(d.Functions, cellIds)
+}
+
+func collectFromMap[V interface{ cellId() int }](m map[string]V, cellIds Set[int]) {
+	for _, decl := range m {
+		cellIds.Insert(decl.cellId())
+	}
+}
+
+func dropFromMap[V interface{ cellId() int }](m map[string]V, cellIds Set[int]) {
+	for key, decl := range m {
+		if cellIds.Has(decl.cellId()) {
+			delete(m, key)
+		}
+	}
+}
+
+// cellId returns the id of the cell (or loaded file) where the declaration came from.
+func (c CellLines) cellId() int {
+	return c.Id
+}
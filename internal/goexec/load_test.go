@@ -0,0 +1,97 @@
+package goexec
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGoFiles(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte(content), 0644))
+	}
+	writeFile("a.go", `package mylib
+
+import "fmt"
+
+// Hello prints a greeting.
+func Hello(name string) {
+	fmt.Println("Hello", name)
+}
+
+func init() { Hello("init") }
+
+func main() {}
+`)
+	writeFile("b.go", `package mylib
+
+import "strings"
+
+const Greeting = "hi"
+
+func init() { _ = strings.ToUpper(Greeting) }
+`)
+	writeFile("b_test.go", "package mylib\n")
+
+	files, err := goFilesToLoad([]string{dir})
+	require.NoError(t, err)
+	require.Equal(t, []string{path.Join(dir, "a.go"), path.Join(dir, "b.go")}, files)
+
+	result := &LoadResult{}
+	decls, err := s.parseGoFiles(files, result)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"func main"}, result.Dropped)
+	assert.Equal(t, []string{"const Greeting", "func Hello", "func init_a_1", "func init_b_1",
+		"import fmt", "import strings"}, decls.keys())
+
+	// Lines must point back to the original files.
+	hello := decls.Functions["Hello"]
+	filePath, found := s.LoadedFile(hello.Id)
+	require.True(t, found)
+	assert.Equal(t, path.Join(dir, "a.go"), filePath)
+	assert.Equal(t, []int{5, 6, 7}, hello.Lines)
+	assert.Equal(t, []int{4}, hello.Comments.CellLines.Lines)
+	_, found = s.LoadedFile(decls.Constants["Greeting"].Id)
+	require.True(t, found)
+	assert.NotEqual(t, hello.Id, decls.Constants["Greeting"].Id)
+	assert.Equal(t, hello.Id, s.loadedFileId(path.Join(dir, "a.go")), "Same file should keep its id")
+
+	// Inits are rendered back as `func init()`.
+	var buf bytes.Buffer
+	_, _, err = s.createCodeFromDecls(&buf, decls, nil)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "func init() { Hello(\"init\") }")
+	assert.NotContains(t, buf.String(), "init_a_1")
+
+	// Conflicts with memorized declarations.
+	s.Definitions.Functions["Hello"] = &Function{Key: "Hello", Name: "Hello", Definition: "func Hello() {}"}
+	s.Definitions.Imports["strings"] = NewImport("github.com/other/strings", "")
+	assert.Equal(t, []string{"func Hello", "import strings"}, conflicts(s.Definitions, decls))
+
+	// Duplicate declarations across files.
+	writeFile("c.go", "package mylib\n\nconst Greeting = \"hello\"\n")
+	_, err = s.parseGoFiles([]string{path.Join(dir, "b.go"), path.Join(dir, "c.go")}, &LoadResult{})
+	require.ErrorContains(t, err, "const Greeting declared more than once")
+
+	// Reset forgets the loaded files, except those used by other namespaces.
+	otherNamespace := NewDeclarations()
+	otherNamespace.Functions["Hello"] = hello
+	s.namespaces["other"] = &namespace{definitions: otherNamespace}
+	s.Reset()
+	_, found = s.LoadedFile(decls.Constants["Greeting"].Id)
+	assert.False(t, found)
+	_, found = s.LoadedFile(hello.Id)
+	assert.True(t, found)
+	assert.NotEqual(t, hello.Id, s.loadedFileId(path.Join(dir, "b.go")), "New ids must not reuse ids in use")
+}
@@ -0,0 +1,235 @@
+package goexec
+
+import (
+	"maps"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements definition namespaces (`%ns`): each namespace has its own memorized declarations,
+// packages (`%%package`), program arguments, `go build` flags, `go.mod` (and `go.sum`) and pinned module
+// versions, so different variants of the same code can be tried side by side.
+//
+// Only the current namespace is rendered and built, in State.TempDir: switching namespaces saves the state of the
+// current one, and restores (including the `go.mod` and `go.sum` files) the state of the other.
+
+// DefaultNamespace is the namespace the kernel starts with.
+const DefaultNamespace = "default"
+
+// reNamespaceName matches valid names of namespaces.
+var reNamespaceName = regexp.MustCompile(`^[\w.-]+$`)
+
+// namespace holds the state of a namespace that is not the current one.
+type namespace struct {
+	definitions        *Declarations
+	args, goBuildFlags []string
+	requirements       map[string]string
+	goMod, goSum       []byte
+	packages           map[string]*cellPackage
+
+	export    *exportInfo
+	variables []VariableValue
+	stale     map[int]*cellDependencies
+}
+
+// NamespaceInfo describes a namespace, see State.Namespaces.
+type NamespaceInfo struct {
+	Name string
+
+	// Current is set for the namespace currently used.
+	Current bool
+
+	// NumDeclarations is the number of memorized declarations, as listed by `%list`.
+	NumDeclarations int
+}
+
+// Namespace returns the name of the current namespace.
+func (s *State) Namespace() string {
+	return s.namespace
+}
+
+// Namespaces returns the existing namespaces, sorted by name.
+func (s *State) Namespaces() []NamespaceInfo {
+	infos := []NamespaceInfo{{Name: s.namespace, Current: true, NumDeclarations: len(s.Definitions.keys())}}
+	for name, ns := range s.namespaces {
+		infos = append(infos, NamespaceInfo{Name: name, NumDeclarations: len(ns.definitions.keys())})
+	}
+	slices.SortFunc(infos, func(a, b NamespaceInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// SwitchNamespace saves the state of the current namespace, and switches to the namespace name.
+// If it doesn't exist yet, it is created empty, with a new `go.mod`.
+//
+// A `%session` worker is stopped, since its state depends on the declarations of the previous namespace.
+func (s *State) SwitchNamespace(name string) error {
+	if name == s.namespace {
+		return nil
+	}
+	if err := validateNamespaceName(name); err != nil {
+		return err
+	}
+	current, err := s.saveNamespace()
+	if err != nil {
+		return err
+	}
+	s.StopSession()
+	next, found := s.namespaces[name]
+	if !found {
+		next = &namespace{
+			definitions:  NewDeclarations(),
+			requirements: make(map[string]string),
+			packages:     make(map[string]*cellPackage),
+			export:       newExportInfo(),
+			stale:        make(map[int]*cellDependencies),
+		}
+	}
+	err = s.restoreNamespace(next)
+	if err == nil && !found {
+		err = s.GoModInit()
+	}
+	if err != nil {
+		// Go back to the current namespace, so it is not lost.
+		if restoreErr := s.restoreNamespace(current); restoreErr != nil {
+			klog.Errorf("Failed to restore namespace %q: %+v", s.namespace, restoreErr)
+		}
+		return errors.WithMessagef(err, "failed to switch to namespace %q", name)
+	}
+	s.namespaces[s.namespace] = current
+	delete(s.namespaces, name)
+	s.namespace = name
+	return nil
+}
+
+// CopyNamespace creates the namespace to as a copy of the namespace from (which can be the current one).
+// It fails if to already exists.
+func (s *State) CopyNamespace(from, to string) error {
+	if err := validateNamespaceName(to); err != nil {
+		return err
+	}
+	if _, found := s.namespaces[to]; found || to == s.namespace {
+		return errors.Errorf("namespace %q already exists", to)
+	}
+	source, found := s.namespaces[from]
+	if from == s.namespace {
+		var err error
+		if source, err = s.saveNamespace(); err != nil {
+			return err
+		}
+	} else if !found {
+		return errors.Errorf("namespace %q doesn't exist", from)
+	}
+	s.namespaces[to] = source.copy()
+	return nil
+}
+
+// RemoveNamespace removes the namespace name. The current namespace can't be removed.
+func (s *State) RemoveNamespace(name string) error {
+	if name == s.namespace {
+		return errors.Errorf("can't remove the current namespace %q, switch to another one first", name)
+	}
+	if _, found := s.namespaces[name]; !found {
+		return errors.Errorf("namespace %q doesn't exist", name)
+	}
+	delete(s.namespaces, name)
+	return nil
+}
+
+// validateNamespaceName returns an error if name is not a valid name for a namespace.
+func validateNamespaceName(name string) error {
+	if !reNamespaceName.MatchString(name) {
+		return errors.Errorf("invalid namespace name %q: use only letters, digits, \"_\", \".\" and \"-\"", name)
+	}
+	return nil
+}
+
+// saveNamespace returns a copy of the state of the current namespace.
+func (s *State) saveNamespace() (*namespace, error) {
+	ns := &namespace{
+		definitions:  s.Definitions.Copy(),
+		args:         slices.Clone(s.Args),
+		goBuildFlags: slices.Clone(s.GoBuildFlags),
+		requirements: maps.Clone(s.requirements),
+		packages:     maps.Clone(s.packages),
+		export:       s.export,
+		variables:    s.Variables(),
+	}
+	s.stale.mu.Lock()
+	ns.stale = maps.Clone(s.stale.cells)
+	s.stale.mu.Unlock()
+	var err error
+	for fileName, contents := range map[string]*[]byte{"go.mod": &ns.goMod, "go.sum": &ns.goSum} {
+		*contents, err = os.ReadFile(path.Join(s.TempDir, fileName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to read %q", fileName)
+		}
+	}
+	return ns, nil
+}
+
+// restoreNamespace makes ns the state of the current namespace. If ns has no `go.mod`, the current one is left
+// as is. The state kept in memory is restored even if restoring the files fails.
+func (s *State) restoreNamespace(ns *namespace) error {
+	s.Definitions = ns.definitions
+	s.Args = ns.args
+	s.GoBuildFlags = ns.goBuildFlags
+	s.requirements = ns.requirements
+	s.export = ns.export
+	s.variables.mu.Lock()
+	s.variables.values = ns.variables
+	s.variables.mu.Unlock()
+	s.stale.mu.Lock()
+	s.stale.cells = ns.stale
+	s.stale.mu.Unlock()
+
+	// The incremental "defs" package was built for the declarations of the previous namespace.
+	s.incremental.defsKeys = nil
+
+	if ns.goMod != nil {
+		for fileName, contents := range map[string][]byte{"go.mod": ns.goMod, "go.sum": ns.goSum} {
+			filePath := path.Join(s.TempDir, fileName)
+			var err error
+			if contents == nil {
+				err = os.Remove(filePath)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				err = os.WriteFile(filePath, contents, 0600)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to restore %q", fileName)
+			}
+		}
+	}
+	return s.replacePackages(ns.packages)
+}
+
+// copy returns a deep copy of the namespace.
+func (ns *namespace) copy() *namespace {
+	export := newExportInfo()
+	export.mainDecl = ns.export.mainDecl
+	export.testCells = MakeSet[int]()
+	for cellId := range ns.export.testCells {
+		export.testCells.Insert(cellId)
+	}
+	return &namespace{
+		definitions:  ns.definitions.Copy(),
+		args:         slices.Clone(ns.args),
+		goBuildFlags: slices.Clone(ns.goBuildFlags),
+		requirements: maps.Clone(ns.requirements),
+		goMod:        slices.Clone(ns.goMod),
+		goSum:        slices.Clone(ns.goSum),
+		packages:     maps.Clone(ns.packages),
+		export:       export,
+		variables:    slices.Clone(ns.variables),
+		stale:        maps.Clone(ns.stale),
+	}
+}
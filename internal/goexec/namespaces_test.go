@@ -0,0 +1,81 @@
+package goexec
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaces(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.GoModInit())
+
+	parseCell := func(cellId int, cell string) {
+		updatedDecls, _, _, _, err := s.parseLinesAndComposeMain(nil, cellId, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+		require.NoError(t, err)
+		s.Definitions = updatedDecls
+	}
+	goModPath := path.Join(s.TempDir, "go.mod")
+	parseCell(1, "func f() int { return 1 }")
+	s.Args = []string{"--x=1"}
+	require.NoError(t, os.WriteFile(goModPath, []byte("module gonb_test\n\ngo 1.23\n"), 0600))
+	assert.Equal(t, DefaultNamespace, s.Namespace())
+
+	// Copy and switch: declarations, arguments and go.mod are preserved.
+	require.NoError(t, s.CopyNamespace(DefaultNamespace, "b"))
+	require.Error(t, s.CopyNamespace(DefaultNamespace, "b"))
+	require.Error(t, s.CopyNamespace(DefaultNamespace, "invalid name"))
+	require.NoError(t, s.SwitchNamespace("b"))
+	assert.Equal(t, "b", s.Namespace())
+	assert.Equal(t, []string{"func f"}, s.Definitions.keys())
+	assert.Equal(t, []string{"--x=1"}, s.Args)
+	parseCell(2, "func g() int { return 2 }")
+	s.Args = nil
+
+	// A new namespace is empty, with a new go.mod.
+	require.NoError(t, s.SwitchNamespace("c"))
+	assert.Empty(t, s.Definitions.keys())
+	assert.Empty(t, s.Args)
+	goMod, err := os.ReadFile(goModPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(goMod), "gonb_test")
+	assert.Equal(t, []NamespaceInfo{
+		{Name: "b", NumDeclarations: 2},
+		{Name: "c", Current: true, NumDeclarations: 0},
+		{Name: DefaultNamespace, NumDeclarations: 1},
+	}, s.Namespaces())
+
+	// Switching back restores the namespace, with its go.mod.
+	require.NoError(t, s.SwitchNamespace(DefaultNamespace))
+	assert.Equal(t, []string{"func f"}, s.Definitions.keys())
+	assert.Equal(t, []string{"--x=1"}, s.Args)
+	goMod, err = os.ReadFile(goModPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "gonb_test")
+
+	// If switching fails (here `go mod init`), the current namespace is kept.
+	t.Setenv("PATH", "")
+	require.Error(t, s.SwitchNamespace("e"))
+	assert.Equal(t, DefaultNamespace, s.Namespace())
+	assert.Equal(t, []string{"func f"}, s.Definitions.keys())
+	assert.Equal(t, []string{"--x=1"}, s.Args)
+	goMod, err = os.ReadFile(goModPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "gonb_test")
+	assert.Len(t, s.Namespaces(), 3)
+
+	// The current namespace can't be removed.
+	require.Error(t, s.RemoveNamespace(DefaultNamespace))
+	require.NoError(t, s.RemoveNamespace("c"))
+	require.Error(t, s.RemoveNamespace("c"))
+	assert.Len(t, s.Namespaces(), 2)
+}
@@ -0,0 +1,164 @@
+package goexec
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/module"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the offline mode (`%offline` and the flag `--offline_cache`), for kernels without
+// access to the internet: modules are fetched from a local module cache, used as a `GOPROXY=file://...`.
+//
+// The cache is a Go module cache (a `GOMODCACHE` directory, whose "cache/download" subdirectory has the layout
+// of a module proxy), pre-populated on a connected machine with `gonb prefetch`. A directory with the layout
+// of a module proxy (e.g. a copy of "cache/download") can also be used directly.
+
+// OfflineEnvVars are the environment variables changed by the offline mode.
+var OfflineEnvVars = []string{"GOPROXY", "GOSUMDB", "GOTOOLCHAIN"}
+
+// OfflineProxyDir returns the directory with the layout of a module proxy in the given module cache: its
+// "cache/download" subdirectory if it exists, or otherwise the cache directory itself.
+func OfflineProxyDir(cacheDir string) string {
+	downloadDir := filepath.Join(cacheDir, "cache", "download")
+	if info, err := os.Stat(downloadDir); err == nil && info.IsDir() {
+		return downloadDir
+	}
+	return cacheDir
+}
+
+// SetOfflineCache enables the offline mode using the module cache in cacheDir, or disables it if
+// cacheDir is empty.
+//
+// It sets the environment variables (OfflineEnvVars) used by `go` -- so they also affect shell commands --
+// and restores their previous values when disabled.
+func (s *State) SetOfflineCache(cacheDir string) error {
+	if cacheDir != "" {
+		var err error
+		cacheDir, err = filepath.Abs(ReplaceTildeInDir(cacheDir))
+		if err != nil {
+			return errors.Wrapf(err, "invalid offline module cache directory %q", cacheDir)
+		}
+		info, err := os.Stat(cacheDir)
+		if err != nil {
+			return errors.Wrapf(err, "offline module cache %q not available", cacheDir)
+		}
+		if !info.IsDir() {
+			return errors.Errorf("offline module cache %q is not a directory", cacheDir)
+		}
+	}
+
+	// Restore the original values, if the offline mode was enabled.
+	if s.OfflineCache != "" {
+		for _, key := range OfflineEnvVars {
+			var err error
+			if value, found := s.offlineSavedEnv[key]; found {
+				err = os.Setenv(key, value)
+			} else {
+				err = os.Unsetenv(key)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to restore environment variable %q", key)
+			}
+		}
+		s.OfflineCache = ""
+		s.offlineSavedEnv = nil
+	}
+	if cacheDir == "" {
+		return nil
+	}
+
+	s.offlineSavedEnv = make(map[string]string)
+	for _, key := range OfflineEnvVars {
+		if value, found := os.LookupEnv(key); found {
+			s.offlineSavedEnv[key] = value
+		}
+	}
+	values := map[string]string{
+		"GOPROXY":     "file://" + filepath.ToSlash(OfflineProxyDir(cacheDir)),
+		"GOSUMDB":     "off",   // The checksum database is not reachable: go.sum is still verified.
+		"GOTOOLCHAIN": "local", // Don't try to download another toolchain.
+	}
+	for _, key := range OfflineEnvVars {
+		if err := os.Setenv(key, values[key]); err != nil {
+			return errors.Wrapf(err, "failed to set environment variable %q", key)
+		}
+	}
+	s.OfflineCache = cacheDir
+	klog.Infof("Offline mode: using module cache in %q", cacheDir)
+	return nil
+}
+
+var (
+	// offlineURLRegexp matches the "file://" URLs in the errors of `go`.
+	offlineURLRegexp = regexp.MustCompile(`file://[^\s:]+`)
+
+	// missingPackageRegexp matches the error of `go get` when no module in the cache provides an imported package.
+	missingPackageRegexp = regexp.MustCompile(`cannot find module providing package (\S+)`)
+)
+
+// offlineMissingModules returns the modules (with the versions, if known) and packages that `go` failed to find
+// in the offline module cache, according to its output.
+func (s *State) offlineMissingModules(output string) []string {
+	proxyPrefix := "file://" + filepath.ToSlash(OfflineProxyDir(s.OfflineCache)) + "/"
+	missing := MakeSet[string]()
+	for _, url := range offlineURLRegexp.FindAllString(output, -1) {
+		// URLs are ".../<escaped module>/@v/list", ".../<escaped module>/@v/<version>.<ext>" or ".../<escaped module>/@latest".
+		unescapedURL, err := neturl.PathUnescape(url)
+		if err != nil || !strings.HasPrefix(unescapedURL, proxyPrefix) {
+			continue
+		}
+		escapedPath, query, found := strings.Cut(unescapedURL[len(proxyPrefix):], "/@")
+		if !found {
+			continue
+		}
+		modPath, err := module.UnescapePath(escapedPath)
+		if err != nil {
+			continue
+		}
+		if fileName, found := strings.CutPrefix(query, "v/"); found && fileName != "list" {
+			if version, err := module.UnescapeVersion(strings.TrimSuffix(fileName, path.Ext(fileName))); err == nil {
+				modPath += "@" + version
+			}
+		}
+		missing.Insert(modPath)
+	}
+	for _, match := range missingPackageRegexp.FindAllStringSubmatch(output, -1) {
+		missing.Insert(fmt.Sprintf("module providing package %s", match[1]))
+	}
+	return SortedKeys(missing)
+}
+
+// networkErrorMarkers are substrings of the errors of `go` when the network is not available.
+var networkErrorMarkers = []string{"dial tcp", "i/o timeout", "no such host", "network is unreachable"}
+
+// filterOfflineError explains the errors of `go get` related to the offline mode, or suggests it if the
+// network is not available.
+func (s *State) filterOfflineError(output string) string {
+	if s.OfflineCache == "" {
+		for _, marker := range networkErrorMarkers {
+			if strings.Contains(output, marker) {
+				return fmt.Sprintf("%s\n---------------\nNote: `go get` failed to access the network. If this machine has no "+
+					"access to the internet, consider using a local module cache with `%%offline <cache_dir>`, "+
+					"pre-populated on a connected machine with `gonb prefetch`.\n", output)
+			}
+		}
+		return output
+	}
+	missing := s.offlineMissingModules(output)
+	if len(missing) == 0 {
+		return output
+	}
+	return fmt.Sprintf("%s\n---------------\nOffline mode: the following modules are not available in the module cache %q:\n\t%s\n\n"+
+		"Pre-populate the cache on a connected machine with `gonb prefetch --cache=<cache_dir> <notebook.ipynb>`, and "+
+		"copy it over. Or disable the offline mode with `%%offline off`.\n",
+		output, s.OfflineCache, strings.Join(missing, "\n\t"))
+}
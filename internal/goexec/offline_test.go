@@ -0,0 +1,50 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineCache(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	t.Setenv("GOPROXY", "https://proxy.example.com")
+	t.Setenv("GOSUMDB", "")
+	require.NoError(t, os.Unsetenv("GOSUMDB"))
+
+	cacheDir := t.TempDir()
+	require.Error(t, s.SetOfflineCache(filepath.Join(cacheDir, "missing")))
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "cache", "download"), 0755))
+	require.NoError(t, s.SetOfflineCache(cacheDir))
+	assert.Equal(t, cacheDir, s.OfflineCache)
+	proxyDir := filepath.Join(cacheDir, "cache", "download")
+	assert.Equal(t, "file://"+proxyDir, os.Getenv("GOPROXY"))
+	assert.Equal(t, "off", os.Getenv("GOSUMDB"))
+
+	output := "go: module github.com/BurntSushi/toml: reading file://" + proxyDir +
+		"/github.com/%21burnt%21sushi/toml/@v/list: no such file or directory\n" +
+		"go: github.com/janpfeifer/must@v0.2.0: reading file://" + proxyDir +
+		"/github.com/janpfeifer/must/@v/v0.2.0.info: no such file or directory\n" +
+		"\tgithub.com/other/thing: cannot find module providing package github.com/other/thing\n"
+	assert.Equal(t, []string{
+		"github.com/BurntSushi/toml",
+		"github.com/janpfeifer/must@v0.2.0",
+		"module providing package github.com/other/thing",
+	}, s.offlineMissingModules(output))
+	assert.Contains(t, s.filterOfflineError(output), "not available in the module cache")
+
+	// Disabling restores the environment.
+	require.NoError(t, s.SetOfflineCache(""))
+	assert.Empty(t, s.OfflineCache)
+	assert.Equal(t, "https://proxy.example.com", os.Getenv("GOPROXY"))
+	_, found := os.LookupEnv("GOSUMDB")
+	assert.False(t, found)
+	assert.Contains(t, s.filterOfflineError("dial tcp: lookup proxy.golang.org: no such host"), "%offline")
+}
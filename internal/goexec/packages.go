@@ -0,0 +1,252 @@
+package goexec
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the `%%package <name>` cells: their declarations are memorized separately from the
+// ones of the main package, and written to the package `<name>`, in a subdirectory of State.TempDir. Other
+// cells can then import it as "<State.Package>/<name>" (see State.PackageImportPath), e.g. to prototype
+// package boundaries and unexported identifiers.
+//
+// The package is written to the `main.go` of its directory (see State.CodePath), so errors are reported in
+// the cells the same way as for the main package. Declarations of later `%%package` cells with the same name
+// are merged, as usual.
+
+// cellPackage is a package defined by `%%package` cells. It is not modified once created, a new one
+// replaces it when a cell changes the package.
+type cellPackage struct {
+	definitions *Declarations
+
+	// code is the contents of the generated `main.go` of the package, used to restore it, see `%ns`.
+	code []byte
+}
+
+// reservedPackageNames can't be used in `%%package`: either they have a special meaning for Go, or the
+// directory is used by GoNB.
+var reservedPackageNames = SetWithValues("_", "main", "vendor", "testdata", IncrementalDir, PersistSubdir,
+	DebugCellsSubdir)
+
+// PackageDir returns the directory where the package defined with `%%package <name>` is written.
+func (s *State) PackageDir(name string) string {
+	return path.Join(s.TempDir, name)
+}
+
+// PackageImportPath returns the path used by the cells to import the package defined with `%%package <name>`.
+func (s *State) PackageImportPath(name string) string {
+	return path.Join(s.Package, name)
+}
+
+// Packages returns the names of the packages defined with `%%package`, sorted.
+func (s *State) Packages() []string {
+	return SortedKeys(s.packages)
+}
+
+// PackageDefinitions returns the memorized declarations of the package defined with `%%package <name>`, or nil
+// if it is not defined.
+func (s *State) PackageDefinitions(name string) *Declarations {
+	pkg, found := s.packages[name]
+	if !found {
+		return nil
+	}
+	return pkg.definitions
+}
+
+// validatePackageName returns an error if name can't be used in `%%package`.
+func validatePackageName(name string) error {
+	if !token.IsIdentifier(name) || reservedPackageNames.Has(name) || strings.HasPrefix(name, "gonb_") {
+		return errors.Errorf("invalid package name %q: it must be a Go identifier, not starting with \"gonb_\", "+
+			"and other than %q", name, SortedKeys(reservedPackageNames))
+	}
+	return nil
+}
+
+// ExecutePackageCell executes a `%%package <name>` cell: its declarations are merged with the ones of the package
+// name, which is written and compiled.
+//
+// The first line of lines is the `%%package` command, it's kept so the line numbers of errors match the cell.
+func (s *State) ExecutePackageCell(msg kernel.Message, cellId int, name string, lines []string) error {
+	params := &cellExecParams{
+		msg:         msg,
+		cellId:      cellId,
+		lines:       lines,
+		packageName: name,
+		done:        NewLatchWithValue[error](),
+	}
+	s.cellExecChan <- params
+	return params.done.Wait()
+}
+
+// executePackageCellImpl implements ExecutePackageCell. Like executeCellImpl, calls to it are serialized.
+func (s *State) executePackageCellImpl(msg kernel.Message, cellId int, name string, lines []string) (err error) {
+	if err = validatePackageName(name); err != nil {
+		return err
+	}
+	if err = s.AutoTrack(); err != nil {
+		return err
+	}
+	s.cellPackage = name
+	defer func() {
+		s.cellPackage = ""
+		if err != nil {
+			s.restorePackage(name)
+		}
+	}()
+
+	var updatedDecls *Declarations
+	var fileToCellIdAndLine []CellIdAndLine
+	updatedDecls, fileToCellIdAndLine, err = s.parseLinesAndComposePackage(msg, cellId, lines)
+	if err != nil {
+		klog.Infof("goexec.ExecutePackageCell() failed to parse the cell: %+v", err)
+		return err
+	}
+	_, fileToCellIdAndLine, err = s.GoImports(msg, updatedDecls, nil, fileToCellIdAndLine)
+	if err != nil {
+		klog.Infof("goexec.ExecutePackageCell() failed to run `go imports` and `go get`: %+v", err)
+		return err
+	}
+	if err = s.Compile(msg, fileToCellIdAndLine); err != nil {
+		klog.Infof("goexec.ExecutePackageCell() failed to compile package %q: %+v", name, err)
+		return err
+	}
+	if s.Vet {
+		s.vet(msg, fileToCellIdAndLine)
+	}
+	var code []byte
+	code, err = os.ReadFile(s.CodePath())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the code of package %q", name)
+	}
+	s.packages[name] = &cellPackage{definitions: updatedDecls, code: code}
+	if msg != nil {
+		err = kernel.PublishWriteStream(msg, kernel.StreamStdout,
+			fmt.Sprintf("Package %q: import %q\n", name, s.PackageImportPath(name)))
+		if err != nil {
+			klog.Errorf("Failed to publish package import path: %+v", err)
+		}
+	}
+	return nil
+}
+
+// parseLinesAndComposePackage parses the lines of a `%%package` cell, and merges its declarations with the
+// ones of the package (State.cellPackage), which are written to its `main.go`.
+//
+// It returns the merged declarations, which are only memorized by the caller if the package compiles,
+// and the mapping of the lines of `main.go` to the cells.
+func (s *State) parseLinesAndComposePackage(msg kernel.Message, cellId int, lines []string) (
+	updatedDecls *Declarations, fileToCellIdAndLine []CellIdAndLine, err error) {
+	dir := s.PackageDir(s.cellPackage)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		err = errors.Wrapf(err, "failed to create directory %q for package %q", dir, s.cellPackage)
+		return
+	}
+
+	// Blank the `%%package` line: everything else is Go code.
+	s.sourceLines[cellId] = slices.Clone(lines)
+	lines = slices.Clone(lines)
+	if len(lines) > 0 {
+		lines[0] = ""
+	}
+	var fileToCellLine []int
+	_, fileToCellLine, err = s.createGoFileFromLines(s.CodePath(), cellId, lines, MakeSet[int](), NoCursor)
+	if err != nil {
+		return
+	}
+	fileToCellIdAndLine = MakeFileToCellIdAndLine(cellId, fileToCellLine)
+	var newDecls *Declarations
+	newDecls, err = s.parseFromGoCode(msg, cellId, NoCursor, fileToCellIdAndLine)
+	if err != nil {
+		return
+	}
+
+	if pkg, found := s.packages[s.cellPackage]; found {
+		updatedDecls = pkg.definitions.Copy()
+	} else {
+		updatedDecls = NewDeclarations()
+	}
+	updatedDecls.ClearCursor()
+	updatedDecls.MergeFrom(newDecls)
+	_, fileToCellIdAndLine, err = s.createCodeFileFromDecls(updatedDecls, nil)
+	if err != nil {
+		err = errors.WithMessagef(err, "while composing package %q with all declarations", s.cellPackage)
+	}
+	return
+}
+
+// createPackageFileFromDecls creates the `main.go` of the package of a `%%package` cell (State.cellPackage),
+// with the given declarations. It is called by createCodeFileFromDecls.
+func (s *State) createPackageFileFromDecls(decls *Declarations) (cursor Cursor, fileToCellIdAndLine []CellIdAndLine, err error) {
+	var f *os.File
+	f, err = os.Create(s.CodePath())
+	if err != nil {
+		err = errors.Wrapf(err, "Failed to create %q", s.CodePath())
+		return
+	}
+	cursor, fileToCellIdAndLine, err = s.createCodeFromDecls(f, decls, nil)
+	err2 := f.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "creating %q", s.CodePath())
+		return
+	}
+	err = err2
+	if err != nil {
+		err = errors.Wrapf(err, "closing %q", s.CodePath())
+	}
+	return
+}
+
+// restorePackage writes back the code of the package name as it was before a failed `%%package` cell, so it
+// doesn't break the programs that import it. If the package wasn't defined yet, its directory is removed.
+func (s *State) restorePackage(name string) {
+	dir := s.PackageDir(name)
+	var err error
+	if pkg, found := s.packages[name]; found {
+		err = os.WriteFile(path.Join(dir, MainGo), pkg.code, 0600)
+	} else {
+		err = os.RemoveAll(dir)
+	}
+	if err != nil {
+		klog.Errorf("Failed to restore package %q: %+v", name, err)
+	}
+}
+
+// replacePackages removes the directories of the current packages, and writes the given ones instead.
+// Used when switching namespaces, see `%ns`.
+func (s *State) replacePackages(packages map[string]*cellPackage) error {
+	for name := range s.packages {
+		if err := os.RemoveAll(s.PackageDir(name)); err != nil {
+			return errors.Wrapf(err, "failed to remove package %q", name)
+		}
+	}
+	s.packages = packages
+	for name, pkg := range packages {
+		dir := s.PackageDir(name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return errors.Wrapf(err, "failed to create directory %q for package %q", dir, name)
+		}
+		if err := os.WriteFile(path.Join(dir, MainGo), pkg.code, 0600); err != nil {
+			return errors.Wrapf(err, "failed to write package %q", name)
+		}
+	}
+	return nil
+}
+
+// resetPackages discards the packages defined with `%%package`, e.g. after a `%reset`.
+func (s *State) resetPackages() {
+	for name := range s.packages {
+		if err := os.RemoveAll(s.PackageDir(name)); err != nil {
+			klog.Errorf("Failed to remove package %q: %+v", name, err)
+		}
+	}
+	s.packages = make(map[string]*cellPackage)
+}
@@ -0,0 +1,107 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackages(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.GoModInit())
+
+	require.Error(t, validatePackageName("main"))
+	require.Error(t, validatePackageName("gonb_x"))
+	require.Error(t, validatePackageName("my-pkg"))
+	require.NoError(t, validatePackageName("mypkg"))
+
+	// The directories used by GoNB are not overwritten: e.g. `%%package persist` would remove the persisted values.
+	for _, subdir := range []string{PersistSubdir, DebugCellsSubdir} {
+		require.Error(t, validatePackageName(subdir))
+		dir := path.Join(s.TempDir, subdir)
+		require.NoError(t, os.MkdirAll(dir, 0700))
+		valuePath := path.Join(dir, "value")
+		require.NoError(t, os.WriteFile(valuePath, []byte("1"), 0600))
+		err := s.executePackageCellImpl(nil, 1, subdir, []string{"%%package " + subdir, "func F() {}"})
+		require.Errorf(t, err, "%%%%package %s must fail", subdir)
+		assert.FileExists(t, valuePath)
+		assert.NoFileExists(t, path.Join(dir, MainGo))
+	}
+
+	// executePackageCell without `goimports`: cells must import what they use.
+	executePackageCell := func(cellId int, cell string) error {
+		s.cellPackage = "mypkg"
+		defer func() { s.cellPackage = "" }()
+		updatedDecls, fileToCellIdAndLine, err := s.parseLinesAndComposePackage(nil, cellId, strings.Split(cell, "\n"))
+		require.NoError(t, err)
+		if err = s.Compile(nil, fileToCellIdAndLine); err != nil {
+			s.restorePackage(s.cellPackage)
+			return err
+		}
+		code, err := os.ReadFile(s.CodePath())
+		require.NoError(t, err)
+		s.packages[s.cellPackage] = &cellPackage{definitions: updatedDecls, code: code}
+		return nil
+	}
+	require.NoError(t, executePackageCell(1, "%%package mypkg\nimport \"strings\"\nfunc Upper(s string) string { return strings.ToUpper(s) }"))
+	require.NoError(t, executePackageCell(2, "%%package mypkg\nfunc Twice(s string) string { return Upper(s) + s }"))
+	assert.Equal(t, []string{"mypkg"}, s.Packages())
+	assert.Equal(t, []string{"func Twice", "func Upper", "import strings"}, s.PackageDefinitions("mypkg").keys())
+	assert.Empty(t, s.Definitions.keys())
+
+	// Errors are mapped to the cell lines.
+	s.rawError = true
+	err := executePackageCell(3, "%%package mypkg\n\nfunc Broken() int { return undefinedName }")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "undefined: undefinedName")
+	var nbErr *GonbError
+	require.ErrorAs(t, err, &nbErr)
+	assert.Contains(t, strings.Join(nbErr.Traceback(), "\n"), "Cell[3]: Line 3")
+	assert.Equal(t, []string{"func Twice", "func Upper", "import strings"}, s.PackageDefinitions("mypkg").keys())
+
+	// The main package imports it.
+	cell := fmt.Sprintf("import (\"flag\"; \"fmt\"; \"%s\")\n%%%%\nfmt.Println(mypkg.Twice(\"a\"))", s.PackageImportPath("mypkg"))
+	_, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 4, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	require.NoError(t, s.Compile(nil, fileToCellIdAndLine))
+	output, err := exec.Command(s.BinaryPath()).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "Aa\n", string(output))
+
+	// `go vet` findings in the package are located in its cells.
+	s.ResetDiagnostics(nil)
+	s.cellPackage = "mypkg"
+	cell = "%%package mypkg\nimport \"fmt\"\nfunc Show(x int) { fmt.Printf(\"%s\\n\", x) }"
+	_, fileToCellIdAndLine, err = s.parseLinesAndComposePackage(nil, 5, strings.Split(cell, "\n"))
+	require.NoError(t, err)
+	s.vet(nil, fileToCellIdAndLine)
+	s.cellPackage = ""
+	diagnostics := s.Diagnostics()
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, 5, diagnostics[0].ExecutionCount)
+	assert.Equal(t, 2, diagnostics[0].Line)
+	assert.Equal(t, "printf", diagnostics[0].Code)
+	s.restorePackage("mypkg")
+
+	// Packages belong to the namespace.
+	require.NoError(t, s.SwitchNamespace("other"))
+	assert.Empty(t, s.Packages())
+	assert.NoDirExists(t, s.PackageDir("mypkg"))
+	require.NoError(t, s.SwitchNamespace(DefaultNamespace))
+	assert.FileExists(t, path.Join(s.PackageDir("mypkg"), MainGo))
+
+	s.Reset()
+	assert.Empty(t, s.Packages())
+	assert.NoDirExists(t, s.PackageDir("mypkg"))
+}
@@ -9,7 +9,9 @@ import (
 	"io/fs"
 	"math/rand"
 	"os"
+	"path"
 	"regexp"
+	"slices"
 	"strings"
 
 	. "github.com/janpfeifer/gonb/common"
@@ -133,7 +135,8 @@ func (s *State) parseFromGoCode(msg kernel.Message,
 	}
 	var packages map[string]*ast.Package
 	// Parse "main.go" or "main_test.go".
-	packages, err = parser.ParseDir(pi.fileSet, s.TempDir, func(info fs.FileInfo) bool {
+	codeDir := path.Dir(s.CodePath())
+	packages, err = parser.ParseDir(pi.fileSet, codeDir, func(info fs.FileInfo) bool {
 		name := info.Name()
 		keep := name == "main.go" || name == "main_test.go"
 		klog.V(2).Infof("parser.ParseDir().filter(%q) -> keep=%v", name, keep)
@@ -143,61 +146,69 @@ func (s *State) parseFromGoCode(msg kernel.Message,
 		if msg != nil {
 			err = s.DisplayErrorWithContext(msg, fileToCellIdAndLine, err.Error(), err)
 		}
-		err = errors.Wrapf(err, "parsing go files in TempDir %q", s.TempDir)
+		err = errors.Wrapf(err, "parsing go files in %q", codeDir)
 		return
 	}
 
 	pi.filesContents = make(map[string]string)
 	for name, pkgAst := range packages {
 		klog.V(2).Infof("Parsed package %q:\n", pkgAst.Name)
-		if name != "main" {
+		if name != s.codePackageName() {
 			err = errors.New("Invalid package %q declared: there should be no `package` declaration, " +
 				"GoNB will automatically create `package main` when combining cell code.")
 			return
 		}
 		for fileName, fileObj := range pkgAst.Files {
 			// Currently, there is only `main.go`, and potentially `main_test.go` files.
-			klog.V(2).Infof("> Parsed file %q: %d declarations\n", fileName, len(fileObj.Decls))
-			content, err := os.ReadFile(fileName)
-			if err != nil {
-				return nil, errors.Wrapf(err, "Failed to read %q", fileObj.Name)
+			if err = pi.parseFile(decls, fileName, fileObj); err != nil {
+				return
 			}
-			pi.filesContents[fileName] = string(content)
+		}
+	}
+	return
+}
 
-			// Incorporate Imports
-			for _, entry := range fileObj.Imports {
-				pi.ParseImportEntry(decls, entry)
-			}
+// parseFile reads the declarations of the parsed file into decls.
+func (pi *parseInfo) parseFile(decls *Declarations, fileName string, fileObj *ast.File) error {
+	klog.V(2).Infof("> Parsed file %q: %d declarations\n", fileName, len(fileObj.Decls))
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read %q", fileObj.Name)
+	}
+	pi.filesContents[fileName] = string(content)
 
-			// Enumerate various declarations.
-			for _, decl := range fileObj.Decls {
-				switch typedDecl := decl.(type) {
-				case *ast.FuncDecl:
-					if klog.V(2).Enabled() {
-						klog.Infof("> Declaration %T: %+v", typedDecl, typedDecl.Name)
-					}
-					pi.ParseFuncEntry(decls, typedDecl)
-				case *ast.GenDecl:
-					klog.V(2).Infof("> Declaration %T: %s", typedDecl, typedDecl.Tok)
-					if typedDecl.Tok == token.IMPORT {
-						// Imports are handled above.
-						continue
-					} else if typedDecl.Tok == token.VAR {
-						pi.ParseVarEntry(decls, typedDecl)
-					} else if typedDecl.Tok == token.CONST {
-						pi.ParseConstEntry(decls, typedDecl)
-					} else if typedDecl.Tok == token.TYPE {
-						pi.ParseTypeEntry(decls, typedDecl)
-					} else {
-						klog.Warningf("Dropped unknown generic declaration of type %s\n", typedDecl.Tok)
-					}
-				default:
-					klog.Warningf("Dropped unknown declaration type %T\n", decl)
-				}
+	// Incorporate Imports
+	for _, entry := range fileObj.Imports {
+		pi.ParseImportEntry(decls, entry)
+	}
+
+	// Enumerate various declarations.
+	for _, decl := range fileObj.Decls {
+		switch typedDecl := decl.(type) {
+		case *ast.FuncDecl:
+			if klog.V(2).Enabled() {
+				klog.Infof("> Declaration %T: %+v", typedDecl, typedDecl.Name)
+			}
+			pi.ParseFuncEntry(decls, typedDecl)
+		case *ast.GenDecl:
+			klog.V(2).Infof("> Declaration %T: %s", typedDecl, typedDecl.Tok)
+			if typedDecl.Tok == token.IMPORT {
+				// Imports are handled above.
+				continue
+			} else if typedDecl.Tok == token.VAR {
+				pi.ParseVarEntry(decls, typedDecl)
+			} else if typedDecl.Tok == token.CONST {
+				pi.ParseConstEntry(decls, typedDecl)
+			} else if typedDecl.Tok == token.TYPE {
+				pi.ParseTypeEntry(decls, typedDecl)
+			} else {
+				klog.Warningf("Dropped unknown generic declaration of type %s\n", typedDecl.Tok)
 			}
+		default:
+			klog.Warningf("Dropped unknown declaration type %T\n", decl)
 		}
 	}
-	return
+	return nil
 }
 
 // NewImport from the importPath and it's alias. If alias is empty or "<nil>", it will default to the
@@ -263,6 +274,7 @@ func (pi *parseInfo) ParseFuncEntry(decls *Declarations, funcDecl *ast.FuncDecl)
 	}
 	f.CellLines = pi.calculateCellLines(funcDecl)
 	f.Cursor = pi.getCursor(funcDecl)
+	f.Uses = usedIdentifiers(funcDecl)
 	decls.Functions[f.Key] = f
 }
 
@@ -309,6 +321,7 @@ func (pi *parseInfo) ParseVarEntry(decls *Declarations, genDecl *ast.GenDecl) {
 			cursorInType = pi.getCursor(vType)
 		}
 
+		uses := usedIdentifiers(vSpec)
 		isTuple := len(vSpec.Names) > 0 && len(vSpec.Values) == 1
 		var tupleDefinitions []*Variable
 		if isTuple {
@@ -317,7 +330,7 @@ func (pi *parseInfo) ParseVarEntry(decls *Declarations, genDecl *ast.GenDecl) {
 
 		// Each spec may be a list of variables (comma separated).
 		for nameIdx, name := range vSpec.Names {
-			v := &Variable{Name: name.Name, TypeDefinition: typeDefinition}
+			v := &Variable{Name: name.Name, TypeDefinition: typeDefinition, Uses: uses}
 			if isTuple {
 				v.TupleDefinitions = tupleDefinitions
 				tupleDefinitions[nameIdx] = v
@@ -371,9 +384,10 @@ func (pi *parseInfo) ParseConstEntry(decls *Declarations, typedDecl *ast.GenDecl
 			typeDefinition = pi.extractContentOfNode(vType)
 			cursorInType = pi.getCursor(vType)
 		}
+		uses := usedIdentifiers(vSpec)
 		// Each spec may be a list of variables (comma separated).
 		for nameIdx, name := range vSpec.Names {
-			c := &Constant{Cursor: NoCursor, Key: name.Name, TypeDefinition: typeDefinition}
+			c := &Constant{Cursor: NoCursor, Key: name.Name, TypeDefinition: typeDefinition, Uses: uses}
 			c.Prev = prevConstDecl
 			if c.Prev != nil {
 				c.Prev.Next = c
@@ -414,7 +428,7 @@ func (pi *parseInfo) ParseTypeEntry(decls *Declarations, typedDecl *ast.GenDecl)
 		tSpec := spec.(*ast.TypeSpec)
 		name := tSpec.Name.Name
 		tDef := pi.extractContentOfNode(tSpec)
-		tDecl := &TypeDecl{Key: name, TypeDefinition: tDef}
+		tDecl := &TypeDecl{Key: name, TypeDefinition: tDef, Uses: usedIdentifiers(tSpec)}
 		if c := pi.getCursor(tSpec); c.HasCursor() {
 			tDecl.Cursor = c
 			tDecl.CursorInType = true
@@ -424,6 +438,36 @@ func (pi *parseInfo) ParseTypeEntry(decls *Declarations, typedDecl *ast.GenDecl)
 	}
 }
 
+// usedIdentifiers returns the sorted identifiers referenced under node, that may refer to other declarations.
+// Without object resolution (see parseFromGoCode), local identifiers are also included.
+// Selected fields and methods, names of fields and parameters, and labels are not included.
+func usedIdentifiers(node ast.Node) []string {
+	excluded := MakeSet[*ast.Ident]()
+	used := MakeSet[string]()
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			excluded.Insert(n.Sel)
+		case *ast.Field:
+			for _, name := range n.Names {
+				excluded.Insert(name)
+			}
+		case *ast.LabeledStmt:
+			excluded.Insert(n.Label)
+		case *ast.BranchStmt:
+			if n.Label != nil {
+				excluded.Insert(n.Label)
+			}
+		case *ast.Ident:
+			if n.Name != "_" && !excluded.Has(n) {
+				used.Insert(n.Name)
+			}
+		}
+		return true
+	})
+	return SortedKeys(used)
+}
+
 // parseLinesAndComposeMain parses the cell (given in Lines and skipLines), merges with
 // memorized declarations in the State (presumably from previous Cell runs) and compose a `main.go`.
 //
@@ -450,6 +494,7 @@ func (s *State) parseLinesAndComposeMain(
 	if err = s.RemoveGeneratedCode(); err != nil {
 		return
 	}
+	s.sourceLines[cellId] = slices.Clone(lines)
 	cursorInFile, fileToCellLine, err = s.createGoFileFromLines(s.CodePath(), cellId, lines, skipLines, cursorInCell)
 	if err != nil {
 		return
@@ -13,11 +13,11 @@ import (
 )
 
 // newEmptyState returns an empty state with a temporary directory created.
-func newEmptyState(t *testing.T) *State {
+func newEmptyState(t testing.TB) *State {
 	return newEmptyStateWithRawError(t, false)
 }
 
-func newEmptyStateWithRawError(t *testing.T, rawError bool) *State {
+func newEmptyStateWithRawError(t testing.TB, rawError bool) *State {
 	uuidTmp, _ := uuid.NewV7()
 	uuidStr := uuidTmp.String()
 	uniqueID := uuidStr[len(uuidStr)-8:]
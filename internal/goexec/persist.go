@@ -0,0 +1,298 @@
+package goexec
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/janpfeifer/gonb/cache"
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the persistence of variables across cell executions, controlled by the
+// special command `%persist`.
+//
+// For each variable marked as persistent, the generated `main()` saves its value when the program exits,
+// and the variable initialization is wrapped in a function that restores the saved value, if there is one,
+// instead of re-executing the original initialization.
+//
+// Values are saved with the `cache` package, in a `persist` subdirectory of `State.TempDir`, so they use
+// `encoding/gob` or the `cache.Serializable` interface, if the type implements it.
+
+const (
+	// PersistGo is the name of the generated file, in `State.TempDir`, with the helper functions
+	// used to save and restore persistent variables.
+	PersistGo = "gonb_persist.go"
+
+	// PersistSubdir is the subdirectory of `State.TempDir` where persistent variables are saved.
+	PersistSubdir = "persist"
+)
+
+// persistInfo holds the information about the variables marked as persistent with `%persist`.
+type persistInfo struct {
+	// variables marked as persistent.
+	variables Set[string]
+
+	// definitions holds the definition (type and value) of each persistent variable the last time
+	// it was executed. If it changes, the saved value is discarded.
+	definitions map[string]string
+}
+
+func newPersistInfo() *persistInfo {
+	return &persistInfo{
+		variables:   MakeSet[string](),
+		definitions: make(map[string]string),
+	}
+}
+
+// PersistDir returns the directory where persistent variables are saved.
+func (s *State) PersistDir() string {
+	return path.Join(s.TempDir, PersistSubdir)
+}
+
+// persistStorage returns the cache.Storage used to save persistent variables.
+func (s *State) persistStorage() (*cache.Storage, error) {
+	return cache.New(s.PersistDir())
+}
+
+// Persist marks the given variables as persistent.
+func (s *State) Persist(names ...string) error {
+	for _, name := range names {
+		if name == "_" || strings.ContainsAny(name, "/~ \t") {
+			return errors.Errorf("invalid variable name %q for %%persist", name)
+		}
+		s.persist.variables.Insert(name)
+	}
+	return nil
+}
+
+// Unpersist removes the persistent mark from the given variables, and discards their saved values.
+func (s *State) Unpersist(names ...string) error {
+	storage, err := s.persistStorage()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !s.persist.variables.Has(name) {
+			return errors.Errorf("variable %q is not marked as persistent", name)
+		}
+		s.persist.variables.Delete(name)
+		delete(s.persist.definitions, name)
+		if err = storage.ResetKey(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetPersisted discards the saved values of all persistent variables, so they are re-initialized
+// in the next execution. The variables are still kept as persistent.
+func (s *State) ResetPersisted() error {
+	storage, err := s.persistStorage()
+	if err != nil {
+		return err
+	}
+	s.persist.definitions = make(map[string]string)
+	return storage.Reset()
+}
+
+// ListPersisted returns the sorted names of the variables marked as persistent.
+func (s *State) ListPersisted() []string {
+	return SortedKeys(s.persist.variables)
+}
+
+// IsPersistedSaved returns whether there is a value saved for the persistent variable.
+func (s *State) IsPersistedSaved(name string) bool {
+	storage, err := s.persistStorage()
+	if err != nil {
+		return false
+	}
+	keys, err := storage.ListKeys()
+	if err != nil {
+		return false
+	}
+	for _, key := range keys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// persistableVariable returns the variable definition for the persistent variable name, or an
+// explanation of why it can't be persisted.
+func persistableVariable(decls *Declarations, name string) (*Variable, string) {
+	v, found := decls.Variables[name]
+	if !found {
+		return nil, "not declared (yet)"
+	}
+	if len(v.TupleDefinitions) > 1 {
+		return nil, "variables defined as a tuple (e.g. `var a, b = f()`) can't be persisted"
+	}
+	if v.TypeDefinition == "" {
+		return nil, fmt.Sprintf("it requires an explicit type in its declaration, e.g. `var %s MyType = ...`", name)
+	}
+	return v, ""
+}
+
+// persistedVariables returns the sorted names of the persistent variables that can be persisted in decls.
+func (s *State) persistedVariables(decls *Declarations) []string {
+	if s.CellIsWasm || len(s.persist.variables) == 0 {
+		return nil
+	}
+	var names []string
+	for _, name := range SortedKeys(s.persist.variables) {
+		if v, _ := persistableVariable(decls, name); v != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// persistPrefix wraps the value definition of a persistent variable.
+// It must not include new lines, so the line numbers mapping to the cells are preserved.
+func persistPrefix(v *Variable) string {
+	return fmt.Sprintf("gonbPersistLoad(%q, func() %s { return ", v.Name, v.TypeDefinition)
+}
+
+// wrapVariableSuffix closes the function literal opened by the prefixes used with wrapVariableValue.
+const wrapVariableSuffix = " })"
+
+// wrapVariableValue returns a copy of v with its value definition wrapped by prefix and suffix. If v has no
+// value definition, the zero value of its type is used.
+//
+// prefix and suffix must not include new lines, so the line numbers mapping to the cells are preserved.
+func wrapVariableValue(v *Variable, prefix, suffix string) *Variable {
+	newV := *v                  // Shallow copy.
+	newV.TupleDefinitions = nil // Single variable "tuple", it would point to the original Variable.
+	if newV.ValueDefinition == "" {
+		newV.ValueDefinition = prefix + fmt.Sprintf("*new(%s)", newV.TypeDefinition) + suffix
+	} else {
+		newV.ValueDefinition = prefix + newV.ValueDefinition + suffix
+		if newV.CursorInValue && newV.Cursor.Line == 0 {
+			newV.Cursor.Col += len(prefix)
+		}
+	}
+	return &newV
+}
+
+// persistDecls returns a copy of decls where the initialization of the persistent variables is wrapped
+// by a call to restore the previously saved values.
+//
+// The returned declarations are used only for rendering, they should not be memorized.
+func (s *State) persistDecls(decls *Declarations, names []string) *Declarations {
+	if len(names) == 0 {
+		return decls
+	}
+	decls = decls.Copy()
+	for _, name := range names {
+		v := decls.Variables[name]
+		decls.Variables[name] = wrapVariableValue(v, persistPrefix(v), wrapVariableSuffix)
+	}
+	return decls
+}
+
+// persistMainPrefix is injected at the start of `func main()`, in the same line, when there are persistent variables.
+const persistMainPrefix = " defer gonbPersistSave();"
+
+// persistMain returns a copy of mainDecl that saves the persistent variables on exit.
+func (s *State) persistMain(mainDecl *Function, names []string) *Function {
+	if len(names) == 0 {
+		return mainDecl
+	}
+	return injectIntoMain(mainDecl, persistMainPrefix)
+}
+
+// writePersistFile writes (or removes if there are no persistent variables) the PersistGo file with the helper
+// functions used to save and restore persistent variables.
+func (s *State) writePersistFile(names []string) error {
+	if len(names) == 0 {
+		return s.writeOrRemoveGenerated(PersistGo, "")
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(persistGoTemplate, s.PersistDir()))
+	sb.WriteString("\nfunc gonbPersistSave() {\n")
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("\tgonbPersistSaveVar(%q, %s)\n", name, name))
+	}
+	sb.WriteString("}\n")
+	return s.writeOrRemoveGenerated(PersistGo, sb.String())
+}
+
+// persistGoTemplate is the static part of PersistGo. It takes as parameter the storage directory.
+const persistGoTemplate = `// Generated by GoNB to support %%persist: do not edit.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/janpfeifer/gonb/cache"
+)
+
+var gonbPersistStorage = cache.MustNew(%q)
+
+// gonbPersistLoad returns the value saved for key, or the result of fn if there is none.
+func gonbPersistLoad[T any](key string, fn func() T) T {
+	value, err := cache.LoadWith[T](gonbPersistStorage, key)
+	if err == nil {
+		return value
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "%%%%persist: failed to restore variable %%q, re-initializing it: %%v\n", key, err)
+	}
+	return fn()
+}
+
+// gonbPersistSaveVar saves the value of a persistent variable.
+func gonbPersistSaveVar(key string, value any) {
+	err := gonbPersistStorage.Save(key, value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%%%%persist: variable %%q of type %%T can't be saved: %%v\n"+
+			"\tTypes need to be serializable by encoding/gob (no functions, channels and only exported fields),\n"+
+			"\tor implement cache.Serializable. Use \"%%%%persist --rm %%s\" to stop persisting it.\n",
+			key, value, err, key)
+		_ = gonbPersistStorage.ResetKey(key)
+	}
+}
+`
+
+// persistPreExecute is called before executing a cell, after it compiled successfully.
+// It discards saved values of persistent variables whose definitions changed, and reports
+// persistent variables that can't be persisted.
+func (s *State) persistPreExecute(msg kernel.Message, decls *Declarations) {
+	if s.CellIsWasm || len(s.persist.variables) == 0 {
+		return
+	}
+	storage, err := s.persistStorage()
+	if err != nil {
+		klog.Errorf("%%persist: failed to open storage: %+v", err)
+		return
+	}
+	var warnings []string
+	for _, name := range SortedKeys(s.persist.variables) {
+		v, reason := persistableVariable(decls, name)
+		if v == nil {
+			warnings = append(warnings, fmt.Sprintf("%%persist: variable %q not persisted: %s\n", name, reason))
+			continue
+		}
+		definition := v.TypeDefinition + " = " + v.ValueDefinition
+		if previous, found := s.persist.definitions[name]; found && previous != definition {
+			klog.V(1).Infof("%%persist: definition of %q changed, discarding saved value", name)
+			if err = storage.ResetKey(name); err != nil {
+				klog.Errorf("%%persist: failed to discard saved value of %q: %+v", name, err)
+			}
+		}
+		s.persist.definitions[name] = definition
+	}
+	if len(warnings) > 0 && msg != nil {
+		err = kernel.PublishWriteStream(msg, kernel.StreamStderr, strings.Join(warnings, ""))
+		if err != nil {
+			klog.Errorf("Failed to publish %%persist warnings: %+v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package goexec
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersist(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.Persist("x", "y", "z"))
+	require.Error(t, s.Persist("_"))
+	s.RequestVariables()
+
+	cellLines := strings.Split(`var x []float64 = []float64{
+	1, 2, 3}
+var y = 7
+var z int
+
+%%
+fmt.Println(x, y, z)`, "\n")
+	skipLines := MakeSet[int]()
+	updatedDecls, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 1, cellLines, skipLines, NoCursor)
+	require.NoError(t, err)
+
+	// Only "x" and "z" have explicit types, and can be persisted.
+	assert.Equal(t, []string{"x", "z"}, s.persistedVariables(updatedDecls))
+
+	// Memorized declarations should not be changed.
+	assert.Equal(t, "[]float64{\n\t1, 2, 3}", updatedDecls.Variables["x"].ValueDefinition)
+
+	contentBytes, err := os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	content := string(contentBytes)
+	assert.Contains(t, content, `x []float64 = gonbPersistLoad("x", func() []float64 { return []float64{`+"\n\t1, 2, 3} })")
+	assert.Contains(t, content, `z int = gonbPersistLoad("z", func() int { return *new(int) })`)
+	assert.Contains(t, content, "y = 7\n")
+	assert.Contains(t, content, "func main() { defer gonbVarsSave(); defer gonbPersistSave();\n")
+
+	// Line mapping must be preserved.
+	fileLines := strings.Split(content, "\n")
+	for ii, cellIdAndLine := range fileToCellIdAndLine {
+		if cellIdAndLine.Line == NoCursorLine || cellLines[cellIdAndLine.Line] == "%%" {
+			continue
+		}
+		expected := strings.TrimPrefix(strings.TrimSpace(cellLines[cellIdAndLine.Line]), "var ")[:3]
+		assert.Containsf(t, fileLines[ii], expected,
+			"main.go line %d mapped to cell line %d", ii, cellIdAndLine.Line)
+	}
+
+	persistContent, err := os.ReadFile(path.Join(s.TempDir, PersistGo))
+	require.NoError(t, err)
+	assert.Contains(t, string(persistContent), "gonbPersistSaveVar(\"x\", x)\n\tgonbPersistSaveVar(\"z\", z)\n")
+	assert.Contains(t, string(persistContent), s.PersistDir())
+
+	// Without persistent variables, the helper file is removed.
+	require.NoError(t, s.Unpersist("x", "y", "z"))
+	_, _, _, _, err = s.parseLinesAndComposeMain(nil, 2, cellLines, skipLines, NoCursor)
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(s.TempDir, PersistGo))
+	require.True(t, os.IsNotExist(err))
+	contentBytes, err = os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	assert.NotContains(t, string(contentBytes), "gonbPersist")
+}
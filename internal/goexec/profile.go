@@ -0,0 +1,220 @@
+package goexec
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the profiling of cells (`%pprof` and `%trace`): the generated `main()` is changed to
+// start the profile at the beginning and stop it at the end -- with the code in the generated ProfileGo file.
+// The profile is then offered as a download by the program, and rendered by GoNB as a flame graph and a
+// table with the top functions.
+
+// ProfileKind is the kind of profile collected during the execution of a cell.
+type ProfileKind string
+
+const (
+	ProfileNone  ProfileKind = ""
+	ProfileCPU   ProfileKind = "cpu"
+	ProfileHeap  ProfileKind = "heap"
+	ProfileBlock ProfileKind = "block"
+	ProfileTrace ProfileKind = "trace"
+)
+
+// ProfileGo is the name of the generated file, in `State.TempDir`, with the functions that start and stop the
+// profiling of the cell.
+const ProfileGo = "gonb_profile.go"
+
+// profileMainPrefix is inserted just after the opening "{" of `main()`, in the same line.
+const profileMainPrefix = " defer gonbProfile()();"
+
+// ProfileTopN is the number of functions listed in the table rendered for profiles.
+var ProfileTopN = 20
+
+// ProfilePath is the path where the profile of the cell is saved.
+func (s *State) ProfilePath() string {
+	return path.Join(s.TempDir, "gonb_profile.out")
+}
+
+// profileFileName is the name of the file offered as download.
+func (kind ProfileKind) profileFileName() string {
+	if kind == ProfileTrace {
+		return "trace.out"
+	}
+	return string(kind) + ".pprof"
+}
+
+// profileStartStop has the Go code that starts the profile of each kind, and returns the function that stops it.
+var profileStartStop = map[ProfileKind]string{
+	ProfileCPU: `	if err := pprof.StartCPUProfile(f); err != nil {
+		panic(err)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		gonbProfileDone(f)
+	}`,
+	ProfileHeap: `	return func() {
+		runtime.GC()
+		if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+			panic(err)
+		}
+		gonbProfileDone(f)
+	}`,
+	ProfileBlock: `	runtime.SetBlockProfileRate(1)
+	return func() {
+		if err := pprof.Lookup("block").WriteTo(f, 0); err != nil {
+			panic(err)
+		}
+		gonbProfileDone(f)
+	}`,
+	ProfileTrace: `	if err := trace.Start(f); err != nil {
+		panic(err)
+	}
+	return func() {
+		trace.Stop()
+		gonbProfileDone(f)
+	}`,
+}
+
+// profileGoTemplate is the template of the ProfileGo file: the first parameter is the path to the profile file, the
+// second the code from profileStartStop and the third the name of the file offered as download.
+const profileGoTemplate = `// Generated by GoNB to profile the cell: do not edit.
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+)
+
+var (
+	_ = runtime.GC
+	_ = pprof.Lookup
+	_ = trace.Start
+)
+
+// gonbProfile starts profiling and returns the function that stops it.
+func gonbProfile() func() {
+	f, err := os.Create(%q)
+	if err != nil {
+		panic(err)
+	}
+%s
+}
+
+// gonbProfileDone closes the profile file, and offers it as a download.
+func gonbProfileDone(f *os.File) {
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+	if data, err := os.ReadFile(f.Name()); err == nil {
+		dom.SendAsDownload(%q, data, "application/octet-stream")
+		gonbui.Sync()
+	}
+}
+`
+
+// profileMain returns a copy of mainDecl that starts the profile of the cell (see `%pprof`), if one was requested.
+// If no profile is requested it returns mainDecl unchanged and false.
+// The line mapping to the cell is preserved.
+func (s *State) profileMain(mainDecl *Function) (*Function, bool) {
+	if mainDecl == nil || s.CellProfile == ProfileNone || s.CellIsTest || s.CellIsWasm {
+		return mainDecl, false
+	}
+	return injectIntoMain(mainDecl, profileMainPrefix), true
+}
+
+// writeProfileFile writes (or removes if not needed) the ProfileGo file.
+func (s *State) writeProfileFile(hasProfile bool) error {
+	if !hasProfile {
+		return s.writeOrRemoveGenerated(ProfileGo, "")
+	}
+	return s.writeOrRemoveGenerated(ProfileGo, fmt.Sprintf(profileGoTemplate, s.ProfilePath(),
+		profileStartStop[s.CellProfile], s.CellProfile.profileFileName()))
+}
+
+// removeProfile removes the profile of a previous execution, if there is one.
+func (s *State) removeProfile() error {
+	if err := os.Remove(s.ProfilePath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove previous profile %q", s.ProfilePath())
+	}
+	return nil
+}
+
+// publishProfile renders the profile collected during the execution of the cell, if any, as a flame graph
+// and a table with the top functions.
+func (s *State) publishProfile(msg kernel.Message) {
+	if s.CellProfile == ProfileNone || msg == nil {
+		return
+	}
+	if _, err := os.Stat(s.ProfilePath()); err != nil {
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr,
+			"No profile was collected: the program may have exited before main() returned.\n")
+		return
+	}
+	if s.CellProfile == ProfileTrace {
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf(
+			"Execution trace saved in %q (also offered as a download): use `go tool trace` to inspect it.\n",
+			s.ProfilePath()))
+		return
+	}
+
+	top, err := s.runPProf("-top", fmt.Sprintf("-nodecount=%d", ProfileTopN))
+	if err != nil {
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf("Failed to render profile: %v\n", err))
+		return
+	}
+	var htmlContent strings.Builder
+	traces, err := s.runPProf("-traces")
+	if err == nil {
+		var svg string
+		svg, err = flameGraphSVG(parsePProfTraces(traces))
+		if err == nil {
+			htmlContent.WriteString("<div>")
+			htmlContent.WriteString(svg)
+			htmlContent.WriteString("</div>")
+		}
+	}
+	if err != nil {
+		klog.Warningf("Failed to render flame graph for profile: %+v", err)
+	}
+	fmt.Fprintf(&htmlContent, "<pre>%s</pre>", html.EscapeString(top))
+	err = kernel.PublishDisplayData(msg, kernel.Data{
+		Data: kernel.MIMEMap{
+			string(protocol.MIMETextPlain): top,
+			string(protocol.MIMETextHTML):  htmlContent.String(),
+		},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: make(kernel.MIMEMap),
+	})
+	if err != nil {
+		klog.Errorf("Failed to publish profile: %+v", err)
+	}
+}
+
+// runPProf runs `go tool pprof` with the given arguments on the profile of the cell, and returns its output.
+func (s *State) runPProf(args ...string) (string, error) {
+	args = append([]string{"tool", "pprof"}, args...)
+	args = append(args, s.ProfilePath())
+	cmd := exec.Command("go", args...)
+	cmd.Dir = s.TempDir
+	klog.V(2).Infof("Executing %s", cmd)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to run %q: %s", cmd, output)
+	}
+	return string(output), nil
+}
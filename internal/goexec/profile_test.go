@@ -0,0 +1,73 @@
+package goexec
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileMain(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	cellLines := strings.Split("%%\nfmt.Println(\"hello\")", "\n")
+	s.CellProfile = ProfileCPU
+	_, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	content, err := os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "func main() { defer gonbProfile()();\n")
+	profileContent, err := os.ReadFile(path.Join(s.TempDir, ProfileGo))
+	require.NoError(t, err)
+	assert.Contains(t, string(profileContent), "pprof.StartCPUProfile(f)")
+	assert.Contains(t, string(profileContent), `dom.SendAsDownload("cpu.pprof"`)
+
+	// Without profiling, the helper file is removed.
+	s.CellProfile = ProfileNone
+	_, _, _, _, err = s.parseLinesAndComposeMain(nil, 2, cellLines, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(s.TempDir, ProfileGo))
+	require.True(t, os.IsNotExist(err))
+}
+
+// sampleTraces is an excerpt of the output of `go tool pprof -traces`.
+const sampleTraces = `File: prof
+Type: inuse_space
+Time: 2026-10-16 13:37:47 UTC
+-----------+-------------------------------------------------------
+     bytes:  64kB
+    1.50MB   main.main
+             runtime.main
+-----------+-------------------------------------------------------
+     bytes:  128kB
+  512kB   main.(*T).alloc
+             main.main
+             runtime.main
+-----------+-------------------------------------------------------
+`
+
+func TestFlameGraph(t *testing.T) {
+	traces := parsePProfTraces(sampleTraces)
+	require.Len(t, traces, 2)
+	assert.Equal(t, 1.5*(1<<20), traces[0].value)
+	assert.Equal(t, []string{"main.main", "runtime.main"}, traces[0].stack)
+	assert.Equal(t, float64(512<<10), traces[1].value)
+	assert.Equal(t, []string{"main.(*T).alloc", "main.main", "runtime.main"}, traces[1].stack)
+
+	svg, err := flameGraphSVG(traces)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(svg, "<svg"))
+	assert.Contains(t, svg, "<title>runtime.main (100.00%)</title>")
+	assert.Contains(t, svg, "<title>main.(*T).alloc (25.00%)</title>")
+
+	_, err = flameGraphSVG(nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package goexec
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the reporting of the changes to the memorized declarations made by a cell: declarations
+// replaced by a different definition, implicitly removed (e.g. the other variables of a tuple `var a, b = f()`,
+// when one of them is redefined), or shadowing a declaration of a different kind with the same name.
+//
+// The report is displayed as a note under the cell, after it is compiled. With `%strict`, ambiguous changes are
+// refused, unless the cell is confirmed with `%confirm`.
+
+// MergeReport lists the changes to the memorized declarations made by a cell. The declarations are identified
+// by their kind and key, as listed by `%list` (e.g. "func f").
+type MergeReport struct {
+	// Added declarations, not previously defined.
+	Added []string
+
+	// Replaced declarations with a different definition, with the execution number of the cell that
+	// previously defined them.
+	Replaced []Redefinition
+
+	// Removed declarations, that were implicitly dropped -- e.g. the other variables of a tuple.
+	Removed []Redefinition
+
+	// Shadowed are declarations of a different kind, that have the same name as a declaration of the cell.
+	Shadowed []Redefinition
+}
+
+// Redefinition is a memorized declaration changed by a cell.
+type Redefinition struct {
+	// Key of the declaration, e.g.: "func f".
+	Key string
+
+	// CellId is the execution number of the cell where the previous declaration came from.
+	CellId int
+
+	// Ambiguous is set if the previous declaration didn't come from the same front-end cell: with `%strict`, the
+	// change must be confirmed.
+	Ambiguous bool
+}
+
+// IsEmpty returns whether the report has no changes other than added declarations.
+func (r *MergeReport) IsEmpty() bool {
+	return len(r.Replaced) == 0 && len(r.Removed) == 0 && len(r.Shadowed) == 0
+}
+
+// Ambiguous returns the changes that must be confirmed with `%strict`.
+func (r *MergeReport) Ambiguous() []Redefinition {
+	var ambiguous []Redefinition
+	for _, changes := range [][]Redefinition{r.Replaced, r.Removed, r.Shadowed} {
+		for _, change := range changes {
+			if change.Ambiguous {
+				ambiguous = append(ambiguous, change)
+			}
+		}
+	}
+	return ambiguous
+}
+
+// mergeReport compares the declarations before (previous) and after (updated) merging the declarations of
+// the cell cellId.
+//
+// A change is ambiguous if the previous declaration came from a different front-end cell (see
+// frontEndCellId), or if it is not known.
+func (s *State) mergeReport(msg kernel.Message, cellId int, previous, updated *Declarations) *MergeReport {
+	previousNodes, _ := previous.dependencyGraph()
+	updatedNodes, _ := updated.dependencyGraph()
+	cellFrontEndId := frontEndCellId(msg)
+	isAmbiguous := func(previousCellId int) bool {
+		if cellFrontEndId == "" {
+			return true
+		}
+		s.stale.mu.Lock()
+		defer s.stale.mu.Unlock()
+		deps, found := s.stale.cells[previousCellId]
+		return !found || deps.frontEndCellId != cellFrontEndId
+	}
+
+	report := &MergeReport{}
+	namesByKind := make(map[string]Set[string])
+	for key := range updatedNodes {
+		kind, name, _ := strings.Cut(key, " ")
+		if namesByKind[name] == nil {
+			namesByKind[name] = MakeSet[string]()
+		}
+		namesByKind[name].Insert(kind)
+	}
+	for _, key := range SortedKeys(updatedNodes) {
+		node := updatedNodes[key]
+		if node.cellId != cellId || isBlankKey(key) {
+			continue
+		}
+		previousNode, found := previousNodes[key]
+		switch {
+		case !found:
+			report.Added = append(report.Added, key)
+		case previousNode.cellId != cellId && previousNode.definition != node.definition:
+			report.Replaced = append(report.Replaced,
+				Redefinition{Key: key, CellId: previousNode.cellId, Ambiguous: isAmbiguous(previousNode.cellId)})
+		}
+		kind, name, _ := strings.Cut(key, " ")
+		for _, otherKind := range SortedKeys(namesByKind[name]) {
+			otherKey := otherKind + " " + name
+			if otherKind == kind || updatedNodes[otherKey].cellId == cellId {
+				continue
+			}
+			report.Shadowed = append(report.Shadowed,
+				Redefinition{Key: otherKey, CellId: updatedNodes[otherKey].cellId, Ambiguous: true})
+		}
+	}
+	for _, key := range SortedKeys(previousNodes) {
+		if _, found := updatedNodes[key]; !found && !isBlankKey(key) {
+			previousNode := previousNodes[key]
+			report.Removed = append(report.Removed,
+				Redefinition{Key: key, CellId: previousNode.cellId, Ambiguous: isAmbiguous(previousNode.cellId)})
+		}
+	}
+	return report
+}
+
+// isBlankKey returns whether the key (as in Declarations.keys) is of a blank variable (`var _ = ...`), which
+// are never replaced.
+func isBlankKey(key string) bool {
+	return strings.HasPrefix(key, "var _~")
+}
+
+// checkStrict returns an error if the report has ambiguous changes, `%strict` is enabled and the cell
+// is not confirmed with `%confirm`.
+func (s *State) checkStrict(report *MergeReport) error {
+	if !s.Strict || s.CellConfirmed {
+		return nil
+	}
+	ambiguous := report.Ambiguous()
+	if len(ambiguous) == 0 {
+		return nil
+	}
+	parts := make([]string, 0, len(ambiguous))
+	for _, change := range ambiguous {
+		parts = append(parts, fmt.Sprintf("%s (from Cell[%d])", change.Key, change.CellId))
+	}
+	return errors.Errorf("%%strict: the cell replaces, removes or shadows %s: add `%%confirm` to the cell to accept it",
+		strings.Join(parts, ", "))
+}
+
+// publishMergeReport displays the report as a note under the cell, if there are declarations replaced,
+// removed or shadowed.
+func publishMergeReport(msg kernel.Message, report *MergeReport) {
+	if msg == nil || report.IsEmpty() {
+		return
+	}
+	var parts []string
+	addPart := func(title string, changes []Redefinition) {
+		if len(changes) == 0 {
+			return
+		}
+		items := make([]string, 0, len(changes))
+		for _, change := range changes {
+			items = append(items, fmt.Sprintf("<code>%s</code> (Cell[%d])", html.EscapeString(change.Key), change.CellId))
+		}
+		parts = append(parts, fmt.Sprintf("<b>%s:</b> %s", title, strings.Join(items, ", ")))
+	}
+	addPart("Replaced", report.Replaced)
+	addPart("Removed", report.Removed)
+	addPart("Shadowed", report.Shadowed)
+	if len(report.Added) > 0 {
+		items := make([]string, 0, len(report.Added))
+		for _, key := range report.Added {
+			items = append(items, fmt.Sprintf("<code>%s</code>", html.EscapeString(key)))
+		}
+		parts = append(parts, fmt.Sprintf("<b>Added:</b> %s", strings.Join(items, ", ")))
+	}
+	note := fmt.Sprintf(`<div style="font-size: small; opacity: 0.8">%s</div>`, strings.Join(parts, "; "))
+	if err := kernel.PublishHtml(msg, note); err != nil {
+		klog.Errorf("Failed to publish the changes to the memorized declarations: %+v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeReport(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	parseCell := func(cellId int, cell string) (previous, updated *Declarations) {
+		updatedDecls, _, _, _, err := s.parseLinesAndComposeMain(nil, cellId, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+		require.NoError(t, err)
+		return s.Definitions, updatedDecls
+	}
+	previous, updated := parseCell(1, "func g() (int, int) { return 1, 2 }\nvar a, b = g()\nfunc f() int { return 1 }")
+	report := s.mergeReport(nil, 1, previous, updated)
+	assert.Equal(t, []string{"func f", "func g", "var a", "var b"}, report.Added)
+	assert.True(t, report.IsEmpty())
+	s.Definitions = updated
+
+	// Redefining `a` implicitly removes `b`; `var g` shadows `func g`. Re-defining `f` with the same
+	// definition is not reported.
+	previous, updated = parseCell(2, "var a = 3\nfunc f() int { return 1 }\nvar g = 1")
+	report = s.mergeReport(nil, 2, previous, updated)
+	assert.Equal(t, []string{"var g"}, report.Added)
+	assert.Equal(t, []Redefinition{{Key: "var a", CellId: 1, Ambiguous: true}}, report.Replaced)
+	assert.Equal(t, []Redefinition{{Key: "var b", CellId: 1, Ambiguous: true}}, report.Removed)
+	assert.Equal(t, []Redefinition{{Key: "func g", CellId: 1, Ambiguous: true}}, report.Shadowed)
+	assert.Len(t, report.Ambiguous(), 3)
+
+	// %strict refuses the cell, unless confirmed.
+	require.NoError(t, s.checkStrict(report))
+	s.Strict = true
+	err := s.checkStrict(report)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "var a (from Cell[1]), var b (from Cell[1]), func g (from Cell[1])")
+	s.CellConfirmed = true
+	require.NoError(t, s.checkStrict(report))
+}
@@ -0,0 +1,230 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the pinning of module versions, with the special commands `%require` and `%drop`.
+//
+// Pinned versions are written in the `go.mod` in `State.TempDir`, and they are re-applied whenever `go.mod`
+// is re-created (State.GoModInit), and after `go get` (see State.AutoGet) changes them.
+//
+// The pins are also recorded in the notebook metadata, under RequirementsMetadataKey, so they can be
+// re-applied when the kernel restarts, see NotebookRequirements and State.SetRequirements: by `gonb run` in the
+// notebook metadata, and by the interactive kernel in the metadata of the outputs of `%require` and `%drop`.
+
+// RequirementsMetadataKey is the key, under the "gonb" object of the notebook metadata, holding the pinned module
+// versions, as an object mapping module path to version.
+const RequirementsMetadataKey = "requirements"
+
+// NotebookRequirements returns the module versions pinned in the notebook, a map of module path to version:
+// the ones recorded in the metadata of the last output of `%require` or `%drop`, if any, or otherwise the ones
+// in the notebook metadata. It returns nil if none were recorded.
+func NotebookRequirements(nb *ipynb.Notebook) (map[string]string, error) {
+	value := nb.OutputsGonbMetadata(RequirementsMetadataKey)
+	if value == nil {
+		value = nb.GonbMetadata(RequirementsMetadataKey)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	pins, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.Errorf("invalid notebook metadata %s.%s: expected an object mapping modules to versions, got %T",
+			ipynb.GonbMetadataKey, RequirementsMetadataKey, value)
+	}
+	requirements := make(map[string]string, len(pins))
+	for modPath, version := range pins {
+		versionStr, ok := version.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid notebook metadata %s.%s: version of %q should be a string, got %T",
+				ipynb.GonbMetadataKey, RequirementsMetadataKey, modPath, version)
+		}
+		requirements[modPath] = versionStr
+	}
+	return requirements, nil
+}
+
+// Requirement is a `require` line in `go.mod`.
+type Requirement struct {
+	Path, Version string
+
+	// Indirect is set for requirements only used by other dependencies.
+	Indirect bool
+
+	// Pinned is set if the version was pinned with `%require`.
+	Pinned bool
+}
+
+// Requirements returns the pinned module versions, a map of module path to version.
+func (s *State) Requirements() map[string]string {
+	return s.requirements
+}
+
+// SetRequirements replaces the pinned module versions (a map of module path to version), e.g. with the ones
+// recorded in the notebook metadata, and applies them to `go.mod`.
+func (s *State) SetRequirements(requirements map[string]string) error {
+	for modPath, version := range requirements {
+		if err := module.Check(modPath, version); err != nil {
+			return errors.Wrapf(err, "invalid requirement %s@%s", modPath, version)
+		}
+	}
+	s.requirements = make(map[string]string, len(requirements))
+	for modPath, version := range requirements {
+		s.requirements[modPath] = version
+	}
+	_, err := s.applyRequirements()
+	return err
+}
+
+// Require pins the version of the modules given as `module@version`, and updates `go.mod` accordingly.
+func (s *State) Require(modules ...string) error {
+	for _, mod := range modules {
+		modPath, version, found := strings.Cut(mod, "@")
+		if !found || version == "" {
+			return errors.Errorf("%%require: %q must be given as <module>@<version>", mod)
+		}
+		if err := module.Check(modPath, version); err != nil {
+			return errors.WithMessagef(err, "%%require: invalid %q", mod)
+		}
+	}
+	for _, mod := range modules {
+		modPath, version, _ := strings.Cut(mod, "@")
+		s.requirements[modPath] = version
+	}
+	_, err := s.applyRequirements()
+	return err
+}
+
+// Drop removes the pin of the given modules, and their requirements from `go.mod`.
+// Modules still used by the cells will be required again (in their latest version) by `go get`.
+func (s *State) Drop(modules ...string) error {
+	modFile, err := s.readGoMod()
+	if err != nil {
+		return err
+	}
+	for _, modPath := range modules {
+		_, pinned := s.requirements[modPath]
+		var required bool
+		for _, req := range modFile.Require {
+			required = required || req.Mod.Path == modPath
+		}
+		if !pinned && !required {
+			return errors.Errorf("%%drop: module %q is not required", modPath)
+		}
+		delete(s.requirements, modPath)
+		if err = modFile.DropRequire(modPath); err != nil {
+			return errors.Wrapf(err, "%%drop: failed to remove requirement of %q", modPath)
+		}
+	}
+	return s.writeGoMod(modFile)
+}
+
+// ListRequirements returns the requirements in `go.mod`, plus the pinned ones not there yet, sorted by module path.
+func (s *State) ListRequirements() ([]Requirement, error) {
+	modFile, err := s.readGoMod()
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]Requirement, len(modFile.Require))
+	for _, req := range modFile.Require {
+		_, pinned := s.requirements[req.Mod.Path]
+		byPath[req.Mod.Path] = Requirement{Path: req.Mod.Path, Version: req.Mod.Version, Indirect: req.Indirect, Pinned: pinned}
+	}
+	for modPath, version := range s.requirements {
+		if _, found := byPath[modPath]; !found {
+			byPath[modPath] = Requirement{Path: modPath, Version: version, Pinned: true}
+		}
+	}
+	requirements := make([]Requirement, 0, len(byPath))
+	for _, modPath := range SortedKeys(byPath) {
+		requirements = append(requirements, byPath[modPath])
+	}
+	return requirements, nil
+}
+
+// applyRequirements writes the pinned versions into `go.mod`, if they are not there yet.
+// It returns the modules whose versions were changed.
+func (s *State) applyRequirements() (changed []string, err error) {
+	if len(s.requirements) == 0 {
+		return
+	}
+	var modFile *modfile.File
+	modFile, err = s.readGoMod()
+	if err != nil {
+		return
+	}
+	current := make(map[string]string, len(modFile.Require))
+	for _, req := range modFile.Require {
+		current[req.Mod.Path] = req.Mod.Version
+	}
+	for _, modPath := range SortedKeys(s.requirements) {
+		version := s.requirements[modPath]
+		if currentVersion, found := current[modPath]; found && currentVersion == version {
+			continue
+		}
+		if err = modFile.AddRequire(modPath, version); err != nil {
+			err = errors.Wrapf(err, "failed to require %s@%s", modPath, version)
+			return
+		}
+		changed = append(changed, modPath)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	err = s.writeGoMod(modFile)
+	return
+}
+
+// reapplyRequirements is called after `go get`, which may change the pinned versions, because of
+// the requirements of other modules. It restores them, and warns the user.
+func (s *State) reapplyRequirements(msg kernel.Message) error {
+	changed, err := s.applyRequirements()
+	if err != nil || len(changed) == 0 || msg == nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, modPath := range changed {
+		sb.WriteString(fmt.Sprintf("%%require: `go get` changed the version of %q, restored it to the pinned %s. "+
+			"If other modules require a newer version, `go` will fail to build.\n", modPath, s.requirements[modPath]))
+	}
+	if err = kernel.PublishWriteStream(msg, kernel.StreamStderr, sb.String()); err != nil {
+		klog.Errorf("Failed to publish %%require warnings: %+v", err)
+	}
+	return nil
+}
+
+// readGoMod parses the `go.mod` in State.TempDir.
+func (s *State) readGoMod() (*modfile.File, error) {
+	goModPath := path.Join(s.TempDir, "go.mod")
+	contents, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", goModPath)
+	}
+	modFile, err := modfile.Parse(goModPath, contents, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %q", goModPath)
+	}
+	return modFile, nil
+}
+
+// writeGoMod formats and writes modFile to the `go.mod` in State.TempDir.
+func (s *State) writeGoMod(modFile *modfile.File) error {
+	goModPath := path.Join(s.TempDir, "go.mod")
+	modFile.Cleanup()
+	if err := os.WriteFile(goModPath, modfile.Format(modFile.Syntax), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", goModPath)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package goexec
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequirements(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	readGoMod := func() string {
+		contents, err := os.ReadFile(path.Join(s.TempDir, "go.mod"))
+		require.NoError(t, err)
+		return string(contents)
+	}
+
+	require.Error(t, s.Require("github.com/janpfeifer/must"))
+	require.Error(t, s.Require("github.com/janpfeifer/must@latest"))
+	require.NoError(t, s.Require("github.com/janpfeifer/must@v0.2.0"))
+	assert.Contains(t, readGoMod(), "require github.com/janpfeifer/must v0.2.0\n")
+	assert.Equal(t, map[string]string{"github.com/janpfeifer/must": "v0.2.0"}, s.Requirements())
+	requirements, err := s.ListRequirements()
+	require.NoError(t, err)
+	assert.Equal(t, []Requirement{{Path: "github.com/janpfeifer/must", Version: "v0.2.0", Pinned: true}}, requirements)
+
+	// Pins survive the re-creation of go.mod.
+	require.NoError(t, s.GoModInit())
+	assert.Contains(t, readGoMod(), "require github.com/janpfeifer/must v0.2.0\n")
+
+	// Changes to pinned versions (e.g. by `go get`) are reverted.
+	require.NoError(t, s.Require("github.com/janpfeifer/must@v0.1.0"))
+	require.NoError(t, os.WriteFile(path.Join(s.TempDir, "go.mod"),
+		[]byte(readGoMod()+"\nrequire github.com/janpfeifer/must v0.3.0\n"), 0600))
+	changed, err := s.applyRequirements()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github.com/janpfeifer/must"}, changed)
+	assert.Contains(t, readGoMod(), "require github.com/janpfeifer/must v0.1.0\n")
+	assert.NotContains(t, readGoMod(), "v0.3.0")
+
+	require.NoError(t, s.Drop("github.com/janpfeifer/must"))
+	assert.NotContains(t, readGoMod(), "janpfeifer/must")
+	assert.Empty(t, s.Requirements())
+	require.Error(t, s.Drop("github.com/janpfeifer/must"))
+
+	require.Error(t, s.SetRequirements(map[string]string{"github.com/janpfeifer/must": "invalid"}))
+	require.NoError(t, s.SetRequirements(map[string]string{"github.com/janpfeifer/must": "v0.2.0"}))
+	assert.Contains(t, readGoMod(), "require github.com/janpfeifer/must v0.2.0\n")
+}
+
+func TestNotebookRequirements(t *testing.T) {
+	nb := ipynb.New()
+	requirements, err := NotebookRequirements(nb)
+	require.NoError(t, err)
+	assert.Nil(t, requirements)
+
+	nb.SetGonbMetadata(RequirementsMetadataKey, map[string]any{"github.com/janpfeifer/must": "v0.1.0"})
+	requirements, err = NotebookRequirements(nb)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"github.com/janpfeifer/must": "v0.1.0"}, requirements)
+
+	// The output of the last `%require` or `%drop` takes precedence, even if no versions are pinned.
+	addOutput := func(source string, pins map[string]any) {
+		output, err := json.Marshal(map[string]any{
+			"output_type": "display_data",
+			"data":        map[string]any{"text/html": "Requirements"},
+			"metadata":    map[string]any{"gonb": map[string]any{"requirements": pins}},
+		})
+		require.NoError(t, err)
+		nb.Cells = append(nb.Cells, &ipynb.Cell{CellType: "code", Source: ipynb.Source(source), Outputs: []json.RawMessage{output}})
+	}
+	addOutput("%require github.com/janpfeifer/must@v0.2.0", map[string]any{"github.com/janpfeifer/must": "v0.2.0"})
+	requirements, err = NotebookRequirements(nb)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"github.com/janpfeifer/must": "v0.2.0"}, requirements)
+	addOutput("%drop github.com/janpfeifer/must", map[string]any{})
+	requirements, err = NotebookRequirements(nb)
+	require.NoError(t, err)
+	assert.Empty(t, requirements)
+	assert.NotNil(t, requirements)
+
+	addOutput("%require", map[string]any{"github.com/janpfeifer/must": 1})
+	_, err = NotebookRequirements(nb)
+	require.Error(t, err)
+}
@@ -0,0 +1,368 @@
+package goexec
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the display of the value of the last expression of the cell's `main()` (e.g.: the last line
+// after a `%%`), like IPython's `Out[n]`.
+//
+// A trailing expression that is not a valid statement by itself (e.g.: `x` or `a+b`, as opposed to a function
+// call) is wrapped in a call to `gonbDisplayResult()`, defined in the generated ResultGo file, which calls
+// `gonbui.DisplayResult`.
+//
+// Whether a trailing function call (e.g.: `strings.ToUpper(s)`) returns a value can only be known with its type:
+// after `goimports`, the program is type-checked (see displayTrailingCall), and the call is wrapped only if it
+// returns exactly one value -- so `fmt.Println(x)` is not displayed.
+//
+// The type-check costs a `go list -export` of the imported packages (and, if needed, a `go get` of `gonbui`) for
+// every cell that ends with a function call, so calls whose results are conventionally ignored (e.g.: `fmt.Println`)
+// are skipped without it, see ignoredResultCalls.
+
+// ResultGo is the name of the generated file, in `State.TempDir`, with the function that displays the value of the
+// last expression of the cell.
+const ResultGo = "gonb_result.go"
+
+const (
+	gonbuiImportPath = "github.com/janpfeifer/gonb/gonbui"
+	resultPrefix     = "gonbDisplayResult("
+	resultSuffix     = ")"
+)
+
+// resultGoContent is the content of the ResultGo file.
+const resultGoContent = `// Generated by GoNB to display the result of the cell: do not edit.
+package main
+
+import "github.com/janpfeifer/gonb/gonbui"
+
+// gonbDisplayResult displays the value of the last expression of the cell.
+func gonbDisplayResult(value any) {
+	gonbui.DisplayResult(value)
+	gonbui.Sync()
+}
+`
+
+// ignoredResultCalls are the functions, as "<import path>.<name>", whose results are conventionally ignored:
+// cells ending with calls to them are not type-checked, and their results are not displayed.
+var ignoredResultCalls = SetWithValues(
+	"fmt.Print", "fmt.Printf", "fmt.Println", "fmt.Fprint", "fmt.Fprintf", "fmt.Fprintln",
+	"log.Print", "log.Printf", "log.Println", "log.Fatal", "log.Fatalf", "log.Fatalln",
+	"log.Panic", "log.Panicf", "log.Panicln")
+
+// valueBuiltins are the predeclared functions and types whose calls return a value that must be used:
+// they can't be used as statements.
+var valueBuiltins = SetWithValues(
+	"append", "cap", "complex", "imag", "len", "make", "max", "min", "new", "real",
+	"any", "bool", "byte", "complex64", "complex128", "error", "float32", "float64",
+	"int", "int8", "int16", "int32", "int64", "rune", "string",
+	"uint", "uint8", "uint16", "uint32", "uint64", "uintptr")
+
+// isResultExpr returns whether the expression is not a valid statement by itself, and hence
+// should be displayed as the result of the cell.
+func isResultExpr(expr ast.Expr) bool {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+	switch e := expr.(type) {
+	case *ast.CallExpr:
+		// Function calls are valid statements, except for some builtins and type conversions.
+		switch fun := e.Fun.(type) {
+		case *ast.Ident:
+			return valueBuiltins.Has(fun.Name)
+		case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.StructType:
+			return true
+		}
+		return false
+	case *ast.UnaryExpr:
+		// Receiving from a channel is a valid statement.
+		return e.Op != token.ARROW
+	}
+	return true
+}
+
+// isCallExpr returns whether the expression is a function call, possibly in parenthesis.
+func isCallExpr(expr ast.Expr) bool {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+	_, ok := expr.(*ast.CallExpr)
+	return ok
+}
+
+// isIgnoredResultCall returns whether expr is a call to one of the ignoredResultCalls, resolved with the imports
+// of file.
+func isIgnoredResultCall(file *ast.File, expr ast.Expr) bool {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkgIdent, ok := selector.X.(*ast.Ident)
+	if !ok || pkgIdent.Obj != nil {
+		return false // Not a package, e.g. a local variable.
+	}
+	for _, spec := range file.Imports {
+		if importName(spec) == pkgIdent.Name {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			return ignoredResultCalls.Has(importPath + "." + selector.Sel.Name)
+		}
+	}
+	return false
+}
+
+// lastExprStmt returns the last statement of the function funcDecl, if it is an expression statement.
+func lastExprStmt(funcDecl *ast.FuncDecl) *ast.ExprStmt {
+	if funcDecl.Body == nil || len(funcDecl.Body.List) == 0 {
+		return nil
+	}
+	exprStmt, _ := funcDecl.Body.List[len(funcDecl.Body.List)-1].(*ast.ExprStmt)
+	return exprStmt
+}
+
+// resultMain returns a copy of mainDecl with its last statement wrapped in a call to `gonbDisplayResult()`, if
+// it is an expression to be displayed (see isResultExpr). If there is no such expression it returns mainDecl
+// unchanged and false.
+//
+// Trailing function calls are handled later by displayTrailingCall, once their type can be checked.
+//
+// The wrapping doesn't change the number of lines, so the mapping of lines to the cell is preserved.
+func (s *State) resultMain(mainDecl *Function) (*Function, bool) {
+	if mainDecl == nil || s.CellIsTest || s.CellIsWasm {
+		return mainDecl, false
+	}
+	const header = "package main\n"
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", header+mainDecl.Definition, parser.SkipObjectResolution)
+	if err != nil || len(file.Decls) == 0 {
+		return mainDecl, false
+	}
+	funcDecl, ok := file.Decls[len(file.Decls)-1].(*ast.FuncDecl)
+	if !ok {
+		return mainDecl, false
+	}
+	exprStmt := lastExprStmt(funcDecl)
+	if exprStmt == nil || !isResultExpr(exprStmt.X) {
+		return mainDecl, false
+	}
+	start := fileSet.Position(exprStmt.X.Pos())
+	end := fileSet.Position(exprStmt.X.End())
+	startOffset, endOffset := start.Offset-len(header), end.Offset-len(header)
+	def := mainDecl.Definition
+	newMain := *mainDecl // Shallow copy.
+	newMain.Definition = def[:startOffset] + resultPrefix + def[startOffset:endOffset] + resultSuffix + def[endOffset:]
+
+	// Adjust cursor: lines are 1-based in the parser, and 0-based in the cursor. The header line is discounted.
+	if newMain.HasCursor() {
+		startLine, endLine := start.Line-2, end.Line-2
+		cursor := mainDecl.Cursor
+		if cursor.Line == startLine && cursor.Col >= start.Column-1 {
+			newMain.Cursor.Col += len(resultPrefix)
+		}
+		if cursor.Line == endLine && cursor.Col >= end.Column-1 {
+			newMain.Cursor.Col += len(resultSuffix)
+		}
+	}
+	return &newMain, true
+}
+
+// displayTrailingCall type-checks the program, if the last statement of `main()` in `main.go` is a function call,
+// and wraps it in a call to `gonbDisplayResult()` if it returns exactly one value. It is called after `goimports`,
+// so the imports are complete, and it doesn't change the line numbers.
+//
+// The call is not displayed if the program can't be type-checked (the errors are reported by the compilation),
+// or if the package `gonbui` is not available and can't be fetched. Calls to ignoredResultCalls are not
+// type-checked.
+func (s *State) displayTrailingCall() error {
+	if s.CellIsTest || s.CellIsWasm || s.cellPackage != "" {
+		return nil
+	}
+	if _, err := os.Stat(path.Join(s.TempDir, ResultGo)); err == nil {
+		return nil // Already wrapped by resultMain.
+	}
+	content, err := os.ReadFile(s.CodePath())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %q", s.CodePath())
+	}
+	start := time.Now()
+	startOffset, endOffset, found := s.singleValueTrailingCall(content)
+	s.recordTiming("Type-check result", start)
+	if !found {
+		return nil
+	}
+	newContent := bytes.Join([][]byte{content[:startOffset], []byte(resultPrefix), content[startOffset:endOffset],
+		[]byte(resultSuffix), content[endOffset:]}, nil)
+	if err = os.WriteFile(s.CodePath(), newContent, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", s.CodePath())
+	}
+	return s.writeResultFile(true)
+}
+
+// singleValueTrailingCall type-checks the main package, with `main.go` content, and returns the offsets
+// [startOffset, endOffset) in content of the trailing call of `main()`, if it returns exactly one value.
+// It returns found=false otherwise, or if the package couldn't be type-checked.
+func (s *State) singleValueTrailingCall(content []byte) (startOffset, endOffset int, found bool) {
+	fileSet := token.NewFileSet()
+	mainFile, err := parser.ParseFile(fileSet, s.CodePath(), content, 0)
+	if err != nil {
+		return
+	}
+	var exprStmt *ast.ExprStmt
+	for _, decl := range mainFile.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Name.Name == "main" {
+			exprStmt = lastExprStmt(funcDecl)
+		}
+	}
+	if exprStmt == nil || !isCallExpr(exprStmt.X) || isIgnoredResultCall(mainFile, exprStmt.X) {
+		return
+	}
+
+	// Parse the other files of the main package, and collect the imports.
+	files := []*ast.File{mainFile}
+	entries, err := os.ReadDir(s.TempDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == MainGo || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fileSet, path.Join(s.TempDir, name), nil, 0)
+		if err != nil {
+			return
+		}
+		if file.Name.Name == "main" {
+			files = append(files, file)
+		}
+	}
+	imports := SetWithValues(gonbuiImportPath)
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || importPath == "C" {
+				return // cgo is not supported by the type-checker without running cgo.
+			}
+			imports.Insert(importPath)
+		}
+	}
+	exportFiles, err := s.exportDataFiles(SortedKeys(imports))
+	if err != nil {
+		klog.V(1).Infof("Failed to list the export data of the imported packages: %+v", err)
+		return
+	}
+
+	conf := types.Config{
+		Importer: importer.ForCompiler(fileSet, "gc", func(importPath string) (io.ReadCloser, error) {
+			exportFile, found := exportFiles[importPath]
+			if !found {
+				return nil, errors.Errorf("no export data for package %q", importPath)
+			}
+			return os.Open(exportFile)
+		}),
+		Error: func(err error) {}, // Errors are reported by the compilation.
+	}
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	_, _ = conf.Check("main", fileSet, files, info)
+	typeAndValue, hasType := info.Types[exprStmt.X]
+	if !hasType || !typeAndValue.IsValue() {
+		return
+	}
+	if _, isTuple := typeAndValue.Type.(*types.Tuple); isTuple {
+		return // Multiple values.
+	}
+	if _, hasGonbui := exportFiles[gonbuiImportPath]; !hasGonbui && !s.getGonbui() {
+		return
+	}
+	return fileSet.Position(exprStmt.X.Pos()).Offset, fileSet.Position(exprStmt.X.End()).Offset, true
+}
+
+// exportDataFiles returns the files with the export data of the given packages (as built by `go build`),
+// indexed by their import paths. Packages that fail to build are omitted.
+func (s *State) exportDataFiles(importPaths []string) (map[string]string, error) {
+	exportFiles := make(map[string]string)
+	args := append([]string{"list", "-e", "-export", "-f", "{{.ImportPath}}\t{{.Export}}"}, s.GoBuildFlags...)
+	args = append(args, importPaths...)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = s.TempDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to run %q: %s", cmd, stderr.String())
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		importPath, exportFile, found := strings.Cut(line, "\t")
+		if found && exportFile != "" {
+			exportFiles[importPath] = exportFile
+		}
+	}
+	return exportFiles, nil
+}
+
+// getGonbui runs `go get` for the package `gonbui`, used by the ResultGo file, if State.AutoGet is set.
+// It returns whether it succeeded.
+func (s *State) getGonbui() bool {
+	if !s.AutoGet {
+		return false
+	}
+	start := time.Now()
+	cmd := exec.Command("go", "get", gonbuiImportPath)
+	cmd.Dir = s.TempDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		klog.V(1).Infof("Failed to run %q, the result of the cell is not displayed: %v\n%s", cmd, err, output)
+		return false
+	}
+	s.recordTiming("go get", start)
+	return true
+}
+
+// writeResultFile writes (or removes if not needed) the ResultGo file.
+func (s *State) writeResultFile(hasResult bool) error {
+	filePath := path.Join(s.TempDir, ResultGo)
+	if !hasResult {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %q", filePath)
+		}
+		return nil
+	}
+	if err := os.WriteFile(filePath, []byte(resultGoContent), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", filePath)
+	}
+	return nil
+}
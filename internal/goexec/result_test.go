@@ -0,0 +1,130 @@
+package goexec
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultMain(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	for _, testCase := range []struct {
+		code, want string
+	}{
+		{"x := 3\nx * 2", "gonbDisplayResult(x * 2)"},
+		{"x := []int{1, 2}\nlen(x)", "gonbDisplayResult(len(x))"},
+		{"float64(3)", "gonbDisplayResult(float64(3))"},
+		{"x := map[string]int{\"a\": 1}\n(x)", "gonbDisplayResult((x))"},
+		{"fmt.Println(\"hello\")", ""},
+		{"ch := make(chan int, 1)\nch <- 1\n<-ch", ""},
+		{"x := 1\n_ = x", ""},
+	} {
+		cellLines := strings.Split("%%\n"+testCase.code, "\n")
+		_, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), NoCursor)
+		require.NoError(t, err)
+		contentBytes, err := os.ReadFile(s.CodePath())
+		require.NoError(t, err)
+		_, err = os.Stat(path.Join(s.TempDir, ResultGo))
+		if testCase.want == "" {
+			assert.NotContainsf(t, string(contentBytes), "gonbDisplayResult", "code: %q", testCase.code)
+			assert.Truef(t, os.IsNotExist(err), "%s should not exist for code %q", ResultGo, testCase.code)
+		} else {
+			assert.Containsf(t, string(contentBytes), testCase.want, "code: %q", testCase.code)
+			assert.NoErrorf(t, err, "%s should exist for code %q", ResultGo, testCase.code)
+		}
+	}
+
+	// Cursor is shifted by the inserted prefix.
+	cursor := Cursor{Line: 2, Col: 2}
+	cellLines := strings.Split("%%\nx := 3\nx * 2", "\n")
+	_, _, cursorInFile, _, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), cursor)
+	require.NoError(t, err)
+	contentBytes, err := os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	fileLine := strings.Split(string(contentBytes), "\n")[cursorInFile.Line]
+	assert.Equal(t, "x * 2", fileLine[cursorInFile.Col-2:cursorInFile.Col+3])
+}
+
+func TestIsIgnoredResultCall(t *testing.T) {
+	for src, want := range map[string]bool{
+		`fmt.Println("a")`:           true,
+		`(fmt.Printf("%d", 1))`:      true,
+		`log.Fatal("a")`:             true,
+		`f.Println("a")`:             true,
+		`fmt.Sprint("a")`:            false,
+		`strings.ToUpper("a")`:       false,
+		`func() { fmt.Println() }()`: false,
+	} {
+		file, err := parser.ParseFile(token.NewFileSet(), "",
+			"package main\nimport (\"fmt\"; f \"fmt\"; \"log\"; \"strings\")\nfunc main() {\n"+src+"\n}", 0)
+		require.NoError(t, err)
+		exprStmt := lastExprStmt(file.Decls[len(file.Decls)-1].(*ast.FuncDecl))
+		require.NotNil(t, exprStmt, src)
+		assert.Equal(t, want, isIgnoredResultCall(file, exprStmt.X), src)
+	}
+}
+
+func TestDisplayTrailingCall(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.GoModInit())
+	useLocalGonb(t, s)
+
+	for _, testCase := range []struct {
+		code, want string
+	}{
+		{"import \"strings\"\n%%\nstrings.ToUpper(\"a\")", "gonbDisplayResult(strings.ToUpper(\"a\"))"},
+		{"func twice(x int) int { return 2 * x }\n%%\ntwice(3)", "gonbDisplayResult(twice(3))"},
+		{"import \"fmt\"\n%%\nfmt.Println(\"hello\")", ""},
+		{"func noop() {}\n%%\nnoop()", ""},
+		{"func pair() (int, int) { return 1, 2 }\n%%\npair()", ""},
+		{"%%\nundefinedFunc()", ""},
+	} {
+		cellLines := strings.Split(testCase.code, "\n")
+		_, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), NoCursor)
+		require.NoError(t, err)
+		require.NoError(t, s.displayTrailingCall())
+		contentBytes, err := os.ReadFile(s.CodePath())
+		require.NoError(t, err)
+		_, err = os.Stat(path.Join(s.TempDir, ResultGo))
+		if testCase.want == "" {
+			assert.NotContainsf(t, string(contentBytes), "gonbDisplayResult", "code: %q", testCase.code)
+			assert.Truef(t, os.IsNotExist(err), "%s should not exist for code %q", ResultGo, testCase.code)
+		} else {
+			assert.Containsf(t, string(contentBytes), testCase.want, "code: %q", testCase.code)
+			assert.NoErrorf(t, err, "%s should exist for code %q", ResultGo, testCase.code)
+		}
+	}
+}
+
+// useLocalGonb makes the module in s.TempDir use gonbui from this repository, without network access.
+func useLocalGonb(t *testing.T, s *State) {
+	repoRoot, err := filepath.Abs("../..")
+	require.NoError(t, err)
+	cmd := exec.Command("go", "mod", "edit", "-require=github.com/janpfeifer/gonb@v0.0.0",
+		"-replace=github.com/janpfeifer/gonb="+repoRoot)
+	cmd.Dir = s.TempDir
+	output, err := cmd.CombinedOutput()
+	require.NoErrorf(t, err, "go mod edit: %s", output)
+	goSum, err := os.ReadFile(path.Join(repoRoot, "go.sum"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path.Join(s.TempDir, "go.sum"), goSum, 0600))
+}
@@ -0,0 +1,714 @@
+package goexec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the experimental "session" execution mode, controlled by the special command `%session`.
+//
+// In session mode, instead of compiling each cell to a new program executed in a new process, cells are
+// compiled as Go plugins (`go build -buildmode=plugin`) and loaded into a long-lived worker process, started
+// with the first cell. So goroutines, servers and connections started in one cell survive into the next ones.
+//
+// The worker is executed with jpyexec, like any cell program, so it uses the usual GONB_PIPE/GONB_PIPE_BACK
+// named pipes. It reads one JSON encoded sessionRequest per cell from its stdin, and it signals the end of the cell
+// by writing a marker line (sessionMarker) to both stdout and stderr, which are stripped from the output. Whatever
+// the worker outputs is sent to the cell currently (or last) executed, see sessionMessage and sessionStreamWriter.
+//
+// Each cell is a different plugin, with its own copy of the package-level variables. To keep their values, the
+// variables declared with an explicit type are initialized with `session.Define` (package `gonbui/session`), and
+// they are only re-initialized if their definition changes. Their values are saved at the end of each cell.
+
+const (
+	// SessionGo is the name of the generated file, in `State.TempDir`, that exports the cell's `main()` to the
+	// session worker.
+	SessionGo = "gonb_session.go"
+
+	// SessionSubdir is the subdirectory of `State.TempDir` with the session worker and the compiled cells (plugins).
+	SessionSubdir = "gonb_session"
+
+	// sessionWorkerGo is the name of the source of the session worker, in SessionSubdir.
+	sessionWorkerGo = "worker.go"
+
+	// sessionMarker prefixes the line written by the session worker to stdout and stderr at the end of each cell.
+	sessionMarker = "\x1e#gonb_session:"
+
+	// sessionImportPath is the package used to keep the values of variables across cells.
+	sessionImportPath = "github.com/janpfeifer/gonb/gonbui/session"
+)
+
+// Status of a cell execution reported by the session worker.
+const (
+	sessionStatusOk         = "ok"
+	sessionStatusPanic      = "panic"
+	sessionStatusLoadFailed = "load_failed"
+	sessionStatusExited     = "exited"  // Set by GoNB, if the worker exits during the execution of a cell.
+	sessionStatusTimeout    = "timeout" // Set by GoNB, if the cell exceeds the timeout, see State.Limits.
+)
+
+// sessionInfo holds the state of the session execution mode.
+type sessionInfo struct {
+	// enabled is set with `%session on`.
+	enabled bool
+
+	// worker currently running, if any. It is started lazily with the first cell executed.
+	worker *sessionWorker
+
+	// count of cells compiled, used to make the plugins unique.
+	count int
+}
+
+// sessionRequest is sent to the session worker to execute a cell.
+type sessionRequest struct {
+	Id     int      `json:"id"`
+	Plugin string   `json:"plugin"`
+	Args   []string `json:"args"`
+	Dir    string   `json:"dir"`
+	Env    []string `json:"env"`
+}
+
+// SetSessionMode enables or disables the session execution mode.
+// Disabling it stops the session worker, if one is running.
+func (s *State) SetSessionMode(enabled bool) {
+	s.session.enabled = enabled
+	if !enabled {
+		s.StopSession()
+	}
+}
+
+// IsSessionMode returns whether the session execution mode is enabled.
+func (s *State) IsSessionMode() bool {
+	return s.session.enabled
+}
+
+// IsSessionRunning returns whether there is a session worker running.
+func (s *State) IsSessionRunning() bool {
+	return s.session.worker != nil && !s.session.worker.hasExited()
+}
+
+// StopSession stops the session worker, if one is running. All the state kept by it is lost.
+// If the session mode is enabled, a new worker is started with the next cell executed.
+func (s *State) StopSession() {
+	w := s.session.worker
+	if w == nil {
+		return
+	}
+	s.session.worker = nil
+	// The worker exits when its stdin is closed.
+	_ = w.requests.Close()
+	select {
+	case <-w.exited:
+	case <-time.After(jpyexec.WaitToKill):
+		klog.Warningf("%%session: worker didn't exit after %s", jpyexec.WaitToKill)
+	}
+	if err := os.RemoveAll(s.SessionDir()); err != nil {
+		klog.Errorf("%%session: failed to remove %q: %+v", s.SessionDir(), err)
+	}
+}
+
+// SessionDir returns the directory with the session worker and the compiled cells.
+func (s *State) SessionDir() string {
+	return path.Join(s.TempDir, SessionSubdir)
+}
+
+// useSession returns whether the current cell is executed in the session worker.
+// Tests and wasm cells are always compiled and executed normally.
+func (s *State) useSession() bool {
+	return s.session.enabled && !s.CellIsTest && !s.CellIsWasm
+}
+
+// sessionPluginPath is the path of the plugin compiled for the current cell.
+func (s *State) sessionPluginPath() string {
+	return path.Join(s.SessionDir(), fmt.Sprintf("cell_%d.so", s.session.count))
+}
+
+// sessionGoFiles returns the Go files to build the current cell as a plugin.
+//
+// The files are listed explicitly to `go build`, so the plugin is built as a "command-line-arguments" package,
+// whose plugin path depends on its contents: the Go runtime refuses to load two plugins with the same path.
+func (s *State) sessionGoFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.TempDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files in %q", s.TempDir)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// sessionVariables returns the sorted names of the variables whose values are kept across cells by the
+// session worker: those with an explicit type, that are not tuples and are not handled by `%persist`.
+func (s *State) sessionVariables(decls *Declarations, persisted []string) []string {
+	if !s.useSession() {
+		return nil
+	}
+	var names []string
+	for _, name := range SortedKeys(decls.Variables) {
+		v := decls.Variables[name]
+		if v.Name == "_" || v.TypeDefinition == "" || len(v.TupleDefinitions) > 1 || slices.Contains(persisted, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// sessionDefinitionHash returns a hash of the definition of the variable, used to decide whether the value kept
+// by the session worker can be reused.
+func sessionDefinitionHash(v *Variable) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(v.TypeDefinition+" = "+v.ValueDefinition)))[:16]
+}
+
+// sessionDecls returns a copy of decls where the initialization of the given variables is wrapped by a call
+// to reuse the value kept by the session worker.
+//
+// The returned declarations are used only for rendering, they should not be memorized.
+func (s *State) sessionDecls(decls *Declarations, names []string) *Declarations {
+	if len(names) == 0 {
+		return decls
+	}
+	decls = decls.Copy()
+	for _, name := range names {
+		v := decls.Variables[name]
+		prefix := fmt.Sprintf("gonbSessionDefine(%q, %q, func() %s { return ", name, sessionDefinitionHash(v), v.TypeDefinition)
+		decls.Variables[name] = wrapVariableValue(v, prefix, wrapVariableSuffix)
+	}
+	return decls
+}
+
+// writeSessionFile writes (or removes if not in session mode) the SessionGo file, that exports the cell's `main()`
+// to the session worker.
+func (s *State) writeSessionFile(decls *Declarations, names []string) error {
+	filePath := path.Join(s.TempDir, SessionGo)
+	if !s.useSession() {
+		err := os.Remove(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %q", filePath)
+		}
+		return nil
+	}
+
+	// If the module already depends on GoNB, wait for any in-transit display data before returning.
+	var usesGonb bool
+	for _, imp := range decls.Imports {
+		if strings.HasPrefix(imp.Path, "github.com/janpfeifer/gonb/") {
+			usesGonb = true
+			break
+		}
+	}
+
+	s.session.count++
+	var sb strings.Builder
+	sb.WriteString("// Generated by GoNB to support %session: do not edit.\npackage main\n\n")
+	if usesGonb {
+		sb.WriteString("import \"github.com/janpfeifer/gonb/gonbui\"\n")
+	}
+	if len(names) > 0 {
+		sb.WriteString(fmt.Sprintf("import gonbsession %q\n", sessionImportPath))
+	}
+	sb.WriteString(fmt.Sprintf(`
+// gonbSessionCell makes each compiled cell unique: the session worker can't load the same plugin twice.
+const gonbSessionCell = %d
+
+// GonbSessionMain is called by the session worker to execute the cell.
+func GonbSessionMain() {
+`, s.session.count))
+	if usesGonb {
+		sb.WriteString("\tdefer gonbui.Sync()\n")
+	}
+	sb.WriteString("\tmain()\n")
+	if len(names) > 0 {
+		sb.WriteString("\tgonbSessionSave()\n")
+	}
+	sb.WriteString("}\n")
+	if len(names) > 0 {
+		sb.WriteString(`
+// gonbSessionDefine returns the value kept by the session worker, if its definition didn't change.
+func gonbSessionDefine[T any](key, definition string, fn func() T) T {
+	return gonbsession.Define(key, definition, fn)
+}
+
+// gonbSessionSave keeps the values of the variables at the end of the cell, to be used by the next cells.
+func gonbSessionSave() {
+`)
+		for _, name := range names {
+			sb.WriteString(fmt.Sprintf("\tgonbsession.Update(%q, %q, %s)\n", name, sessionDefinitionHash(decls.Variables[name]), name))
+		}
+		sb.WriteString("}\n")
+	}
+	err := os.WriteFile(filePath, []byte(sb.String()), 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %q", filePath)
+	}
+	return nil
+}
+
+// executeInSession executes the current cell, already compiled as a plugin, in the session worker.
+// It starts the worker if needed.
+//
+// The timeout of State.Limits is enforced by stopping the worker (so the state of previous cells is lost). The
+// memory and CPU limits would apply to the worker as a whole, so they are not enforced.
+func (s *State) executeInSession(msg kernel.Message, args []string, stdout, stderr io.Writer) error {
+	warnUnenforcedLimits(msg, s.Limits, "%session")
+	pwd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get current directory")
+	}
+	req := &sessionRequest{
+		Plugin: s.sessionPluginPath(),
+		Args:   args,
+		Dir:    pwd,
+		Env:    os.Environ(),
+	}
+	for attempt := 0; ; attempt++ {
+		if err = s.startSessionWorker(msg); err != nil {
+			return err
+		}
+		status, err := s.session.worker.run(msg, stdout, stderr, req, s.Limits.Timeout)
+		if err != nil {
+			return err
+		}
+		switch {
+		case status == sessionStatusOk:
+			return nil
+		case status == sessionStatusExited:
+			s.StopSession()
+			publishSessionNote(msg, "the session worker exited, the state of previous cells is lost. "+
+				"A new worker is started with the next cell.")
+			return errors.Errorf("%%session: the session worker exited while executing the cell")
+		case status == sessionStatusTimeout:
+			s.StopSession()
+			publishSessionNote(msg, fmt.Sprintf("execution stopped: timeout of %s exceeded, the session worker was "+
+				"stopped and the state of previous cells is lost.", s.Limits.Timeout))
+			return errors.Errorf("%%session: timeout of %s exceeded", s.Limits.Timeout)
+		case status == sessionStatusLoadFailed && attempt == 0:
+			// Usually the plugin was built with versions of packages different from the ones already loaded
+			// by the worker (e.g.: after a `go get -u`): this requires a new worker.
+			s.StopSession()
+			publishSessionNote(msg, "restarting the session worker, the state of previous cells is lost.")
+			continue
+		case status == sessionStatusLoadFailed:
+			return errors.Errorf("%%session: the session worker failed to load the cell")
+		case status == sessionStatusPanic:
+			return errors.Errorf("%%session: the cell panicked")
+		default:
+			return errors.Errorf("%%session: unknown status %q reported by the session worker", status)
+		}
+	}
+}
+
+func publishSessionNote(msg kernel.Message, note string) {
+	err := kernel.PublishWriteStream(msg, kernel.StreamStderr, "%session: "+note+"\n")
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+}
+
+// startSessionWorker builds and starts the session worker, if one is not yet running.
+// The worker is restarted if the Go build flags changed, since plugins must be built with the same flags.
+func (s *State) startSessionWorker(msg kernel.Message) error {
+	buildFlags := strings.Join(s.GoBuildFlags, " ")
+	if w := s.session.worker; w != nil {
+		if !w.hasExited() && w.buildFlags == buildFlags {
+			return nil
+		}
+		if !w.hasExited() {
+			publishSessionNote(msg, "Go build flags changed, restarting the session worker, "+
+				"the state of previous cells is lost.")
+		}
+		s.StopSession()
+	}
+
+	dir := s.SessionDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create %q", dir)
+	}
+	workerPath := path.Join(dir, sessionWorkerGo)
+	workerSource := fmt.Sprintf(sessionWorkerTemplate, protocol.GONB_PIPE_ENV, protocol.GONB_PIPE_BACK_ENV, sessionMarker,
+		sessionStatusPanic, sessionStatusLoadFailed, sessionStatusLoadFailed, sessionStatusOk)
+	if err := os.WriteFile(workerPath, []byte(workerSource), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", workerPath)
+	}
+	binaryPath := path.Join(dir, "worker")
+	args := append([]string{"build", "-o", binaryPath}, s.GoBuildFlags...)
+	args = append(args, workerPath)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = s.TempDir
+	klog.V(2).Infof("Executing %s", cmd)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to build the session worker with %q:\n%s", cmd, output)
+	}
+
+	w := newSessionWorker(msg, buildFlags)
+	requestsReader, requestsWriter := io.Pipe()
+	w.requests = requestsWriter
+	w.executor = jpyexec.New(w.msg, binaryPath).
+		UseNamedPipes(s.Comms).
+		WithStdout(w.stdout).
+		WithStderr(w.stderr).
+		WithInputReader(requestsReader)
+	go func() {
+		err := w.executor.Exec()
+		if err != nil {
+			klog.Errorf("%%session: failed to execute the session worker: %+v", err)
+			w.stderr.forward([]byte(fmt.Sprintf("%%session: failed to execute the session worker: %v\n", err)))
+		}
+		// Unblocks any pending requests.
+		_ = requestsReader.CloseWithError(errors.New("session worker exited"))
+		close(w.exited)
+	}()
+	s.session.worker = w
+	return nil
+}
+
+// sessionWorker is a running session worker.
+type sessionWorker struct {
+	msg            *sessionMessage
+	stdout, stderr *sessionStreamWriter
+	markers        chan sessionDone
+	requests       *io.PipeWriter
+	executor       *jpyexec.Executor
+	exited         chan struct{}
+	lastId         int
+
+	// buildFlags used to build the worker: plugins must be built with the same flags.
+	buildFlags string
+}
+
+// sessionDone is parsed from the marker the session worker writes at the end of a cell.
+type sessionDone struct {
+	id     int
+	stream string
+	status string
+}
+
+func newSessionWorker(msg kernel.Message, buildFlags string) *sessionWorker {
+	w := &sessionWorker{
+		msg:        &sessionMessage{current: msg},
+		markers:    make(chan sessionDone, 16),
+		exited:     make(chan struct{}),
+		buildFlags: buildFlags,
+	}
+	w.stdout = newSessionStreamWriter(kernel.StreamStdout, kernel.NewJupyterStreamWriter(w.msg, kernel.StreamStdout), w.markers)
+	w.stderr = newSessionStreamWriter(kernel.StreamStderr, kernel.NewJupyterStreamWriter(w.msg, kernel.StreamStderr), w.markers)
+	return w
+}
+
+func (w *sessionWorker) hasExited() bool {
+	select {
+	case <-w.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// run executes a cell in the worker, and waits for it to finish. All output is sent to msg, stdout and stderr.
+//
+// It returns the status reported by the worker, or sessionStatusExited if the worker exited. If timeout is
+// not zero and the cell takes longer than that, the worker is stopped and sessionStatusTimeout is returned.
+func (w *sessionWorker) run(msg kernel.Message, stdout, stderr io.Writer, req *sessionRequest,
+	timeout time.Duration) (string, error) {
+	w.msg.set(msg)
+	w.stdout.setTarget(stdout)
+	w.stderr.setTarget(stderr)
+	w.lastId++
+	req.Id = w.lastId
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode request to the session worker")
+	}
+	if _, err = w.requests.Write(append(data, '\n')); err != nil {
+		<-w.exited
+		return sessionStatusExited, nil
+	}
+
+	// Wait for the markers in both stdout and stderr, so all the output of the cell was published.
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+	var status string
+	doneStreams := MakeSet[string]()
+	for len(doneStreams) < 2 {
+		select {
+		case done := <-w.markers:
+			if done.id != req.Id {
+				continue
+			}
+			doneStreams.Insert(done.stream)
+			status = done.status
+		case <-w.exited:
+			return sessionStatusExited, nil
+		case <-timeoutChan:
+			w.executor.Stop()
+			<-w.exited
+			return sessionStatusTimeout, nil
+		}
+	}
+	return status, nil
+}
+
+// sessionMessage implements kernel.Message by delegating to the message of the cell being executed by the
+// session worker, which changes at every cell.
+type sessionMessage struct {
+	mu      sync.Mutex
+	current kernel.Message
+}
+
+var _ kernel.Message = (*sessionMessage)(nil)
+
+func (m *sessionMessage) set(msg kernel.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = msg
+}
+
+func (m *sessionMessage) get() kernel.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Error implements kernel.Message.
+func (m *sessionMessage) Error() error { return m.get().Error() }
+
+// Ok implements kernel.Message.
+func (m *sessionMessage) Ok() bool { return m.get().Ok() }
+
+// ComposedMsg implements kernel.Message.
+func (m *sessionMessage) ComposedMsg() kernel.ComposedMsg { return m.get().ComposedMsg() }
+
+// Kernel implements kernel.Message.
+func (m *sessionMessage) Kernel() *kernel.Kernel { return m.get().Kernel() }
+
+// Publish implements kernel.Message.
+func (m *sessionMessage) Publish(msgType string, content interface{}) error {
+	return m.get().Publish(msgType, content)
+}
+
+// PromptInput implements kernel.Message.
+func (m *sessionMessage) PromptInput(prompt string, password bool, onInput kernel.OnInputFn) error {
+	return m.get().PromptInput(prompt, password, onInput)
+}
+
+// CancelInput implements kernel.Message.
+func (m *sessionMessage) CancelInput() error { return m.get().CancelInput() }
+
+// DeliverInput implements kernel.Message.
+func (m *sessionMessage) DeliverInput() error { return m.get().DeliverInput() }
+
+// Reply implements kernel.Message.
+func (m *sessionMessage) Reply(msgType string, content interface{}) error {
+	return m.get().Reply(msgType, content)
+}
+
+// ReplyWithMetadata implements kernel.Message.
+func (m *sessionMessage) ReplyWithMetadata(msgType string, content interface{}, metadata map[string]any) error {
+	return m.get().ReplyWithMetadata(msgType, content, metadata)
+}
+
+// sessionStreamWriter implements an io.Writer for the stdout or stderr of the session worker.
+// It forwards the output to the writer of the current cell, and it strips and reports the markers
+// written by the worker at the end of each cell.
+type sessionStreamWriter struct {
+	mu      sync.Mutex
+	stream  string
+	target  io.Writer
+	pending []byte // Partial marker, waiting for more data.
+	markers chan<- sessionDone
+}
+
+func newSessionStreamWriter(stream string, target io.Writer, markers chan<- sessionDone) *sessionStreamWriter {
+	return &sessionStreamWriter{stream: stream, target: target, markers: markers}
+}
+
+func (w *sessionStreamWriter) setTarget(target io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.target = target
+}
+
+// forward writes p to the current target.
+func (w *sessionStreamWriter) forward(p []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.forwardLocked(p)
+}
+
+func (w *sessionStreamWriter) forwardLocked(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	if _, err := w.target.Write(p); err != nil {
+		klog.Errorf("%%session: failed to write worker's %s: %+v", w.stream, err)
+	}
+}
+
+// Write implements io.Writer.
+func (w *sessionStreamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data := append(w.pending, p...)
+	w.pending = nil
+	marker := []byte(sessionMarker)
+	for len(data) > 0 {
+		idx := bytes.Index(data, marker)
+		if idx == -1 {
+			// Hold back the end of the data if it could be the start of a marker.
+			keep := 0
+			for ii := min(len(marker)-1, len(data)); ii > 0; ii-- {
+				if bytes.HasSuffix(data, marker[:ii]) {
+					keep = ii
+					break
+				}
+			}
+			w.forwardLocked(data[:len(data)-keep])
+			w.pending = bytes.Clone(data[len(data)-keep:])
+			break
+		}
+		w.forwardLocked(data[:idx])
+		data = data[idx:]
+		end := bytes.IndexByte(data, '\n')
+		if end == -1 {
+			w.pending = bytes.Clone(data)
+			break
+		}
+		w.parseMarker(string(data[len(marker):end]))
+		data = data[end+1:]
+	}
+	return n, nil
+}
+
+// parseMarker parses the contents of the marker ("<id> <status>") and reports it.
+func (w *sessionStreamWriter) parseMarker(contents string) {
+	idStr, status, _ := strings.Cut(contents, " ")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		klog.Errorf("%%session: invalid marker %q in worker's %s", contents, w.stream)
+		return
+	}
+	select {
+	case w.markers <- sessionDone{id: id, stream: w.stream, status: status}:
+	default:
+		klog.Errorf("%%session: dropped marker %q in worker's %s", contents, w.stream)
+	}
+}
+
+// sessionWorkerTemplate is the source of the session worker. It only uses the standard library, so it
+// doesn't constrain the versions of the packages used by the cells.
+//
+// It takes as parameters the names of the environment variables with the named pipes, the sessionMarker and
+// the statuses reported for a panic, a failure to load the cell (twice) and a successful execution.
+const sessionWorkerTemplate = `// Generated by GoNB to support %%session: do not edit.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"plugin"
+	"runtime/debug"
+	"strings"
+)
+
+type request struct {
+	Id     int      ` + "`json:\"id\"`" + `
+	Plugin string   ` + "`json:\"plugin\"`" + `
+	Args   []string ` + "`json:\"args\"`" + `
+	Dir    string   ` + "`json:\"dir\"`" + `
+	Env    []string ` + "`json:\"env\"`" + `
+}
+
+var keepEnv = []string{%q, %q}
+
+const marker = %q
+
+func main() {
+	decoder := json.NewDecoder(os.Stdin)
+	for {
+		var req request
+		if err := decoder.Decode(&req); err != nil {
+			// Stdin closed: session stopped.
+			return
+		}
+		status := run(&req)
+		fmt.Fprintf(os.Stdout, "%%s%%d %%s\n", marker, req.Id, status)
+		fmt.Fprintf(os.Stderr, "%%s%%d %%s\n", marker, req.Id, status)
+	}
+}
+
+// setUp reproduces the environment of a normal execution of the cell.
+func setUp(req *request) {
+	kept := make(map[string]string)
+	for _, key := range keepEnv {
+		kept[key] = os.Getenv(key)
+	}
+	os.Clearenv()
+	for _, keyValue := range req.Env {
+		if key, value, found := strings.Cut(keyValue, "="); found {
+			_ = os.Setenv(key, value)
+		}
+	}
+	for key, value := range kept {
+		_ = os.Setenv(key, value)
+	}
+	if err := os.Chdir(req.Dir); err != nil {
+		fmt.Fprintf(os.Stderr, "%%%%session: failed to change directory to %%q: %%v\n", req.Dir, err)
+	}
+	os.Args = append([]string{os.Args[0]}, req.Args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+}
+
+func run(req *request) (status string) {
+	setUp(req)
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "panic: %%v\n\n%%s", r, debug.Stack())
+			status = %q
+		}
+	}()
+	p, err := plugin.Open(req.Plugin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%%%%session: failed to load cell: %%v\n", err)
+		return %q
+	}
+	symbol, err := p.Lookup("GonbSessionMain")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%%%%session: %%v\n", err)
+		return %q
+	}
+	symbol.(func())()
+	return %q
+}
+`
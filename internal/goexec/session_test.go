@@ -0,0 +1,156 @@
+package goexec
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionStreamWriter(t *testing.T) {
+	markers := make(chan sessionDone, 10)
+	var out strings.Builder
+	w := newSessionStreamWriter("stdout", &out, markers)
+
+	// Marker split across writes.
+	for _, chunk := range []string{"hello\n", "partial \x1e#gonb_", "session:3 ok\nworld", "\n\x1e", "x\n"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "hello\npartial world\n\x1ex\n", out.String())
+	require.Len(t, markers, 1)
+	assert.Equal(t, sessionDone{id: 3, stream: "stdout", status: "ok"}, <-markers)
+
+	// Output of the next cell goes to the new target.
+	var out2 strings.Builder
+	w.setTarget(&out2)
+	_, err := w.Write([]byte("next\x1e#gonb_session:4 panic\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "next", out2.String())
+	assert.Equal(t, sessionDone{id: 4, stream: "stdout", status: "panic"}, <-markers)
+}
+
+func TestSessionFiles(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	s.SetSessionMode(true)
+	require.NoError(t, s.Persist("p"))
+
+	cellLines := strings.Split(`var x []float64 = []float64{
+	1, 2, 3}
+var y = 7
+var z int
+var p int = 3
+
+%%
+fmt.Println(x, y, z)`, "\n")
+	skipLines := MakeSet[int]()
+	_, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 1, cellLines, skipLines, NoCursor)
+	require.NoError(t, err)
+
+	contentBytes, err := os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	content := string(contentBytes)
+	assert.Contains(t, content, `x []float64 = gonbSessionDefine("x", "`)
+	assert.Contains(t, content, `func() []float64 { return []float64{`+"\n\t1, 2, 3} })")
+	assert.Contains(t, content, `z int = gonbSessionDefine("z", "`)
+	assert.Contains(t, content, `func() int { return *new(int) })`)
+	assert.Contains(t, content, "y = 7\n")                       // No explicit type: not kept.
+	assert.Contains(t, content, `p int = gonbPersistLoad("p", `) // Persistent variable.
+
+	// Line mapping must be preserved.
+	fileLines := strings.Split(content, "\n")
+	for ii, cellIdAndLine := range fileToCellIdAndLine {
+		if cellIdAndLine.Line == NoCursorLine || cellLines[cellIdAndLine.Line] == "%%" {
+			continue
+		}
+		expected := strings.TrimPrefix(strings.TrimSpace(cellLines[cellIdAndLine.Line]), "var ")[:3]
+		assert.Containsf(t, fileLines[ii], expected,
+			"main.go line %d mapped to cell line %d", ii, cellIdAndLine.Line)
+	}
+
+	sessionContent, err := os.ReadFile(path.Join(s.TempDir, SessionGo))
+	require.NoError(t, err)
+	assert.Contains(t, string(sessionContent), "func GonbSessionMain() {\n\tmain()\n\tgonbSessionSave()\n}\n")
+	assert.Contains(t, string(sessionContent), `gonbsession.Update("x", "`)
+	assert.NotContains(t, string(sessionContent), `gonbsession.Update("p", "`)
+
+	// Each cell is compiled to a different plugin.
+	firstPlugin := s.sessionPluginPath()
+	_, _, _, _, err = s.parseLinesAndComposeMain(nil, 2, cellLines, skipLines, NoCursor)
+	require.NoError(t, err)
+	assert.NotEqual(t, firstPlugin, s.sessionPluginPath())
+	files, err := s.sessionGoFiles()
+	require.NoError(t, err)
+	assert.Contains(t, files, SessionGo)
+	assert.Contains(t, files, MainGo)
+
+	// Without session mode, the helper file is removed.
+	s.SetSessionMode(false)
+	_, _, _, _, err = s.parseLinesAndComposeMain(nil, 3, cellLines, skipLines, NoCursor)
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(s.TempDir, SessionGo))
+	require.True(t, os.IsNotExist(err))
+	contentBytes, err = os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	assert.NotContains(t, string(contentBytes), "gonbSession")
+}
+
+func TestSessionExecutionErrors(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.GoModInit())
+	s.SetSessionMode(true)
+	k := kernel.NewHeadless()
+	defer k.Stop()
+	msg := &headlessMessage{k: k}
+
+	// executeCell without `goimports`: cells must import what they use.
+	executeCell := func(cellId int, cell string) error {
+		updatedDecls, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, cellId, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+		require.NoError(t, err)
+		require.NoError(t, s.Compile(nil, fileToCellIdAndLine))
+		s.Definitions = updatedDecls
+		return s.Execute(msg, fileToCellIdAndLine)
+	}
+	require.NoError(t, executeCell(1, "import \"flag\"\n\n%%\nprintln(\"ok\")"))
+	err := executeCell(2, "%%\npanic(\"boom\")")
+	require.Error(t, err, "A cell that panics in the session worker must fail")
+	assert.Contains(t, err.Error(), "panicked")
+
+	// The worker survives the panic.
+	require.NoError(t, executeCell(3, "%%\nprintln(\"ok again\")"))
+
+	// The timeout stops the worker, and a new one is started with the next cell.
+	s.Limits = jpyexec.Limits{Timeout: time.Second}
+	err = executeCell(4, "import \"time\"\n\n%%\ntime.Sleep(time.Minute)")
+	require.Error(t, err, "A cell that exceeds the timeout in the session worker must fail")
+	assert.Contains(t, err.Error(), "timeout of 1s exceeded")
+	assert.False(t, s.IsSessionRunning())
+	require.NoError(t, executeCell(5, "%%\nprintln(time.Second)"))
+}
+
+// headlessMessage is a kernel.Message of a headless kernel, that discards what is published. Only the
+// methods used to execute cells are implemented.
+type headlessMessage struct {
+	kernel.Message
+	k *kernel.Kernel
+}
+
+func (m *headlessMessage) Kernel() *kernel.Kernel                { return m.k }
+func (m *headlessMessage) ComposedMsg() kernel.ComposedMsg       { return kernel.ComposedMsg{} }
+func (m *headlessMessage) Publish(_ string, _ interface{}) error { return nil }
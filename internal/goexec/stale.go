@@ -0,0 +1,269 @@
+package goexec
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the tracking of stale cells, used by the special command `%stale` and by the
+// front-end comms opened with the target StaleCommTarget.
+//
+// After a cell is compiled, the declarations it depends on -- the ones used by its `main()` and by its own
+// declarations, and transitively the ones they use -- are recorded, along with their definitions. A cell
+// becomes stale when any of these declarations is later redefined (with a different definition) or removed:
+// its outputs may no longer reflect the current declarations.
+//
+// Dependencies are found syntactically, from the identifiers used by the declarations (see usedIdentifiers),
+// so they may include declarations that are not actually used, when shadowed by local names.
+
+// StaleCommTarget is the "target_name" of the comms opened by the front-end to be notified of stale cells.
+//
+// Any message sent by the front-end is taken as a request for the stale cells, which are sent back in the
+// field "stale" of the data, as a list of StaleCell objects, in the order the cells were executed -- the order
+// in which they should be re-executed. They are also sent after every execution, while the comm is open.
+const StaleCommTarget = "gonb_stale"
+
+// StaleCell describes a cell whose dependencies changed since it was executed.
+type StaleCell struct {
+	// ExecutionCount is the execution number of the cell, as in "Cell[3]" in the error messages.
+	ExecutionCount int `json:"execution_count"`
+
+	// CellId is the id of the cell given by the front-end (JupyterLab's "cellId" in the metadata of the
+	// "execute_request"), if available.
+	CellId string `json:"cell_id,omitempty"`
+
+	// Reasons describe the changed dependencies, e.g.: "func f redefined in Cell[7]".
+	Reasons []string `json:"reasons"`
+}
+
+// staleInfo holds the dependencies recorded for each executed cell.
+// It is accessed concurrently by the comms handlers, hence the mutex.
+type staleInfo struct {
+	mu sync.Mutex
+
+	// cells maps the execution number of the cells to their recorded dependencies.
+	cells map[int]*cellDependencies
+
+	// comms holds the ids of the open comms with the target StaleCommTarget.
+	comms Set[string]
+}
+
+func newStaleInfo() *staleInfo {
+	return &staleInfo{cells: make(map[int]*cellDependencies), comms: MakeSet[string]()}
+}
+
+// cellDependencies are the declarations a cell depended on when it was executed.
+type cellDependencies struct {
+	frontEndCellId string
+
+	// declarations maps the key of the declarations (see Declarations.keys) to the node of the dependency graph
+	// at the time of the execution.
+	declarations map[string]*declarationNode
+}
+
+// declarationNode is a declaration in the dependency graph, see Declarations.dependencyGraph.
+type declarationNode struct {
+	cellId     int
+	definition string
+	uses       []string
+}
+
+// dependencyGraph returns the nodes of the dependency graph of the declarations, indexed by their keys (see
+// Declarations.keys), and the function that lists the keys of the declarations used by a node.
+//
+// Besides the identifiers they use, types depend on their methods, and constants on the previous constants of
+// their block (for the implicit repetition of the values, e.g. with `iota`).
+func (d *Declarations) dependencyGraph() (nodes map[string]*declarationNode, edges func(key string) []string) {
+	nodes = make(map[string]*declarationNode)
+	for key, decl := range d.Imports {
+		nodes["import "+key] = &declarationNode{cellId: decl.Id, definition: decl.Path}
+	}
+	for key, decl := range d.Constants {
+		uses := decl.Uses
+		if decl.Prev != nil {
+			uses = append(slices.Clone(uses), decl.Prev.Key)
+		}
+		nodes["const "+key] = &declarationNode{cellId: decl.Id,
+			definition: decl.TypeDefinition + " = " + decl.ValueDefinition, uses: uses}
+	}
+	methods := make(map[string][]string)
+	for key, decl := range d.Functions {
+		nodes["func "+key] = &declarationNode{cellId: decl.Id, definition: decl.Definition, uses: decl.Uses}
+		if typeName, _, isMethod := strings.Cut(key, "~"); isMethod {
+			methods[typeName] = append(methods[typeName], "func "+key)
+		}
+	}
+	for key, decl := range d.Types {
+		nodes["type "+key] = &declarationNode{cellId: decl.Id, definition: decl.TypeDefinition, uses: decl.Uses}
+	}
+	for key, decl := range d.Variables {
+		nodes["var "+key] = &declarationNode{cellId: decl.Id,
+			definition: decl.TypeDefinition + " = " + decl.ValueDefinition, uses: decl.Uses}
+	}
+
+	edges = func(key string) []string {
+		node := nodes[key]
+		if node == nil {
+			return nil
+		}
+		var used []string
+		for _, name := range node.uses {
+			for _, kind := range []string{"import", "const", "type", "var", "func"} {
+				usedKey := kind + " " + name
+				if _, found := nodes[usedKey]; found && usedKey != key {
+					used = append(used, usedKey)
+				}
+			}
+		}
+		if typeName, isType := strings.CutPrefix(key, "type "); isType {
+			used = append(used, methods[typeName]...)
+		}
+		return used
+	}
+	return
+}
+
+// recordDependencies is called after a cell is successfully compiled, to record the declarations it depends on.
+func (s *State) recordDependencies(msg kernel.Message, cellId int, mainDecl *Function) {
+	nodes, edges := s.Definitions.dependencyGraph()
+
+	// Roots: the declarations of the cell, and the ones used by its `main()`.
+	var toVisit []string
+	for key, node := range nodes {
+		if node.cellId == cellId {
+			toVisit = append(toVisit, key)
+		}
+	}
+	if mainDecl != nil {
+		nodes["func main"] = &declarationNode{cellId: cellId, uses: mainDecl.Uses}
+		toVisit = append(toVisit, "func main")
+	}
+	visited := MakeSet[string]()
+	deps := &cellDependencies{frontEndCellId: frontEndCellId(msg), declarations: make(map[string]*declarationNode)}
+	for len(toVisit) > 0 {
+		key := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if visited.Has(key) {
+			continue
+		}
+		visited.Insert(key)
+		if node := nodes[key]; node.cellId != cellId {
+			deps.declarations[key] = node
+		}
+		toVisit = append(toVisit, edges(key)...)
+	}
+
+	s.stale.mu.Lock()
+	defer s.stale.mu.Unlock()
+	if deps.frontEndCellId != "" {
+		// The front-end cell was re-executed: its previous execution is gone.
+		for id, previous := range s.stale.cells {
+			if previous.frontEndCellId == deps.frontEndCellId {
+				delete(s.stale.cells, id)
+			}
+		}
+	}
+	s.stale.cells[cellId] = deps
+}
+
+// frontEndCellId returns the id of the cell given by the front-end in the metadata of the message
+// ("cellId", sent by JupyterLab), or "" if not available.
+func frontEndCellId(msg kernel.Message) string {
+	if msg == nil || msg.ComposedMsg().Metadata == nil {
+		return ""
+	}
+	cellId, _ := msg.ComposedMsg().Metadata["cellId"].(string)
+	return cellId
+}
+
+// StaleCells returns the cells whose dependencies were redefined or removed since they were executed, in the
+// order they were executed.
+func (s *State) StaleCells() []StaleCell {
+	nodes, _ := s.Definitions.dependencyGraph()
+	s.stale.mu.Lock()
+	defer s.stale.mu.Unlock()
+	var staleCells []StaleCell
+	for _, id := range SortedKeys(s.stale.cells) {
+		deps := s.stale.cells[id]
+		var reasons []string
+		for _, key := range SortedKeys(deps.declarations) {
+			previous := deps.declarations[key]
+			current, found := nodes[key]
+			switch {
+			case !found:
+				reasons = append(reasons, fmt.Sprintf("%s removed", key))
+			case current.cellId != previous.cellId && current.definition != previous.definition:
+				reasons = append(reasons, fmt.Sprintf("%s redefined in Cell[%d]", key, current.cellId))
+			}
+		}
+		if len(reasons) > 0 {
+			staleCells = append(staleCells, StaleCell{ExecutionCount: id, CellId: deps.frontEndCellId, Reasons: reasons})
+		}
+	}
+	return staleCells
+}
+
+// resetStale discards the recorded dependencies, e.g. after a `%reset`.
+func (s *State) resetStale() {
+	s.stale.mu.Lock()
+	defer s.stale.mu.Unlock()
+	s.stale.cells = make(map[int]*cellDependencies)
+}
+
+// PublishStaleCells sends the stale cells to the open comms with the target StaleCommTarget.
+func (s *State) PublishStaleCells(msg kernel.Message) {
+	s.stale.mu.Lock()
+	commIds := SortedKeys(s.stale.comms)
+	s.stale.mu.Unlock()
+	for _, commId := range commIds {
+		if err := s.publishStaleCellsTo(msg, commId); err != nil {
+			klog.Warningf("Failed to send the stale cells to the front-end: %+v", err)
+		}
+	}
+}
+
+// publishStaleCellsTo sends the stale cells to the comm commId.
+func (s *State) publishStaleCellsTo(msg kernel.Message, commId string) error {
+	staleCells := s.StaleCells()
+	if staleCells == nil {
+		staleCells = []StaleCell{}
+	}
+	return msg.Publish("comm_msg", map[string]any{
+		"comm_id": commId,
+		"data":    map[string]any{"stale": staleCells},
+	})
+}
+
+// OpenStaleComm registers a comm opened by the front-end with the target StaleCommTarget,
+// and sends it the current stale cells.
+func (s *State) OpenStaleComm(msg kernel.Message, commId string) error {
+	s.stale.mu.Lock()
+	s.stale.comms.Insert(commId)
+	s.stale.mu.Unlock()
+	return s.publishStaleCellsTo(msg, commId)
+}
+
+// IsStaleComm returns whether commId was opened with the target StaleCommTarget.
+func (s *State) IsStaleComm(commId string) bool {
+	s.stale.mu.Lock()
+	defer s.stale.mu.Unlock()
+	return s.stale.comms.Has(commId)
+}
+
+// HandleStaleCommMsg handles a message sent by the front-end: it replies with the current stale cells.
+func (s *State) HandleStaleCommMsg(msg kernel.Message, commId string) error {
+	return s.publishStaleCellsTo(msg, commId)
+}
+
+// CloseStaleComm unregisters a comm with the target StaleCommTarget.
+func (s *State) CloseStaleComm(commId string) {
+	s.stale.mu.Lock()
+	defer s.stale.mu.Unlock()
+	s.stale.comms.Delete(commId)
+}
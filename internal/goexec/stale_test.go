@@ -0,0 +1,52 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleCells(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	// executeCell parses the cell and records its dependencies, as if it was executed.
+	executeCell := func(cellId int, cell string) {
+		updatedDecls, mainDecl, _, _, err := s.parseLinesAndComposeMain(nil, cellId, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+		require.NoError(t, err)
+		s.Definitions = updatedDecls
+		s.recordDependencies(nil, cellId, mainDecl)
+	}
+	executeCell(1, "func f() int { return 1 }\ntype T struct{}\nfunc (T) m() int { return f() }\nconst (\n\tA = iota\n\tB\n)")
+	executeCell(2, "%%\nfmt.Println(T{}.m())")
+	executeCell(3, "var x = B\n%%\nfmt.Println(x)")
+	executeCell(4, "%%\nfmt.Println(1)")
+	assert.Empty(t, s.StaleCells())
+
+	// Redefining `f` makes cell 2 stale: it uses `T`, whose method uses `f`. Cell 1 defined `f` itself.
+	executeCell(5, "func f() int { return 2 }")
+	assert.Equal(t, []StaleCell{{ExecutionCount: 2, Reasons: []string{"func f redefined in Cell[5]"}}}, s.StaleCells())
+
+	// Redefining the first constant of a block changes the following ones (`B` repeats `iota + 1`);
+	// re-executing the same definition of `f` changes nothing.
+	executeCell(6, "const (\n\tA = iota + 1\n\tB\n)\nfunc f() int { return 2 }")
+	staleCells := s.StaleCells()
+	require.Len(t, staleCells, 2)
+	assert.Equal(t, 3, staleCells[1].ExecutionCount)
+	assert.Equal(t, []string{"const A redefined in Cell[6]"}, staleCells[1].Reasons)
+
+	// Removed declarations.
+	delete(s.Definitions.Types, "T")
+	staleCells = s.StaleCells()
+	require.Len(t, staleCells, 2)
+	assert.Equal(t, []string{"func f redefined in Cell[6]", "type T removed"}, staleCells[0].Reasons)
+
+	s.Reset()
+	assert.Empty(t, s.StaleCells())
+}
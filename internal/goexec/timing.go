@@ -0,0 +1,65 @@
+package goexec
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+)
+
+// cellTiming is the duration of one step of the execution of a cell, reported by `%time`.
+type cellTiming struct {
+	step     string
+	duration time.Duration
+}
+
+// recordTiming records the duration of the step started at start, if `%time` was set for the cell.
+func (s *State) recordTiming(step string, start time.Time) {
+	if !s.CellTiming {
+		return
+	}
+	s.timings = append(s.timings, cellTiming{step: step, duration: time.Since(start)})
+}
+
+// publishTimings reports the durations of the steps recorded for the cell (see `%time`), and resets them.
+func (s *State) publishTimings(msg kernel.Message) {
+	timings := s.timings
+	s.timings = nil
+	if !s.CellTiming || len(timings) == 0 || msg == nil {
+		return
+	}
+	var total time.Duration
+	for _, t := range timings {
+		total += t.duration
+	}
+	timings = append(timings, cellTiming{step: "Total", duration: total})
+
+	width := 10
+	for _, t := range timings {
+		width = max(width, len(t.step)+1)
+	}
+	var text, htmlRows strings.Builder
+	for _, t := range timings {
+		duration := t.duration.Round(time.Microsecond)
+		fmt.Fprintf(&text, "%-*s %12s\n", width, t.step+":", duration)
+		fmt.Fprintf(&htmlRows, "<tr><td>%s</td><td style=\"text-align: right\">%s</td></tr>",
+			html.EscapeString(t.step), duration)
+	}
+	err := kernel.PublishDisplayData(msg, kernel.Data{
+		Data: kernel.MIMEMap{
+			string(protocol.MIMETextPlain): text.String(),
+			string(protocol.MIMETextHTML): fmt.Sprintf(
+				"<table><thead><tr><th>Step</th><th>Duration</th></tr></thead><tbody>%s</tbody></table>",
+				htmlRows.String()),
+		},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: make(kernel.MIMEMap),
+	})
+	if err != nil {
+		klog.Errorf("Failed to publish cell timings: %+v", err)
+	}
+}
@@ -0,0 +1,415 @@
+package goexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the reporting of the values of the global variables of the program, used by
+// the special command `%vars` and by the variables inspector (front-end comms opened with the target
+// VariablesCommTarget).
+//
+// The values are only reported once they are requested -- by `%vars` (after which they are always reported)
+// or while a variables inspector comm is open -- so other programs don't pay the cost.
+//
+// The generated `main()` then calls `gonbVarsSave()` (defined in the generated VarsGo file) on exit, which
+// describes every global variable and saves them in the VarsJson file, read after the execution.
+// The generated code only uses the standard library, so it doesn't add dependencies to the cells -- that is
+// also why a file is used, and not the named pipe, which carries the gob stream of `gonbui`.
+// The variables saved are only kept if the execution succeeds.
+
+const (
+	// VarsGo is the name of the generated file, in `State.TempDir`, with the function that saves the
+	// values of the variables.
+	VarsGo = "gonb_vars.go"
+
+	// VarsJson is the name of the file, in `State.TempDir`, where the program saves the values of the variables.
+	VarsJson = "gonb_vars.json"
+
+	// VariablesCommTarget is the "target_name" of the comms opened by the front-end to inspect the variables.
+	//
+	// Any message sent by the front-end is taken as a request for the variables, which are sent back in
+	// the field "variables" of the data, as a list of objects with the fields "name", "type", "size" and
+	// "value". They are also sent after every successful execution, while the comm is open.
+	VariablesCommTarget = "gonb_variables"
+
+	// MaxVariableValueLength is the maximum length of the values of the variables reported: longer values
+	// are truncated with an ellipsis.
+	MaxVariableValueLength = 200
+)
+
+// VariableValue describes the value of a global variable at the end of the execution of a cell.
+type VariableValue struct {
+	Name string `json:"name"`
+
+	// Type of the value, as printed by "%T".
+	Type string `json:"type"`
+
+	// Size of the value: the number of elements for slices, arrays, maps, channels and strings,
+	// or otherwise the size of the value in bytes. E.g.: "len=10" or "8 bytes".
+	Size string `json:"size"`
+
+	// Value formatted with "%v", truncated to MaxVariableValueLength.
+	Value string `json:"value"`
+}
+
+// variablesInfo holds the values of the variables reported by the program.
+// It is accessed concurrently by the comms handlers, hence the mutex.
+type variablesInfo struct {
+	mu sync.Mutex
+
+	// values of the variables at the end of the last successful execution, sorted by name.
+	values []VariableValue
+
+	// comms holds the ids of the open comms of the variables inspector.
+	comms Set[string]
+
+	// requested is set once the variables are requested with `%vars`.
+	requested bool
+}
+
+func newVariablesInfo() *variablesInfo {
+	return &variablesInfo{comms: MakeSet[string]()}
+}
+
+// Variables returns the values of the variables at the end of the last successful execution, sorted by name.
+func (s *State) Variables() []VariableValue {
+	s.variables.mu.Lock()
+	defer s.variables.mu.Unlock()
+	return s.variables.values
+}
+
+// RequestVariables makes the following executions report the values of the variables, used by `%vars`.
+// Otherwise, they are only reported while a variables inspector comm is open.
+func (s *State) RequestVariables() {
+	s.variables.mu.Lock()
+	defer s.variables.mu.Unlock()
+	s.variables.requested = true
+}
+
+// variablesRequested returns whether the values of the variables were requested, with `%vars` or by an open
+// variables inspector comm.
+func (s *State) variablesRequested() bool {
+	s.variables.mu.Lock()
+	defer s.variables.mu.Unlock()
+	return s.variables.requested || len(s.variables.comms) > 0
+}
+
+// resetVariables discards the values of the variables, e.g. after a `%reset`.
+func (s *State) resetVariables() {
+	s.variables.mu.Lock()
+	defer s.variables.mu.Unlock()
+	s.variables.values = nil
+}
+
+// VarsJsonPath returns the path of the file where the program saves the values of the variables.
+func (s *State) VarsJsonPath() string {
+	return path.Join(s.TempDir, VarsJson)
+}
+
+// removeVarsJson removes the values of the variables saved by a previous execution.
+func (s *State) removeVarsJson() error {
+	err := os.Remove(s.VarsJsonPath())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove %q", s.VarsJsonPath())
+	}
+	return nil
+}
+
+// updateVariables is called after the execution of a cell: if it succeeded, the values of the variables saved
+// by the program become the current values, and they are sent to the open variables inspectors.
+//
+// If the values of the variables were not requested, the previous values are discarded, since they are stale.
+func (s *State) updateVariables(msg kernel.Message, succeeded bool) {
+	if !succeeded || !s.reportsVariables() {
+		return
+	}
+	if !s.variablesRequested() {
+		s.resetVariables()
+		return
+	}
+	var values []VariableValue
+	contents, err := os.ReadFile(s.VarsJsonPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Errorf("%%vars: failed to read the values of the variables: %+v", err)
+			return
+		}
+		if len(s.reportedVariables(s.Definitions)) > 0 {
+			// The program exited without saving its variables, e.g. with os.Exit(): keep the previous values.
+			return
+		}
+	} else if err = json.Unmarshal(contents, &values); err != nil {
+		klog.Errorf("%%vars: failed to parse the values of the variables in %q: %+v", s.VarsJsonPath(), err)
+		return
+	}
+
+	s.variables.mu.Lock()
+	s.variables.values = values
+	commIds := SortedKeys(s.variables.comms)
+	s.variables.mu.Unlock()
+	for _, commId := range commIds {
+		if err = s.publishVariables(msg, commId); err != nil {
+			klog.Warningf("Failed to send variables to the variables inspector: %+v", err)
+		}
+	}
+}
+
+// publishVariables sends the current values of the variables to the variables inspector comm.
+func (s *State) publishVariables(msg kernel.Message, commId string) error {
+	values := s.Variables()
+	if values == nil {
+		values = []VariableValue{}
+	}
+	return msg.Publish("comm_msg", map[string]any{
+		"comm_id": commId,
+		"data":    map[string]any{"variables": values},
+	})
+}
+
+// OpenVariablesComm registers a comm opened by the front-end with the target VariablesCommTarget,
+// and sends it the current values of the variables.
+func (s *State) OpenVariablesComm(msg kernel.Message, commId string) error {
+	s.variables.mu.Lock()
+	s.variables.comms.Insert(commId)
+	s.variables.mu.Unlock()
+	return s.publishVariables(msg, commId)
+}
+
+// IsVariablesComm returns whether commId was opened with the target VariablesCommTarget.
+func (s *State) IsVariablesComm(commId string) bool {
+	s.variables.mu.Lock()
+	defer s.variables.mu.Unlock()
+	return s.variables.comms.Has(commId)
+}
+
+// HandleVariablesCommMsg handles a message sent by the variables inspector: it replies with the current
+// values of the variables.
+func (s *State) HandleVariablesCommMsg(msg kernel.Message, commId string) error {
+	return s.publishVariables(msg, commId)
+}
+
+// CloseVariablesComm unregisters a comm of the variables inspector.
+func (s *State) CloseVariablesComm(commId string) {
+	s.variables.mu.Lock()
+	defer s.variables.mu.Unlock()
+	s.variables.comms.Delete(commId)
+}
+
+// reportsVariables returns whether the program of the current cell reports the values of its variables.
+// Tests, WASM and cells executed in a session don't.
+func (s *State) reportsVariables() bool {
+	return !s.CellIsTest && !s.CellIsWasm && !s.useSession()
+}
+
+// reportedVariables returns the sorted names of the variables in decls whose values are reported at the end
+// of the execution: none if they were not requested (see RequestVariables).
+func (s *State) reportedVariables(decls *Declarations) []string {
+	if !s.reportsVariables() || !s.variablesRequested() {
+		return nil
+	}
+	names := make([]string, 0, len(decls.Variables))
+	for _, key := range SortedKeys(decls.Variables) {
+		// Blank variables have unique keys, but they have no value to report.
+		if name := decls.Variables[key].Name; name != "_" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// varsMainPrefix is injected at the start of `func main()`, in the same line, when there are variables to report.
+const varsMainPrefix = " defer gonbVarsSave();"
+
+// varsMain returns a copy of mainDecl that saves the values of the variables on exit.
+func (s *State) varsMain(mainDecl *Function, names []string) *Function {
+	if len(names) == 0 {
+		return mainDecl
+	}
+	return injectIntoMain(mainDecl, varsMainPrefix)
+}
+
+// writeVarsFile writes (or removes if there are no variables to report) the VarsGo file.
+func (s *State) writeVarsFile(names []string) error {
+	if len(names) == 0 {
+		return s.writeOrRemoveGenerated(VarsGo, "")
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(varsGoTemplate, MaxVariableValueLength, s.VarsJsonPath()))
+	sb.WriteString("\nfunc gonbVarsSave() {\n\tgonbVarsWrite([]gonbVarsValue{\n")
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("\t\tgonbVarsDescribe(%q, %s),\n", name, name))
+	}
+	sb.WriteString("\t})\n}\n")
+	return s.writeOrRemoveGenerated(VarsGo, sb.String())
+}
+
+// varsGoTemplate is the static part of VarsGo. It takes as parameters the maximum length of the values, and the
+// path where to save them.
+//
+// Imports are aliased, so they don't conflict with the names declared in the cells.
+const varsGoTemplate = `// Generated by GoNB to report the values of the variables to %%vars: do not edit.
+package main
+
+import (
+	gonbVarsCmp "cmp"
+	gonbVarsJson "encoding/json"
+	gonbVarsFmt "fmt"
+	gonbVarsOs "os"
+	gonbVarsReflect "reflect"
+	gonbVarsSlices "slices"
+	gonbVarsStrings "strings"
+)
+
+type gonbVarsValue struct {
+	Name  string ` + "`json:\"name\"`" + `
+	Type  string ` + "`json:\"type\"`" + `
+	Size  string ` + "`json:\"size\"`" + `
+	Value string ` + "`json:\"value\"`" + `
+}
+
+const gonbVarsMaxLength = %d
+
+// gonbVarsDescribe returns the type, size and truncated value of a variable.
+func gonbVarsDescribe(name string, value any) gonbVarsValue {
+	v := gonbVarsValue{Name: name, Type: gonbVarsFmt.Sprintf("%%T", value), Value: "<nil>"}
+	rv := gonbVarsReflect.ValueOf(value)
+	if !rv.IsValid() {
+		return v
+	}
+	switch rv.Kind() {
+	case gonbVarsReflect.Slice, gonbVarsReflect.Array, gonbVarsReflect.Map, gonbVarsReflect.Chan, gonbVarsReflect.String:
+		v.Size = gonbVarsFmt.Sprintf("len=%%d", rv.Len())
+	default:
+		v.Size = gonbVarsFmt.Sprintf("%%d bytes", rv.Type().Size())
+	}
+	var sb gonbVarsStrings.Builder
+	gonbVarsFormat(&sb, rv, 0)
+	v.Value = sb.String()
+	if runes := []rune(v.Value); len(runes) > gonbVarsMaxLength {
+		v.Value = string(runes[:gonbVarsMaxLength]) + "…"
+	}
+	return v
+}
+
+// gonbVarsFormat formats rv like "%%v", but it stops formatting the elements of slices, arrays, maps and
+// structs (at any depth) once the value is longer than can be displayed, so large values are not fully formatted.
+// Values that implement their own formatting are formatted with "%%v".
+func gonbVarsFormat(sb *gonbVarsStrings.Builder, rv gonbVarsReflect.Value, depth int) {
+	if !rv.IsValid() {
+		sb.WriteString("<nil>")
+		return
+	}
+	if rv.CanInterface() {
+		switch value := rv.Interface().(type) {
+		case gonbVarsFmt.Formatter, gonbVarsFmt.Stringer, error:
+			gonbVarsFmt.Fprintf(sb, "%%v", value)
+			return
+		}
+	}
+	full := func() bool { return sb.Len() > gonbVarsMaxLength }
+	switch rv.Kind() {
+	case gonbVarsReflect.Slice, gonbVarsReflect.Array:
+		sb.WriteString("[")
+		for ii := range rv.Len() {
+			if ii > 0 {
+				sb.WriteString(" ")
+			}
+			if full() {
+				sb.WriteString("...")
+				break
+			}
+			gonbVarsFormat(sb, rv.Index(ii), depth+1)
+		}
+		sb.WriteString("]")
+	case gonbVarsReflect.Map:
+		sb.WriteString("map[")
+		var keys []gonbVarsReflect.Value
+		if rv.Len() <= gonbVarsMaxLength {
+			keys = rv.MapKeys()
+			gonbVarsSlices.SortFunc(keys, gonbVarsCompare)
+		} else {
+			// Too many to sort: only the first ones (in random order) are displayed.
+			for iter := rv.MapRange(); iter.Next() && len(keys) <= gonbVarsMaxLength; {
+				keys = append(keys, iter.Key())
+			}
+		}
+		for ii, key := range keys {
+			if ii > 0 {
+				sb.WriteString(" ")
+			}
+			if full() {
+				sb.WriteString("...")
+				break
+			}
+			gonbVarsFormat(sb, key, depth+1)
+			sb.WriteString(":")
+			gonbVarsFormat(sb, rv.MapIndex(key), depth+1)
+		}
+		sb.WriteString("]")
+	case gonbVarsReflect.Struct:
+		sb.WriteString("{")
+		for ii := range rv.NumField() {
+			if ii > 0 {
+				sb.WriteString(" ")
+			}
+			if full() {
+				sb.WriteString("...")
+				break
+			}
+			gonbVarsFormat(sb, rv.Field(ii), depth+1)
+		}
+		sb.WriteString("}")
+	case gonbVarsReflect.Interface:
+		gonbVarsFormat(sb, rv.Elem(), depth)
+	case gonbVarsReflect.Pointer:
+		// Like "%%v", only pointers at the top level are followed.
+		switch elemKind := rv.Type().Elem().Kind(); {
+		case depth == 0 && !rv.IsNil() && (elemKind == gonbVarsReflect.Struct || elemKind == gonbVarsReflect.Slice ||
+			elemKind == gonbVarsReflect.Array || elemKind == gonbVarsReflect.Map):
+			sb.WriteString("&")
+			gonbVarsFormat(sb, rv.Elem(), depth+1)
+		default:
+			gonbVarsFmt.Fprintf(sb, "%%v", rv)
+		}
+	default:
+		// fmt formats the value held by a reflect.Value, even if it was obtained from unexported fields.
+		gonbVarsFmt.Fprintf(sb, "%%v", rv)
+	}
+}
+
+// gonbVarsCompare orders map keys: numbers and strings by their values, anything else by their formatting.
+func gonbVarsCompare(a, b gonbVarsReflect.Value) int {
+	switch {
+	case a.CanInt():
+		return gonbVarsCmp.Compare(a.Int(), b.Int())
+	case a.CanUint():
+		return gonbVarsCmp.Compare(a.Uint(), b.Uint())
+	case a.CanFloat():
+		return gonbVarsCmp.Compare(a.Float(), b.Float())
+	case a.Kind() == gonbVarsReflect.String:
+		return gonbVarsCmp.Compare(a.String(), b.String())
+	}
+	return gonbVarsCmp.Compare(gonbVarsFmt.Sprintf("%%v", a), gonbVarsFmt.Sprintf("%%v", b))
+}
+
+// gonbVarsWrite saves the values of the variables for GoNB.
+func gonbVarsWrite(values []gonbVarsValue) {
+	data, err := gonbVarsJson.Marshal(values)
+	if err == nil {
+		err = gonbVarsOs.WriteFile(%q, data, 0600)
+	}
+	if err != nil {
+		gonbVarsFmt.Fprintf(gonbVarsOs.Stderr, "%%%%vars: failed to save the values of the variables: %%v\n", err)
+	}
+}
+`
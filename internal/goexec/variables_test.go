@@ -0,0 +1,104 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariables(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	// Variables are only reported once requested.
+	cellLines := strings.Split("var x, y = 1, \"a\"\nvar _ = 3\n\n%%\nfmt.Println(x, y)", "\n")
+	_, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(s.TempDir, VarsGo))
+	require.True(t, os.IsNotExist(err))
+
+	s.RequestVariables()
+	updatedDecls, _, _, _, err := s.parseLinesAndComposeMain(nil, 1, cellLines, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	s.Definitions = updatedDecls
+	contentBytes, err := os.ReadFile(s.CodePath())
+	require.NoError(t, err)
+	assert.Contains(t, string(contentBytes), "func main() { defer gonbVarsSave();\n")
+	varsContent, err := os.ReadFile(path.Join(s.TempDir, VarsGo))
+	require.NoError(t, err)
+	assert.Contains(t, string(varsContent),
+		"\t\tgonbVarsDescribe(\"x\", x),\n\t\tgonbVarsDescribe(\"y\", y),\n\t})\n")
+	assert.NotContains(t, string(varsContent), "_~")
+	assert.Contains(t, string(varsContent), s.VarsJsonPath())
+
+	// Values are only updated by successful executions.
+	require.NoError(t, os.WriteFile(s.VarsJsonPath(),
+		[]byte(`[{"name":"x","type":"int","size":"8 bytes","value":"1"},{"name":"y","type":"string","size":"len=1","value":"a"}]`), 0600))
+	s.updateVariables(nil, true)
+	require.Len(t, s.Variables(), 2)
+	assert.Equal(t, VariableValue{Name: "x", Type: "int", Size: "8 bytes", Value: "1"}, s.Variables()[0])
+	require.NoError(t, os.WriteFile(s.VarsJsonPath(), []byte(`[]`), 0600))
+	s.updateVariables(nil, false)
+	assert.Len(t, s.Variables(), 2)
+
+	// An execution that didn't save the variables (e.g. `os.Exit()`) keeps the previous values.
+	require.NoError(t, s.removeVarsJson())
+	s.updateVariables(nil, true)
+	assert.Len(t, s.Variables(), 2)
+
+	// Without variables, the generated file is removed.
+	s.Reset()
+	assert.Empty(t, s.Variables())
+	_, _, _, _, err = s.parseLinesAndComposeMain(nil, 2, []string{"%%", "fmt.Println(1)"}, MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	_, err = os.Stat(path.Join(s.TempDir, VarsGo))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestVariablesFormat(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	require.NoError(t, s.GoModInit())
+	s.RequestVariables()
+
+	cell := `import ("flag"; "fmt")
+type point struct { x, y int }
+var (
+	matrix = [][]float64{make([]float64, 1e6), make([]float64, 1e6)}
+	counts = map[int]string{10: "a", 9: "b"}
+	p = &point{1, 2}
+	err = fmt.Errorf("failed")
+)
+%%
+`
+	updatedDecls, _, _, fileToCellIdAndLine, err := s.parseLinesAndComposeMain(nil, 1, strings.Split(cell, "\n"), MakeSet[int](), NoCursor)
+	require.NoError(t, err)
+	s.Definitions = updatedDecls
+	require.NoError(t, s.Compile(nil, fileToCellIdAndLine))
+	require.NoError(t, exec.Command(s.BinaryPath()).Run())
+	s.updateVariables(nil, true)
+
+	values := make(map[string]VariableValue)
+	for _, v := range s.Variables() {
+		values[v.Name] = v
+	}
+	matrix := values["matrix"]
+	assert.Equal(t, "len=2", matrix.Size)
+	assert.True(t, strings.HasPrefix(matrix.Value, "[[0 0 0 "), "matrix value: %q", matrix.Value)
+	assert.True(t, strings.HasSuffix(matrix.Value, "…"), "matrix value: %q", matrix.Value)
+	assert.Equal(t, "map[9:b 10:a]", values["counts"].Value)
+	assert.Equal(t, "&{1 2}", values["p"].Value)
+	assert.Equal(t, "failed", values["err"].Value)
+}
@@ -126,6 +126,21 @@ func JupyterRootDirectory() (string, error) {
 	return jupyterRootDirectory, nil
 }
 
+// JupyterNotebookPath returns the path of the notebook of the kernel session, given by Jupyter (relative to
+// its root directory) in the environment variable JupyterSessionNameEnv.
+func JupyterNotebookPath() (string, error) {
+	sessionName := os.Getenv(JupyterSessionNameEnv)
+	if path.Ext(sessionName) != ".ipynb" {
+		return "", errors.Errorf("cannot figure out the notebook path, environment variable %s=%q is not a notebook",
+			JupyterSessionNameEnv, sessionName)
+	}
+	rootDir, err := JupyterRootDirectory()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(rootDir, sessionName), nil
+}
+
 var goRoot string
 
 func GoRoot() (string, error) {
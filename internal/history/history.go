@@ -0,0 +1,319 @@
+// Package history stores the code executed in the cells, along with their status and outputs, in a file on disk.
+//
+// It is used to answer Jupyter's "history_request" messages (used by Jupyter console and qtconsole to recall
+// previous inputs) and by the `%history` special command, which allows one to recover the code of cells that
+// were overwritten in the notebook.
+//
+// Each execution of the kernel is a new "session", identified by a unique id, so concurrent kernels sharing the
+// file don't mix their entries. For the Jupyter protocol, sessions are numbered sequentially, in the order they
+// first appear in the file. Within a session each entry is identified by its "line", the execution count
+// (`Kernel.ExecCounter`) of the cell.
+//
+// Entries are stored as one JSON object per line, in a file shared by all kernels. When the file grows larger
+// than Store.MaxFileSize, it is rotated: only the last two files are kept.
+//
+// The outputs of the cells are only stored if Store.RecordOutputs is set (flag `--history_outputs`).
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Entry of the history: one cell execution.
+type Entry struct {
+	// SessionId identifies the kernel session that executed the cell.
+	SessionId string `json:"session_id"`
+
+	// Session is the number of the session, assigned when the history is read: sessions are numbered
+	// sequentially (starting from 1), in the order they first appear in the history.
+	Session int `json:"-"`
+
+	Line int       `json:"line"`
+	Time time.Time `json:"time"`
+	Code string    `json:"code"`
+
+	// Status of the execution: "ok" or "error".
+	Status string `json:"status"`
+
+	// Outputs of the execution, if Store.RecordOutputs is set, and whether they were truncated because they were
+	// too large (see MaxOutputSize).
+	Outputs   []ipynb.Output `json:"outputs,omitempty"`
+	Truncated bool           `json:"truncated,omitempty"`
+}
+
+// Text returns the textual output of the entry: the contents of the streams and the "text/plain"
+// representation of the data displayed.
+func (e *Entry) Text() string {
+	var sb strings.Builder
+	for _, output := range e.Outputs {
+		switch output.OutputType {
+		case "stream":
+			sb.WriteString(output.Text)
+		case "error":
+			sb.WriteString(output.EName + ": " + output.EValue + "\n")
+		default:
+			if text, ok := output.Data["text/plain"].(string); ok {
+				sb.WriteString(text)
+				if !strings.HasSuffix(text, "\n") {
+					sb.WriteString("\n")
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+// DefaultMaxFileSize is the default value of Store.MaxFileSize.
+var DefaultMaxFileSize int64 = 10 << 20
+
+// Store of the history entries, in a file.
+type Store struct {
+	// MaxFileSize of the history file, in bytes: when an entry would make the file larger, the file is rotated
+	// (renamed with the suffix ".1", replacing the previous one) and a new file is started. Entries are read from
+	// both files. If <= 0 there is no limit.
+	// It is initialized with DefaultMaxFileSize.
+	MaxFileSize int64
+
+	// RecordOutputs enables the recording of the outputs of the cells (up to MaxOutputSize per cell), besides
+	// their code. It is disabled by default, since outputs may be large or hold sensitive data.
+	RecordOutputs bool
+
+	mu        sync.Mutex
+	filePath  string
+	sessionId string
+}
+
+// DefaultPath returns the default file where to store the history: `gonb/history.jsonl` under the user
+// configuration directory (see `os.UserConfigDir`). It returns "" if the configuration directory is unknown.
+func DefaultPath() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return path.Join(configDir, "gonb", "history.jsonl")
+}
+
+// Open the history stored in filePath, creating its directory if it doesn't exist, and starts a new session.
+// The id of the session is derived from kernelId (the unique id of the kernel) and the current time.
+func Open(filePath, kernelId string) (*Store, error) {
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory for history file %q", filePath)
+	}
+	s := &Store{
+		MaxFileSize: DefaultMaxFileSize,
+		filePath:    filePath,
+		sessionId:   fmt.Sprintf("%s_%x", kernelId, time.Now().UnixNano()),
+	}
+	klog.V(1).Infof("History in %q, session %q", filePath, s.sessionId)
+	return s, nil
+}
+
+// Path of the file where the history is stored.
+func (s *Store) Path() string {
+	return s.filePath
+}
+
+// SessionId returns the id of the current session.
+func (s *Store) SessionId() string {
+	return s.sessionId
+}
+
+// Add entry to the history of the current session. It sets entry.SessionId.
+func (s *Store) Add(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.SessionId = s.sessionId
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode history entry")
+	}
+	if s.MaxFileSize > 0 {
+		info, err := os.Stat(s.filePath)
+		if err == nil && info.Size()+int64(len(data))+1 > s.MaxFileSize {
+			if err = os.Rename(s.filePath, s.rotatedPath()); err != nil {
+				return errors.Wrapf(err, "failed to rotate history file %q", s.filePath)
+			}
+		}
+	}
+	f, err := os.OpenFile(s.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open history file %q", s.filePath)
+	}
+	_, err = f.Write(append(data, '\n'))
+	err2 := f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "failed to write to history file %q", s.filePath)
+	}
+	if err2 != nil {
+		return errors.Wrapf(err2, "failed to close history file %q", s.filePath)
+	}
+	return nil
+}
+
+// rotatedPath is the path of the previous history file, after it was rotated.
+func (s *Store) rotatedPath() string {
+	return s.filePath + ".1"
+}
+
+// Entries returns all the entries in the history, in the order they were added, with their session numbers.
+// Invalid lines in the file are logged and skipped.
+func (s *Store) Entries() ([]*Entry, error) {
+	entries, _, err := s.numberedEntries()
+	return entries, err
+}
+
+// numberedEntries returns all the entries in the history, with their session numbers, and the number of the
+// current session.
+func (s *Store) numberedEntries() (entries []*Entry, session int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, filePath := range []string{s.rotatedPath(), s.filePath} {
+		entries, err = readEntries(filePath, entries)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	sessions := make(map[string]int)
+	for _, entry := range entries {
+		number, found := sessions[entry.SessionId]
+		if !found {
+			number = len(sessions) + 1
+			sessions[entry.SessionId] = number
+		}
+		entry.Session = number
+	}
+	session, found := sessions[s.sessionId]
+	if !found {
+		session = len(sessions) + 1
+	}
+	return entries, session, nil
+}
+
+// readEntries reads the entries of the history file filePath, and appends them to entries.
+// It is not an error if the file doesn't exist.
+func readEntries(filePath string, entries []*Entry) ([]*Entry, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, errors.Wrapf(err, "failed to open history file %q", filePath)
+	}
+	defer func() { _ = f.Close() }()
+
+	reader := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			entry := &Entry{}
+			if jsonErr := json.Unmarshal(line, entry); jsonErr != nil {
+				klog.Warningf("History file %q: skipping invalid line %d: %v", filePath, lineNum, jsonErr)
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// Range returns the entries of the given session with lines in the range [start, stop).
+//
+// If session is <= 0, it is relative to the current session: 0 is the current session, -1 the previous one, etc.
+// If stop <= 0, there is no upper limit.
+func (s *Store) Range(session, start, stop int) ([]*Entry, error) {
+	entries, current, err := s.numberedEntries()
+	if err != nil {
+		return nil, err
+	}
+	if session <= 0 {
+		session += current
+	}
+	var selected []*Entry
+	for _, entry := range entries {
+		if entry.Session == session && entry.Line >= start && (stop <= 0 || entry.Line < stop) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, nil
+}
+
+// Tail returns the last n entries, from all sessions. If n <= 0, it returns all entries.
+func (s *Store) Tail(n int) ([]*Entry, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+// Search returns the last n entries, from all sessions, whose code matches the glob pattern, where
+// "*" matches any sequence of characters and "?" matches any single character. If n <= 0, it returns all
+// the matching entries.
+//
+// If unique is true, only the last entry with any given code is returned.
+func (s *Store) Search(pattern string, n int, unique bool) ([]*Entry, error) {
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var selected []*Entry
+	seen := make(map[string]bool)
+	// Loop backwards, to select the latest entries.
+	for ii := len(entries) - 1; ii >= 0 && (n <= 0 || len(selected) < n); ii-- {
+		entry := entries[ii]
+		if !re.MatchString(entry.Code) || (unique && seen[entry.Code]) {
+			continue
+		}
+		seen[entry.Code] = true
+		selected = append(selected, entry)
+	}
+	// Revert to chronological order.
+	for ii, jj := 0, len(selected)-1; ii < jj; ii, jj = ii+1, jj-1 {
+		selected[ii], selected[jj] = selected[jj], selected[ii]
+	}
+	return selected, nil
+}
+
+// globToRegexp converts a glob pattern ("*" and "?" wildcards) to an anchored regular expression.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid history search pattern %q", pattern)
+	}
+	return re, nil
+}
@@ -0,0 +1,169 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "gonb", "history.jsonl")
+	s, err := Open(filePath, "kernel1")
+	require.NoError(t, err)
+	require.NoError(t, s.Add(&Entry{Line: 1, Code: "a := 1", Status: "ok"}))
+	require.NoError(t, s.Add(&Entry{Line: 2, Code: "fmt.Println(a)", Status: "ok",
+		Outputs: []ipynb.Output{{OutputType: "stream", Name: "stdout", Text: "1\n"}}}))
+
+	// Second session, with an invalid line in between.
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString("not json\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	s, err = Open(filePath, "kernel2")
+	require.NoError(t, err)
+	require.NoError(t, s.Add(&Entry{Line: 1, Code: "b := 2", Status: "ok"}))
+	require.NoError(t, s.Add(&Entry{Line: 2, Code: "fmt.Println(a)", Status: "error"}))
+
+	entries, err := s.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "1\n", entries[1].Text())
+
+	// Range: current session, and previous one.
+	entries, err = s.Range(0, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 2, entries[0].Session)
+	assert.Equal(t, "fmt.Println(a)", entries[0].Code)
+	entries, err = s.Range(-1, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a := 1", entries[0].Code)
+
+	// Tail.
+	entries, err = s.Tail(3)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "fmt.Println(a)", entries[0].Code)
+	assert.Equal(t, 1, entries[0].Session)
+
+	// Search.
+	entries, err = s.Search("fmt.*", 0, false)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	entries, err = s.Search("fmt.*", 0, true)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 2, entries[0].Session)
+	entries, err = s.Search("? := ?", 1, false)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "b := 2", entries[0].Code)
+
+	// A concurrent kernel, sharing the file: sessions don't mix.
+	other, err := Open(filePath, "kernel3")
+	require.NoError(t, err)
+	require.NoError(t, other.Add(&Entry{Line: 1, Code: "c := 3", Status: "ok"}))
+	require.NoError(t, s.Add(&Entry{Line: 3, Code: "d := 4", Status: "ok"}))
+	entries, err = s.Range(0, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "d := 4", entries[2].Code)
+	entries, err = other.Range(0, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 3, entries[0].Session)
+	assert.Equal(t, "c := 3", entries[0].Code)
+}
+
+func TestStoreRotation(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "history.jsonl")
+	s, err := Open(filePath, "kernel")
+	require.NoError(t, err)
+	s.MaxFileSize = 300
+	for line := 1; line <= 20; line++ {
+		require.NoError(t, s.Add(&Entry{Line: line, Code: fmt.Sprintf("x := %d", line), Status: "ok"}))
+	}
+	for _, p := range []string{filePath, filePath + ".1"} {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), s.MaxFileSize)
+	}
+
+	// Only the entries of the last two files are kept.
+	entries, err := s.Range(0, 0, 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Less(t, len(entries), 20)
+	assert.Equal(t, 20, entries[len(entries)-1].Line)
+	for ii, entry := range entries[1:] {
+		assert.Equal(t, entries[ii].Line+1, entry.Line)
+	}
+}
+
+// fakeMessage implements kernel.Message.Publish only.
+type fakeMessage struct {
+	kernel.Message
+	published []string
+}
+
+func (m *fakeMessage) Publish(msgType string, _ any) error {
+	m.published = append(m.published, msgType)
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	msg := &fakeMessage{}
+	r := NewRecorder(msg)
+	require.NoError(t, r.Publish("execute_input", map[string]any{"code": "x"}))
+	require.NoError(t, r.Publish("stream", map[string]any{"name": "stdout", "text": "a"}))
+	require.NoError(t, r.Publish("stream", map[string]any{"name": "stdout", "text": "b\n"}))
+	require.NoError(t, r.Publish("display_data", map[string]any{
+		"data": map[string]any{"text/plain": "plot"}, "metadata": map[string]any{}, "transient": map[string]any{}}))
+	require.NoError(t, r.Publish("error", map[string]any{"ename": "ERROR", "evalue": "failed", "traceback": []string{"failed"}}))
+	assert.Len(t, msg.published, 5)
+
+	outputs, truncated := r.Outputs()
+	assert.False(t, truncated)
+	require.Len(t, outputs, 3)
+	assert.Equal(t, "ab\n", outputs[0].Text)
+	entry := &Entry{Outputs: outputs}
+	assert.Equal(t, "ab\nplot\nERROR: failed\n", entry.Text())
+
+	// Updates to displays and clearing of the output.
+	r = NewRecorder(msg)
+	display := func(msgType, text string) {
+		require.NoError(t, r.Publish(msgType, map[string]any{
+			"data": map[string]any{"text/plain": text}, "metadata": map[string]any{},
+			"transient": map[string]any{"display_id": "progress"}}))
+	}
+	display("display_data", "0%")
+	display("update_display_data", "100%")
+	outputs, _ = r.Outputs()
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "display_data", outputs[0].OutputType)
+	assert.Equal(t, "100%", outputs[0].Data["text/plain"])
+	require.NoError(t, r.Publish("clear_output", map[string]any{"wait": true}))
+	outputs, _ = r.Outputs()
+	require.Len(t, outputs, 1, "With wait, outputs are only cleared at the next output")
+	require.NoError(t, r.Publish("stream", map[string]any{"name": "stdout", "text": "done"}))
+	outputs, _ = r.Outputs()
+	require.Len(t, outputs, 1)
+	assert.Equal(t, "done", outputs[0].Text)
+
+	// Truncation.
+	defer func(size int) { MaxOutputSize = size }(MaxOutputSize)
+	MaxOutputSize = 10
+	r = NewRecorder(msg)
+	require.NoError(t, r.Publish("stream", map[string]any{"name": "stdout", "text": "a very long output"}))
+	outputs, truncated = r.Outputs()
+	assert.True(t, truncated)
+	assert.Empty(t, outputs)
+}
@@ -0,0 +1,128 @@
+package history
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+)
+
+// MaxOutputSize is the maximum size, in bytes (when encoded), of the outputs recorded for one cell execution.
+// Outputs beyond that are dropped, and the entry is marked as truncated.
+var MaxOutputSize = 1 << 20
+
+// Recorder wraps a kernel.Message, and records the outputs published through it, while forwarding
+// everything to the wrapped message.
+type Recorder struct {
+	kernel.Message
+
+	// MaxSize of the outputs recorded, in bytes (when encoded). If <= 0 there is no limit.
+	// It is initialized with MaxOutputSize.
+	MaxSize int
+
+	mu         sync.Mutex
+	outputs    []ipynb.Output
+	displayIds map[string]int // display_id -> index in outputs.
+	clearNext  bool           // Set by a "clear_output" with "wait", the outputs are cleared by the next output.
+	size       int
+	truncated  bool
+}
+
+// NewRecorder returns a Recorder of the outputs published through msg.
+func NewRecorder(msg kernel.Message) *Recorder {
+	return &Recorder{Message: msg, MaxSize: MaxOutputSize, displayIds: make(map[string]int)}
+}
+
+// Publish implements kernel.Message: it records the outputs, and forwards them to the wrapped message.
+func (r *Recorder) Publish(msgType string, content interface{}) error {
+	switch msgType {
+	case "stream", "display_data", "update_display_data", "execute_result", "error", "clear_output":
+		r.record(msgType, content)
+	}
+	return r.Message.Publish(msgType, content)
+}
+
+// record converts the content to an Output and appends it to the recorded outputs.
+// Consecutive outputs to the same stream are merged, and updates to a display replace its contents.
+func (r *Recorder) record(msgType string, content any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msgType == "clear_output" {
+		var clear struct {
+			Wait bool `json:"wait"`
+		}
+		data, _ := json.Marshal(content)
+		_ = json.Unmarshal(data, &clear)
+		r.clearNext = clear.Wait
+		if !clear.Wait {
+			r.clear()
+		}
+		return
+	}
+	if r.clearNext {
+		r.clear()
+	}
+	if r.truncated {
+		return
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		klog.Warningf("history: failed to encode %q output: %v", msgType, err)
+		return
+	}
+	if r.MaxSize > 0 && r.size+len(data) > r.MaxSize {
+		r.truncated = true
+		return
+	}
+	r.size += len(data)
+	output := ipynb.Output{}
+	if err = json.Unmarshal(data, &output); err != nil {
+		klog.Warningf("history: failed to decode %q output: %v", msgType, err)
+		return
+	}
+	var transient struct {
+		Transient struct {
+			DisplayId string `json:"display_id"`
+		} `json:"transient"`
+	}
+	_ = json.Unmarshal(data, &transient)
+	displayId := transient.Transient.DisplayId
+	output.OutputType = msgType
+	switch msgType {
+	case "stream":
+		if len(r.outputs) > 0 {
+			last := &r.outputs[len(r.outputs)-1]
+			if last.OutputType == "stream" && last.Name == output.Name {
+				last.Text += output.Text
+				return
+			}
+		}
+	case "update_display_data":
+		if idx, found := r.displayIds[displayId]; found {
+			r.outputs[idx].Data = output.Data
+			r.outputs[idx].Metadata = output.Metadata
+			return
+		}
+		output.OutputType = "display_data"
+	}
+	if displayId != "" {
+		r.displayIds[displayId] = len(r.outputs)
+	}
+	r.outputs = append(r.outputs, output)
+}
+
+// clear the outputs recorded so far. It must be called with r.mu locked.
+func (r *Recorder) clear() {
+	r.outputs = nil
+	r.displayIds = make(map[string]int)
+	r.clearNext = false
+}
+
+// Outputs returns the outputs recorded so far, and whether they were truncated.
+func (r *Recorder) Outputs() (outputs []ipynb.Output, truncated bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ipynb.Output(nil), r.outputs...), r.truncated
+}
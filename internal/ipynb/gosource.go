@@ -0,0 +1,150 @@
+package ipynb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the "Go source" format of notebooks: a plain `.go` file where cells are separated
+// by comment lines, so notebooks can be edited in an IDE and code-reviewed as normal Go diffs:
+//
+//	//gonb:cell
+//	func f() int { return 1 }
+//
+//	//gonb:markdown
+//	// # Some Title
+//	//
+//	// Markdown text, in comments.
+//
+//	//gonb:cell {"tags":["parameters"]}
+//	var x = 10
+//
+// Files are written with a "//go:build ignore" constraint in the first line, so notebooks (which are not
+// valid Go programs) can live in the directories of Go packages without breaking their build. Build
+// constraints before the first separator are dropped when parsing.
+//
+// Separators are "//gonb:cell" for code cells, "//gonb:markdown" for markdown cells and "//gonb:raw" for raw
+// cells, optionally followed by the cell metadata in JSON, if not empty. The contents of markdown and raw
+// cells are prefixed with "// ". Lines before the first separator, if not empty, form a code cell.
+//
+// Special commands can be written as `//gonb:%...` comments, see goexec.TrimGonbCommentPrefix.
+// Outputs, execution counts and the notebook metadata are not stored.
+
+// GoSourceExt is the extension of notebooks in the Go source format.
+const GoSourceExt = ".go"
+
+// goSourceSeparators maps the cell separators of the Go source format to the type of the cell.
+var goSourceSeparators = map[string]string{
+	"//gonb:cell":     "code",
+	"//gonb:markdown": "markdown",
+	"//gonb:raw":      "raw",
+}
+
+// goSourceBuildConstraint is written in the first line of notebooks in the Go source format.
+const goSourceBuildConstraint = "//go:build ignore"
+
+// goSourceCommentPrefix prefixes the lines of the contents of markdown and raw cells.
+const goSourceCommentPrefix = "// "
+
+// ParseGoSource parses a notebook in the Go source format.
+func ParseGoSource(src string) (*Notebook, error) {
+	nb := New()
+	var (
+		cell  *Cell // nil before the first separator.
+		lines []string
+	)
+	flush := func() {
+		// Leading and trailing empty lines are only separating cells.
+		for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+			lines = lines[1:]
+		}
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if cell == nil {
+			if len(lines) == 0 {
+				return
+			}
+			cell = &Cell{CellType: "code", Metadata: map[string]any{}}
+		}
+		if cell.CellType != "code" {
+			for ii, line := range lines {
+				if line == strings.TrimSpace(goSourceCommentPrefix) {
+					line = ""
+				}
+				lines[ii] = strings.TrimPrefix(line, goSourceCommentPrefix)
+			}
+		}
+		cell.Source = Source(strings.Join(lines, "\n"))
+		nb.Cells = append(nb.Cells, cell)
+		lines = nil
+	}
+
+	for lineNum, line := range strings.Split(src, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		separator, metadata, _ := strings.Cut(strings.TrimRight(line, " \t"), " ")
+		cellType, isSeparator := goSourceSeparators[separator]
+		if cell == nil && (strings.HasPrefix(line, "//go:build ") || strings.HasPrefix(line, "// +build ")) {
+			continue
+		}
+		if !isSeparator {
+			lines = append(lines, line)
+			continue
+		}
+		flush()
+		cell = &Cell{CellType: cellType, Metadata: map[string]any{}}
+		if metadata = strings.TrimSpace(metadata); metadata != "" {
+			if err := json.Unmarshal([]byte(metadata), &cell.Metadata); err != nil {
+				return nil, errors.Wrapf(err, "invalid metadata of cell in line %d", lineNum+1)
+			}
+		}
+	}
+	flush()
+	return nb, nil
+}
+
+// GoSource returns the notebook in the Go source format.
+func (nb *Notebook) GoSource() (string, error) {
+	var sb strings.Builder
+	sb.WriteString(goSourceBuildConstraint)
+	sb.WriteString("\n")
+	for ii, cell := range nb.Cells {
+		sb.WriteString("\n")
+		var separator string
+		for sep, cellType := range goSourceSeparators {
+			if cellType == cell.CellType {
+				separator = sep
+			}
+		}
+		if separator == "" {
+			return "", errors.Errorf("cell #%d has unknown type %q", ii, cell.CellType)
+		}
+		sb.WriteString(separator)
+		if len(cell.Metadata) > 0 {
+			metadata, err := json.Marshal(cell.Metadata)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to encode metadata of cell #%d", ii)
+			}
+			_, _ = fmt.Fprintf(&sb, " %s", metadata)
+		}
+		sb.WriteString("\n")
+		if cell.Source == "" {
+			continue
+		}
+		for _, line := range cell.Lines() {
+			if cell.CellType != "code" {
+				if line == "" {
+					line = strings.TrimSpace(goSourceCommentPrefix)
+				} else {
+					line = goSourceCommentPrefix + line
+				}
+			}
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,73 @@
+package ipynb
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoSource(t *testing.T) {
+	src := `//go:build ignore
+
+import "fmt"
+
+//gonb:markdown
+// # Title
+//
+// Some text.
+
+//gonb:cell {"tags":["parameters"]}
+var x = 10
+
+//gonb:cell
+//gonb:%%
+fmt.Println(x)
+
+//gonb:raw
+// raw text
+`
+	nb, err := ParseGoSource(src)
+	require.NoError(t, err)
+	require.Len(t, nb.Cells, 5)
+	assert.Equal(t, "code", nb.Cells[0].CellType)
+	assert.Equal(t, Source(`import "fmt"`), nb.Cells[0].Source)
+	assert.Equal(t, "markdown", nb.Cells[1].CellType)
+	assert.Equal(t, Source("# Title\n\nSome text."), nb.Cells[1].Source)
+	assert.Equal(t, map[string]any{"tags": []any{"parameters"}}, nb.Cells[2].Metadata)
+	assert.Equal(t, Source("var x = 10"), nb.Cells[2].Source)
+	assert.Equal(t, []string{"//gonb:%%", "fmt.Println(x)"}, nb.Cells[3].Lines())
+	assert.Equal(t, "raw", nb.Cells[4].CellType)
+	assert.Equal(t, Source("raw text"), nb.Cells[4].Source)
+
+	// Round trip: the first cell gets an explicit separator.
+	got, err := nb.GoSource()
+	require.NoError(t, err)
+	assert.Equal(t, strings.Replace(src, "import", "//gonb:cell\nimport", 1), got)
+
+	// Invalid metadata.
+	_, err = ParseGoSource("//gonb:cell {invalid\nvar x = 1\n")
+	require.Error(t, err)
+}
+
+func TestGoSourceFile(t *testing.T) {
+	dir := t.TempDir()
+	nb := New()
+	nb.Cells = append(nb.Cells,
+		&Cell{CellType: "markdown", Metadata: map[string]any{}, Source: "Text"},
+		&Cell{CellType: "code", Metadata: map[string]any{}, Source: "%%\nfmt.Println(1)"})
+	goPath := path.Join(dir, "nb.go")
+	require.NoError(t, nb.Write(goPath))
+	contents, err := os.ReadFile(goPath)
+	require.NoError(t, err)
+	assert.Equal(t, "//go:build ignore\n\n//gonb:markdown\n// Text\n\n//gonb:cell\n%%\nfmt.Println(1)\n", string(contents))
+
+	nb2, err := Read(goPath)
+	require.NoError(t, err)
+	require.Len(t, nb2.Cells, 2)
+	assert.Equal(t, nb.Cells[0].Source, nb2.Cells[0].Source)
+	assert.Equal(t, nb.Cells[1].Source, nb2.Cells[1].Source)
+}
@@ -0,0 +1,285 @@
+// Package ipynb reads and writes Jupyter notebook files (`.ipynb`), so GoNB can work on notebooks
+// outside of Jupyter -- e.g.: `gonb export` and `gonb run`. It also supports a "Go source" format
+// for notebooks, see ParseGoSource.
+//
+// Only the parts of the format used by GoNB are modeled, see
+// https://nbformat.readthedocs.io/en/latest/format_description.html for the full description.
+package ipynb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Notebook is the contents of a `.ipynb` file.
+type Notebook struct {
+	Cells         []*Cell        `json:"cells"`
+	Metadata      map[string]any `json:"metadata"`
+	NBFormat      int            `json:"nbformat"`
+	NBFormatMinor int            `json:"nbformat_minor"`
+}
+
+// Cell of a notebook.
+type Cell struct {
+	// Id of the cell, only present in newer versions (>= 4.5) of the format.
+	Id string `json:"id,omitempty"`
+
+	// CellType is one of "code", "markdown" or "raw".
+	CellType    string         `json:"cell_type"`
+	Metadata    map[string]any `json:"metadata"`
+	Source      Source         `json:"source"`
+	Attachments map[string]any `json:"attachments,omitempty"`
+
+	// ExecutionCount and Outputs are only used for "code" cells.
+	ExecutionCount *int              `json:"execution_count"`
+	Outputs        []json.RawMessage `json:"outputs"`
+}
+
+// MarshalJSON implements json.Marshaler: "execution_count" and "outputs" are required for "code" cells,
+// and not allowed for the other cells. Fields are written in alphabetical order, like Jupyter does.
+func (c *Cell) MarshalJSON() ([]byte, error) {
+	fields := map[string]any{
+		"cell_type": c.CellType,
+		"metadata":  c.Metadata,
+		"source":    c.Source,
+	}
+	if c.Metadata == nil {
+		fields["metadata"] = map[string]any{}
+	}
+	if c.Id != "" {
+		fields["id"] = c.Id
+	}
+	if c.Attachments != nil {
+		fields["attachments"] = c.Attachments
+	}
+	if c.CellType == "code" {
+		fields["execution_count"] = c.ExecutionCount
+		fields["outputs"] = c.Outputs
+		if c.Outputs == nil {
+			fields["outputs"] = []json.RawMessage{}
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// Output of a code cell. Only the fields used by the output type are set, see Output.Encode.
+type Output struct {
+	// OutputType is one of "stream", "display_data", "execute_result" or "error".
+	OutputType string `json:"output_type"`
+
+	// Name and Text are set for "stream" outputs. Name is either "stdout" or "stderr".
+	Name string `json:"name,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	// Data and Metadata are set for "display_data" and "execute_result" outputs.
+	Data     map[string]any `json:"data,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+
+	// ExecutionCount is set for "execute_result" outputs.
+	ExecutionCount int `json:"execution_count,omitempty"`
+
+	// EName, EValue and Traceback are set for "error" outputs.
+	EName     string   `json:"ename,omitempty"`
+	EValue    string   `json:"evalue,omitempty"`
+	Traceback []string `json:"traceback,omitempty"`
+}
+
+// Encode the output for Cell.Outputs, with the fields required by the notebook format for its type.
+func (o *Output) Encode() (json.RawMessage, error) {
+	fields := map[string]any{"output_type": o.OutputType}
+	switch o.OutputType {
+	case "stream":
+		fields["name"] = o.Name
+		fields["text"] = o.Text
+	case "error":
+		fields["ename"] = o.EName
+		fields["evalue"] = o.EValue
+		fields["traceback"] = o.Traceback
+		if o.Traceback == nil {
+			fields["traceback"] = []string{}
+		}
+	default:
+		fields["data"] = o.Data
+		fields["metadata"] = o.Metadata
+		if o.Metadata == nil {
+			fields["metadata"] = map[string]any{}
+		}
+		if o.OutputType == "execute_result" {
+			fields["execution_count"] = o.ExecutionCount
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode %q output", o.OutputType)
+	}
+	return data, nil
+}
+
+// Source of a cell: in the file it can be either one string or a list of strings (each with its
+// own "\n"), here it is always joined in one string.
+type Source string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Source) UnmarshalJSON(data []byte) error {
+	var parts []string
+	if err := json.Unmarshal(data, &parts); err == nil {
+		*s = Source(strings.Join(parts, ""))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return errors.Wrapf(err, "invalid cell source")
+	}
+	*s = Source(str)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler: it is written as a list of lines, like Jupyter does.
+func (s Source) MarshalJSON() ([]byte, error) {
+	parts := strings.SplitAfter(string(s), "\n")
+	if parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if parts == nil {
+		parts = []string{}
+	}
+	return json.Marshal(parts)
+}
+
+// New returns an empty notebook, using the GoNB kernel.
+func New() *Notebook {
+	return &Notebook{
+		Metadata: map[string]any{
+			"kernelspec": map[string]any{
+				"display_name": "Go (gonb)",
+				"language":     "go",
+				"name":         "gonb",
+			},
+			"language_info": map[string]any{"name": "go"},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 4,
+	}
+}
+
+// Read parses the notebook in filePath. Files with the `.go` extension are parsed in the Go source
+// format (see ParseGoSource).
+func Read(filePath string) (*Notebook, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read notebook %q", filePath)
+	}
+	if filepath.Ext(filePath) == GoSourceExt {
+		nb, err := ParseGoSource(string(contents))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to parse notebook %q", filePath)
+		}
+		return nb, nil
+	}
+	nb := &Notebook{}
+	if err = json.Unmarshal(contents, nb); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse notebook %q", filePath)
+	}
+	return nb, nil
+}
+
+// Write the notebook to filePath, indented like Jupyter does. Files with the `.go` extension are written
+// in the Go source format (see Notebook.GoSource), without the outputs.
+func (nb *Notebook) Write(filePath string) error {
+	var (
+		contents []byte
+		err      error
+	)
+	if filepath.Ext(filePath) == GoSourceExt {
+		var src string
+		src, err = nb.GoSource()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to encode notebook")
+		}
+		contents = []byte(src)
+	} else {
+		contents, err = json.MarshalIndent(nb, "", " ")
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode notebook")
+		}
+		contents = append(contents, '\n')
+	}
+	if err = os.WriteFile(filePath, contents, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write notebook %q", filePath)
+	}
+	return nil
+}
+
+// CodeCells returns the cells of type "code", in order.
+func (nb *Notebook) CodeCells() []*Cell {
+	var cells []*Cell
+	for _, cell := range nb.Cells {
+		if cell.CellType == "code" {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// Lines returns the source of the cell split in lines.
+func (c *Cell) Lines() []string {
+	return strings.Split(string(c.Source), "\n")
+}
+
+// GonbMetadataKey is the key of the notebook metadata object where GoNB records its own information.
+const GonbMetadataKey = "gonb"
+
+// GonbMetadata returns the value recorded by GoNB under key in the notebook metadata, or nil if not set.
+func (nb *Notebook) GonbMetadata(key string) any {
+	gonbMetadata, _ := nb.Metadata[GonbMetadataKey].(map[string]any)
+	return gonbMetadata[key]
+}
+
+// OutputsGonbMetadata returns the value recorded by GoNB under key in the metadata of the outputs of the code
+// cells -- the last one found, in the order of the notebook -- or nil if there is none.
+//
+// Jupyter doesn't allow the kernel to change the notebook metadata, so the interactive kernel records information
+// in the metadata of the outputs of its special commands instead.
+func (nb *Notebook) OutputsGonbMetadata(key string) any {
+	var value any
+	for _, cell := range nb.CodeCells() {
+		for _, rawOutput := range cell.Outputs {
+			var output struct {
+				Metadata map[string]any `json:"metadata"`
+			}
+			if json.Unmarshal(rawOutput, &output) != nil {
+				continue
+			}
+			gonbMetadata, _ := output.Metadata[GonbMetadataKey].(map[string]any)
+			if outputValue, found := gonbMetadata[key]; found && outputValue != nil {
+				value = outputValue
+			}
+		}
+	}
+	return value
+}
+
+// SetGonbMetadata records value under key in the GoNB object of the notebook metadata.
+// If value is nil, the key is removed instead, and the GoNB object as well, if it becomes empty.
+func (nb *Notebook) SetGonbMetadata(key string, value any) {
+	gonbMetadata, _ := nb.Metadata[GonbMetadataKey].(map[string]any)
+	if value == nil {
+		delete(gonbMetadata, key)
+		if len(gonbMetadata) == 0 {
+			delete(nb.Metadata, GonbMetadataKey)
+		}
+		return
+	}
+	if gonbMetadata == nil {
+		gonbMetadata = make(map[string]any)
+		if nb.Metadata == nil {
+			nb.Metadata = make(map[string]any)
+		}
+		nb.Metadata[GonbMetadataKey] = gonbMetadata
+	}
+	gonbMetadata[key] = value
+}
@@ -0,0 +1,48 @@
+package ipynb
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWrite(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "test.ipynb")
+	require.NoError(t, os.WriteFile(filePath, []byte(`{
+ "cells": [
+  {"cell_type": "markdown", "id": "m1", "metadata": {}, "source": "# Title\nText"},
+  {"cell_type": "code", "id": "c1", "metadata": {}, "source": ["%%\n", "fmt.Println(1)"],
+   "execution_count": 3, "outputs": [{"name": "stdout", "output_type": "stream", "text": "1\n"}]}
+ ],
+ "metadata": {"kernelspec": {"name": "gonb"}},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}`), 0644))
+	nb, err := Read(filePath)
+	require.NoError(t, err)
+	require.Len(t, nb.Cells, 2)
+	require.Len(t, nb.CodeCells(), 1)
+	assert.Equal(t, []string{"%%", "fmt.Println(1)"}, nb.CodeCells()[0].Lines())
+
+	nb.Cells[1].ExecutionCount = nil
+	nb.Cells[1].Outputs = nil
+	require.NoError(t, nb.Write(filePath))
+	contents, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	var cells struct {
+		Cells []map[string]any `json:"cells"`
+	}
+	require.NoError(t, json.Unmarshal(contents, &cells))
+	assert.Equal(t, map[string]any{
+		"cell_type": "markdown", "id": "m1", "metadata": map[string]any{},
+		"source": []any{"# Title\n", "Text"},
+	}, cells.Cells[0])
+	assert.Equal(t, map[string]any{
+		"cell_type": "code", "id": "c1", "metadata": map[string]any{},
+		"source": []any{"%%\n", "fmt.Println(1)"}, "execution_count": nil, "outputs": []any{},
+	}, cells.Cells[1])
+}
@@ -9,15 +9,14 @@
 package jpyexec
 
 import (
+	"fmt"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"github.com/pkg/errors"
 	"io"
 	"k8s.io/klog/v2"
-	"os"
 	osexec "os/exec"
 	"sync"
-	"syscall"
 	"time"
 )
 
@@ -34,6 +33,7 @@ type Executor struct {
 	commsHandler               CommsHandler
 	stdoutWriter, stderrWriter io.Writer
 	stdinContent               []byte
+	stdinReader                io.Reader
 	millisecondsToInput        int
 	inputPassword              bool
 
@@ -62,6 +62,10 @@ type Executor struct {
 	// Notice the contents are written raw, without the mime-type.
 	captureDisplayDataOutput io.Writer
 
+	// limits to the resources of the program, and the state of their enforcement.
+	limits      Limits
+	limitsState *limitsState
+
 	isDone   bool
 	doneChan chan struct{}
 	muDone   sync.Mutex
@@ -162,6 +166,15 @@ func (exec *Executor) WithStaticInput(stdinContent []byte) *Executor {
 	return exec
 }
 
+// WithInputReader configures the executor to continuously copy the contents of stdinReader to the
+// program's stdin, until stdinReader returns io.EOF (or another error), at which point stdin is closed.
+//
+// This conflicts with [Executor.WithInputs], [Executor.WithPassword] and [Executor.WithStaticInput].
+func (exec *Executor) WithInputReader(stdinReader io.Reader) *Executor {
+	exec.stdinReader = stdinReader
+	return exec
+}
+
 // CaptureDisplayDataOutput configures the Executor to capture the output of the program
 // and send it as protocol.DisplayMessage messages, in the named pipe.
 //
@@ -213,6 +226,7 @@ func (exec *Executor) Exec() error {
 	if exec.stderrWriter == nil {
 		exec.stderrWriter = kernel.NewJupyterStreamWriter(exec.Msg, kernel.StreamStderr)
 	}
+	exec.newLimitsState()
 	var streamersWG sync.WaitGroup
 	streamersWG.Add(2)
 	go func() {
@@ -246,33 +260,25 @@ func (exec *Executor) Exec() error {
 	}
 
 	// Start command.
+	exec.prepareLimits()
 	if err := cmd.Start(); err != nil {
+		exec.limitsState.release()
 		klog.Warningf("Failed to start command %q", exec.command)
 		return errors.WithMessagef(err, "failed to start to execute command %q", exec.command)
 	}
 
+	exec.startLimits()
+
 	var interruptId kernel.SubscriptionId
 	interruptId = exec.Msg.Kernel().SubscribeInterrupt(func(id kernel.SubscriptionId) {
-		// Sent interrupt to process.
-		err := cmd.Process.Signal(os.Interrupt)
 		exec.Msg.Kernel().UnsubscribeInterrupt(interruptId)
-		if err != nil {
-			klog.Errorf("failed to interrupt process %s (%v): %+v", cmd, cmd.Process, err)
-		}
-		select {
-		case <-exec.doneChan:
-			// Normal stop, nothing to do.
-		case <-time.After(WaitToKill):
-			// If process hasn't yet died, kill it.
-			err = cmd.Process.Signal(syscall.SIGKILL)
-			if err != nil {
-				klog.Errorf("failed to kill process %s (%v): %+v", cmd, cmd.Process, err)
-			}
-		}
+		exec.interruptAndKill()
 	})
 
 	if exec.stdinContent != nil {
 		exec.handleStaticInput()
+	} else if exec.stdinReader != nil {
+		exec.handleInputReader()
 	}
 
 	// Wait for output pipes to finish.
@@ -284,6 +290,10 @@ func (exec *Executor) Exec() error {
 		}
 		_ = kernel.PublishWriteStream(exec.Msg, kernel.StreamStderr, errMsg)
 	}
+	if report := exec.limitsReport(); report != "" {
+		_ = kernel.PublishWriteStream(exec.Msg, kernel.StreamStderr,
+			fmt.Sprintf("Execution stopped: %s (limits: %s)\n", report, exec.limits))
+	}
 
 	// Unsubscribe from interruption messages.
 	exec.Msg.Kernel().UnsubscribeInterrupt(interruptId)
@@ -374,3 +384,15 @@ func (exec *Executor) handleStaticInput() {
 
 	}()
 }
+
+func (exec *Executor) handleInputReader() {
+	go func() {
+		_, err := io.Copy(exec.cmdStdin, exec.stdinReader)
+		if err != nil {
+			// Expected if the program exits before stdinReader is closed.
+			klog.V(1).Infof("stopped copying input to stdin of %q %v: %v", exec.command, exec.args, err)
+		}
+		// stdin may already be closed, if the program exited.
+		_ = exec.cmdStdin.Close()
+	}()
+}
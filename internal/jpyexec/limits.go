@@ -0,0 +1,255 @@
+package jpyexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Limits to the resources used by an executed program. Zero values mean no limit.
+//
+// The timeout is enforced by interrupting the program (and killing it if it doesn't stop), like a
+// Jupyter interrupt. Memory and CPU limits are enforced by the operating system, where available:
+// see prepareResourceLimits.
+type Limits struct {
+	// Timeout is the maximum wall time the program is allowed to run.
+	Timeout time.Duration
+
+	// Memory is the maximum memory, in bytes, the program is allowed to use.
+	Memory uint64
+
+	// CPU is the maximum CPU time the program is allowed to use.
+	CPU time.Duration
+}
+
+// IsZero returns whether no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// String returns a description of the limits, in the format accepted by `%limits`.
+func (l Limits) String() string {
+	if l.IsZero() {
+		return "no limits"
+	}
+	var parts []string
+	if l.Timeout > 0 {
+		parts = append(parts, fmt.Sprintf("timeout=%s", l.Timeout))
+	}
+	if l.Memory > 0 {
+		parts = append(parts, fmt.Sprintf("mem=%s", FormatMemorySize(l.Memory)))
+	}
+	if l.CPU > 0 {
+		parts = append(parts, fmt.Sprintf("cpu=%s", l.CPU))
+	}
+	return strings.Join(parts, " ")
+}
+
+// memoryUnits used by ParseMemorySize and FormatMemorySize, in decreasing order.
+var memoryUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseMemorySize parses sizes like "2G", "512M", "1.5GB", "100k" or "4096" (bytes).
+// Units are powers of 1024.
+func ParseMemorySize(value string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	multiplier := uint64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) {
+		return 0, errors.Errorf("invalid memory size %q, use something like \"2G\" or \"512M\"", value)
+	}
+	return uint64(number * float64(multiplier)), nil
+}
+
+// FormatMemorySize formats size in bytes using the largest unit that represents it exactly, if any.
+func FormatMemorySize(size uint64) string {
+	for _, unit := range memoryUnits {
+		if size >= unit.size && size%unit.size == 0 {
+			return fmt.Sprintf("%d%s", size/unit.size, unit.suffix)
+		}
+	}
+	return strconv.FormatUint(size, 10)
+}
+
+// WithLimits configures the resources limits of the executed program.
+func (exec *Executor) WithLimits(limits Limits) *Executor {
+	exec.limits = limits
+	return exec
+}
+
+// Stop interrupts the program, and kills it if it doesn't finish within WaitToKill, as is done when the
+// timeout is exceeded. It is used to stop long-running programs that don't use WithLimits.
+// It must be called after the program started, and it blocks until it finishes or is killed.
+func (exec *Executor) Stop() {
+	select {
+	case <-exec.doneChan:
+		return
+	default:
+	}
+	exec.interruptAndKill()
+}
+
+// interruptAndKill sends an interrupt to the program, and if it doesn't finish within WaitToKill,
+// it kills it.
+//
+// It blocks until the program finishes or is killed.
+func (exec *Executor) interruptAndKill() {
+	cmd := exec.cmd
+	err := cmd.Process.Signal(os.Interrupt)
+	if err != nil {
+		klog.Errorf("failed to interrupt process %s (%v): %+v", cmd, cmd.Process, err)
+	}
+	select {
+	case <-exec.doneChan:
+		// Normal stop, nothing to do.
+	case <-time.After(WaitToKill):
+		// If process hasn't yet died, kill it.
+		err = cmd.Process.Signal(syscall.SIGKILL)
+		if err != nil {
+			klog.Errorf("failed to kill process %s (%v): %+v", cmd, cmd.Process, err)
+		}
+	}
+}
+
+// limitsState keeps track of the enforcement of the limits during one execution.
+type limitsState struct {
+	mu sync.Mutex
+
+	// exceeded describes the limit that stopped the program, if it was detected by the executor itself.
+	exceeded string
+
+	// cleanup releases OS resources (e.g.: cgroup) used to enforce the limits, see release.
+	cleanup func()
+
+	// memoryExceeded checks with the OS whether the memory limit was hit, if supported.
+	memoryExceeded func() bool
+
+	// outOfMemory is set if the program reported it ran out of memory.
+	outOfMemory bool
+}
+
+// setExceeded records the limit that stopped the program.
+func (l *limitsState) setExceeded(description string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.exceeded == "" {
+		l.exceeded = description
+	}
+}
+
+// release the OS resources used to enforce the limits, if any. It can be called more than once.
+func (l *limitsState) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cleanup != nil {
+		l.cleanup()
+		l.cleanup = nil
+	}
+}
+
+// newLimitsState creates the limitsState for the execution, and wraps the stderr writer to detect
+// when the program runs out of memory. It must be called before the program starts.
+func (exec *Executor) newLimitsState() {
+	exec.limitsState = &limitsState{}
+	if exec.limits.Memory > 0 {
+		exec.stderrWriter = &oomWatcher{w: exec.stderrWriter, state: exec.limitsState}
+	}
+}
+
+// prepareLimits configures the command with the memory and CPU limits, so they are enforced by the OS
+// from the start of the program. It must be called before the program starts.
+func (exec *Executor) prepareLimits() {
+	if exec.limits.Memory == 0 && exec.limits.CPU == 0 {
+		return
+	}
+	if err := exec.prepareResourceLimits(); err != nil {
+		klog.Warningf("Failed to apply resource limits (%s) to %q: %+v", exec.limits, exec.command, err)
+		_ = kernel.PublishWriteStream(exec.Msg, kernel.StreamStderr,
+			fmt.Sprintf("Warning: failed to apply resource limits (%s): %v\n", exec.limits, err))
+	}
+}
+
+// startLimits starts enforcing the timeout on the started program.
+func (exec *Executor) startLimits() {
+	if exec.limits.Timeout > 0 {
+		timeout := exec.limits.Timeout
+		go func() {
+			select {
+			case <-exec.doneChan:
+				return
+			case <-time.After(timeout):
+			}
+			exec.limitsState.setExceeded(fmt.Sprintf("timeout of %s exceeded", timeout))
+			exec.interruptAndKill()
+		}()
+	}
+}
+
+// limitsReport returns the description of the limit that stopped the program, or "" if no limit was reached.
+// It should be called after the program finished.
+func (exec *Executor) limitsReport() string {
+	l := exec.limitsState
+	if l == nil {
+		return ""
+	}
+	defer l.release()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.exceeded != "" {
+		return l.exceeded
+	}
+	if exec.limits.Memory > 0 && (l.outOfMemory || (l.memoryExceeded != nil && l.memoryExceeded())) {
+		return fmt.Sprintf("memory limit of %s exceeded", FormatMemorySize(exec.limits.Memory))
+	}
+	if state := exec.cmd.ProcessState; exec.limits.CPU > 0 && state != nil && !state.Success() {
+		if cpuTime := state.UserTime() + state.SystemTime(); cpuTime >= exec.limits.CPU-time.Second/10 {
+			return fmt.Sprintf("CPU time limit of %s exceeded", exec.limits.CPU)
+		}
+	}
+	return ""
+}
+
+// outOfMemoryMarker is printed by the Go runtime when an allocation fails.
+var outOfMemoryMarker = []byte("runtime: out of memory")
+
+// oomWatcher wraps the stderr of the program, to detect whether it ran out of memory.
+type oomWatcher struct {
+	w     io.Writer
+	state *limitsState
+}
+
+// Write implements io.Writer.
+func (o *oomWatcher) Write(p []byte) (int, error) {
+	if bytes.Contains(p, outOfMemoryMarker) {
+		o.state.mu.Lock()
+		o.state.outOfMemory = true
+		o.state.mu.Unlock()
+	}
+	return o.w.Write(p)
+}
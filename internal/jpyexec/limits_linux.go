@@ -0,0 +1,175 @@
+//go:build linux
+
+package jpyexec
+
+import (
+	"fmt"
+	"math"
+	"os"
+	osexec "os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// cgroupRoot is where the cgroups v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupCounter is used to create unique cgroup names.
+var cgroupCounter atomic.Int64
+
+// shellPath is used to apply the resource limits (with `ulimit`) before executing the program.
+const shellPath = "/bin/sh"
+
+// prepareResourceLimits configures the command, before it is started, so the program runs from its start
+// with the memory and CPU limits.
+//
+// The memory limit uses a cgroup (v2) if GoNB's cgroup delegates the "memory" controller to its children
+// (e.g.: when running under a systemd user service with delegation), which accounts for the memory actually used:
+// the program is started directly in the cgroup (see syscall.SysProcAttr.UseCgroupFD, it requires Linux >= 5.7).
+// Otherwise, it falls back to limiting the program's address space (RLIMIT_AS).
+// The CPU limit uses RLIMIT_CPU.
+//
+// The resource limits (RLIMIT_*) are set with `ulimit` by a shell that then executes the program (see wrapWithRlimits).
+func (exec *Executor) prepareResourceLimits() error {
+	var cpuSeconds, memoryKB uint64
+	if exec.limits.CPU > 0 {
+		cpuSeconds = uint64(max(math.Ceil(exec.limits.CPU.Seconds()), 1))
+	}
+	if exec.limits.Memory > 0 {
+		err := exec.startInMemoryCgroup()
+		if err != nil {
+			klog.V(1).Infof("Using RLIMIT_AS to limit memory, cgroup not available: %v", err)
+			memoryKB = max(exec.limits.Memory/1024, 1)
+		}
+	}
+	return wrapWithRlimits(exec.cmd, cpuSeconds, memoryKB)
+}
+
+// wrapWithRlimits changes cmd to be executed by a shell that first sets the CPU time (RLIMIT_CPU) and address
+// space (RLIMIT_AS) limits. Zero values mean no limit, and if there are no limits cmd is not changed.
+//
+// For the CPU limit, the soft limit sends SIGXCPU, and the hard limit (one second later) SIGKILL.
+// Limits are capped to the current hard limits, which can't be raised.
+func wrapWithRlimits(cmd *osexec.Cmd, cpuSeconds, memoryKB uint64) error {
+	if cmd.Err != nil {
+		return nil // Command not found: it will fail to start anyway.
+	}
+	var ulimits []string
+	if cpuSeconds > 0 {
+		var current unix.Rlimit
+		if err := unix.Getrlimit(unix.RLIMIT_CPU, &current); err != nil {
+			return errors.Wrapf(err, "failed to get current CPU limit")
+		}
+		hard := min(cpuSeconds+1, current.Max)
+		// The soft limit is set first, since the hard limit can't be lower than it.
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -S -t %d", min(cpuSeconds, hard)), fmt.Sprintf("ulimit -H -t %d", hard))
+	}
+	if memoryKB > 0 {
+		var current unix.Rlimit
+		if err := unix.Getrlimit(unix.RLIMIT_AS, &current); err != nil {
+			return errors.Wrapf(err, "failed to get current memory limit")
+		}
+		if current.Max != unix.RLIM_INFINITY {
+			memoryKB = min(memoryKB, current.Max/1024)
+		}
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", memoryKB))
+	}
+	if len(ulimits) == 0 {
+		return nil
+	}
+	script := strings.Join(ulimits, " && ") + ` && exec "$0" "$@"`
+	cmd.Args = append([]string{shellPath, "-c", script, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = shellPath
+	return nil
+}
+
+// startInMemoryCgroup creates a cgroup with the memory limit, under the cgroup of the GoNB process, and
+// configures the command to be started in it.
+//
+// It returns an error if cgroups v2 with the "memory" controller delegated is not available.
+func (exec *Executor) startInMemoryCgroup() error {
+	parent, err := selfCgroupPath()
+	if err != nil {
+		return err
+	}
+	controllers, err := os.ReadFile(path.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return errors.Wrapf(err, "cgroup v2 not available")
+	}
+	if !strings.Contains(" "+strings.TrimSpace(string(controllers))+" ", " memory ") {
+		return errors.Errorf("cgroup %q doesn't delegate the \"memory\" controller", parent)
+	}
+	cgroupPath := path.Join(parent, fmt.Sprintf("gonb_%d_%d", os.Getpid(), cgroupCounter.Add(1)))
+	if err = os.Mkdir(cgroupPath, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create cgroup")
+	}
+	removeCgroup := func() {
+		if err := os.Remove(cgroupPath); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("Failed to remove cgroup %q: %+v", cgroupPath, err)
+		}
+	}
+	for _, setting := range []struct{ file, value string }{
+		{"memory.max", strconv.FormatUint(exec.limits.Memory, 10)},
+		{"memory.swap.max", "0"},
+	} {
+		err = os.WriteFile(path.Join(cgroupPath, setting.file), []byte(setting.value), 0644)
+		if err != nil && !(setting.file == "memory.swap.max" && os.IsNotExist(err)) {
+			removeCgroup()
+			return errors.Wrapf(err, "failed to configure cgroup %q", cgroupPath)
+		}
+	}
+	cgroupDir, err := os.Open(cgroupPath)
+	if err != nil {
+		removeCgroup()
+		return errors.Wrapf(err, "failed to open cgroup %q", cgroupPath)
+	}
+	if exec.cmd.SysProcAttr == nil {
+		exec.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	exec.cmd.SysProcAttr.UseCgroupFD = true
+	exec.cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	exec.limitsState.cleanup = func() {
+		_ = cgroupDir.Close()
+		removeCgroup()
+	}
+	exec.limitsState.memoryExceeded = func() bool {
+		return cgroupOOMKills(cgroupPath) > 0
+	}
+	return nil
+}
+
+// selfCgroupPath returns the path to the cgroup (v2) of the current process.
+func selfCgroupPath() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read cgroup of current process")
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if relPath, found := strings.CutPrefix(line, "0::"); found {
+			return path.Join(cgroupRoot, relPath), nil
+		}
+	}
+	return "", errors.New("cgroup v2 not in use")
+}
+
+// cgroupOOMKills returns the number of processes killed in the cgroup for reaching the memory limit.
+func cgroupOOMKills(cgroupPath string) int {
+	data, err := os.ReadFile(path.Join(cgroupPath, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, "oom_kill "); found {
+			count, _ := strconv.Atoi(strings.TrimSpace(value))
+			return count
+		}
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package jpyexec
+
+import (
+	osexec "os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapWithRlimits(t *testing.T) {
+	// The limits are already set when the program starts.
+	cmd := osexec.Command("sh", "-c", "ulimit -S -t; ulimit -H -t; ulimit -v; echo $0 $1", "arg0", "arg1")
+	require.NoError(t, wrapWithRlimits(cmd, 5, 1<<20))
+	assert.Equal(t, shellPath, cmd.Path)
+	output, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, "5\n6\n1048576\narg0 arg1\n", string(output))
+
+	// No limits: the command is not changed.
+	cmd = osexec.Command("sh", "-c", "true")
+	require.NoError(t, wrapWithRlimits(cmd, 0, 0))
+	assert.Equal(t, []string{"sh", "-c", "true"}, cmd.Args)
+}
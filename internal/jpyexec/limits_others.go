@@ -0,0 +1,10 @@
+//go:build !linux
+
+package jpyexec
+
+import "github.com/pkg/errors"
+
+// prepareResourceLimits is only supported in Linux: only the timeout is enforced in other platforms.
+func (exec *Executor) prepareResourceLimits() error {
+	return errors.New("memory and CPU limits are only supported in Linux")
+}
@@ -0,0 +1,36 @@
+package jpyexec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySize(t *testing.T) {
+	for value, want := range map[string]uint64{
+		"4096":   4096,
+		"2G":     2 << 30,
+		"512m":   512 << 20,
+		"1.5GB":  3 << 29,
+		"100KiB": 100 << 10,
+	} {
+		got, err := ParseMemorySize(value)
+		require.NoErrorf(t, err, "ParseMemorySize(%q)", value)
+		assert.Equalf(t, want, got, "ParseMemorySize(%q)", value)
+	}
+	for _, value := range []string{"", "G", "-1M", "2X"} {
+		_, err := ParseMemorySize(value)
+		assert.Errorf(t, err, "ParseMemorySize(%q) should fail", value)
+	}
+	assert.Equal(t, "2G", FormatMemorySize(2<<30))
+	assert.Equal(t, "1536M", FormatMemorySize(3<<29))
+	assert.Equal(t, "1000", FormatMemorySize(1000))
+}
+
+func TestLimitsString(t *testing.T) {
+	assert.Equal(t, "no limits", Limits{}.String())
+	assert.Equal(t, "timeout=1m0s mem=2G cpu=30s",
+		Limits{Timeout: time.Minute, Memory: 2 << 30, CPU: 30 * time.Second}.String())
+}
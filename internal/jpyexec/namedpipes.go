@@ -301,7 +301,9 @@ func (exec *Executor) dispatchDisplayData(data *protocol.DisplayData) {
 		msgData.Metadata[key] = content
 	}
 	var err error
-	if data.DisplayID != "" {
+	if data.ExecuteResult {
+		err = kernel.PublishExecuteResult(exec.Msg, msgData)
+	} else if data.DisplayID != "" {
 		msgData.Transient["display_id"] = data.DisplayID
 		err = kernel.PublishUpdateDisplayData(exec.Msg, msgData)
 	} else {
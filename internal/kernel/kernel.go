@@ -149,6 +149,10 @@ func (k *Kernel) Stop() {
 	klog.V(1).Infof("Kernel.Stop()")
 	k.Interrupted.Store(true) // Also mark as interrupted.
 	close(k.stop)
+	if k.sockets == nil {
+		// Headless kernel, see NewHeadless.
+		return
+	}
 	err := k.sockets.ShellSocket.Socket.Close()
 	if err != nil {
 		klog.Errorf("Failed to close Shell socket: %v", err)
@@ -357,12 +361,29 @@ func New(connectionFile string) (*Kernel, error) {
 	}
 
 	k.pollHeartbeat()
-	k.pollCommonSocket(k.shell, k.sockets.ShellSocket.Socket, "shell")
-	k.pollCommonSocket(k.stdin, k.sockets.StdinSocket.Socket, "stdin")
-	k.pollCommonSocket(k.control, k.sockets.ControlSocket.Socket, "control")
+	k.pollCommonSocket(k.shell, &k.sockets.ShellSocket, "shell")
+	k.pollCommonSocket(k.stdin, &k.sockets.StdinSocket, "stdin")
+	k.pollCommonSocket(k.control, &k.sockets.ControlSocket, "control")
 	return k, nil
 }
 
+// NewHeadless creates a Kernel that is not connected to Jupyter: it is used to execute notebooks
+// from the command line (see `gonb run`), with messages that implement Message without the
+// wire protocol.
+//
+// It has no incoming messages, and it can be stopped with Kernel.Stop.
+func NewHeadless() *Kernel {
+	return &Kernel{
+		stop:    make(chan struct{}),
+		shell:   make(chan Message, 1),
+		stdin:   make(chan Message, 1),
+		control: make(chan Message, 1),
+
+		interruptSubscriptions: list.New(),
+		KnownBlockIds:          make(common.Set[string]),
+	}
+}
+
 // pollCommonSocket polls for messages from a socket, parses them, and sends them to msgChan.
 //
 // This function runs the loop of receiving messages, parsing and verifying from the wire
@@ -372,7 +393,7 @@ func New(connectionFile string) (*Kernel, error) {
 //
 // It runs on a separate Go routine, and uses `k.pollingWait` to account for it (it adds 1
 // at the start, and calls `.Done()` when finished.
-func (k *Kernel) pollCommonSocket(msgChan chan Message, sck zmq4.Socket, socketName string) {
+func (k *Kernel) pollCommonSocket(msgChan chan Message, sck *SyncSocket, socketName string) {
 	k.pollingWait.Add(1)
 	go func() {
 		klog.V(1).Infof("Polling of %q socket started.", socketName)
@@ -382,12 +403,13 @@ func (k *Kernel) pollCommonSocket(msgChan chan Message, sck zmq4.Socket, socketN
 			close(msgChan)
 		}()
 		for {
-			zmqMsg, err := sck.Recv()
+			zmqMsg, err := sck.Socket.Recv()
 			var msg Message
 			if err != nil {
 				msg = &MessageImpl{kernel: k, err: err}
 			} else {
 				msg = k.FromWireMsg(zmqMsg)
+				msg.(*MessageImpl).replySocket = sck
 			}
 			select {
 			case msgChan <- msg:
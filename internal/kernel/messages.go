@@ -53,6 +53,9 @@ type KernelInfo struct {
 	Banner                string             `json:"banner"`
 	HelpLinks             []HelpLink         `json:"help_links"`
 	Status                string             `json:"status"`
+
+	// Debugger indicates the kernel supports the debug protocol (`debug_request` messages).
+	Debugger bool `json:"debugger"`
 }
 
 // KernelLanguageInfo holds information about the language that this kernel executes code in.
@@ -191,8 +194,11 @@ type Message interface {
 	DeliverInput() error
 
 	// Reply creates a new ComposedMsg and sends it back to the return identities over the
-	// Shell channel.
+	// Shell channel, or the Control channel if the message was received from it.
 	Reply(msgType string, content interface{}) error
+
+	// ReplyWithMetadata is like Reply, but it also sets the metadata of the reply message.
+	ReplyWithMetadata(msgType string, content interface{}, metadata map[string]any) error
 }
 
 // MessageImpl represents a received message or an Error, with its return identities, and
@@ -202,6 +208,10 @@ type MessageImpl struct {
 	Composed   ComposedMsg
 	Identities [][]byte
 	kernel     *Kernel
+
+	// replySocket is the socket where the message was received, and where replies are sent.
+	// If nil, replies are sent to the shell socket.
+	replySocket *SyncSocket
 }
 
 // Error returns the error receiving the message, or nil if no error.
@@ -330,16 +340,26 @@ func (m *MessageImpl) DeliverInput() error {
 }
 
 // Reply creates a new ComposedMsg and sends it back to the return identities over the
-// Shell channel.
+// Shell channel, or the Control channel if the message was received from it.
 func (m *MessageImpl) Reply(msgType string, content interface{}) error {
+	return m.ReplyWithMetadata(msgType, content, nil)
+}
+
+// ReplyWithMetadata is like Reply, but it also sets the metadata of the reply message.
+func (m *MessageImpl) ReplyWithMetadata(msgType string, content interface{}, metadata map[string]any) error {
 	msg, err := NewComposed(msgType, m.Composed)
 	if err != nil {
 		return err
 	}
 
+	msg.Metadata = metadata
 	msg.Content = content
+	socket := m.replySocket
+	if socket == nil {
+		socket = &m.kernel.sockets.ShellSocket
+	}
 	klog.V(1).Infof("[Shell] Reply message %q, parent msg_id=%q", msgType, msg.ParentHeader.MsgID)
-	return m.kernel.sockets.ShellSocket.RunLocked(func(shell zmq4.Socket) error {
+	return socket.RunLocked(func(shell zmq4.Socket) error {
 		return m.sendMessage(shell, msg)
 	})
 }
@@ -557,7 +577,8 @@ func PublishKernelStatus(msg Message, status string) error {
 }
 
 // SendKernelInfo sends a kernel_info_reply message.
-func SendKernelInfo(msg Message, version string) error {
+// If debugger is true, it announces support for the debug protocol.
+func SendKernelInfo(msg Message, version string, debugger bool) error {
 	return msg.Reply("kernel_info_reply",
 		KernelInfo{
 			ProtocolVersion:       ProtocolVersion,
@@ -574,7 +595,8 @@ func SendKernelInfo(msg Message, version string) error {
 				{Text: "Go", URL: "https://golang.org/"},
 				{Text: "gonb", URL: "https://github.com/janpfeifer/gonb"},
 			},
-			Status: "ok",
+			Status:   "ok",
+			Debugger: debugger,
 		},
 	)
 }
@@ -0,0 +1,238 @@
+package nbrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// message implements kernel.Message for the execution of one cell, without Jupyter: the messages
+// published are converted to the outputs of the cell, in the format of the notebook file.
+type message struct {
+	kernel   *kernel.Kernel
+	composed kernel.ComposedMsg
+
+	// stdout and stderr, if not nil, get a copy of the streams and errors published.
+	stdout, stderr io.Writer
+
+	mu           sync.Mutex
+	outputs      []map[string]any
+	displayIds   map[string]int // display_id -> index in outputs.
+	pendingClear bool           // Set by a "clear_output" with "wait", cleared by the next output.
+	reply        map[string]any
+
+	// replyMetadata is the metadata of the reply, e.g. with the diagnostics of a failed execution.
+	replyMetadata map[string]any
+}
+
+var _ kernel.Message = (*message)(nil)
+
+// newExecuteMessage returns a message with an "execute_request" for the given code.
+func newExecuteMessage(k *kernel.Kernel, code string, stdout, stderr io.Writer) *message {
+	m := &message{
+		kernel:     k,
+		stdout:     stdout,
+		stderr:     stderr,
+		displayIds: make(map[string]int),
+	}
+	header := &m.composed.Header
+	header.MsgType = "execute_request"
+	header.Session = "gonb-run"
+	header.Username = "gonb"
+	header.ProtocolVersion = kernel.ProtocolVersion
+	header.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if u, err := uuid.NewV4(); err == nil {
+		header.MsgID = u.String()
+	}
+	m.composed.Content = map[string]any{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]any{},
+		"allow_stdin":      false,
+		"stop_on_error":    true,
+	}
+	return m
+}
+
+// Error implements kernel.Message.
+func (m *message) Error() error { return nil }
+
+// Ok implements kernel.Message.
+func (m *message) Ok() bool { return true }
+
+// ComposedMsg implements kernel.Message.
+func (m *message) ComposedMsg() kernel.ComposedMsg { return m.composed }
+
+// Kernel implements kernel.Message.
+func (m *message) Kernel() *kernel.Kernel { return m.kernel }
+
+// Publish implements kernel.Message: outputs are recorded, everything else is ignored.
+func (m *message) Publish(msgType string, content interface{}) error {
+	switch msgType {
+	case "stream", "display_data", "update_display_data", "execute_result", "error", "clear_output":
+	default:
+		klog.V(2).Infof("nbrun: ignoring published %q message", msgType)
+		return nil
+	}
+	output, err := toMap(content)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to encode %q output", msgType)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msgType == "clear_output" {
+		if wait, _ := output["wait"].(bool); wait {
+			m.pendingClear = true
+		} else {
+			m.clearLocked()
+		}
+		return nil
+	}
+	if m.pendingClear {
+		m.clearLocked()
+	}
+	m.echoLocked(msgType, output)
+
+	// Transient information (e.g.: "display_id") is not saved in the notebook.
+	var displayId string
+	if transient, ok := output["transient"].(map[string]any); ok {
+		displayId, _ = transient["display_id"].(string)
+	}
+	delete(output, "transient")
+	switch msgType {
+	case "stream":
+		if len(m.outputs) > 0 {
+			last := m.outputs[len(m.outputs)-1]
+			if last["output_type"] == "stream" && last["name"] == output["name"] {
+				last["text"] = fmt.Sprint(last["text"]) + fmt.Sprint(output["text"])
+				return nil
+			}
+		}
+	case "update_display_data":
+		if idx, found := m.displayIds[displayId]; found {
+			m.outputs[idx]["data"] = output["data"]
+			m.outputs[idx]["metadata"] = output["metadata"]
+		}
+		return nil
+	}
+	output["output_type"] = msgType
+	if displayId != "" {
+		m.displayIds[displayId] = len(m.outputs)
+	}
+	m.outputs = append(m.outputs, output)
+	return nil
+}
+
+// clearLocked removes the outputs so far. It must be called with m.mu locked.
+func (m *message) clearLocked() {
+	m.outputs = nil
+	m.displayIds = make(map[string]int)
+	m.pendingClear = false
+}
+
+// echoLocked writes the streams and errors to the stdout/stderr configured, if any.
+func (m *message) echoLocked(msgType string, output map[string]any) {
+	var (
+		w    io.Writer
+		text string
+	)
+	switch msgType {
+	case "stream":
+		w = m.stdout
+		if output["name"] == kernel.StreamStderr {
+			w = m.stderr
+		}
+		text = fmt.Sprint(output["text"])
+	case "error":
+		w = m.stderr
+		if traceback, ok := output["traceback"].([]any); ok && len(traceback) > 0 {
+			lines := make([]string, 0, len(traceback))
+			for _, line := range traceback {
+				lines = append(lines, fmt.Sprint(line))
+			}
+			text = strings.Join(lines, "\n") + "\n"
+		} else {
+			text = fmt.Sprintf("%v: %v\n", output["ename"], output["evalue"])
+		}
+	}
+	if w != nil && text != "" {
+		_, _ = io.WriteString(w, text)
+	}
+}
+
+// PromptInput implements kernel.Message: there is no front-end to provide input.
+func (m *message) PromptInput(_ string, _ bool, _ kernel.OnInputFn) error {
+	return errors.New("input is not available when running notebooks with `gonb run`")
+}
+
+// CancelInput implements kernel.Message.
+func (m *message) CancelInput() error { return nil }
+
+// DeliverInput implements kernel.Message.
+func (m *message) DeliverInput() error { return nil }
+
+// Reply implements kernel.Message: it records the reply, with the status of the execution.
+func (m *message) Reply(msgType string, content interface{}) error {
+	reply, err := toMap(content)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to encode %q reply", msgType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reply = reply
+	return nil
+}
+
+// ReplyWithMetadata implements kernel.Message: it records the reply as Reply does, and its metadata (e.g. the
+// diagnostics).
+func (m *message) ReplyWithMetadata(msgType string, content interface{}, metadata map[string]any) error {
+	if err := m.Reply(msgType, content); err != nil {
+		return err
+	}
+	replyMetadata, err := toMap(metadata)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to encode %q reply metadata", msgType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replyMetadata = replyMetadata
+	return nil
+}
+
+// Outputs returns the outputs recorded, encoded as in the notebook file.
+func (m *message) Outputs() ([]json.RawMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outputs := make([]json.RawMessage, 0, len(m.outputs))
+	for _, output := range m.outputs {
+		data, err := json.Marshal(output)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode %q output", output["output_type"])
+		}
+		outputs = append(outputs, data)
+	}
+	return outputs, nil
+}
+
+// toMap converts a message content to a generic map, the same way it would be seen by Jupyter.
+func toMap(content any) (map[string]any, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode message content")
+	}
+	m := make(map[string]any)
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode message content")
+	}
+	return m, nil
+}
@@ -0,0 +1,66 @@
+package nbrun
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageOutputs(t *testing.T) {
+	k := kernel.NewHeadless()
+	defer k.Stop()
+	var stdout, stderr strings.Builder
+	msg := newExecuteMessage(k, "%%\nfmt.Println(1)", &stdout, &stderr)
+	assert.Equal(t, "execute_request", msg.ComposedMsg().Header.MsgType)
+
+	require.NoError(t, kernel.PublishExecuteInput(msg, "ignored"))
+	require.NoError(t, kernel.PublishWriteStream(msg, kernel.StreamStdout, "a"))
+	require.NoError(t, kernel.PublishWriteStream(msg, kernel.StreamStdout, "b\n"))
+	require.NoError(t, kernel.PublishWriteStream(msg, kernel.StreamStderr, "c\n"))
+	display := kernel.Data{
+		Data:      kernel.MIMEMap{"text/plain": "v1"},
+		Transient: kernel.MIMEMap{"display_id": "progress"},
+	}
+	require.NoError(t, kernel.PublishUpdateDisplayData(msg, display))
+	display.Data = kernel.MIMEMap{"text/plain": "v2"}
+	require.NoError(t, kernel.PublishUpdateDisplayData(msg, display))
+	require.NoError(t, kernel.PublishExecutionError(msg, "failed", []string{"trace"}, "ERROR"))
+	diagnostics := []goexec.Diagnostic{{ExecutionCount: 1, Line: 1, Severity: goexec.DiagnosticError, Message: "undefined: x"}}
+	require.NoError(t, msg.ReplyWithMetadata("execute_reply", map[string]any{"status": "error"},
+		map[string]any{goexec.DiagnosticsMetadataKey: diagnostics}))
+
+	outputs, err := msg.Outputs()
+	require.NoError(t, err)
+	var got []string
+	for _, output := range outputs {
+		got = append(got, string(output))
+	}
+	assert.Equal(t, []string{
+		`{"name":"stdout","output_type":"stream","text":"ab\n"}`,
+		`{"name":"stderr","output_type":"stream","text":"c\n"}`,
+		`{"data":{"text/plain":"v2"},"metadata":{},"output_type":"display_data"}`,
+		`{"ename":"ERROR","evalue":"failed","output_type":"error","traceback":["trace"]}`,
+	}, got)
+	assert.Equal(t, "ab\n", stdout.String())
+	assert.Equal(t, "c\ntrace\n", stderr.String())
+	assert.Equal(t, "error", msg.reply["status"])
+
+	// Diagnostics are recorded in the cell metadata, and removed when the cell is executed again without them.
+	cell := &ipynb.Cell{CellType: "code"}
+	setDiagnostics(cell, msg.replyMetadata[goexec.DiagnosticsMetadataKey])
+	assert.Equal(t, []any{map[string]any{"execution_count": 1.0, "line": 1.0, "column": 0.0, "severity": "error",
+		"message": "undefined: x"}}, cell.Metadata[goexec.DiagnosticsMetadataKey])
+	setDiagnostics(cell, nil)
+	assert.NotContains(t, cell.Metadata, goexec.DiagnosticsMetadataKey)
+
+	// Clearing the output.
+	require.NoError(t, msg.Publish("clear_output", map[string]any{"wait": false}))
+	outputs, err = msg.Outputs()
+	require.NoError(t, err)
+	assert.Empty(t, outputs)
+}
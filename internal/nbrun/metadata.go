@@ -0,0 +1,63 @@
+package nbrun
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/pkg/errors"
+)
+
+// This file handles the information GoNB records in the notebook metadata: the module versions pinned
+// with `%require` and the environment saved with `%save_env`.
+
+// LoadMetadata restores in goExec the environment and the pinned module versions recorded in the notebook.
+// It returns the warnings of the restoration of the environment, see goexec.State.RestoreEnvironment.
+func LoadMetadata(goExec *goexec.State, nb *ipynb.Notebook) (warnings []string, err error) {
+	env, err := goexec.NotebookEnvironment(nb)
+	if err != nil {
+		return nil, err
+	}
+	if env != nil {
+		if warnings, err = goExec.RestoreEnvironment(env); err != nil {
+			return nil, errors.WithMessagef(err, "failed to restore the environment of the notebook")
+		}
+	}
+	if err = LoadRequirements(goExec, nb); err != nil {
+		return nil, errors.WithMessagef(err, "failed to apply pinned module versions")
+	}
+	return warnings, nil
+}
+
+// SaveMetadata records in the notebook metadata the environment and the pinned module versions of goExec.
+func SaveMetadata(goExec *goexec.State, nb *ipynb.Notebook) error {
+	SaveRequirements(goExec, nb)
+	env, err := goExec.SaveEnvironment()
+	if err != nil {
+		return errors.WithMessagef(err, "failed to save the environment of the notebook")
+	}
+	nb.SetGonbMetadata(goexec.EnvironmentMetadataKey, env)
+	return nil
+}
+
+// LoadRequirements applies to goExec the module versions pinned (with `%require`) in the notebook,
+// see goexec.NotebookRequirements.
+func LoadRequirements(goExec *goexec.State, nb *ipynb.Notebook) error {
+	requirements, err := goexec.NotebookRequirements(nb)
+	if err != nil || requirements == nil {
+		return err
+	}
+	return goExec.SetRequirements(requirements)
+}
+
+// SaveRequirements records the module versions pinned in goExec (with `%require`) in the notebook metadata.
+func SaveRequirements(goExec *goexec.State, nb *ipynb.Notebook) {
+	requirements := goExec.Requirements()
+	if len(requirements) == 0 {
+		nb.SetGonbMetadata(goexec.RequirementsMetadataKey, nil)
+		return
+	}
+	pins := make(map[string]any, len(requirements))
+	for modPath, version := range requirements {
+		pins[modPath] = version
+	}
+	nb.SetGonbMetadata(goexec.RequirementsMetadataKey, pins)
+}
@@ -0,0 +1,105 @@
+// Package nbrun executes notebooks without Jupyter (`gonb run`): the code cells are executed in order,
+// in-process, by the same dispatcher used by the kernel, through a kernel.Message that records the outputs
+// in the notebook, instead of sending them to a front-end.
+package nbrun
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/janpfeifer/gonb/internal/dispatcher"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Options for Run.
+type Options struct {
+	// Parameters to inject in the notebook before running it, see InjectParameters.
+	Parameters []Parameter
+
+	// KeepGoing executes all cells, even after a cell fails.
+	KeepGoing bool
+
+	// Stdout and Stderr, if not nil, get a copy of the output streams of the cells, and of the errors.
+	Stdout, Stderr io.Writer
+}
+
+// Run executes the code cells of the notebook in order, replacing their outputs and execution counts.
+// The kernel k must be the one used by goExec, usually created with kernel.NewHeadless.
+//
+// Execution stops at the first cell that fails, unless opts.KeepGoing is set. The outputs of the cells
+// not executed are cleared.
+//
+// The diagnostics of the cells that fail (the errors located in the cells, see goexec.Diagnostic) are recorded
+// in the cell metadata, under goexec.DiagnosticsMetadataKey.
+//
+// The environment (`go.mod`, `go.sum`, see `%save_env`) and the module versions pinned (see `%require`)
+// recorded in the notebook are restored before the execution, and recorded back in the notebook metadata
+// at the end of the execution, see LoadMetadata and SaveMetadata.
+//
+// It returns the indices (in nb.Cells) of the cells that failed. The error returned is only for
+// failures other than the execution of the cells.
+func Run(k *kernel.Kernel, goExec *goexec.State, nb *ipynb.Notebook, opts Options) (failed []int, err error) {
+	if err = InjectParameters(nb, opts.Parameters); err != nil {
+		return nil, errors.WithMessagef(err, "failed to inject parameters")
+	}
+	warnings, err := LoadMetadata(goExec, nb)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		klog.Warningf("nbrun: %s", warning)
+		if opts.Stderr != nil {
+			_, _ = fmt.Fprintf(opts.Stderr, "Warning: %s\n", warning)
+		}
+	}
+	defer func() {
+		if saveErr := SaveMetadata(goExec, nb); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+	stopped := false
+	for ii, cell := range nb.Cells {
+		if cell.CellType != "code" {
+			continue
+		}
+		if stopped || k.IsStopped() {
+			cell.ExecutionCount = nil
+			cell.Outputs = nil
+			setDiagnostics(cell, nil)
+			continue
+		}
+		klog.V(1).Infof("nbrun: executing cell #%d", ii)
+		msg := newExecuteMessage(k, string(cell.Source), opts.Stdout, opts.Stderr)
+		if err = dispatcher.HandleExecuteRequest(msg, goExec); err != nil {
+			return failed, errors.WithMessagef(err, "executing cell #%d", ii)
+		}
+		if cell.Outputs, err = msg.Outputs(); err != nil {
+			return failed, errors.WithMessagef(err, "cell #%d", ii)
+		}
+		count := k.ExecCounter
+		cell.ExecutionCount = &count
+		setDiagnostics(cell, msg.replyMetadata[goexec.DiagnosticsMetadataKey])
+		if status, _ := msg.reply["status"].(string); status != "ok" {
+			failed = append(failed, ii)
+			stopped = !opts.KeepGoing
+		}
+	}
+	return failed, nil
+}
+
+// setDiagnostics records the diagnostics of the execution of the cell in its metadata, or removes the ones of
+// a previous execution if diagnostics is nil.
+func setDiagnostics(cell *ipynb.Cell, diagnostics any) {
+	if diagnostics == nil {
+		delete(cell.Metadata, goexec.DiagnosticsMetadataKey)
+		return
+	}
+	if cell.Metadata == nil {
+		cell.Metadata = make(map[string]any)
+	}
+	cell.Metadata[goexec.DiagnosticsMetadataKey] = diagnostics
+}
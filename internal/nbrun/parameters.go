@@ -0,0 +1,257 @@
+package nbrun
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Parameter injection follows the papermill (https://papermill.readthedocs.io/) convention: the cell tagged
+// "parameters" declares the parameters with their default values, and a new cell tagged
+// "injected-parameters", redeclaring the parameters with the given values, is inserted right after it.
+// Since GoNB memorizes the last declaration of each variable or constant, the injected values are the
+// ones used by the following cells.
+
+const (
+	// ParametersTag marks the cell with the declaration of the parameters.
+	ParametersTag = "parameters"
+
+	// InjectedParametersTag marks the cell with the injected parameters.
+	InjectedParametersTag = "injected-parameters"
+)
+
+// Parameter to inject in the notebook.
+type Parameter struct {
+	Name string
+
+	// Value is either a string, given in the command line, or a value decoded from JSON
+	// (string, float64 or bool).
+	//
+	// Strings are converted according to the type of the parameter in the "parameters" cell: for
+	// non-string types they are taken as a Go expression (e.g.: "10*time.Second").
+	Value any
+}
+
+// declaredParameter is a variable or constant declared in the "parameters" cell.
+type declaredParameter struct {
+	isConst bool
+
+	// goType is the type of the parameter: either explicitly declared (typed is true), or inferred from
+	// the literal of its value. Empty if unknown.
+	goType string
+	typed  bool
+}
+
+// InjectParameters inserts a cell tagged "injected-parameters" with the given parameters after the
+// cell tagged "parameters", or at the start of the notebook if there is none.
+// Cells previously injected are removed. If there are no parameters, the notebook is not changed.
+func InjectParameters(nb *ipynb.Notebook, params []Parameter) error {
+	if len(params) == 0 {
+		return nil
+	}
+	nb.Cells = slices.DeleteFunc(nb.Cells, func(cell *ipynb.Cell) bool {
+		return hasTag(cell, InjectedParametersTag)
+	})
+	insertAt := 0
+	declared := make(map[string]declaredParameter)
+	for ii, cell := range nb.Cells {
+		if cell.CellType == "code" && hasTag(cell, ParametersTag) {
+			insertAt = ii + 1
+			declared = parseDeclaredParameters(cell.Lines())
+			break
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("// Parameters injected by `gonb run`.\n")
+	for _, param := range params {
+		decl, found := declared[param.Name]
+		if !found {
+			klog.Warningf("Parameter %q is not declared in the cell tagged %q", param.Name, ParametersTag)
+		}
+		value, err := formatValue(decl.goType, param.Value)
+		if err != nil {
+			return errors.WithMessagef(err, "parameter %q", param.Name)
+		}
+		keyword := "var"
+		if decl.isConst {
+			keyword = "const"
+		}
+		if decl.typed {
+			_, _ = fmt.Fprintf(&sb, "%s %s %s = %s\n", keyword, param.Name, decl.goType, value)
+		} else {
+			_, _ = fmt.Fprintf(&sb, "%s %s = %s\n", keyword, param.Name, value)
+		}
+	}
+
+	cell := &ipynb.Cell{
+		CellType: "code",
+		Metadata: map[string]any{"tags": []any{InjectedParametersTag}},
+		Source:   ipynb.Source(strings.TrimSuffix(sb.String(), "\n")),
+	}
+	if nb.NBFormat > 4 || (nb.NBFormat == 4 && nb.NBFormatMinor >= 5) {
+		// Cell ids are required since version 4.5.
+		if u, err := uuid.NewV4(); err == nil {
+			cell.Id = u.String()
+		}
+	}
+	nb.Cells = slices.Insert(nb.Cells, insertAt, cell)
+	return nil
+}
+
+// hasTag returns whether the cell metadata has the given tag.
+func hasTag(cell *ipynb.Cell, tag string) bool {
+	tags, _ := cell.Metadata["tags"].([]any)
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDeclaredParameters returns the variables and constants declared in the lines of the
+// "parameters" cell. Special commands and lines that fail to parse are ignored.
+func parseDeclaredParameters(lines []string) map[string]declaredParameter {
+	declared := make(map[string]declaredParameter)
+	var sb strings.Builder
+	sb.WriteString("package main\n")
+	for _, line := range lines {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!") {
+			line = ""
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	fileObj, err := parser.ParseFile(token.NewFileSet(), "parameters.go", sb.String(), parser.SkipObjectResolution)
+	if err != nil {
+		klog.Warningf("Failed to parse the cell tagged %q, parameters types will be inferred from their values: %v",
+			ParametersTag, err)
+	}
+	if fileObj == nil {
+		return declared
+	}
+	for _, decl := range fileObj.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || (genDecl.Tok != token.VAR && genDecl.Tok != token.CONST) {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for ii, name := range valueSpec.Names {
+				param := declaredParameter{isConst: genDecl.Tok == token.CONST}
+				if valueSpec.Type != nil {
+					param.goType = types.ExprString(valueSpec.Type)
+					param.typed = true
+				} else if len(valueSpec.Values) == len(valueSpec.Names) {
+					param.goType = literalType(valueSpec.Values[ii])
+				}
+				declared[name.Name] = param
+			}
+		}
+	}
+	return declared
+}
+
+// literalType returns the default type of a literal expression, or "" if it is not a literal.
+func literalType(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return "int"
+		case token.FLOAT:
+			return "float64"
+		case token.STRING:
+			return "string"
+		case token.CHAR:
+			return "rune"
+		}
+	case *ast.UnaryExpr:
+		if goType := literalType(e.X); (e.Op == token.SUB || e.Op == token.ADD) && (goType == "int" || goType == "float64") {
+			return goType
+		}
+	case *ast.Ident:
+		if e.Name == "true" || e.Name == "false" {
+			return "bool"
+		}
+	}
+	return ""
+}
+
+var reDecimalInt = regexp.MustCompile(`^[0-9]+$`)
+
+// formatValue returns the Go expression of the value for a parameter of the given type.
+func formatValue(goType string, value any) (string, error) {
+	if _, isString := value.(string); goType == "string" && !isString {
+		value = fmt.Sprint(value)
+	}
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if isIntegerType(goType) || (goType == "" && v == math.Trunc(v)) {
+			if v != math.Trunc(v) {
+				return "", errors.Errorf("value %v is not an integer, as required by type %s", v, goType)
+			}
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return floatLiteral(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case string:
+		switch goType {
+		case "string":
+			return strconv.Quote(v), nil
+		case "rune":
+			if utf8.RuneCountInString(v) == 1 {
+				return strconv.QuoteRune([]rune(v)[0]), nil
+			}
+		}
+		expr, err := parser.ParseExpr(v)
+		if goType == "" {
+			// Unknown type: only literals are used as is.
+			if err == nil && literalType(expr) != "" {
+				return v, nil
+			}
+			return strconv.Quote(v), nil
+		}
+		if err != nil {
+			return "", errors.Errorf("invalid value %q for type %s", v, goType)
+		}
+		if goType == "float32" || goType == "float64" {
+			return floatLiteral(v), nil
+		}
+		return v, nil
+	default:
+		return "", errors.Errorf("values of type %T are not supported", value)
+	}
+}
+
+// floatLiteral makes sure a decimal integer literal is written as a float, so its default type is float64.
+func floatLiteral(literal string) string {
+	if reDecimalInt.MatchString(literal) {
+		return literal + ".0"
+	}
+	return literal
+}
+
+// isIntegerType returns whether goType is one of Go's integer types.
+func isIntegerType(goType string) bool {
+	switch goType {
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte":
+		return true
+	}
+	return false
+}
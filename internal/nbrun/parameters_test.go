@@ -0,0 +1,56 @@
+package nbrun
+
+import (
+	"testing"
+
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectParameters(t *testing.T) {
+	nb := &ipynb.Notebook{
+		NBFormat:      4,
+		NBFormatMinor: 4,
+		Cells: []*ipynb.Cell{
+			{CellType: "markdown", Source: "# Parameters"},
+			{CellType: "code", Metadata: map[string]any{"tags": []any{ParametersTag}}, Source: `%env FOO=bar
+var (
+	steps = 10
+	rate = 0.1
+	label string
+)
+const name = "world"
+var timeout time.Duration = time.Second`},
+			{CellType: "code", Metadata: map[string]any{"tags": []any{InjectedParametersTag}}, Source: "var steps = 1"},
+			{CellType: "code", Source: "%%\nfmt.Println(steps)"},
+		},
+	}
+	params := []Parameter{
+		{"steps", "20"},
+		{"rate", "1"},
+		{"label", 3.0},
+		{"name", "gopher"},
+		{"timeout", "5*time.Minute"},
+		{"extra", "some text"},
+		{"flag", true},
+	}
+	require.NoError(t, InjectParameters(nb, params))
+	require.Len(t, nb.Cells, 4)
+	injected := nb.Cells[2]
+	assert.True(t, hasTag(injected, InjectedParametersTag))
+	assert.Empty(t, injected.Id, "Cell ids are only used since nbformat 4.5")
+	assert.Equal(t, `// Parameters injected by `+"`gonb run`"+`.
+var steps = 20
+var rate = 1.0
+var label string = "3"
+const name = "gopher"
+var timeout time.Duration = 5*time.Minute
+var extra = "some text"
+var flag = true`, string(injected.Source))
+
+	// Invalid values.
+	require.Error(t, InjectParameters(nb, []Parameter{{"steps", 1.5}}))
+	require.Error(t, InjectParameters(nb, []Parameter{{"timeout", "5*"}}))
+	require.Error(t, InjectParameters(nb, []Parameter{{"steps", []any{1.0}}}))
+}
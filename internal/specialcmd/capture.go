@@ -0,0 +1,96 @@
+package specialcmd
+
+import (
+	"os"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/capture"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execCapture executes the "%capture" special command. The parameter `args` excludes "%capture".
+//
+//   - `-a`: append to the file, instead of overwriting it.
+//   - `--format=<format>`: one of "text" (default), "html", "md" or "json".
+//   - `--images=inline|files`: whether images in "html" and "md" reports are embedded as data URIs, or
+//     saved in side files.
+//   - `<file_path>`: where to save the output.
+func execCapture(goExec *goexec.State, args []string) error {
+	var (
+		appendToFile bool
+		format       = capture.FormatText
+		images       string
+		filePath     string
+		err          error
+	)
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if arg == "--format" || arg == "--images" {
+			if ii+1 >= len(args) {
+				return errors.Errorf("%%capture: missing value for %q", arg)
+			}
+			ii++
+			arg += "=" + args[ii]
+		}
+		name, value, _ := strings.Cut(arg, "=")
+		switch {
+		case arg == "-a":
+			appendToFile = true
+		case name == "--format":
+			format, err = capture.ParseFormat(value)
+			if err != nil {
+				return errors.WithMessagef(err, "%%capture")
+			}
+		case name == "--images":
+			if value != "inline" && value != "files" {
+				return errors.Errorf("%%capture: invalid --images=%q, it must be \"inline\" or \"files\"", value)
+			}
+			images = value
+		case strings.HasPrefix(arg, "-"):
+			return errors.Errorf("%%capture: unknown argument %q, see %%help", arg)
+		case filePath != "":
+			return errors.New("%capture takes one argument, the name of the file where to save the captured output")
+		default:
+			filePath = arg
+		}
+	}
+	if filePath == "" {
+		return errors.New("%capture takes one argument, the name of the file where to save the captured output")
+	}
+	filePath = ReplaceTildeInDir(filePath)
+	filePath = ReplaceEnvVars(filePath)
+
+	if format != capture.FormatText {
+		// Reports are written by the dispatcher at the end of the execution of the cell.
+		goExec.CaptureReport = &capture.Report{
+			Path:         filePath,
+			Format:       format,
+			Append:       appendToFile,
+			InlineImages: images == "inline" || (images == "" && format == capture.FormatHTML),
+		}
+		return nil
+	}
+
+	var f *os.File
+	if appendToFile {
+		f, err = os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to append to \"%%capture\" file %q", filePath)
+			klog.Errorf("Error: %+v", err)
+			return err
+		}
+	} else {
+		f, err = os.Create(filePath)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create \"%%capture\" file %q", filePath)
+			klog.Errorf("Error: %+v", err)
+			return err
+		}
+	}
+	// Notice, file will be closed in goExec.PostExecuteCell(), where all "one-shot" state is cleaned up.
+	goExec.CaptureFile = f
+	return nil
+}
@@ -18,7 +18,8 @@ var (
 		"%%writefile",
 		"%%script",
 		"%%bash",
-		"%%sh")
+		"%%sh",
+		"%%package")
 )
 
 // IsGoCell returns whether the cell is expected to be a Go cell, based on the first line.
@@ -63,6 +64,13 @@ func ExecuteSpecialCell(msg kernel.Message, goExec *goexec.State, lines []string
 		}
 		err = cellCmdScript(msg, goExec, args, lines[1:])
 
+	case "%%package":
+		if len(parts) != 2 {
+			err = errors.Errorf("expected \"%%%%package <name>\", but got %q instead", parts[1:])
+			return
+		}
+		err = goExec.ExecutePackageCell(msg, msg.Kernel().ExecCounter, parts[1], lines)
+
 	default:
 		err = errors.Errorf("special cell command %q not implemented", parts[0])
 	}
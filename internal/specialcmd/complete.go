@@ -0,0 +1,39 @@
+package specialcmd
+
+import (
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+)
+
+// IsComplete checks whether the cell is complete and ready to be executed, or whether more lines are
+// expected. It is used to answer the "is_complete_request" from console front-ends.
+//
+// Special commands are not executed, only stripped from the Go code. A special command ending with `\`
+// (see joinLine) and special cells (e.g.: `%%writefile`) not yet terminated by an empty line are incomplete.
+//
+// It returns the status (goexec.CodeComplete, goexec.CodeIncomplete or goexec.CodeInvalid) and, if incomplete,
+// a suggested indentation for the next line.
+func IsComplete(lines []string) (status, indent string) {
+	if len(lines) == 0 {
+		return goexec.CodeComplete, ""
+	}
+	lastLine := goexec.TrimGonbCommentPrefix(lines[len(lines)-1])
+	if !IsGoCell(lines[0]) {
+		if len(lines) == 1 || strings.TrimSpace(lastLine) != "" {
+			return goexec.CodeIncomplete, ""
+		}
+		return goexec.CodeComplete, ""
+	}
+
+	usedLines := MakeSet[int]()
+	if err := Parse(nil, nil, false, lines, usedLines); err != nil {
+		return goexec.CodeInvalid, ""
+	}
+	if usedLines.Has(len(lines)-1) && strings.HasSuffix(lastLine, "\\") {
+		// Special command continued in the next line.
+		return goexec.CodeIncomplete, ""
+	}
+	return goexec.IsCompleteCode(lines, usedLines)
+}
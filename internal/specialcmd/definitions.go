@@ -5,6 +5,7 @@ import (
 	"github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/internal/goexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"html"
 	"k8s.io/klog/v2"
 	"strings"
 )
@@ -38,14 +39,24 @@ func displayEnumeration(msg kernel.Message, title string, items []string) {
 	}
 }
 
-// listDefinitions lists all memorized definitions. It implements the "%list" (or "%ls") command.
+// listDefinitions lists all memorized definitions, grouped by package. It implements the "%list" (or "%ls") command.
 func listDefinitions(msg kernel.Message, goExec *goexec.State) {
 	_ = kernel.PublishHtml(msg, "<h3>Memorized Definitions</h3>\n")
-	displayEnumeration(msg, "Imports", common.SortedKeys(goExec.Definitions.Imports))
-	displayEnumeration(msg, "Constants", common.SortedKeys(goExec.Definitions.Constants))
-	displayEnumeration(msg, "Types", common.SortedKeys(goExec.Definitions.Types))
-	displayEnumeration(msg, "Variables", common.SortedKeys(goExec.Definitions.Variables))
-	displayEnumeration(msg, "Functions", common.SortedKeys(goExec.Definitions.Functions))
+	listDeclarations(msg, goExec.Definitions)
+	for _, name := range goExec.Packages() {
+		_ = kernel.PublishHtml(msg, fmt.Sprintf("<h3>Package %s</h3>\n<pre>import %q</pre>\n",
+			html.EscapeString(name), goExec.PackageImportPath(name)))
+		listDeclarations(msg, goExec.PackageDefinitions(name))
+	}
+}
+
+// listDeclarations lists the declarations by kind.
+func listDeclarations(msg kernel.Message, decls *goexec.Declarations) {
+	displayEnumeration(msg, "Imports", common.SortedKeys(decls.Imports))
+	displayEnumeration(msg, "Constants", common.SortedKeys(decls.Constants))
+	displayEnumeration(msg, "Types", common.SortedKeys(decls.Types))
+	displayEnumeration(msg, "Variables", common.SortedKeys(decls.Variables))
+	displayEnumeration(msg, "Functions", common.SortedKeys(decls.Functions))
 }
 
 func removeDefinitionImpl[T any](msg kernel.Message, mapName string, m *map[string]*T, key string) bool {
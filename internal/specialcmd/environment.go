@@ -0,0 +1,97 @@
+package specialcmd
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execSaveEnv executes the "%save_env" special command: it displays the current environment (`go.mod`, `go.sum`
+// and the versions of Go and GoNB), recording it in the metadata of the output, so it is saved in the notebook.
+// It is also sent to the front-ends with comms opened with goexec.EnvironmentCommTarget.
+func execSaveEnv(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 0 {
+		return errors.Errorf("%%save_env takes no parameters")
+	}
+	env, err := goExec.SaveEnvironment()
+	if err != nil {
+		return err
+	}
+	if err = goExec.PublishEnvironment(msg, env, nil); err != nil {
+		klog.Errorf("Failed to publish environment to comms: %+v", err)
+	}
+	err = kernel.PublishData(msg, kernel.Data{
+		Data: kernel.MIMEMap{string(protocol.MIMETextHTML): environmentHtml("Environment saved", env)},
+		Metadata: kernel.MIMEMap{
+			ipynb.GonbMetadataKey: map[string]any{goexec.EnvironmentMetadataKey: env},
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to publish environment back to jupyter: %+v", err)
+	}
+	return nil
+}
+
+// execRestoreEnv executes the "%restore_env [<notebook.ipynb>]" special command: it restores the environment
+// saved in the given notebook (by default the notebook of the kernel session), with `%save_env` or `gonb run`.
+func execRestoreEnv(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.Errorf("%%restore_env takes at most one parameter, the notebook with the saved environment")
+	}
+	var nbPath string
+	if len(args) == 1 {
+		nbPath = args[0]
+	} else {
+		var err error
+		if nbPath, err = goexec.JupyterNotebookPath(); err != nil {
+			return errors.WithMessagef(err, "%%restore_env: give the path to the notebook with the saved environment")
+		}
+	}
+	nb, err := ipynb.Read(nbPath)
+	if err != nil {
+		return err
+	}
+	env, err := goexec.NotebookEnvironment(nb)
+	if err != nil {
+		return errors.WithMessagef(err, "%%restore_env: notebook %q", nbPath)
+	}
+	if env == nil {
+		return errors.Errorf("%%restore_env: no environment saved in notebook %q, "+
+			"execute `%%save_env` and save the notebook first", nbPath)
+	}
+	warnings, err := goExec.RestoreEnvironment(env)
+	if err != nil {
+		return err
+	}
+	if err = goExec.PublishEnvironment(msg, env, warnings); err != nil {
+		klog.Errorf("Failed to publish environment to comms: %+v", err)
+	}
+	if len(warnings) > 0 {
+		goExec.AddPendingWarnings(warnings...)
+		goExec.PublishPendingWarnings(msg)
+	}
+	if err = kernel.PublishHtml(msg, environmentHtml("Environment restored", env)); err != nil {
+		klog.Errorf("Failed to publish environment back to jupyter: %+v", err)
+	}
+	return nil
+}
+
+// environmentHtml returns a summary of the environment in HTML.
+func environmentHtml(title string, env *goexec.Environment) string {
+	parts := []string{
+		fmt.Sprintf("<h4>%s</h4>", html.EscapeString(title)),
+		"<table>",
+		fmt.Sprintf("<tr><th>Go version</th><td><code>%s</code></td></tr>", html.EscapeString(env.GoVersion)),
+		fmt.Sprintf("<tr><th>GoNB version</th><td><code>%s</code></td></tr>", html.EscapeString(env.GonbVersion)),
+		"</table>",
+		fmt.Sprintf("<details><summary>go.mod</summary><pre>%s</pre></details>", html.EscapeString(env.GoMod)),
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,68 @@
+package specialcmd
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execExport executes the "%export" special command. The parameter `args` excludes "%export".
+//
+//   - `--split`: write the declarations of each cell in its own file.
+//   - `--module=<path>`: set the module path in the exported `go.mod`.
+//   - `--force`: export even if the directory is not empty.
+//   - `<dir>`: directory where to export the Go module.
+func execExport(msg kernel.Message, goExec *goexec.State, args []string) error {
+	var (
+		opts goexec.ExportOptions
+		dir  string
+	)
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		switch {
+		case arg == "--split":
+			opts.SplitByCell = true
+		case arg == "--force" || arg == "-f":
+			opts.Force = true
+		case strings.HasPrefix(arg, "--module="):
+			opts.Module = strings.TrimPrefix(arg, "--module=")
+		case arg == "--module":
+			if ii+1 >= len(args) {
+				return errors.Errorf("%%export: missing value for %q", arg)
+			}
+			ii++
+			opts.Module = args[ii]
+		case strings.HasPrefix(arg, "-"):
+			return errors.Errorf("%%export: unknown argument %q, see %%help", arg)
+		default:
+			if dir != "" {
+				return errors.Errorf("%%export takes only one directory, got %q and %q", dir, arg)
+			}
+			dir = arg
+		}
+	}
+	if dir == "" {
+		return errors.New("%export requires the directory where to export the Go module")
+	}
+	dir = ReplaceEnvVars(ReplaceTildeInDir(dir))
+
+	files, err := goExec.Export(dir, opts)
+	if err != nil {
+		return errors.WithMessagef(err, "%%export")
+	}
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "Exported Go module to %q:\n", dir)
+	for _, filePath := range files {
+		_, _ = fmt.Fprintf(&sb, "  - %s\n", filePath)
+	}
+	err = kernel.PublishWriteStream(msg, kernel.StreamStdout, sb.String())
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
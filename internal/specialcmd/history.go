@@ -0,0 +1,116 @@
+package specialcmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/history"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execHistory executes the "%history" special command. The parameter `args` excludes "%history".
+//
+//   - `-n <N>`: show only the last N entries.
+//   - `-g <pattern>`: show only entries whose code contains the glob pattern.
+//   - `-o`: include the (textual) outputs of the cells.
+//   - `-f <file>`: write the history to the file, instead of displaying it.
+//   - `--all`: include entries of all sessions, not only the current one.
+func execHistory(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if goExec.History == nil {
+		return errors.New("%history: history is disabled, see the `--history` flag of gonb")
+	}
+	var (
+		n                int
+		pattern, outFile string
+		withOutput, all  bool
+		err              error
+	)
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		switch arg {
+		case "-o":
+			withOutput = true
+		case "--all":
+			all = true
+		case "-n", "-g", "-f":
+			if ii+1 >= len(args) {
+				return errors.Errorf("%%history: missing value for %q", arg)
+			}
+			ii++
+			switch arg {
+			case "-n":
+				n, err = strconv.Atoi(args[ii])
+				if err != nil || n <= 0 {
+					return errors.Errorf("%%history: invalid number of entries %q for \"-n\"", args[ii])
+				}
+			case "-g":
+				pattern = args[ii]
+			case "-f":
+				outFile = args[ii]
+			}
+		default:
+			return errors.Errorf("%%history: unknown argument %q, see %%help", arg)
+		}
+	}
+
+	var entries []*history.Entry
+	if pattern != "" {
+		// Searches always include all sessions.
+		entries, err = goExec.History.Search("*"+pattern+"*", n, false)
+	} else if all {
+		entries, err = goExec.History.Tail(n)
+	} else {
+		entries, err = goExec.History.Range(0, 0, 0)
+		if n > 0 && len(entries) > n {
+			entries = entries[len(entries)-n:]
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		if entry.SessionId == goExec.History.SessionId() {
+			_, _ = fmt.Fprintf(&sb, "In [%d]:\n", entry.Line)
+		} else {
+			_, _ = fmt.Fprintf(&sb, "In [%d/%d]:\n", entry.Session, entry.Line)
+		}
+		sb.WriteString(entry.Code)
+		if !strings.HasSuffix(entry.Code, "\n") {
+			sb.WriteString("\n")
+		}
+		if withOutput {
+			if text := entry.Text(); text != "" {
+				sb.WriteString("Out:\n")
+				sb.WriteString(text)
+				if entry.Truncated {
+					sb.WriteString("[... output truncated]\n")
+				}
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	if outFile != "" {
+		outFile = ReplaceEnvVars(ReplaceTildeInDir(outFile))
+		if err = os.WriteFile(outFile, []byte(sb.String()), 0644); err != nil {
+			return errors.Wrapf(err, "%%history: failed to write to %q", outFile)
+		}
+		sb.Reset()
+		_, _ = fmt.Fprintf(&sb, "%d history entries written to %q\n", len(entries), outFile)
+	} else if len(entries) == 0 {
+		sb.WriteString("No history entries found.\n")
+	}
+	err = kernel.PublishWriteStream(msg, kernel.StreamStdout, sb.String())
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
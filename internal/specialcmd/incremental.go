@@ -0,0 +1,43 @@
+package specialcmd
+
+import (
+	"fmt"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execIncremental executes the "%incremental" special command. The parameter `args` excludes the command.
+//
+//   - `%incremental`: displays whether the incremental compilation is enabled, and how many declarations
+//     were in the cached package in the last compilation.
+//   - `%incremental on`: enables the incremental compilation.
+//   - `%incremental off`: disables the incremental compilation.
+func execIncremental(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.Errorf("%%incremental takes at most one parameter, \"on\" or \"off\"")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			goExec.SetIncremental(true)
+		case "off":
+			goExec.SetIncremental(false)
+		default:
+			return errors.Errorf("%%incremental: invalid parameter %q, it must be \"on\" or \"off\"", args[0])
+		}
+	}
+	status := "Incremental compilation disabled.\n"
+	if goExec.IsIncremental() {
+		status = "Incremental compilation enabled.\n"
+		if moved, total := goExec.IncrementalStats(); total > 0 {
+			status += fmt.Sprintf("Last compilation: %d of %d declarations in the cached package.\n", moved, total)
+		}
+	}
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status); err != nil {
+		klog.Errorf("Failed publishing contents: %+v", err)
+	}
+	return nil
+}
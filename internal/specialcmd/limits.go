@@ -0,0 +1,95 @@
+package specialcmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execTimeout executes the "%timeout" special command. The parameter `args` excludes "%timeout".
+//
+//   - `%timeout`: reports the current timeout.
+//   - `%timeout <duration>`: sets the maximum time the execution of a cell can take, e.g. "30s" or "5m".
+//   - `%timeout off` (or 0): disables the timeout.
+func execTimeout(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.New("%timeout takes at most one argument: a duration (e.g.: 30s, 5m) or off")
+	}
+	if len(args) == 1 {
+		timeout, err := parseLimitDuration(args[0])
+		if err != nil {
+			return errors.WithMessage(err, "%timeout")
+		}
+		goExec.Limits.Timeout = timeout
+	}
+	status := "No timeout for the execution of cells.\n"
+	if goExec.Limits.Timeout > 0 {
+		status = fmt.Sprintf("Execution of cells is interrupted after %s.\n", goExec.Limits.Timeout)
+	}
+	return publishLimitsStatus(msg, status)
+}
+
+// execLimits executes the "%limits" special command. The parameter `args` excludes "%limits".
+//
+//   - `%limits`: reports the current limits.
+//   - `%limits mem=2G cpu=30s timeout=5m`: sets any of the limits, "0" or "off" disables it.
+//   - `%limits off`: disables all limits.
+func execLimits(msg kernel.Message, goExec *goexec.State, args []string) error {
+	limits := goExec.Limits
+	for _, arg := range args {
+		if arg == "off" {
+			limits = jpyexec.Limits{}
+			continue
+		}
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return errors.Errorf("%%limits: invalid argument %q, use mem=<size>, cpu=<duration> or timeout=<duration>", arg)
+		}
+		var err error
+		switch key {
+		case "mem", "memory":
+			if value == "off" {
+				value = "0"
+			}
+			limits.Memory, err = jpyexec.ParseMemorySize(value)
+		case "cpu":
+			limits.CPU, err = parseLimitDuration(value)
+		case "timeout":
+			limits.Timeout, err = parseLimitDuration(value)
+		default:
+			err = errors.Errorf("unknown limit %q, valid limits are mem, cpu and timeout", key)
+		}
+		if err != nil {
+			return errors.WithMessage(err, "%limits")
+		}
+	}
+	goExec.Limits = limits
+	return publishLimitsStatus(msg, fmt.Sprintf("Limits for the execution of cells: %s\n", limits))
+}
+
+// parseLimitDuration parses a duration, "off" or "0" meaning no limit.
+func parseLimitDuration(value string) (time.Duration, error) {
+	if value == "off" || value == "0" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, errors.Errorf("invalid duration %q, use something like \"30s\" or \"5m\"", value)
+	}
+	return duration, nil
+}
+
+// publishLimitsStatus reports the current limits to the user.
+func publishLimitsStatus(msg kernel.Message, status string) error {
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status)
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package specialcmd
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execLoad executes the "%load" special command. The parameter `args` excludes "%load".
+//
+//   - `--force`: replace memorized declarations that conflict with the loaded ones.
+//   - `<paths...>`: Go files or directories (packages) to load.
+func execLoad(msg kernel.Message, goExec *goexec.State, args []string) error {
+	var (
+		force bool
+		paths []string
+	)
+	for _, arg := range args {
+		switch {
+		case arg == "--force" || arg == "-f":
+			force = true
+		case strings.HasPrefix(arg, "-"):
+			return errors.Errorf("%%load: unknown argument %q, see %%help", arg)
+		default:
+			paths = append(paths, ReplaceEnvVars(ReplaceTildeInDir(arg)))
+		}
+	}
+	if len(paths) == 0 {
+		return errors.New("%load requires the Go files or directories to load")
+	}
+
+	result, err := goExec.Load(msg, paths, force)
+	if err != nil {
+		return errors.WithMessagef(err, "%%load")
+	}
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "Loaded %d declarations from %d files:\n", len(result.Keys), len(result.Files))
+	for _, filePath := range result.Files {
+		_, _ = fmt.Fprintf(&sb, "  - %s\n", filePath)
+	}
+	if len(result.Replaced) > 0 {
+		_, _ = fmt.Fprintf(&sb, "Replaced memorized declarations: %s\n", strings.Join(result.Replaced, ", "))
+	}
+	if len(result.Dropped) > 0 {
+		_, _ = fmt.Fprintf(&sb, "Not loaded: %s\n", strings.Join(result.Dropped, ", "))
+	}
+	err = kernel.PublishWriteStream(msg, kernel.StreamStdout, sb.String())
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
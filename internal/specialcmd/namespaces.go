@@ -0,0 +1,85 @@
+package specialcmd
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execNamespace executes the "%ns" special command. The parameter `args` excludes the command.
+//
+//   - `%ns`: displays the current namespace.
+//   - `%ns <name>`: switches to the namespace, creating it (empty) if it doesn't exist.
+//   - `%ns list`: lists the namespaces.
+//   - `%ns copy <from> <to>`: creates the namespace `<to>` as a copy of `<from>`.
+//   - `%ns rm <name>`: removes the namespace.
+func execNamespace(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		return publishNamespaceStatus(msg, fmt.Sprintf("Current namespace: %q.\n", goExec.Namespace()))
+	}
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return errors.Errorf("%%ns list takes no parameters")
+		}
+		listNamespaces(msg, goExec)
+	case "copy":
+		if len(args) != 3 {
+			return errors.Errorf("%%ns copy takes two parameters, the source and the new namespace")
+		}
+		if err := goExec.CopyNamespace(args[1], args[2]); err != nil {
+			return errors.WithMessage(err, "%ns copy")
+		}
+		return publishNamespaceStatus(msg, fmt.Sprintf("Namespace %q copied to %q.\n", args[1], args[2]))
+	case "rm":
+		if len(args) != 2 {
+			return errors.Errorf("%%ns rm takes one parameter, the namespace to remove")
+		}
+		if err := goExec.RemoveNamespace(args[1]); err != nil {
+			return errors.WithMessage(err, "%ns rm")
+		}
+		return publishNamespaceStatus(msg, fmt.Sprintf("Namespace %q removed.\n", args[1]))
+	default:
+		if len(args) != 1 {
+			return errors.Errorf("%%ns takes one parameter, the namespace to switch to")
+		}
+		if err := goExec.SwitchNamespace(args[0]); err != nil {
+			return errors.WithMessage(err, "%ns")
+		}
+		return publishNamespaceStatus(msg, fmt.Sprintf("Switched to namespace %q.\n", args[0]))
+	}
+	return nil
+}
+
+// publishNamespaceStatus publishes a status message of `%ns`.
+func publishNamespaceStatus(msg kernel.Message, status string) error {
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status); err != nil {
+		klog.Errorf("Failed publishing contents: %+v", err)
+	}
+	return nil
+}
+
+// listNamespaces publishes a table with the namespaces.
+func listNamespaces(msg kernel.Message, goExec *goexec.State) {
+	namespaces := goExec.Namespaces()
+	htmlParts := make([]string, 0, len(namespaces)+4)
+	htmlParts = append(htmlParts, "<h4>Namespaces</h4>", "<table>",
+		"<tr><th>Namespace</th><th>Declarations</th></tr>")
+	for _, ns := range namespaces {
+		name := html.EscapeString(ns.Name)
+		if ns.Current {
+			name = fmt.Sprintf("<b>%s</b> (current)", name)
+		}
+		htmlParts = append(htmlParts, fmt.Sprintf("<tr><td>%s</td><td>%d</td></tr>", name, ns.NumDeclarations))
+	}
+	htmlParts = append(htmlParts, "</table>")
+	err := kernel.PublishHtml(msg, strings.Join(htmlParts, "\n"))
+	if err != nil {
+		klog.Errorf("Failed to publish namespaces back to jupyter: %+v", err)
+	}
+}
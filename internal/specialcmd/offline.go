@@ -0,0 +1,38 @@
+package specialcmd
+
+import (
+	"fmt"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execOffline executes the "%offline" special command. The parameter `args` excludes the command.
+//
+//   - `%offline`: displays whether the offline mode is enabled, and its module cache.
+//   - `%offline <cache_dir>`: enables the offline mode, fetching modules from the given module cache.
+//   - `%offline off`: disables the offline mode.
+func execOffline(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.Errorf("%%offline takes at most one parameter, the module cache directory or \"off\"")
+	}
+	if len(args) == 1 {
+		cacheDir := args[0]
+		if cacheDir == "off" {
+			cacheDir = ""
+		}
+		if err := goExec.SetOfflineCache(cacheDir); err != nil {
+			return err
+		}
+	}
+	status := "Offline mode disabled.\n"
+	if goExec.OfflineCache != "" {
+		status = fmt.Sprintf("Offline mode: modules are fetched from the module cache in %q.\n", goExec.OfflineCache)
+	}
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status); err != nil {
+		klog.Errorf("Failed publishing contents: %+v", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package specialcmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execPersist executes the "%persist" special command. The parameter `args` excludes "%persist".
+//
+//   - `%persist`: lists the persistent variables.
+//   - `%persist <var> [<var>...]`: marks variables as persistent.
+//   - `%persist --rm <var> [<var>...]`: stops persisting the variables, and discards their saved values.
+//   - `%persist --reset`: discards all saved values, so the variables are re-initialized in the next execution.
+func execPersist(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		showPersisted(msg, goExec)
+		return nil
+	}
+	switch args[0] {
+	case "--rm":
+		if len(args) == 1 {
+			return errors.New("%persist --rm requires the name of the variables to stop persisting")
+		}
+		if err := goExec.Unpersist(args[1:]...); err != nil {
+			return err
+		}
+		publishPersist(msg, fmt.Sprintf("\tStopped persisting %s\n", strings.Join(args[1:], ", ")))
+	case "--reset":
+		if len(args) != 1 {
+			return errors.New("%persist --reset takes no arguments")
+		}
+		if err := goExec.ResetPersisted(); err != nil {
+			return err
+		}
+		publishPersist(msg, "\tSaved values of persistent variables discarded.\n")
+	default:
+		if strings.HasPrefix(args[0], "-") {
+			return errors.Errorf("%%persist: unknown flag %q", args[0])
+		}
+		if err := goExec.Persist(args...); err != nil {
+			return err
+		}
+		publishPersist(msg, fmt.Sprintf("\tPersisting %s\n", strings.Join(args, ", ")))
+	}
+	return nil
+}
+
+func publishPersist(msg kernel.Message, text string) {
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, text)
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+}
+
+// showPersisted lists the persistent variables, and whether they have a saved value.
+func showPersisted(msg kernel.Message, goExec *goexec.State) {
+	names := goExec.ListPersisted()
+	if len(names) == 0 {
+		publishPersist(msg, "No variables marked as persistent. Use `%persist <var>` to persist a variable.\n")
+		return
+	}
+	items := make([]string, 0, len(names))
+	for _, name := range names {
+		status := "not saved yet"
+		if goExec.IsPersistedSaved(name) {
+			status = "saved"
+		}
+		items = append(items, fmt.Sprintf("%s: %s", name, status))
+	}
+	displayEnumeration(msg, "Persistent Variables", items)
+}
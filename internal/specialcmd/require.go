@@ -0,0 +1,96 @@
+package specialcmd
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execRequire executes the "%require" special command. The parameter `args` excludes the command.
+//
+//   - `%require`: displays the requirements in `go.mod`.
+//   - `%require <module>@<version> [...]`: pins the version of the given modules, and displays the requirements.
+func execRequire(msg kernel.Message, goExec *goexec.State, args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return errors.Errorf("%%require: unknown flag %q", arg)
+		}
+	}
+	if len(args) > 0 {
+		if err := goExec.Require(args...); err != nil {
+			return err
+		}
+	}
+	return publishRequirements(msg, goExec)
+}
+
+// execDrop executes the "%drop" special command: it removes the pins and requirements of the given modules,
+// and displays the requirements left. The parameter `args` excludes the command.
+func execDrop(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		return errors.Errorf("%%drop takes the modules to remove from go.mod, e.g.: `%%drop github.com/janpfeifer/must`")
+	}
+	if err := goExec.Drop(args...); err != nil {
+		return err
+	}
+	return publishRequirements(msg, goExec)
+}
+
+// publishRequirements displays a table with the requirements in `go.mod`, and which of them are pinned.
+//
+// The pinned versions are recorded in the metadata of the output, so they are saved in the notebook, and
+// re-applied when the kernel starts (see goexec.NotebookRequirements).
+func publishRequirements(msg kernel.Message, goExec *goexec.State) error {
+	requirements, err := goExec.ListRequirements()
+	if err != nil {
+		return err
+	}
+	pins := make(map[string]any, len(goExec.Requirements()))
+	for modPath, version := range goExec.Requirements() {
+		pins[modPath] = version
+	}
+	metadata := kernel.MIMEMap{
+		ipynb.GonbMetadataKey: map[string]any{goexec.RequirementsMetadataKey: pins},
+	}
+	if len(requirements) == 0 {
+		err = kernel.PublishData(msg, kernel.Data{
+			Data:     kernel.MIMEMap{string(protocol.MIMETextPlain): "No requirements in go.mod."},
+			Metadata: metadata,
+		})
+		if err != nil {
+			klog.Errorf("Failed to publish to Jupyter: %+v", err)
+		}
+		return nil
+	}
+	htmlParts := make([]string, 0, len(requirements)+4)
+	htmlParts = append(htmlParts, "<h4>Requirements</h4>", "<table>",
+		"<tr><th>Module</th><th>Version</th><th>Pinned</th><th>Indirect</th></tr>")
+	for _, req := range requirements {
+		htmlParts = append(htmlParts, fmt.Sprintf("<tr><td><code>%s</code></td><td><code>%s</code></td><td>%s</td><td>%s</td></tr>",
+			html.EscapeString(req.Path), html.EscapeString(req.Version), checkMark(req.Pinned), checkMark(req.Indirect)))
+	}
+	htmlParts = append(htmlParts, "</table>")
+	err = kernel.PublishData(msg, kernel.Data{
+		Data:     kernel.MIMEMap{string(protocol.MIMETextHTML): strings.Join(htmlParts, "\n")},
+		Metadata: metadata,
+	})
+	if err != nil {
+		klog.Errorf("Failed to publish requirements back to jupyter: %+v", err)
+	}
+	return nil
+}
+
+// checkMark returns a check mark if value is true, or an empty string.
+func checkMark(value bool) string {
+	if value {
+		return "&#10003;"
+	}
+	return ""
+}
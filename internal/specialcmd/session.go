@@ -0,0 +1,46 @@
+package specialcmd
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execSession executes the "%session" special command. The parameter `args` excludes "%session".
+//
+//   - `%session`: reports whether session mode is enabled, and whether the session worker is running.
+//   - `%session on`: enables session mode, the worker is started with the next cell executed.
+//   - `%session off`: disables session mode, and stops the worker.
+//   - `%session restart`: stops the worker, a new one is started with the next cell executed.
+func execSession(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.New("%session takes at most one argument: on, off or restart")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			goExec.SetSessionMode(true)
+		case "off":
+			goExec.SetSessionMode(false)
+		case "restart":
+			goExec.StopSession()
+		default:
+			return errors.Errorf("%%session: unknown argument %q, valid values are on, off or restart", args[0])
+		}
+	}
+	var status string
+	switch {
+	case !goExec.IsSessionMode():
+		status = "Session mode is off: each cell is executed in a new process.\n"
+	case goExec.IsSessionRunning():
+		status = "Session mode is on: cells are executed in the running session worker.\n"
+	default:
+		status = "Session mode is on: a session worker is started with the next cell executed.\n"
+	}
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status)
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
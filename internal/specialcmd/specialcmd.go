@@ -58,7 +58,7 @@ func Parse(msg kernel.Message, goExec *goexec.State, execute bool, codeLines []s
 			cmdStr = joinLine(codeLines, lineNum, usedLines) // Gather current line and following if line ends with a `\` symbol
 			cmdType := cmdStr[0]
 			cmdStr = cmdStr[1:]
-			for cmdStr[0] == ' ' || cmdStr[0] == '\t' {
+			for len(cmdStr) > 0 && (cmdStr[0] == ' ' || cmdStr[0] == '\t') {
 				cmdStr = cmdStr[1:] // Skip initial space
 			}
 			if len(cmdStr) == 0 {
@@ -228,12 +228,29 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 		if err != nil {
 			klog.Errorf("Failed publishing contents: %+v", err)
 		}
+	case "incremental":
+		return execIncremental(msg, goExec, parts[1:])
 
 	// Automatic `go get` control:
 	case "autoget":
 		goExec.AutoGet = true
 	case "noautoget":
 		goExec.AutoGet = false
+	case "offline":
+		return execOffline(msg, goExec, parts[1:])
+
+	// Pinning of module versions in `go.mod`.
+	case "require":
+		return execRequire(msg, goExec, parts[1:])
+	case "drop":
+		return execDrop(msg, goExec, parts[1:])
+
+	// Saving and restoring the environment (`go.mod`, `go.sum`).
+	case "save_env":
+		return execSaveEnv(msg, goExec, parts[1:])
+	case "restore_env":
+		return execRestoreEnv(msg, goExec, parts[1:])
+
 	case "help":
 		//_ = kernel.PublishWriteStream(msg, kernel.StreamStdout, HelpMessage)
 		err := kernel.PublishMarkdown(msg, HelpMessage)
@@ -260,6 +277,60 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 		listDefinitions(msg, goExec)
 	case "rm", "remove":
 		removeDefinitions(msg, goExec, parts[1:])
+	case "ns":
+		return execNamespace(msg, goExec, parts[1:])
+	case "persist":
+		return execPersist(msg, goExec, parts[1:])
+	case "vars", "whos":
+		return execVars(msg, goExec, parts[1:])
+	case "stale":
+		return execStale(msg, goExec, parts[1:])
+	case "strict":
+		return execStrict(msg, goExec, parts[1:])
+	case "vet":
+		return execVet(msg, goExec, parts[1:])
+	case "confirm":
+		goExec.CellConfirmed = true
+	case "export":
+		return execExport(msg, goExec, parts[1:])
+	case "load":
+		return execLoad(msg, goExec, parts[1:])
+
+	// Session execution mode.
+	case "session":
+		return execSession(msg, goExec, parts[1:])
+
+	// History of executed cells.
+	case "history":
+		return execHistory(msg, goExec, parts[1:])
+
+	// Execution limits.
+	case "timeout":
+		return execTimeout(msg, goExec, parts[1:])
+	case "limits":
+		return execLimits(msg, goExec, parts[1:])
+
+	// Timing and profiling of the cell.
+	case "time":
+		if len(parts) > 1 {
+			return errors.Errorf("`%%time` takes no extra parameters.")
+		}
+		goExec.CellTiming = true
+	case "pprof":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%pprof` takes one parameter, the profile to collect: cpu, heap or block")
+		}
+		switch kind := goexec.ProfileKind(parts[1]); kind {
+		case goexec.ProfileCPU, goexec.ProfileHeap, goexec.ProfileBlock:
+			goExec.CellProfile = kind
+		default:
+			return errors.Errorf("`%%pprof`: unknown profile %q, valid values are cpu, heap or block", parts[1])
+		}
+	case "trace":
+		if len(parts) > 1 {
+			return errors.Errorf("`%%trace` takes no extra parameters.")
+		}
+		goExec.CellProfile = goexec.ProfileTrace
 
 	// Input handling.
 	case "with_inputs":
@@ -290,37 +361,7 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 
 	// Capture output of cell.
 	case "capture":
-		args := parts[1:]
-		var appendToFile bool
-		if len(args) > 1 && args[0] == "-a" {
-			appendToFile = true
-			args = args[1:]
-		}
-		if len(args) != 1 {
-			return errors.New("%capture takes one argument, the name of the file where to save the captured output")
-		}
-		filePath := args[0]
-		filePath = ReplaceTildeInDir(filePath)
-		filePath = ReplaceEnvVars(filePath)
-		var err error
-		var f *os.File
-		if appendToFile {
-			f, err = os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to append to \"%%capture\" file %q", filePath)
-				klog.Errorf("Error: %+v", err)
-				return err
-			}
-		} else {
-			f, err = os.Create(filePath)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to create \"%%capture\" file %q", filePath)
-				klog.Errorf("Error: %+v", err)
-				return err
-			}
-		}
-		// Notice, file will be closed in goExec.PostExecuteCell(), where all "one-shot" state is cleaned up.
-		goExec.CaptureFile = f
+		return execCapture(goExec, parts[1:])
 
 	default:
 		if CellSpecialCommands.Has("%" + parts[0]) {
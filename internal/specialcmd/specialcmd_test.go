@@ -61,3 +61,32 @@ func TestDirEnv(t *testing.T) {
 	assert.Equal(t, "/tmp", os.Getenv(protocol.GONB_DIR_ENV))
 	require.NoError(t, s.Stop())
 }
+
+func TestIsComplete(t *testing.T) {
+	for _, tc := range []struct {
+		code, status, indent string
+	}{
+		{"", goexec.CodeComplete, ""},
+		{"func f() int { return 1 }", goexec.CodeComplete, ""},
+		{"func f() int {", goexec.CodeIncomplete, "\t"},
+		{"func f() {\n\tif true {", goexec.CodeIncomplete, "\t\t"},
+		{"var x = f(1,", goexec.CodeIncomplete, "\t"},
+		{"var s = `multi\nline", goexec.CodeIncomplete, ""},
+		{"/* comment", goexec.CodeIncomplete, ""},
+		{"var x = 1 1", goexec.CodeInvalid, ""},
+		{"x := 1", goexec.CodeInvalid, ""},
+		{"import \"fmt\"\n%%\nfmt.Println(1)", goexec.CodeIncomplete, ""},
+		{"import \"fmt\"\n%%\nfmt.Println(1)\n", goexec.CodeComplete, ""},
+		{"%%\nfor {\n", goexec.CodeIncomplete, "\t"},
+		{"%env A \\", goexec.CodeIncomplete, ""},
+		{"%env A \\\n1", goexec.CodeComplete, ""},
+		{"!ls \\\n-l \\", goexec.CodeIncomplete, ""},
+		{"%%writefile test.txt\nhello", goexec.CodeIncomplete, ""},
+		{"%%writefile test.txt\nhello\n", goexec.CodeComplete, ""},
+		{"% ", goexec.CodeComplete, ""},
+	} {
+		status, indent := IsComplete(strings.Split(tc.code, "\n"))
+		assert.Equalf(t, tc.status, status, "status for code %q", tc.code)
+		assert.Equalf(t, tc.indent, indent, "indent for code %q", tc.code)
+	}
+}
@@ -0,0 +1,47 @@
+package specialcmd
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execStale executes the "%stale" special command. The parameter `args` excludes the command.
+//
+// It lists the cells whose dependencies (the declarations they used) were redefined or removed since they
+// were executed, in the order they should be re-executed.
+func execStale(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 0 {
+		return errors.Errorf("%%stale takes no parameters")
+	}
+	staleCells := goExec.StaleCells()
+	if len(staleCells) == 0 {
+		err := kernel.PublishWriteStream(msg, kernel.StreamStdout, "No stale cells.\n")
+		if err != nil {
+			klog.Errorf("Failed to publish to Jupyter: %+v", err)
+		}
+		return nil
+	}
+	htmlParts := make([]string, 0, len(staleCells)+4)
+	htmlParts = append(htmlParts, "<h4>Stale Cells</h4>", "<table>",
+		"<tr><th>Cell</th><th>Changed dependencies</th></tr>")
+	for _, cell := range staleCells {
+		reasons := make([]string, 0, len(cell.Reasons))
+		for _, reason := range cell.Reasons {
+			reasons = append(reasons, html.EscapeString(reason))
+		}
+		htmlParts = append(htmlParts, fmt.Sprintf("<tr><td>Cell[%d]</td><td><code>%s</code></td></tr>",
+			cell.ExecutionCount, strings.Join(reasons, "<br/>")))
+	}
+	htmlParts = append(htmlParts, "</table>")
+	err := kernel.PublishHtml(msg, strings.Join(htmlParts, "\n"))
+	if err != nil {
+		klog.Errorf("Failed to publish stale cells back to jupyter: %+v", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package specialcmd
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execStrict executes the "%strict" special command. The parameter `args` excludes the command.
+//
+//   - `%strict`: displays whether the strict mode is enabled.
+//   - `%strict on`: refuses cells that replace, remove or shadow memorized declarations from other cells,
+//     unless confirmed with `%confirm`.
+//   - `%strict off`: disables the strict mode.
+func execStrict(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.Errorf("%%strict takes at most one parameter, \"on\" or \"off\"")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			goExec.Strict = true
+		case "off":
+			goExec.Strict = false
+		default:
+			return errors.Errorf("%%strict: invalid parameter %q, it must be \"on\" or \"off\"", args[0])
+		}
+	}
+	status := "Strict mode disabled.\n"
+	if goExec.Strict {
+		status = "Strict mode enabled: use `%confirm` in cells that replace, remove or shadow declarations of other cells.\n"
+	}
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status); err != nil {
+		klog.Errorf("Failed publishing contents: %+v", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package specialcmd
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execVars executes the "%vars" (or "%whos") special command. The parameter `args` excludes the command.
+//
+//   - `%vars`: displays the type, size and value of all variables, at the end of the last successful execution.
+//   - `%vars <var> [<var>...]`: displays only the given variables.
+//
+// The values are reported by the executions that follow the first use of `%vars`.
+func execVars(msg kernel.Message, goExec *goexec.State, args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return errors.Errorf("%%vars: unknown flag %q", arg)
+		}
+	}
+	selected := common.SetWithValues(args...)
+	goExec.RequestVariables()
+
+	// Variables removed since the last execution (e.g. with `%rm`) are not listed.
+	var rows []string
+	for _, v := range goExec.Variables() {
+		if _, found := goExec.Definitions.Variables[v.Name]; !found {
+			continue
+		}
+		if len(selected) > 0 && !selected.Has(v.Name) {
+			continue
+		}
+		rows = append(rows, fmt.Sprintf("<tr><td><code>%s</code></td><td><code>%s</code></td><td>%s</td><td><pre>%s</pre></td></tr>",
+			html.EscapeString(v.Name), html.EscapeString(v.Type), html.EscapeString(v.Size), html.EscapeString(v.Value)))
+	}
+	if len(rows) == 0 {
+		err := kernel.PublishWriteStream(msg, kernel.StreamStdout,
+			"No variables to display: from now on, their values are reported at the end of each successful execution of a cell.\n")
+		if err != nil {
+			klog.Errorf("Failed to publish to Jupyter: %+v", err)
+		}
+		return nil
+	}
+	htmlParts := make([]string, 0, len(rows)+4)
+	htmlParts = append(htmlParts, "<h4>Variables</h4>", "<table>",
+		"<tr><th>Name</th><th>Type</th><th>Size</th><th>Value</th></tr>")
+	htmlParts = append(htmlParts, rows...)
+	htmlParts = append(htmlParts, "</table>")
+	err := kernel.PublishHtml(msg, strings.Join(htmlParts, "\n"))
+	if err != nil {
+		klog.Errorf("Failed to publish variables back to jupyter: %+v", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package specialcmd
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// execVet executes the "%vet" special command. The parameter `args` excludes the command.
+//
+//   - `%vet`: displays whether `go vet` is run after compiling the cells.
+//   - `%vet on`: runs `go vet` after compiling the cells, and reports its findings as warnings.
+//   - `%vet off`: disables it.
+func execVet(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 1 {
+		return errors.Errorf("%%vet takes at most one parameter, \"on\" or \"off\"")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			goExec.Vet = true
+		case "off":
+			goExec.Vet = false
+		default:
+			return errors.Errorf("%%vet: invalid parameter %q, it must be \"on\" or \"off\"", args[0])
+		}
+	}
+	status := "`go vet` disabled.\n"
+	if goExec.Vet {
+		status = "`go vet` enabled: its findings are reported as warnings after compiling the cells.\n"
+	}
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStdout, status); err != nil {
+		klog.Errorf("Failed publishing contents: %+v", err)
+	}
+	return nil
+}
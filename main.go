@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"github.com/janpfeifer/gonb/internal/dispatcher"
 	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/history"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/nbrun"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -26,6 +31,12 @@ var (
 	flagRawError     = flag.Bool("raw_error", false, "When GoNB executes cells, force raw text errors instead of HTML errors, which facilitates command line testing of notebooks.")
 	flagWork         = flag.Bool("work", false, "Print name of temporary work directory and preserve it at exit. ")
 	flagCommsLog     = flag.Bool("comms_log", false, "Enable verbose logging from communication library in Javascript console.")
+	flagHistory      = flag.String("history", history.DefaultPath(), "File where to store the history of executed cells, used by `%history` and Jupyter's history requests. Set to empty to disable it.")
+	flagHistoryOut   = flag.Bool("history_outputs", false, "Also store the outputs of the cells (up to 1MB per cell) in the history file, besides their code.")
+	flagTimeout      = flag.Duration("timeout", 0, "Default maximum time the execution of a cell can take, before it is interrupted. It can be changed with `%timeout`. 0 means no timeout.")
+	flagMemLimit     = flag.String("mem_limit", "", "Default maximum memory (e.g.: \"2G\") the execution of a cell can use. It can be changed with `%limits`. Empty means no limit.")
+	flagCPULimit     = flag.Duration("cpu_limit", 0, "Default maximum CPU time the execution of a cell can use. It can be changed with `%limits`. 0 means no limit.")
+	flagOfflineCache = flag.String("offline_cache", "", "Module cache to fetch modules from, for machines without internet access (offline mode). It can be pre-populated with `gonb prefetch`, and changed with `%offline`.")
 	flagShortVersion = flag.Bool("V", false, "Print version information")
 	flagLongVersion  = flag.Bool("version", false, "Print detailed version information")
 )
@@ -67,15 +78,32 @@ func main() {
 	setUpLogging() // "log" package.
 	setUpKlog()    // "k8s.io/klog/v2" package
 
-	// One of two tasks: (1) install gonb; (2) run kernel, started by JupyterServer.
+	// One of three tasks: (1) install gonb; (2) run kernel, started by JupyterServer; (3) run a subcommand.
 	if install() {
 		return
 	}
 	if runKernel() {
 		return
 	}
+	if runExport() {
+		return
+	}
+	if runNotebook() {
+		return
+	}
+	if runConvert() {
+		return
+	}
+	if runPrefetch() {
+		return
+	}
 
-	_, _ = fmt.Fprintf(os.Stderr, "Use either --install to install the kernel, or if started by Jupyter the flag --kernel must be provided.\n")
+	_, _ = fmt.Fprintf(os.Stderr, "Use either --install to install the kernel, or if started by Jupyter the flag --kernel must be provided.\n"+
+		"Subcommands:\n  export: exports the Go code of a notebook as a Go module, see `%s export --help`.\n"+
+		"  run: executes a notebook without Jupyter, saving the outputs, see `%s run --help`.\n"+
+		"  convert: converts a notebook from/to the Go source format (`.go`), see `%s convert --help`.\n"+
+		"  prefetch: downloads the modules used by notebooks into a module cache, for the offline mode, see `%s prefetch --help`.\n",
+		os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(1)
 	return
@@ -112,6 +140,24 @@ func install() bool {
 	if glogFlag := flag.Lookup("comms_log"); glogFlag != nil && glogFlag.Value.String() != "false" {
 		extraArgs = append(extraArgs, "--comms_log")
 	}
+	if *flagHistory != history.DefaultPath() {
+		extraArgs = append(extraArgs, fmt.Sprintf("--history=%s", *flagHistory))
+	}
+	if *flagHistoryOut {
+		extraArgs = append(extraArgs, "--history_outputs")
+	}
+	for _, name := range []string{"timeout", "mem_limit", "cpu_limit"} {
+		if limitFlag := flag.Lookup(name); limitFlag.Value.String() != limitFlag.DefValue {
+			extraArgs = append(extraArgs, fmt.Sprintf("--%s=%s", name, limitFlag.Value.String()))
+		}
+	}
+	if *flagOfflineCache != "" {
+		offlineCache, err := filepath.Abs(*flagOfflineCache)
+		if err != nil {
+			log.Fatalf("Invalid --offline_cache=%q: %+v\n", *flagOfflineCache, err)
+		}
+		extraArgs = append(extraArgs, fmt.Sprintf("--offline_cache=%s", offlineCache))
+	}
 	err := kernel.Install(extraArgs, *flagForceDeps, *flagForceCopy)
 	if err != nil {
 		log.Fatalf("Installation failed: %+v\n", err)
@@ -235,6 +281,18 @@ func runKernel() bool {
 		klog.Fatalf("Failed to create go executor: %+v", err)
 	}
 	goExec.Comms.LogWebSocket = *flagCommsLog
+	if *flagHistory != "" {
+		goExec.History, err = history.Open(*flagHistory, UniqueID)
+		if err != nil {
+			klog.Warningf("History of executed cells disabled: %+v", err)
+		} else {
+			goExec.History.RecordOutputs = *flagHistoryOut
+		}
+	}
+
+	setLimits(goExec)
+	setOfflineCache(goExec)
+	loadNotebookMetadata(goExec)
 
 	// Orchestrate dispatching of messages.
 	dispatcher.RunKernel(k, goExec)
@@ -252,3 +310,56 @@ func runKernel() bool {
 	klog.Infof("Exiting...")
 	return true
 }
+
+// loadNotebookMetadata restores the environment (see `%save_env`) and the module versions pinned (see `%require`)
+// recorded in the notebook of the kernel session, if it can be found. Errors are only logged.
+func loadNotebookMetadata(goExec *goexec.State) {
+	if os.Getenv(goexec.JupyterSessionNameEnv) == "" {
+		return
+	}
+	nbPath, err := goexec.JupyterNotebookPath()
+	if err != nil {
+		klog.Warningf("Notebook metadata not loaded: %+v", err)
+		return
+	}
+	nb, err := ipynb.Read(nbPath)
+	if err != nil {
+		klog.Warningf("Notebook metadata not loaded: %+v", err)
+		return
+	}
+	warnings, err := nbrun.LoadMetadata(goExec, nb)
+	if err != nil {
+		klog.Errorf("Failed to load notebook metadata: %+v", err)
+		return
+	}
+	for _, warning := range warnings {
+		klog.Warningf("Restoring environment of %q: %s", nbPath, warning)
+	}
+	goExec.AddPendingWarnings(warnings...)
+}
+
+// setOfflineCache enables the offline mode, if --offline_cache is set.
+// Errors are fatal.
+func setOfflineCache(goExec *goexec.State) {
+	if *flagOfflineCache == "" {
+		return
+	}
+	if err := goExec.SetOfflineCache(*flagOfflineCache); err != nil {
+		klog.Fatalf("Invalid --offline_cache: %+v", err)
+	}
+}
+
+// setLimits configures the default limits of the execution of cells, from the flags --timeout,
+// --mem_limit and --cpu_limit.
+// Errors are fatal.
+func setLimits(goExec *goexec.State) {
+	goExec.Limits.Timeout = *flagTimeout
+	goExec.Limits.CPU = *flagCPULimit
+	if *flagMemLimit != "" {
+		var err error
+		goExec.Limits.Memory, err = jpyexec.ParseMemorySize(*flagMemLimit)
+		if err != nil {
+			klog.Fatalf("Invalid --mem_limit: %+v", err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/nbrun"
+	"github.com/pkg/errors"
+	klog "k8s.io/klog/v2"
+)
+
+// runPrefetch implements the `gonb prefetch` subcommand, if it was given. Returns whether it was run.
+// Errors are fatal.
+func runPrefetch() bool {
+	if flag.NArg() == 0 || flag.Arg(0) != "prefetch" {
+		return false
+	}
+	prefetchFlags := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	flagCache := prefetchFlags.String("cache", "", "Module cache directory to populate. It is created if it doesn't exist.")
+	prefetchFlags.Usage = func() {
+		_, _ = fmt.Fprintf(prefetchFlags.Output(), "Usage: %s prefetch --cache=<cache_dir> <notebook>...\n\n"+
+			"Downloads the modules used by the notebooks (the imports of their Go cells, with the versions pinned or\n"+
+			"saved in the notebooks) into the given module cache. It is meant to be run on a machine connected to\n"+
+			"the internet: the cache can then be copied to machines without internet access, and used with the flag\n"+
+			"--offline_cache or the special command `%%offline`.\n\n", os.Args[0])
+		prefetchFlags.PrintDefaults()
+	}
+	_ = prefetchFlags.Parse(flag.Args()[1:])
+	if prefetchFlags.NArg() == 0 || *flagCache == "" {
+		prefetchFlags.Usage()
+		os.Exit(1)
+	}
+	if err := setPrefetchCache(*flagCache); err != nil {
+		klog.Exitf("Failed to use module cache %q: %+v", *flagCache, err)
+	}
+	for _, notebookPath := range prefetchFlags.Args() {
+		if err := prefetchNotebook(notebookPath); err != nil {
+			klog.Exitf("Failed to prefetch modules of %q: %+v", notebookPath, err)
+		}
+		fmt.Printf("Modules used by %q downloaded to %q.\n", notebookPath, os.Getenv("GOMODCACHE"))
+	}
+	return true
+}
+
+// setPrefetchCache makes `go` download modules into cacheDir: it sets GOMODCACHE, and the flag `-modcacherw`,
+// so the cache can be copied and removed like any other directory.
+func setPrefetchCache(cacheDir string) error {
+	cacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return errors.Wrapf(err, "invalid cache directory")
+	}
+	if err = os.MkdirAll(cacheDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create cache directory")
+	}
+	if err = os.Setenv("GOMODCACHE", cacheDir); err != nil {
+		return errors.Wrapf(err, "failed to set GOMODCACHE")
+	}
+	goFlags := strings.TrimSpace(os.Getenv("GOFLAGS") + " -modcacherw")
+	if err = os.Setenv("GOFLAGS", goFlags); err != nil {
+		return errors.Wrapf(err, "failed to set GOFLAGS")
+	}
+	return nil
+}
+
+// prefetchNotebook declares the Go cells of the notebook in notebookPath (see declareNotebook), which fetches
+// the modules they import, and then downloads all the modules in the resulting build list.
+func prefetchNotebook(notebookPath string) error {
+	nb, err := ipynb.Read(notebookPath)
+	if err != nil {
+		return err
+	}
+	goExec, err := goexec.New(nil, UniqueID, *flagWork, true)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to create go executor")
+	}
+	defer func() {
+		if stopErr := goExec.Stop(); stopErr != nil {
+			klog.Warningf("Error during shutdown: %+v", stopErr)
+		}
+	}()
+
+	warnings, err := nbrun.LoadMetadata(goExec, nb)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		klog.Warningf("%s: %s", notebookPath, warning)
+	}
+	if err = declareNotebook(goExec, nb); err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "mod", "download", "all")
+	cmd.Dir = goExec.TempDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to run %q:\n%s", cmd, output)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/ipynb"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/nbrun"
+	"github.com/pkg/errors"
+	klog "k8s.io/klog/v2"
+)
+
+// parametersFlag implements flag.Value for the repeated `-p name=value` flag of `gonb run`.
+type parametersFlag []nbrun.Parameter
+
+func (p *parametersFlag) String() string {
+	parts := make([]string, 0, len(*p))
+	for _, param := range *p {
+		parts = append(parts, fmt.Sprintf("%s=%v", param.Name, param.Value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *parametersFlag) Set(value string) error {
+	name, paramValue, found := strings.Cut(value, "=")
+	if !found || name == "" {
+		return errors.Errorf("parameters must be given as name=value, got %q", value)
+	}
+	*p = append(*p, nbrun.Parameter{Name: name, Value: paramValue})
+	return nil
+}
+
+// runNotebook implements the `gonb run` subcommand, if it was given. Returns whether it was run.
+// Errors are fatal, and if any cell fails the program exits with status 1, after saving the notebook.
+func runNotebook() bool {
+	if flag.NArg() == 0 || flag.Arg(0) != "run" {
+		return false
+	}
+	var params parametersFlag
+	runFlags := flag.NewFlagSet("run", flag.ExitOnError)
+	runFlags.Var(&params, "p", "Parameter to inject in the notebook, as `name=value`. It can be repeated.")
+	flagParameters := runFlags.String("parameters", "", "JSON file with an object with the parameters to inject in the notebook.")
+	flagOutput := runFlags.String("output", "", "Where to save the executed notebook. If empty, the notebook is updated in place, "+
+		"except for notebooks in the Go source format (\".go\"), which can't hold the outputs.")
+	flagKeepGoing := runFlags.Bool("keep_going", false, "Execute all cells, even after a cell fails.")
+	flagQuiet := runFlags.Bool("quiet", false, "Don't print the output streams of the cells and errors.")
+	runFlags.Usage = func() {
+		_, _ = fmt.Fprintf(runFlags.Output(), "Usage: %s run [flags] <notebook.ipynb|notebook.go>\n\n"+
+			"Executes the notebook without Jupyter, and saves it with the outputs of its cells.\n"+
+			"It exits with status 1 if any cell fails.\n\n", os.Args[0])
+		runFlags.PrintDefaults()
+	}
+	_ = runFlags.Parse(flag.Args()[1:])
+	if runFlags.NArg() != 1 {
+		runFlags.Usage()
+		os.Exit(1)
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		klog.Exitf("Failed to find path for the `go` program: %+v\n\nCurrent PATH=%q", err, os.Getenv("PATH"))
+	}
+	opts := nbrun.Options{KeepGoing: *flagKeepGoing}
+	if !*flagQuiet {
+		opts.Stdout, opts.Stderr = os.Stdout, os.Stderr
+	}
+	if *flagParameters != "" {
+		fileParams, err := readParametersFile(*flagParameters)
+		if err != nil {
+			klog.Exitf("Failed to read parameters: %+v", err)
+		}
+		opts.Parameters = fileParams
+	}
+	opts.Parameters = append(opts.Parameters, params...)
+
+	notebookPath := runFlags.Arg(0)
+	outputPath := *flagOutput
+	if outputPath == "" && filepath.Ext(notebookPath) != ipynb.GoSourceExt {
+		outputPath = notebookPath
+	}
+	failed, err := runNotebookFile(notebookPath, outputPath, opts)
+	if err != nil {
+		klog.Exitf("Failed to run %q: %+v", notebookPath, err)
+	}
+	if len(failed) > 0 {
+		if outputPath != "" {
+			_, _ = fmt.Fprintf(os.Stderr, "%d cell(s) failed, outputs saved in %q\n", len(failed), outputPath)
+		} else {
+			_, _ = fmt.Fprintf(os.Stderr, "%d cell(s) failed\n", len(failed))
+		}
+		klog.Flush()
+		os.Exit(1)
+	}
+	return true
+}
+
+// runNotebookFile executes the notebook in notebookPath, and saves it with the outputs in outputPath,
+// if it is not empty. Cells are executed in the directory of the notebook, as in Jupyter.
+//
+// It returns the indices of the cells that failed.
+func runNotebookFile(notebookPath, outputPath string, opts nbrun.Options) (failed []int, err error) {
+	if outputPath != "" {
+		if outputPath, err = filepath.Abs(outputPath); err != nil {
+			return
+		}
+	}
+	if *flagOfflineCache != "" {
+		// Relative to the current directory, before changing to the directory of the notebook.
+		if *flagOfflineCache, err = filepath.Abs(*flagOfflineCache); err != nil {
+			return
+		}
+	}
+	nb, err := ipynb.Read(notebookPath)
+	if err != nil {
+		return
+	}
+	if dir := filepath.Dir(notebookPath); dir != "." {
+		if err = os.Chdir(dir); err != nil {
+			return nil, errors.Wrapf(err, "failed to change to the notebook directory %q", dir)
+		}
+	}
+
+	k := kernel.NewHeadless()
+	k.HandleInterrupt() // Control+C interrupts the cell being executed.
+	goExec, err := goexec.New(k, UniqueID, *flagWork, *flagRawError)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create go executor")
+	}
+	setLimits(goExec)
+	setOfflineCache(goExec)
+	failed, err = nbrun.Run(k, goExec, nb, opts)
+	if stopErr := goExec.Stop(); stopErr != nil {
+		klog.Warningf("Error during shutdown: %+v", stopErr)
+	}
+	k.Stop()
+	if err != nil || outputPath == "" {
+		return
+	}
+	err = nb.Write(outputPath)
+	return
+}
+
+// readParametersFile reads the parameters from a JSON file with one object, sorted by name.
+func readParametersFile(filePath string) ([]nbrun.Parameter, error) {
+	filePath = ReplaceEnvVars(ReplaceTildeInDir(filePath))
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", filePath)
+	}
+	values := make(map[string]any)
+	if err = json.Unmarshal(contents, &values); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %q: it must be a JSON object with the parameter values", filePath)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make([]nbrun.Parameter, 0, len(names))
+	for _, name := range names {
+		params = append(params, nbrun.Parameter{Name: name, Value: values[name]})
+	}
+	return params, nil
+}